@@ -0,0 +1,925 @@
+package main
+
+import (
+	"app/config"
+	"app/core/history"
+	"app/core/interpreter"
+	"app/core/persistence"
+	"app/core/transfer"
+	"app/core/variables"
+	"app/ui"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"os/user"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/skratchdot/open-golang/open"
+	"golang.org/x/term"
+)
+
+// ============================================================================
+// ОБЩИЕ ФЛАГИ
+// ============================================================================
+
+// commonFlags - флаги, доступные во всех подкомандах
+type commonFlags struct {
+	configFile string
+	dataFile   string
+	workspace  string
+	ai         string
+}
+
+func addCommonFlags(fs *flag.FlagSet) *commonFlags {
+	f := &commonFlags{}
+	fs.StringVar(&f.configFile, "config", os.Getenv("CALC_CONFIG"), "файл конфигурации .json, .yaml или .toml (CALC_CONFIG)")
+	fs.StringVar(&f.dataFile, "data-file", "", "файл данных калькулятора (CALC_DATA_FILE)")
+	fs.StringVar(&f.workspace, "workspace", "", "рабочее пространство (CALC_WORKSPACE, по умолчанию default)")
+	fs.StringVar(&f.ai, "ai", "", "AI-ассистент: on или off (CALC_AI)")
+	return f
+}
+
+// flagKeys - соответствие флагов параметрам конфигурации
+var flagKeys = map[string]string{
+	"data-file":  "storage.data_file",
+	"workspace":  "storage.workspace",
+	"ai":         "ai.enabled",
+	"addr":       "server.addr",
+	"static-dir": "server.static_dir",
+}
+
+// load - загрузка конфигурации, применение явно заданных флагов поверх нее и проверка
+func (f *commonFlags) load(fs *flag.FlagSet) (*config.Config, error) {
+	cfg, err := f.resolve(fs)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// resolve - конфигурация из всех источников без проверки (используется и при перезагрузке)
+func (f *commonFlags) resolve(fs *flag.FlagSet) (*config.Config, error) {
+	cfg, err := config.LoadFile(f.configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var flagErr error
+	fs.Visit(func(fl *flag.Flag) {
+		key, ok := flagKeys[fl.Name]
+		if !ok || flagErr != nil {
+			return
+		}
+		if err := cfg.Set(key, fl.Value.String()); err != nil {
+			flagErr = fmt.Errorf("некорректное значение --%s: %v", fl.Name, err)
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	// Флаг-отрицание не ложится на параметр напрямую
+	if fl := fs.Lookup("no-browser"); fl != nil && fl.Value.String() == "true" {
+		cfg.Server.OpenBrowser = false
+	}
+	return cfg, nil
+}
+
+// newInterpreter - интерпретатор по конфигурации; noPersist включает временное состояние.
+// mode - подкоманда, от имени которой изменения попадают в журнал событий.
+func newInterpreter(cfg *config.Config, noPersist bool, mode string) (*interpreter.Interpreter, error) {
+	if noPersist {
+		return interpreter.NewInterpreterWithConfig(cfg, persistence.NewInMemoryPersistenceManager()), nil
+	}
+	pm, err := openStorage(cfg, actor(mode))
+	if err != nil {
+		return nil, err
+	}
+	return interpreter.NewInterpreterWithConfig(cfg, pm), nil
+}
+
+// openStorage - хранилище по конфигурации. Состояние и рабочее пространство запуска
+// читаются сразу: старые файлы обновляются до текущей схемы, а данные более новой
+// версии калькулятора останавливают запуск, а не перезаписываются.
+func openStorage(cfg *config.Config, actor string) (*persistence.PersistenceManager, error) {
+	pm := persistence.NewPersistenceManagerWithConfig(cfg)
+	pm.SetActor(actor)
+	if err := pm.Check(); err != nil {
+		pm.Close()
+		return nil, err
+	}
+	if err := pm.Namespace(cfg.Storage.Workspace).Check(); err != nil {
+		pm.Close()
+		return nil, err
+	}
+	return pm, nil
+}
+
+// actor - кто вносит изменения: подкоманда и пользователь ОС
+func actor(mode string) string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return mode + ":" + u.Username
+	}
+	return mode
+}
+
+// parseInterspersed - разбор флагов, которые могут идти после позиционных аргументов
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	positional := make([]string, 0)
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func fail(format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	return 2
+}
+
+// ============================================================================
+// SERVE
+// ============================================================================
+
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	fs.String("addr", "", "адрес HTTP сервера (CALC_ADDR, по умолчанию "+config.DefaultAddr+")")
+	fs.String("static-dir", "", "каталог статических файлов (CALC_STATIC_DIR)")
+	fs.Bool("no-browser", false, "не открывать браузер при запуске (CALC_OPEN_BROWSER=false)")
+	fs.Parse(args)
+
+	cfg, err := common.load(fs)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	var web *ui.WebInterface
+	var apply func(*config.Config)
+	if cfg.Sessions.Enabled {
+		// У каждого браузера свое состояние в calculator_data.sessions/
+		// Изменения сессий записываются в журнал от имени session:<id>
+		pm, err := openStorage(cfg, "web")
+		if err != nil {
+			return fail("%v", err)
+		}
+		sessions := ui.NewSessionManager(cfg, pm)
+		go sessions.Run(context.Background())
+		web = ui.NewWebInterfaceWithSessions(sessions, cfg.Server.StaticDir)
+		apply = sessions.ApplyConfig
+	} else {
+		i, err := newInterpreter(cfg, false, "web")
+		if err != nil {
+			return fail("%v", err)
+		}
+		i.DisplayRecentHistory()
+		web = ui.NewWebInterfaceWithStaticDir(i, cfg.Server.StaticDir)
+		apply = i.ApplyConfig
+	}
+
+	// Изменения файла конфигурации и SIGHUP применяются без перезапуска
+	watcher := config.NewWatcher(common.configFile, cfg, func() (*config.Config, error) {
+		return common.resolve(fs)
+	}, apply)
+	go watcher.Run(context.Background())
+
+	calcURL := cfg.Server.Addr
+	if strings.HasPrefix(calcURL, ":") {
+		calcURL = "localhost" + calcURL
+	}
+	calcURL = "http://" + calcURL
+
+	if cfg.Server.OpenBrowser {
+		go func() {
+			time.Sleep(500 * time.Millisecond)
+			err := open.Run(calcURL)
+			if err != nil {
+				log.Printf("❌ Не удалось открыть браузер: %v", err)
+			}
+		}()
+		fmt.Printf("🌐 Калькулятор открывается в браузере: %s\n", calcURL)
+	} else {
+		fmt.Printf("🌐 Калькулятор доступен по адресу: %s\n", calcURL)
+	}
+	fmt.Println("Нажмите Ctrl+C для выхода.")
+
+	// Ctrl+C и SIGTERM останавливают сервер штатно: состояние записывается на диск
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = web.Start(ctx, cfg.Server.Addr)
+	if closeErr := web.Close(); closeErr != nil {
+		log.Printf("❌ Не удалось сохранить состояние: %v", closeErr)
+		return 1
+	}
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	return 0
+}
+
+// ============================================================================
+// REPL, EVAL, RUN
+// ============================================================================
+
+func runREPL(args []string) int {
+	fs := flag.NewFlagSet("repl", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	fs.Parse(args)
+
+	cfg, err := common.load(fs)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	i, err := newInterpreter(cfg, false, "repl")
+	if err != nil {
+		return fail("%v", err)
+	}
+	defer i.Close()
+	i.DisplayRecentHistory()
+
+	if err := ui.NewREPL(i).Start(); err != nil {
+		log.Print(err)
+		return 1
+	}
+	return 0
+}
+
+// runEval - вычисление выражений из аргументов или stdin, возвращает код выхода
+func runEval(args []string) int {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	varsFile := fs.String("vars", "", "JSON файл с переменными {\"имя\": значение}")
+	format := fs.String("format", ui.FormatPlain, "формат вывода: plain, json, csv")
+	noPersist := fs.Bool("no-persist", false, "не читать и не сохранять состояние калькулятора")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Использование: calc eval [флаги] [выражение ...]")
+		fmt.Fprintln(fs.Output(), "Без выражений строки читаются из stdin.")
+		fs.PrintDefaults()
+	}
+	exprs := parseInterspersed(fs, args)
+
+	cfg, err := common.load(fs)
+	if err != nil {
+		return fail("%v", err)
+	}
+	i, err := newInterpreter(cfg, *noPersist, "eval")
+	if err != nil {
+		return fail("%v", err)
+	}
+	defer i.Close()
+
+	if *varsFile != "" {
+		vars, err := readVarsFile(*varsFile)
+		if err != nil {
+			return fail("Ошибка чтения %s: %v", *varsFile, err)
+		}
+		if err := i.SetVariables(vars); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️ %s: %v\n", *varsFile, err)
+		}
+	}
+
+	batch, err := ui.NewBatchInterface(i, *format)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	ok := true
+	if len(exprs) > 0 {
+		ok = batch.Run(exprs)
+	} else {
+		ok, err = batch.RunReader(os.Stdin)
+		if err != nil {
+			return fail("Ошибка чтения stdin: %v", err)
+		}
+	}
+
+	if !ok {
+		return 1
+	}
+	return 0
+}
+
+// runScript - выполнение файла построчно, как eval для stdin
+func runScript(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	format := fs.String("format", ui.FormatPlain, "формат вывода: plain, json, csv")
+	noPersist := fs.Bool("no-persist", false, "не читать и не сохранять состояние калькулятора")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Использование: calc run [флаги] <файл>")
+		fs.PrintDefaults()
+	}
+	files := parseInterspersed(fs, args)
+	if len(files) != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := common.load(fs)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	script, err := os.Open(files[0])
+	if err != nil {
+		return fail("Ошибка открытия скрипта: %v", err)
+	}
+	defer script.Close()
+
+	i, err := newInterpreter(cfg, *noPersist, "run")
+	if err != nil {
+		return fail("%v", err)
+	}
+	defer i.Close()
+
+	batch, err := ui.NewBatchInterface(i, *format)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	ok, err := batch.RunReader(script)
+	if err != nil {
+		return fail("Ошибка чтения скрипта: %v", err)
+	}
+	if !ok {
+		return 1
+	}
+	return 0
+}
+
+func readVarsFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := make(map[string]interface{})
+	if err := json.Unmarshal(data, &vars); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// ============================================================================
+// EXPORT, IMPORT
+// ============================================================================
+
+// runExport - без аргумента все состояние в JSON; vars и history - переменные или история
+// рабочего пространства в выбранном формате
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	output := fs.String("output", "", "файл для выгрузки (по умолчанию stdout)")
+	format := fs.String("format", "", "формат vars: "+strings.Join(transfer.VariableFormats, ", ")+
+		"; history: "+strings.Join(transfer.HistoryFormats, ", ")+" (по умолчанию по расширению --output, иначе json)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Использование: calc export [vars|history] [флаги]")
+		fmt.Fprintln(fs.Output(), "Без vars или history выгружается все состояние в JSON.")
+		fs.PrintDefaults()
+	}
+	what := parseInterspersed(fs, args)
+	if len(what) > 1 || (len(what) == 1 && what[0] != "vars" && what[0] != "history") {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := common.load(fs)
+	if err != nil {
+		return fail("%v", err)
+	}
+	var sectionFormat string
+	if len(what) == 1 {
+		if sectionFormat, err = transferFormat(what[0], *format, *output); err != nil {
+			return fail("%v", err)
+		}
+	} else if *format != "" && *format != transfer.FormatJSON {
+		return fail("Все состояние выгружается только в JSON; для других форматов укажите vars или history")
+	}
+
+	pm, err := openStorage(cfg, actor("export"))
+	if err != nil {
+		return fail("%v", err)
+	}
+	defer pm.Close()
+
+	var write func(out io.Writer) error
+	if len(what) == 0 {
+		data := pm.LoadData()
+		if data == nil {
+			return fail("Не удалось прочитать %s", cfg.Storage.DataFile)
+		}
+		write = func(out io.Writer) error {
+			encoder := json.NewEncoder(out)
+			encoder.SetIndent("", "  ")
+			encoder.SetEscapeHTML(false)
+			return encoder.Encode(data)
+		}
+	} else {
+		state, err := workspaceState(pm, cfg)
+		if err != nil {
+			return fail("%v", err)
+		}
+		if what[0] == "vars" {
+			vars := state.LoadVariables()
+			write = func(out io.Writer) error { return transfer.WriteVariables(out, vars, sectionFormat) }
+		} else {
+			entries := state.GetRecentHistory(0)
+			write = func(out io.Writer) error { return transfer.WriteHistory(out, entries, sectionFormat) }
+		}
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		out, err = os.Create(*output)
+		if err != nil {
+			return fail("Ошибка создания файла: %v", err)
+		}
+		defer out.Close()
+	}
+	if err := write(out); err != nil {
+		return fail("Ошибка записи: %v", err)
+	}
+	return 0
+}
+
+// transferFormat - формат переменных (vars) или истории (history): явный или по расширению path
+func transferFormat(what, format, path string) (string, error) {
+	if what == "vars" {
+		return transfer.VariableFormat(format, path)
+	}
+	return transfer.HistoryFormat(format, path)
+}
+
+// workspaceState - состояние рабочего пространства storage.workspace
+func workspaceState(pm *persistence.PersistenceManager, cfg *config.Config) (*persistence.PersistenceManager, error) {
+	name := cfg.Storage.Workspace
+	if name != "" && !pm.NamespaceExists(name) {
+		return nil, fmt.Errorf("рабочее пространство %q не найдено", name)
+	}
+	return pm.Namespace(name), nil
+}
+
+// readOnlyIn - константы из списка constants и встроенные: загрузка их не меняет
+func readOnlyIn(constants []string) func(name string) bool {
+	set := make(map[string]bool, len(constants))
+	for _, name := range constants {
+		set[name] = true
+	}
+	return func(name string) bool { return set[name] || variables.IsBuiltin(name) }
+}
+
+// runImport - из файла полной выгрузки переменные перезаписывают текущие, история
+// дописывается в конец; vars и history загружаются в рабочее пространство по стратегии
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	format := fs.String("format", "", "формат файла vars или history (по умолчанию по расширению, иначе json)")
+	strategy := fs.String("strategy", transfer.StrategyMerge, "при совпадении: "+strings.Join(transfer.Strategies, ", "))
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Использование: calc import [флаги] <файл.json>")
+		fmt.Fprintln(fs.Output(), "       calc import vars|history [флаги] <файл>")
+		fs.PrintDefaults()
+	}
+	files := parseInterspersed(fs, args)
+	if len(files) == 2 && (files[0] == "vars" || files[0] == "history") {
+		return importSection(fs, common, files[0], files[1], *format, *strategy)
+	}
+	if len(files) != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := common.load(fs)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	// Импортируемый файл только читается: файл старой схемы не переписывается
+	imported, err := persistence.ReadDataFile(files[0])
+	if err != nil {
+		return fail("Не удалось прочитать %s: %v", files[0], err)
+	}
+
+	pm, err := openStorage(cfg, actor("import"))
+	if err != nil {
+		return fail("%v", err)
+	}
+	// Закрытие на успешном пути проверяется ниже; повторное ничего не делает
+	defer pm.Close()
+	data := pm.LoadData()
+	if data == nil {
+		return fail("Не удалось прочитать %s", cfg.Storage.DataFile)
+	}
+
+	if data.Variables == nil {
+		data.Variables = make(map[string]interface{})
+	}
+	// Константы не перезаписываются; константы из файла остаются константами,
+	// описания переменных переносятся вместе с ними
+	readOnly := readOnlyIn(data.Constants)
+	names := make([]string, 0, len(imported.Variables))
+	for name := range imported.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	count := 0
+	for _, name := range names {
+		if readOnly(name) {
+			fmt.Printf("⚠️ %s не импортирована: константа только для чтения\n", name)
+			continue
+		}
+		data.Variables[name] = imported.Variables[name]
+		if meta, ok := imported.Metadata[name]; ok {
+			if data.Metadata == nil {
+				data.Metadata = make(map[string]persistence.VariableMeta)
+			}
+			data.Metadata[name] = meta
+		} else {
+			delete(data.Metadata, name)
+		}
+		count++
+	}
+	for _, name := range imported.Constants {
+		if _, ok := imported.Variables[name]; ok && !readOnly(name) {
+			data.Constants = append(data.Constants, name)
+		}
+	}
+	sort.Strings(data.Constants)
+	for _, entry := range imported.History {
+		data.AppendHistory(entry)
+	}
+
+	if !pm.SaveData(data) || pm.Close() != nil {
+		return fail("Не удалось сохранить %s", cfg.Storage.DataFile)
+	}
+	fmt.Printf("Импортировано: переменных %d, записей истории %d\n", count, len(imported.History))
+	return 0
+}
+
+// importSection - загрузка переменных (vars) или истории (history) из файла path
+func importSection(fs *flag.FlagSet, common *commonFlags, what, path, format, strategy string) int {
+	cfg, err := common.load(fs)
+	if err != nil {
+		return fail("%v", err)
+	}
+	if format, err = transferFormat(what, format, path); err != nil {
+		return fail("%v", err)
+	}
+	if strategy, err = transfer.ParseStrategy(strategy); err != nil {
+		return fail("%v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fail("Ошибка открытия файла: %v", err)
+	}
+	defer file.Close()
+	var vars map[string]interface{}
+	var entries []persistence.HistoryEntry
+	if what == "vars" {
+		vars, err = transfer.ReadVariables(file, format)
+	} else {
+		entries, err = transfer.ReadHistory(file, format)
+	}
+	if err != nil {
+		return fail("Не удалось прочитать %s: %v", path, err)
+	}
+
+	pm, err := openStorage(cfg, actor("import"))
+	if err != nil {
+		return fail("%v", err)
+	}
+	defer pm.Close()
+	state, err := workspaceState(pm, cfg)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	var stats transfer.Stats
+	saved := true
+	if what == "vars" {
+		var merged map[string]interface{}
+		var constants []string
+		state.View(func(data *persistence.CalculatorData) {
+			constants = append(constants, data.Constants...)
+		})
+		merged, stats = transfer.MergeVariables(state.LoadVariables(), vars, strategy, readOnlyIn(constants))
+		if stats.Changed() {
+			saved = state.SaveVariables(merged)
+		}
+	} else {
+		stats = history.NewHistoryManagerWithConfig(state, cfg).Import(entries, strategy)
+	}
+	if !saved || pm.Close() != nil {
+		return fail("Не удалось сохранить %s", cfg.Storage.DataFile)
+	}
+	fmt.Printf("Загружено из %s (%s, %s): %s\n", path, format, strategy, stats)
+	return 0
+}
+
+// ============================================================================
+// MIGRATE
+// ============================================================================
+
+// runMigrate - перенос основного состояния, рабочих пространств и сессий в другое хранилище
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	from := fs.String("from", "", "исходное хранилище: "+strings.Join(config.StorageBackends, ", ")+" (по умолчанию storage.backend)")
+	to := fs.String("to", "", "целевое хранилище: "+strings.Join(config.StorageBackends, ", "))
+	force := fs.Bool("force", false, "перезаписать данные, уже лежащие в целевом хранилище")
+	fs.Parse(args)
+
+	cfg, err := common.load(fs)
+	if err != nil {
+		return fail("%v", err)
+	}
+	if *from == "" {
+		*from = cfg.Storage.Backend
+	}
+	if *to == "" {
+		return fail("Использование: calc migrate --from json --to bolt [--force]")
+	}
+	if *from == *to {
+		return fail("Исходное и целевое хранилище совпадают: %s", *from)
+	}
+
+	source, err := persistence.NewStore(*from, cfg.Storage.DataFile, cfg.Storage.Backups)
+	if err != nil {
+		return fail("%v", err)
+	}
+	defer source.Close()
+	target, err := persistence.NewStore(*to, cfg.Storage.DataFile, cfg.Storage.Backups)
+	if err != nil {
+		return fail("%v", err)
+	}
+	defer target.Close()
+
+	if !*force {
+		// Пустое хранилище - то, в котором нет ни основного состояния, ни вложенных
+		workspaces, err := target.List("", persistence.KindWorkspaces)
+		if err != nil {
+			return fail("%v", err)
+		}
+		sessions, err := target.List("", persistence.KindSessions)
+		if err != nil {
+			return fail("%v", err)
+		}
+		if target.Exists("") || len(workspaces) > 0 || len(sessions) > 0 {
+			return fail("В хранилище %s уже есть данные; --force перезапишет их", *to)
+		}
+	}
+
+	copied, err := persistence.CopyStore(source, target)
+	if err != nil {
+		return fail("Ошибка переноса: %v", err)
+	}
+	if err := target.Close(); err != nil {
+		return fail("Ошибка записи: %v", err)
+	}
+	fmt.Printf("Перенесено состояний: %d (%s -> %s)\n", copied, *from, *to)
+	if cfg.Storage.Backend != *to {
+		fmt.Printf("Чтобы работать с новым хранилищем, укажите storage.backend: %s (CALC_STORAGE_BACKEND=%s)\n", *to, *to)
+	}
+	return 0
+}
+
+// ============================================================================
+// REKEY
+// ============================================================================
+
+// runRekey - смена ключа шифрования данных: текущий ключ берется из конфигурации,
+// новый - из флагов. Калькулятор на время смены ключа должен быть остановлен.
+func runRekey(args []string) int {
+	fs := flag.NewFlagSet("rekey", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	keyFile := fs.String("new-key-file", "", "файл с новым ключом (32 байта в hex или base64)")
+	keyEnv := fs.String("new-key-env", "", "переменная окружения с новым ключом")
+	generate := fs.String("generate", "", "создать случайный ключ, записать его в файл и зашифровать им")
+	passphrase := fs.Bool("new-passphrase", false, "ввести новый пароль с терминала")
+	passphraseEnv := fs.String("new-passphrase-env", "", "переменная окружения с новым паролем")
+	decrypt := fs.Bool("decrypt", false, "расшифровать данные")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Использование: calc rekey (--new-key-file файл | --new-key-env ИМЯ | --generate файл | --new-passphrase | --new-passphrase-env ИМЯ | --decrypt) [флаги]")
+		fmt.Fprintln(fs.Output(), "Текущий ключ берется из storage.encryption_*; без него данные считаются незашифрованными.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	sources := 0
+	for _, set := range []bool{*keyFile != "", *keyEnv != "", *generate != "", *passphrase, *passphraseEnv != "", *decrypt} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := common.load(fs)
+	if err != nil {
+		return fail("%v", err)
+	}
+	current, err := persistence.NewKeyWithConfig(cfg)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	var next *persistence.Key
+	hint := ""
+	switch {
+	case *keyFile != "":
+		next, err = persistence.ReadKeyFile(*keyFile)
+		hint = "storage.encryption_key_file: " + *keyFile
+	case *keyEnv != "":
+		next, err = persistence.ParseKey(os.Getenv(*keyEnv))
+		hint = "storage.encryption_key (CALC_ENCRYPTION_KEY) - значение " + *keyEnv
+	case *generate != "":
+		next, err = generateKeyFile(*generate)
+		hint = "storage.encryption_key_file: " + *generate
+	case *passphrase:
+		next, err = readPassphrase()
+		hint = "storage.encryption_passphrase (CALC_ENCRYPTION_PASSPHRASE) - новый пароль"
+	case *passphraseEnv != "":
+		next, err = persistence.NewPassphraseKey(os.Getenv(*passphraseEnv))
+		hint = "storage.encryption_passphrase (CALC_ENCRYPTION_PASSPHRASE) - значение " + *passphraseEnv
+	}
+	if err != nil {
+		return fail("Новый ключ: %v", err)
+	}
+
+	// Архив истории читается заранее: если он не открывается, ничего не меняется
+	writeArchive, err := history.RekeyArchive(cfg, current, next)
+	rekeyed := 0
+	if err == nil {
+		rekeyed, err = persistence.Rekey(cfg, current, next)
+	}
+	if err != nil {
+		if *generate != "" {
+			// Ключом ничего не зашифровано - файл не нужен
+			os.Remove(*generate)
+		}
+		return fail("Ошибка смены ключа: %v", err)
+	}
+	if err := writeArchive(); err != nil {
+		return fail("Ошибка смены ключа архива истории %s: %v", cfg.History.ArchiveFile, err)
+	}
+	if next == nil {
+		fmt.Printf("Расшифровано состояний: %d. Уберите storage.encryption_* из конфигурации\n", rekeyed)
+	} else {
+		fmt.Printf("Перешифровано состояний: %d. Укажите новый ключ: %s\n", rekeyed, hint)
+	}
+	return 0
+}
+
+// generateKeyFile - новый случайный ключ в файле, доступном только владельцу
+func generateKeyFile(path string) (*persistence.Key, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("%s уже существует", path)
+	}
+	text, err := persistence.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(text+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	return persistence.ParseKey(text)
+}
+
+// readPassphrase - новый пароль с терминала, дважды и без эха
+func readPassphrase() (*persistence.Key, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("пароль вводится только с терминала, используйте --new-passphrase-env")
+	}
+	fmt.Fprint(os.Stderr, "Новый пароль: ")
+	first, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprint(os.Stderr, "Повторите пароль: ")
+	second, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if string(first) != string(second) {
+		return nil, fmt.Errorf("пароли не совпадают")
+	}
+	return persistence.NewPassphraseKey(string(first))
+}
+
+// ============================================================================
+// AUDIT
+// ============================================================================
+
+// runAudit - кто, когда и что менял: события журнала рабочего пространства или сессии
+func runAudit(args []string) int {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	variable := fs.String("var", "", "только изменения переменной")
+	session := fs.String("session", "", "журнал веб-сессии")
+	limit := fs.Int("limit", 50, "сколько последних событий показать (0 - все)")
+	format := fs.String("format", "text", "формат вывода: text или json")
+	fs.Parse(args)
+
+	if *format != "text" && *format != "json" {
+		return fail("Неизвестный формат %q, доступны: text, json", *format)
+	}
+	cfg, err := common.load(fs)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	pm := persistence.NewPersistenceManagerWithConfig(cfg)
+	defer pm.Close()
+	state := pm
+	if *session != "" {
+		if !pm.SessionExists(*session) {
+			return fail("Сессия %s не найдена", *session)
+		}
+		state = pm.Session(*session)
+	}
+	events, err := state.Namespace(cfg.Storage.Workspace).Audit()
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	if *variable != "" {
+		filtered := events[:0]
+		for _, e := range events {
+			if (e.Type == persistence.EventVarSet || e.Type == persistence.EventVarDelete) && e.Name == *variable {
+				filtered = append(filtered, e)
+			}
+		}
+		events = filtered
+	}
+	if *limit > 0 && len(events) > *limit {
+		events = events[len(events)-*limit:]
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		for _, e := range events {
+			if err := encoder.Encode(e); err != nil {
+				return fail("Ошибка записи: %v", err)
+			}
+		}
+		return 0
+	}
+	for _, e := range events {
+		fmt.Printf("%6d  %s  %-20s %s\n", e.Seq, e.Time, e.Actor, e.Describe())
+	}
+	return 0
+}
+
+// ============================================================================
+// CONFIG
+// ============================================================================
+
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		return fail("Использование: calc config print [--format json|yaml|toml] [флаги]")
+	}
+
+	fs := flag.NewFlagSet("config print", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	format := fs.String("format", config.FormatYAML, "формат вывода: json, yaml, toml")
+	fs.String("addr", "", "адрес HTTP сервера")
+	fs.String("static-dir", "", "каталог статических файлов")
+	fs.Bool("no-browser", false, "не открывать браузер при запуске")
+	fs.Parse(args[1:])
+
+	cfg, err := common.load(fs)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	if err := cfg.Masked().Encode(os.Stdout, *format); err != nil {
+		return fail("%v", err)
+	}
+	return 0
+}
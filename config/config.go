@@ -1,50 +1,420 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"reflect"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// Значения по умолчанию
+const (
+	DefaultAddr      = ":8080"
+	DefaultDataFile  = "calculator_data.json"
+	DefaultStaticDir = "static"
+	DefaultEnvFile   = ".env"
+	DefaultBackups   = 3
+	DefaultBackend   = "json"
+)
+
+// StorageBackends - доступные хранилища состояния
+var StorageBackends = []string{"json", "jsonl", "bolt"}
+
+// Config - настройки всех подсистем приложения.
+// Приоритет источников (от низшего к высшему): значения по умолчанию,
+// файл конфигурации (JSON, YAML или TOML), переменные окружения, флаги командной строки.
 type Config struct {
-	Username    string
-	Password    string
-	DeepSeekURL string
+	Server    ServerConfig    `json:"server" yaml:"server" toml:"server"`
+	Storage   StorageConfig   `json:"storage" yaml:"storage" toml:"storage"`
+	AI        AIConfig        `json:"ai" yaml:"ai" toml:"ai"`
+	Curl      CurlConfig      `json:"curl" yaml:"curl" toml:"curl"`
+	Launcher  LauncherConfig  `json:"launcher" yaml:"launcher" toml:"launcher"`
+	History   HistoryConfig   `json:"history" yaml:"history" toml:"history"`
+	Variables VariablesConfig `json:"variables" yaml:"variables" toml:"variables"`
+	Output    OutputConfig    `json:"output" yaml:"output" toml:"output"`
+	Sessions  SessionsConfig  `json:"sessions" yaml:"sessions" toml:"sessions"`
+}
+
+// ServerConfig - веб-сервер
+type ServerConfig struct {
+	Addr        string `json:"addr" yaml:"addr" toml:"addr" env:"CALC_ADDR"`
+	StaticDir   string `json:"static_dir" yaml:"static_dir" toml:"static_dir" env:"CALC_STATIC_DIR"`
+	OpenBrowser bool   `json:"open_browser" yaml:"open_browser" toml:"open_browser" env:"CALC_OPEN_BROWSER"`
+	// FilesDir - каталог файлов для export/import и history export из веб-интерфейса;
+	// пусто - команды с файлом из веб-интерфейса отклоняются
+	FilesDir string `json:"files_dir" yaml:"files_dir" toml:"files_dir" env:"CALC_FILES_DIR"`
+}
+
+// StorageConfig - хранение состояния калькулятора
+type StorageConfig struct {
+	// Backend - формат хранения: json (файл на состояние), jsonl (журнал изменений), bolt (база bbolt)
+	Backend  string `json:"backend" yaml:"backend" toml:"backend" env:"CALC_STORAGE_BACKEND"`
+	DataFile string `json:"data_file" yaml:"data_file" toml:"data_file" env:"CALC_DATA_FILE"`
+	// Workspace - рабочее пространство при запуске; пусто - default
+	Workspace string `json:"workspace" yaml:"workspace" toml:"workspace" env:"CALC_WORKSPACE"`
+	// Backups - сколько предыдущих версий файла данных хранить рядом (.bak.1 - самая свежая); 0 - не хранить
+	Backups int `json:"backups" yaml:"backups" toml:"backups" env:"CALC_BACKUPS"`
+	// FlushInterval - через сколько после изменения состояние записывается в файл; 0 - сразу
+	FlushInterval Duration `json:"flush_interval" yaml:"flush_interval" toml:"flush_interval" env:"CALC_FLUSH_INTERVAL"`
+	// Journal - журнал событий: каждое изменение дописывается сразу, файл данных становится снимком
+	Journal bool `json:"journal" yaml:"journal" toml:"journal" env:"CALC_JOURNAL"`
+	// JournalMaxBytes - размер журнала, после которого он при сохранении снимка ротируется в .1, .2, ...
+	JournalMaxBytes int `json:"journal_max_bytes" yaml:"journal_max_bytes" toml:"journal_max_bytes" env:"CALC_JOURNAL_MAX_BYTES"`
+	// JournalKeep - сколько ротированных журналов хранить для calc audit
+	JournalKeep int `json:"journal_keep" yaml:"journal_keep" toml:"journal_keep" env:"CALC_JOURNAL_KEEP"`
+	// EncryptionKey - ключ AES-256-GCM для шифрования данных (32 байта в hex или base64); пусто - без шифрования
+	EncryptionKey string `json:"encryption_key" yaml:"encryption_key" toml:"encryption_key" env:"CALC_ENCRYPTION_KEY" secret:"true"`
+	// EncryptionKeyFile - файл с ключом шифрования (вместо encryption_key)
+	EncryptionKeyFile string `json:"encryption_key_file" yaml:"encryption_key_file" toml:"encryption_key_file" env:"CALC_ENCRYPTION_KEY_FILE"`
+	// EncryptionPassphrase - пароль, из которого ключ выводится через scrypt (вместо encryption_key)
+	EncryptionPassphrase string `json:"encryption_passphrase" yaml:"encryption_passphrase" toml:"encryption_passphrase" env:"CALC_ENCRYPTION_PASSPHRASE" secret:"true"`
+}
+
+// AIConfig - AI-ассистент DeepSeek
+type AIConfig struct {
+	Enabled  bool     `json:"enabled" yaml:"enabled" toml:"enabled" env:"CALC_AI"`
+	URL      string   `json:"url" yaml:"url" toml:"url" env:"DEEPSEEK_URL"`
+	Username string   `json:"username" yaml:"username" toml:"username" env:"USER"`
+	Password string   `json:"password" yaml:"password" toml:"password" env:"PASSWORD" secret:"true"`
+	Timeout  Duration `json:"timeout" yaml:"timeout" toml:"timeout" env:"CALC_AI_TIMEOUT"`
+}
+
+// CurlConfig - HTTP клиент команды curl
+type CurlConfig struct {
+	Timeout Duration `json:"timeout" yaml:"timeout" toml:"timeout" env:"CALC_CURL_TIMEOUT"`
+	// AllowedHosts - разрешенные хосты; пустой список разрешает все
+	AllowedHosts []string `json:"allowed_hosts" yaml:"allowed_hosts" toml:"allowed_hosts" env:"CALC_CURL_ALLOWED_HOSTS"`
+}
+
+// LauncherConfig - открытие браузера и медиафайлов
+type LauncherConfig struct {
+	// SafeDirectories - каталоги, из которых разрешено открывать файлы;
+	// пустой список означает стандартные каталоги пользователя
+	SafeDirectories []string `json:"safe_directories" yaml:"safe_directories" toml:"safe_directories" env:"CALC_SAFE_DIRECTORIES"`
+}
+
+// HistoryConfig - история команд
+type HistoryConfig struct {
+	MaxEntries int `json:"max_entries" yaml:"max_entries" toml:"max_entries" env:"CALC_HISTORY_MAX_ENTRIES"`
+	// MaxAge - записи старше удаляются из истории; 0 - без ограничения
+	MaxAge Duration `json:"max_age" yaml:"max_age" toml:"max_age" env:"CALC_HISTORY_MAX_AGE"`
+	// MaxBytes - предельный размер истории в JSON; 0 - без ограничения
+	MaxBytes int `json:"max_bytes" yaml:"max_bytes" toml:"max_bytes" env:"CALC_HISTORY_MAX_BYTES"`
+	// ArchiveFile - файл JSONL для удаленных записей; пустой - записи удаляются без архива
+	ArchiveFile string `json:"archive_file" yaml:"archive_file" toml:"archive_file" env:"CALC_HISTORY_ARCHIVE_FILE"`
+	// ArchiveMaxBytes - размер архива, после которого он ротируется в .1, .2, ...
+	ArchiveMaxBytes int `json:"archive_max_bytes" yaml:"archive_max_bytes" toml:"archive_max_bytes" env:"CALC_HISTORY_ARCHIVE_MAX_BYTES"`
+	// ArchiveKeep - сколько ротированных архивов хранить
+	ArchiveKeep int `json:"archive_keep" yaml:"archive_keep" toml:"archive_keep" env:"CALC_HISTORY_ARCHIVE_KEEP"`
+
+	RecentCount int `json:"recent_count" yaml:"recent_count" toml:"recent_count" env:"CALC_HISTORY_RECENT_COUNT"`
+	// ResponseLimit - сколько символов ответа AI и curl сохранять в истории; 0 - не сохранять ответ
+	ResponseLimit int `json:"response_limit" yaml:"response_limit" toml:"response_limit" env:"CALC_HISTORY_RESPONSE_LIMIT"`
+	// RedactPatterns - регулярные выражения для маскирования секретов перед сохранением.
+	// Если в выражении есть группа, скрывается только она, иначе все совпадение.
+	RedactPatterns []string `json:"redact_patterns" yaml:"redact_patterns" toml:"redact_patterns" env:"CALC_HISTORY_REDACT_PATTERNS"`
+}
+
+// DefaultRedactPatterns - заголовки авторизации и секреты в параметрах запроса
+var DefaultRedactPatterns = []string{
+	`(?i)\b(?:authorization|proxy-authorization|x-api-key|api-key|cookie)\s*:\s*([^"'\n]+)`,
+	`(?i)\b(?:token|access_token|password|passwd|secret|api_key|apikey)=([^&\s"']+)`,
+}
+
+// VariablesConfig - переменные калькулятора
+type VariablesConfig struct {
+	// UndoDepth - сколько изменений переменных можно отменить командой undo; 0 - отмена выключена
+	UndoDepth int `json:"undo_depth" yaml:"undo_depth" toml:"undo_depth" env:"CALC_UNDO_DEPTH"`
 }
 
+// OutputConfig - ограничения вывода команд
+type OutputConfig struct {
+	MaxOutputLength  int `json:"max_output_length" yaml:"max_output_length" toml:"max_output_length" env:"CALC_MAX_OUTPUT_LENGTH"`
+	MaxSummaryLength int `json:"max_summary_length" yaml:"max_summary_length" toml:"max_summary_length" env:"CALC_MAX_SUMMARY_LENGTH"`
+}
+
+// SessionsConfig - отдельное состояние для каждого клиента веб-сервера
+type SessionsConfig struct {
+	// Enabled - у каждого браузера свои переменные и история (по умолчанию); false - одно
+	// общее состояние из storage.data_file для всех клиентов, как до появления сессий
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled" env:"CALC_SESSIONS"`
+	// IdleTimeout - сессия без запросов дольше этого удаляется вместе с данными
+	IdleTimeout Duration `json:"idle_timeout" yaml:"idle_timeout" toml:"idle_timeout" env:"CALC_SESSION_IDLE_TIMEOUT"`
+	// MaxActive - сколько сессий держать в памяти; остальные выгружаются в хранилище
+	MaxActive int `json:"max_active" yaml:"max_active" toml:"max_active" env:"CALC_SESSION_MAX_ACTIVE"`
+	// MaxSessions - предел числа сессий, включая выгруженные; новые сверх него отклоняются
+	MaxSessions int `json:"max_sessions" yaml:"max_sessions" toml:"max_sessions" env:"CALC_SESSION_MAX"`
+}
+
+// Default - конфигурация со значениями по умолчанию
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:        DefaultAddr,
+			StaticDir:   DefaultStaticDir,
+			OpenBrowser: true,
+		},
+		Storage: StorageConfig{
+			Backend:         DefaultBackend,
+			DataFile:        DefaultDataFile,
+			Backups:         DefaultBackups,
+			FlushInterval:   Duration(time.Second),
+			Journal:         true,
+			JournalMaxBytes: 4 << 20,
+			JournalKeep:     3,
+		},
+		AI: AIConfig{
+			Enabled: true,
+			Timeout: Duration(30 * time.Second),
+		},
+		Curl: CurlConfig{
+			Timeout:      Duration(10 * time.Second),
+			AllowedHosts: []string{},
+		},
+		Launcher: LauncherConfig{
+			SafeDirectories: []string{},
+		},
+		History: HistoryConfig{
+			MaxEntries:      100,
+			ArchiveMaxBytes: 1 << 20,
+			ArchiveKeep:     5,
+			RecentCount:     10,
+			ResponseLimit:   200,
+			RedactPatterns:  append([]string{}, DefaultRedactPatterns...),
+		},
+		Variables: VariablesConfig{
+			UndoDepth: 50,
+		},
+		Output: OutputConfig{
+			MaxOutputLength:  1000,
+			MaxSummaryLength: 500,
+		},
+		Sessions: SessionsConfig{
+			Enabled:     true,
+			IdleTimeout: Duration(24 * time.Hour),
+			MaxActive:   100,
+			MaxSessions: 1000,
+		},
+	}
+}
+
+// Load - значения по умолчанию и переменные окружения (включая .env).
+// Ошибки в переменных окружения игнорируются, как и раньше.
 func Load() *Config {
-	// Загрузка .env файла
-	err := godotenv.Load(".env")
+	cfg := Default()
+	loadEnvFile()
+	cfg.applyEnv()
+	return cfg
+}
+
+// LoadFile - значения по умолчанию, затем файл конфигурации, затем переменные окружения.
+// Пустой путь означает работу без файла.
+func LoadFile(path string) (*Config, error) {
+	cfg := Default()
+	loadEnvFile()
+
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadEnvFile - загрузка .env файла (путь можно переопределить через CALC_ENV_FILE)
+func loadEnvFile() {
+	envFile := getEnv("CALC_ENV_FILE")
+	if envFile == "" {
+		envFile = DefaultEnvFile
+	}
+
+	err := godotenv.Load(envFile)
 	if err != nil {
 		log.Println("No .env file found, using system environment variables")
 	}
+}
 
-	return &Config{
-		Username:    getEnv("USER"),
-		Password:    getEnv("PASSWORD"),
-		DeepSeekURL: getEnv("DEEPSEEK_URL"),
+// applyEnv - перенос значений из переменных окружения по тегам env
+func (c *Config) applyEnv() error {
+	errs := make([]string, 0)
+	c.walk(func(f field) {
+		if f.env == "" {
+			return
+		}
+		value := getEnv(f.env)
+		if value == "" {
+			return
+		}
+		if err := setFromString(f.value, value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s=%q: %v", f.env, value, err))
+		}
+	})
+
+	if len(errs) > 0 {
+		return fmt.Errorf("некорректные переменные окружения:\n  %s", strings.Join(errs, "\n  "))
 	}
+	return nil
 }
 
-func getEnv(key string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// Set - установка значения по ключу вида "section.name" (например, "server.addr")
+func (c *Config) Set(key, value string) error {
+	found := false
+	var err error
+	c.walk(func(f field) {
+		if f.key == key {
+			found = true
+			err = setFromString(f.value, value)
+		}
+	})
+
+	if !found {
+		return fmt.Errorf("неизвестный параметр %q", key)
 	}
-	return ""
+	if err != nil {
+		return fmt.Errorf("%s: %v", key, err)
+	}
+	return nil
+}
+
+// Clone - глубокая копия конфигурации
+func (c *Config) Clone() *Config {
+	clone := *c
+	clone.Curl.AllowedHosts = append([]string{}, c.Curl.AllowedHosts...)
+	clone.Launcher.SafeDirectories = append([]string{}, c.Launcher.SafeDirectories...)
+	clone.History.RedactPatterns = append([]string{}, c.History.RedactPatterns...)
+	return &clone
 }
 
-func getEnvAsBool(key string, defaultValue bool) bool {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
+// Masked - копия конфигурации со скрытыми секретами (для вывода и логов)
+func (c *Config) Masked() *Config {
+	masked := c.Clone()
+	masked.walk(func(f field) {
+		if f.secret && f.value.Kind() == reflect.String && f.value.String() != "" {
+			f.value.SetString("******")
+		}
+	})
+	return masked
+}
+
+// ============================================================================
+// ОБХОД ПОЛЕЙ
+// ============================================================================
+
+// field - конечное поле конфигурации
+type field struct {
+	key    string // section.name
+	env    string
+	secret bool
+	value  reflect.Value
+}
+
+func (c *Config) walk(fn func(f field)) {
+	root := reflect.ValueOf(c).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Field(i)
+		sectionName := tagName(root.Type().Field(i))
+		for j := 0; j < section.NumField(); j++ {
+			sf := section.Type().Field(j)
+			fn(field{
+				key:    sectionName + "." + tagName(sf),
+				env:    sf.Tag.Get("env"),
+				secret: sf.Tag.Get("secret") == "true",
+				value:  section.Field(j),
+			})
+		}
 	}
+}
+
+func tagName(sf reflect.StructField) string {
+	return strings.Split(sf.Tag.Get("json"), ",")[0]
+}
 
-	boolValue, err := strconv.ParseBool(value)
+// setFromString - разбор строкового значения в поле по его типу
+func setFromString(v reflect.Value, value string) error {
+	switch target := v.Addr().Interface().(type) {
+	case *Duration:
+		return target.UnmarshalText([]byte(value))
+	case *bool:
+		b, err := ParseSwitch(value)
+		if err != nil {
+			return fmt.Errorf("ожидается on/off или true/false")
+		}
+		*target = b
+	case *int:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("ожидается целое число")
+		}
+		*target = n
+	case *string:
+		*target = value
+	case *[]string:
+		*target = splitList(value)
+	default:
+		return fmt.Errorf("неподдерживаемый тип %s", v.Type())
+	}
+	return nil
+}
+
+// splitList - список через запятую
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ParseSwitch - разбор значений вида on/off, true/false, 1/0
+func ParseSwitch(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "on", "yes":
+		return true, nil
+	case "off", "no":
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// ============================================================================
+// DURATION
+// ============================================================================
+
+// Duration - длительность, записываемая в файлах строкой вида "30s" или "1m"
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(strings.TrimSpace(string(text)))
 	if err != nil {
-		return defaultValue
+		return fmt.Errorf("ожидается длительность вида 30s, 1m: %v", err)
 	}
+	*d = Duration(parsed)
+	return nil
+}
 
-	return boolValue
+// Std - значение как time.Duration
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+func getEnv(key string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return ""
 }
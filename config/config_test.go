@@ -0,0 +1,158 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadFileFormats(t *testing.T) {
+	files := map[string]string{
+		"calc.json": `{"server": {"addr": ":9000"}, "curl": {"timeout": "5s", "allowed_hosts": ["example.com"]}}`,
+		"calc.yaml": "server:\n  addr: \":9000\"\ncurl:\n  timeout: 5s\n  allowed_hosts: [example.com]\n",
+		"calc.toml": "[server]\naddr = \":9000\"\n[curl]\ntimeout = \"5s\"\nallowed_hosts = [\"example.com\"]\n",
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := LoadFile(writeConfigFile(t, name, content))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if cfg.Server.Addr != ":9000" {
+				t.Errorf("Expected addr :9000, got %s", cfg.Server.Addr)
+			}
+			if cfg.Curl.Timeout.Std() != 5*time.Second {
+				t.Errorf("Expected curl timeout 5s, got %v", cfg.Curl.Timeout.Std())
+			}
+			if len(cfg.Curl.AllowedHosts) != 1 || cfg.Curl.AllowedHosts[0] != "example.com" {
+				t.Errorf("Unexpected allowed hosts: %v", cfg.Curl.AllowedHosts)
+			}
+			// Значения, не указанные в файле, остаются по умолчанию
+			if cfg.History.MaxEntries != 100 {
+				t.Errorf("Expected default max entries, got %d", cfg.History.MaxEntries)
+			}
+		})
+	}
+}
+
+func TestLoadFileUnknownKey(t *testing.T) {
+	path := writeConfigFile(t, "calc.yaml", "server:\n  adr: \":9000\"\n")
+	if _, err := LoadFile(path); err == nil {
+		t.Error("Expected error for unknown key")
+	}
+}
+
+func TestEnvOverridesFile(t *testing.T) {
+	path := writeConfigFile(t, "calc.json", `{"server": {"addr": ":9000"}, "ai": {"enabled": true}}`)
+	t.Setenv("CALC_ADDR", ":9100")
+	t.Setenv("CALC_AI", "off")
+	t.Setenv("CALC_CURL_ALLOWED_HOSTS", "a.com, b.com")
+
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Server.Addr != ":9100" {
+		t.Errorf("Expected env addr :9100, got %s", cfg.Server.Addr)
+	}
+	if cfg.AI.Enabled {
+		t.Error("Expected AI disabled by env")
+	}
+	if strings.Join(cfg.Curl.AllowedHosts, ",") != "a.com,b.com" {
+		t.Errorf("Unexpected allowed hosts: %v", cfg.Curl.AllowedHosts)
+	}
+}
+
+func TestSessionsEnabledByDefault(t *testing.T) {
+	if !Default().Sessions.Enabled {
+		t.Error("Expected separate web sessions by default")
+	}
+	t.Setenv("CALC_SESSIONS", "false")
+	cfg, err := LoadFile(writeConfigFile(t, "calc.json", `{}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Sessions.Enabled {
+		t.Error("Expected shared state with CALC_SESSIONS=false")
+	}
+}
+
+func TestInvalidEnv(t *testing.T) {
+	t.Setenv("CALC_HISTORY_MAX_ENTRIES", "many")
+	if _, err := LoadFile(""); err == nil || !strings.Contains(err.Error(), "CALC_HISTORY_MAX_ENTRIES") {
+		t.Errorf("Expected error naming the variable, got %v", err)
+	}
+}
+
+func TestSet(t *testing.T) {
+	cfg := Default()
+	if err := cfg.Set("ai.timeout", "1m"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.AI.Timeout.Std() != time.Minute {
+		t.Errorf("Expected 1m, got %v", cfg.AI.Timeout.Std())
+	}
+	if err := cfg.Set("ai.nope", "1"); err == nil {
+		t.Error("Expected error for unknown key")
+	}
+	if err := cfg.Set("server.open_browser", "maybe"); err == nil {
+		t.Error("Expected error for invalid bool")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("Default config should be valid: %v", err)
+	}
+
+	cfg := Default()
+	cfg.Server.Addr = "nope"
+	cfg.AI.URL = "ftp://example.com"
+	cfg.History.MaxEntries = 0
+	cfg.Storage.Backups = -1
+	cfg.Storage.Backend = "sqlite"
+	cfg.Storage.JournalMaxBytes = 0
+	cfg.Storage.JournalKeep = -1
+	cfg.Storage.EncryptionKey = "key"
+	cfg.Storage.EncryptionPassphrase = "passphrase"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected validation error")
+	}
+	for _, key := range []string{"server.addr", "ai.url", "history.max_entries", "storage.backups", "storage.backend", "storage.journal_max_bytes", "storage.journal_keep", "storage.encryption_key"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("Expected %s in error, got: %v", key, err)
+		}
+	}
+}
+
+func TestMaskedHidesSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.AI.Password = "hunter2"
+	cfg.Storage.EncryptionPassphrase = "hunter2"
+
+	var buf bytes.Buffer
+	if err := cfg.Masked().Encode(&buf, FormatJSON); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Errorf("Password leaked: %s", buf.String())
+	}
+	if cfg.AI.Password != "hunter2" {
+		t.Error("Masked must not modify original config")
+	}
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"go.yaml.in/yaml/v2"
+)
+
+// Поддерживаемые форматы файла конфигурации
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// FormatFromPath - формат файла по расширению
+func FormatFromPath(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON, nil
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	case ".toml":
+		return FormatTOML, nil
+	}
+	return "", fmt.Errorf("неизвестный формат файла конфигурации %s (ожидается .json, .yaml, .yml или .toml)", path)
+}
+
+// readFile - наложение значений из файла поверх текущих.
+// Неизвестные параметры считаются ошибкой, чтобы опечатки не терялись.
+func (c *Config) readFile(path string) error {
+	format, err := FormatFromPath(path)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения файла конфигурации: %v", err)
+	}
+
+	if err := c.decode(data, format); err != nil {
+		return fmt.Errorf("ошибка разбора файла конфигурации %s: %v", path, err)
+	}
+	return nil
+}
+
+func (c *Config) decode(data []byte, format string) error {
+	switch format {
+	case FormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		return decoder.Decode(c)
+	case FormatYAML:
+		return yaml.UnmarshalStrict(data, c)
+	case FormatTOML:
+		meta, err := toml.Decode(string(data), c)
+		if err != nil {
+			return err
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, len(undecoded))
+			for i, key := range undecoded {
+				keys[i] = key.String()
+			}
+			return fmt.Errorf("неизвестные параметры: %s", strings.Join(keys, ", "))
+		}
+		return nil
+	}
+	return fmt.Errorf("неизвестный формат %q", format)
+}
+
+// Encode - запись конфигурации в заданном формате
+func (c *Config) Encode(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(c)
+	case FormatYAML:
+		data, err := yaml.Marshal(c)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case FormatTOML:
+		return toml.NewEncoder(w).Encode(c)
+	}
+	return fmt.Errorf("неизвестный формат %q (json, yaml, toml)", format)
+}
+
+// Values - плоский список параметров "section.name" -> значение (секреты скрыты)
+func (c *Config) Values() map[string]string {
+	values := make(map[string]string)
+	c.Masked().walk(func(f field) {
+		values[f.key] = formatValue(f)
+	})
+	return values
+}
+
+// Keys - отсортированные ключи параметров
+func Keys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatValue(f field) string {
+	switch v := f.value.Interface().(type) {
+	case Duration:
+		return v.Std().String()
+	case []string:
+		return strings.Join(v, ",")
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"app/metrics"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultWatchInterval - период проверки файла конфигурации
+const DefaultWatchInterval = 2 * time.Second
+
+// Change - изменение одного параметра
+type Change struct {
+	Key string
+	Old string
+	New string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %q -> %q", c.Key, c.Old, c.New)
+}
+
+// Diff - различия между конфигурациями в порядке ключей; секреты скрыты
+func Diff(old, new *Config) []Change {
+	oldValues := make(map[string]string)
+	old.walk(func(f field) {
+		oldValues[f.key] = formatValue(f)
+	})
+
+	changes := make([]Change, 0)
+	new.walk(func(f field) {
+		before, after := oldValues[f.key], formatValue(f)
+		if before == after {
+			return
+		}
+		if f.secret {
+			before, after = "******", "******"
+		}
+		changes = append(changes, Change{Key: f.key, Old: before, New: after})
+	})
+	return changes
+}
+
+// RestartRequired - параметры, которые применяются только при запуске
+func RestartRequired(key string) bool {
+	return (strings.HasPrefix(key, "server.") && key != "server.files_dir") || strings.HasPrefix(key, "storage.") || key == "sessions.enabled"
+}
+
+// Watcher - перечитывание конфигурации при изменении файла или по сигналу SIGHUP.
+// Новая конфигурация проверяется целиком и применяется только если она корректна.
+type Watcher struct {
+	path     string
+	interval time.Duration
+	load     func() (*Config, error)
+	apply    func(*Config)
+
+	mu      sync.Mutex
+	current *Config
+	modTime time.Time
+	size    int64
+}
+
+// NewWatcher - наблюдатель за файлом path (пустой путь - только SIGHUP).
+// load собирает конфигурацию из всех источников без проверки, apply применяет ее.
+func NewWatcher(path string, current *Config, load func() (*Config, error), apply func(*Config)) *Watcher {
+	w := &Watcher{
+		path:     path,
+		interval: DefaultWatchInterval,
+		load:     load,
+		apply:    apply,
+		current:  current,
+	}
+	w.modTime, w.size = w.stat()
+	return w
+}
+
+// Current - последняя примененная конфигурация
+func (w *Watcher) Current() *Config {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.current
+}
+
+// Run - наблюдение до отмены контекста
+func (w *Watcher) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			w.Reload("SIGHUP")
+		case <-ticker.C:
+			if w.path == "" {
+				continue
+			}
+			modTime, size := w.stat()
+			if modTime.Equal(w.modTime) && size == w.size {
+				continue
+			}
+			w.modTime, w.size = modTime, size
+			w.Reload("изменен " + w.path)
+		}
+	}
+}
+
+// Reload - перечитывание и применение конфигурации.
+// При ошибке текущие настройки сохраняются, а в лог выводится ошибка и отклоненные изменения.
+func (w *Watcher) Reload(reason string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	next, err := w.load()
+	if err != nil {
+		metrics.ConfigReloads.WithLabelValues("error").Inc()
+		log.Printf("❌ Конфигурация не перезагружена (%s): %v", reason, err)
+		return err
+	}
+
+	changes := Diff(w.current, next)
+	if err := next.Validate(); err != nil {
+		metrics.ConfigReloads.WithLabelValues("error").Inc()
+		log.Printf("❌ Конфигурация отклонена (%s): %v%s", reason, err, formatChanges(changes))
+		return err
+	}
+
+	if len(changes) == 0 {
+		metrics.ConfigReloads.WithLabelValues("unchanged").Inc()
+		return nil
+	}
+
+	w.apply(next)
+	w.current = next
+	metrics.ConfigReloads.WithLabelValues("success").Inc()
+	log.Printf("🔄 Конфигурация перезагружена (%s)%s", reason, formatChanges(changes))
+
+	for _, change := range changes {
+		if RestartRequired(change.Key) {
+			log.Printf("⚠️  %s применится только после перезапуска", change.Key)
+		}
+	}
+	return nil
+}
+
+func (w *Watcher) stat() (time.Time, int64) {
+	if w.path == "" {
+		return time.Time{}, 0
+	}
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return time.Time{}, 0
+	}
+	return info.ModTime(), info.Size()
+}
+
+func formatChanges(changes []Change) string {
+	if len(changes) == 0 {
+		return ""
+	}
+	lines := make([]string, len(changes))
+	for idx, change := range changes {
+		lines[idx] = change.String()
+	}
+	return "\n  " + strings.Join(lines, "\n  ")
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDiffMasksSecrets(t *testing.T) {
+	old := Default()
+	next := old.Clone()
+	next.AI.Password = "secret"
+	next.Curl.AllowedHosts = []string{"example.com"}
+
+	changes := Diff(old, next)
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %v", changes)
+	}
+	if changes[0].Key != "ai.password" || strings.Contains(changes[0].String(), "secret") {
+		t.Errorf("Unexpected password change: %v", changes[0])
+	}
+	if changes[1].Key != "curl.allowed_hosts" || changes[1].New != "example.com" {
+		t.Errorf("Unexpected hosts change: %v", changes[1])
+	}
+}
+
+func TestWatcherReload(t *testing.T) {
+	path := writeConfigFile(t, "calc.yaml", "curl:\n  timeout: 5s\n")
+	current, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	applied := 0
+	w := NewWatcher(path, current, func() (*Config, error) { return LoadFile(path) }, func(*Config) { applied++ })
+
+	// Без изменений применять нечего
+	if err := w.Reload("test"); err != nil || applied != 0 {
+		t.Fatalf("Expected no-op reload, got err=%v applied=%d", err, applied)
+	}
+
+	// Некорректная конфигурация отклоняется, текущая сохраняется
+	os.WriteFile(path, []byte("history:\n  max_entries: 0\n"), 0644)
+	if err := w.Reload("test"); err == nil {
+		t.Fatal("Expected invalid config to be rejected")
+	}
+	if applied != 0 || w.Current() != current {
+		t.Error("Rejected config must not be applied")
+	}
+
+	os.WriteFile(path, []byte("curl:\n  allowed_hosts: [example.com]\n"), 0644)
+	if err := w.Reload("test"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if applied != 1 || len(w.Current().Curl.AllowedHosts) != 1 {
+		t.Errorf("Expected new config to be applied, got applied=%d hosts=%v", applied, w.Current().Curl.AllowedHosts)
+	}
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Validate - проверка конфигурации; возвращает все найденные проблемы одной ошибкой
+func (c *Config) Validate() error {
+	problems := make([]string, 0)
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		add("server.addr: %q не является адресом вида host:port или :port", c.Server.Addr)
+	}
+	if strings.TrimSpace(c.Server.StaticDir) == "" {
+		add("server.static_dir: не задан каталог статических файлов")
+	}
+
+	if !slices.Contains(StorageBackends, c.Storage.Backend) {
+		add("storage.backend: неизвестное хранилище %q, доступны: %s", c.Storage.Backend, strings.Join(StorageBackends, ", "))
+	}
+	if strings.TrimSpace(c.Storage.DataFile) == "" {
+		add("storage.data_file: не задан файл данных")
+	}
+	if c.Storage.Backups < 0 {
+		add("storage.backups: не может быть отрицательным")
+	}
+	if c.Storage.FlushInterval < 0 {
+		add("storage.flush_interval: не может быть отрицательным")
+	}
+	if c.Storage.Journal && c.Storage.JournalMaxBytes <= 0 {
+		add("storage.journal_max_bytes: должно быть больше нуля")
+	}
+	if c.Storage.JournalKeep < 0 {
+		add("storage.journal_keep: не может быть отрицательным")
+	}
+	keys := 0
+	for _, value := range []string{c.Storage.EncryptionKey, c.Storage.EncryptionKeyFile, c.Storage.EncryptionPassphrase} {
+		if value != "" {
+			keys++
+		}
+	}
+	if keys > 1 {
+		add("storage.encryption_key: задайте только один из encryption_key, encryption_key_file, encryption_passphrase")
+	}
+
+	if c.AI.URL != "" {
+		if u, err := url.Parse(c.AI.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("ai.url: %q не является http(s) URL", c.AI.URL)
+		}
+	}
+	if c.AI.Timeout <= 0 {
+		add("ai.timeout: должен быть больше нуля")
+	}
+
+	if c.Curl.Timeout <= 0 {
+		add("curl.timeout: должен быть больше нуля")
+	}
+	for _, host := range c.Curl.AllowedHosts {
+		if strings.TrimSpace(host) == "" || strings.Contains(host, "/") {
+			add("curl.allowed_hosts: %q не является именем хоста", host)
+		}
+	}
+
+	for _, dir := range c.Launcher.SafeDirectories {
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() {
+			add("launcher.safe_directories: каталог %q не существует", dir)
+		}
+	}
+
+	if c.History.MaxEntries <= 0 {
+		add("history.max_entries: должно быть больше нуля")
+	}
+	if c.History.MaxAge < 0 {
+		add("history.max_age: не может быть отрицательным")
+	}
+	if c.History.MaxBytes < 0 {
+		add("history.max_bytes: не может быть отрицательным")
+	}
+	if c.History.ArchiveFile != "" {
+		if c.History.ArchiveMaxBytes <= 0 {
+			add("history.archive_max_bytes: должно быть больше нуля")
+		}
+		if c.History.ArchiveKeep < 1 {
+			add("history.archive_keep: должно быть не меньше 1")
+		}
+	}
+	if c.History.RecentCount < 0 {
+		add("history.recent_count: не может быть отрицательным")
+	}
+	if c.History.ResponseLimit < 0 {
+		add("history.response_limit: не может быть отрицательным")
+	}
+	for _, pattern := range c.History.RedactPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			add("history.redact_patterns: некорректное выражение %q: %v", pattern, err)
+		}
+	}
+
+	if c.Variables.UndoDepth < 0 {
+		add("variables.undo_depth: не может быть отрицательным")
+	}
+
+	if c.Output.MaxOutputLength <= 0 {
+		add("output.max_output_length: должно быть больше нуля")
+	}
+	if c.Output.MaxSummaryLength <= 0 {
+		add("output.max_summary_length: должно быть больше нуля")
+	}
+
+	if c.Sessions.IdleTimeout <= 0 {
+		add("sessions.idle_timeout: должен быть больше нуля")
+	}
+	if c.Sessions.MaxActive <= 0 {
+		add("sessions.max_active: должно быть больше нуля")
+	}
+	if c.Sessions.MaxSessions < c.Sessions.MaxActive {
+		add("sessions.max_sessions: не может быть меньше sessions.max_active")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("некорректная конфигурация:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
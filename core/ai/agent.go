@@ -7,7 +7,7 @@ import (
 	"io"
 	"net/http"
 	"strings"
-	"time"
+	"sync"
 )
 
 // TokenCredential - учетные данные для одного токена
@@ -22,24 +22,49 @@ type DeepSeekClient struct {
 	credentials       TokenCredential
 	currentTokenIndex int
 	client            *http.Client
+	mu                sync.RWMutex
 }
 
 func NewDeepSeekClient() *DeepSeekClient {
-	config := config.Load()
+	return NewDeepSeekClientWithConfig(config.Load())
+}
 
+func NewDeepSeekClientWithConfig(cfg *config.Config) *DeepSeekClient {
 	return &DeepSeekClient{
-		baseURL: config.DeepSeekURL,
+		baseURL: cfg.AI.URL,
 		credentials: TokenCredential{
-			Username: config.Username,
-			Password: config.Password,
+			Username: cfg.AI.Username,
+			Password: cfg.AI.Password,
 		},
 		currentTokenIndex: 0,
 		client: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout: cfg.AI.Timeout.Std(),
 		},
 	}
 }
 
+// Reconfigure - применение новых настроек AI без пересоздания клиента.
+// Запросы, начатые до вызова, завершаются со старыми настройками.
+func (d *DeepSeekClient) Reconfigure(cfg *config.Config) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.baseURL = cfg.AI.URL
+	d.credentials = TokenCredential{
+		Username: cfg.AI.Username,
+		Password: cfg.AI.Password,
+	}
+	d.client = &http.Client{
+		Timeout: cfg.AI.Timeout.Std(),
+	}
+}
+
+// settings - согласованный снимок настроек для одного запроса
+func (d *DeepSeekClient) settings() (string, TokenCredential, *http.Client) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.baseURL, d.credentials, d.client
+}
+
 type TokenStatus struct {
 	DailyLimit    int    `json:"daily_limit"`
 	Remaining     int    `json:"remaining"`
@@ -48,16 +73,16 @@ type TokenStatus struct {
 }
 
 func (d *DeepSeekClient) CheckTokenStatus() (*TokenStatus, error) {
-	req, err := http.NewRequest("GET", d.baseURL+"/status", nil)
+	baseURL, credentials, client := d.settings()
+	req, err := http.NewRequest("GET", baseURL+"/status", nil)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка создания запроса: %v", err)
 	}
-	fmt.Printf("Проверка статуса токена для пользователя %s\n", d.credentials.Username)
-	fmt.Printf("Используем DeepSeek URL: %s\n", d.baseURL)
-	fmt.Printf("Используем DeepSeek Username: %s\n", d.credentials.Password)
-	req.SetBasicAuth(d.credentials.Username, d.credentials.Password)
+	fmt.Printf("Проверка статуса токена для пользователя %s\n", credentials.Username)
+	fmt.Printf("Используем DeepSeek URL: %s\n", baseURL)
+	req.SetBasicAuth(credentials.Username, credentials.Password)
 
-	response, err := d.client.Do(req)
+	response, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка выполнения запроса: %v", err)
 	}
@@ -167,6 +192,7 @@ func (d *DeepSeekClient) GetAIResponse(userInput string) (string, error) {
 
 // makeDeepSeekRequest - базовый метод для запросов к DeepSeek
 func (d *DeepSeekClient) makeDeepSeekRequest(systemPrompt, userPrompt string) (string, error) {
+	baseURL, credentials, client := d.settings()
 	payload := DeepSeekRequest{
 		Model: "deepseek-chat",
 		Messages: []Message{
@@ -182,25 +208,25 @@ func (d *DeepSeekClient) makeDeepSeekRequest(systemPrompt, userPrompt string) (s
 		return "", fmt.Errorf("ошибка маршалинга JSON: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", d.baseURL+"/completions", strings.NewReader(string(jsonData)))
+	req, err := http.NewRequest("POST", baseURL+"/completions", strings.NewReader(string(jsonData)))
 	if err != nil {
 
-		fmt.Printf("Ошибка создания запроса с токеном %s: %v\n", d.credentials.Username, err)
+		fmt.Printf("Ошибка создания запроса с токеном %s: %v\n", credentials.Username, err)
 	}
 
-	req.SetBasicAuth(d.credentials.Username, d.credentials.Password)
+	req.SetBasicAuth(credentials.Username, credentials.Password)
 	req.Header.Set("Content-Type", "application/json")
 
-	response, err := d.client.Do(req)
+	response, err := client.Do(req)
 	if err != nil {
-		fmt.Printf("Ошибка запроса с токеном %s: %v\n", d.credentials.Username, err)
+		fmt.Printf("Ошибка запроса с токеном %s: %v\n", credentials.Username, err)
 
 	}
 	defer response.Body.Close()
 
 	body, err := io.ReadAll(response.Body)
 	if err != nil {
-		fmt.Printf("Ошибка чтения ответа с токеном %s: %v\n", d.credentials.Username, err)
+		fmt.Printf("Ошибка чтения ответа с токеном %s: %v\n", credentials.Username, err)
 
 	}
 
@@ -210,12 +236,12 @@ func (d *DeepSeekClient) makeDeepSeekRequest(systemPrompt, userPrompt string) (s
 
 	var result DeepSeekResponse
 	if err := json.Unmarshal(body, &result); err != nil {
-		fmt.Printf("Ошибка парсинга JSON с токеном %s: %v\n", d.credentials.Username, err)
+		fmt.Printf("Ошибка парсинга JSON с токеном %s: %v\n", credentials.Username, err)
 
 	}
 
 	if result.Error != nil {
-		fmt.Printf("API ошибка с токеном %s: %s\n", d.credentials.Username, result.Error.Message)
+		fmt.Printf("API ошибка с токеном %s: %s\n", credentials.Username, result.Error.Message)
 
 	}
 
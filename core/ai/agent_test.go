@@ -1,7 +1,9 @@
 package agent
 
 import (
+	"app/config"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -112,6 +114,33 @@ func TestCheckTokenStatus(t *testing.T) {
 	}
 }
 
+func TestCheckTokenStatusHidesPassword(t *testing.T) {
+	client, server := setupTestClient()
+	defer server.Close()
+	client.Reconfigure(&config.Config{AI: config.AIConfig{URL: server.URL, Username: "testuser", Password: "s3cret-pass"}})
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	_, statusErr := client.CheckTokenStatus()
+	os.Stdout = stdout
+	writer.Close()
+	output, _ := io.ReadAll(reader)
+
+	if statusErr != nil {
+		t.Fatalf("Unexpected error: %v", statusErr)
+	}
+	if strings.Contains(string(output), "s3cret-pass") {
+		t.Errorf("Password must not be printed:\n%s", output)
+	}
+	if !strings.Contains(string(output), "testuser") {
+		t.Errorf("Expected the username in the output:\n%s", output)
+	}
+}
+
 func TestClassifyRequest(t *testing.T) {
 	client, server := setupTestClient()
 	defer server.Close()
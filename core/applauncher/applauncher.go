@@ -1,27 +1,48 @@
 package applauncher
 
 import (
+	"app/config"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 )
 
 type AppLauncher struct {
 	system          string
 	safeDirectories []string
+	mu              sync.RWMutex
 }
 
 func NewAppLauncher() *AppLauncher {
+	return NewAppLauncherWithConfig(config.Default())
+}
+
+// NewAppLauncherWithConfig - безопасные директории из конфигурации,
+// если список пуст - стандартные каталоги пользователя
+func NewAppLauncherWithConfig(cfg *config.Config) *AppLauncher {
 	launcher := &AppLauncher{
 		system: runtime.GOOS,
 	}
-	launcher.safeDirectories = launcher.getSafeDirectories()
+	launcher.Reconfigure(cfg)
 	return launcher
 }
 
+// Reconfigure - замена списка безопасных директорий во время работы
+func (a *AppLauncher) Reconfigure(cfg *config.Config) {
+	dirs := a.getSafeDirectories()
+	if len(cfg.Launcher.SafeDirectories) > 0 {
+		dirs = append([]string{}, cfg.Launcher.SafeDirectories...)
+	}
+
+	a.mu.Lock()
+	a.safeDirectories = dirs
+	a.mu.Unlock()
+}
+
 // getSafeDirectories - определение безопасных директорий
 func (a *AppLauncher) getSafeDirectories() []string {
 	homeDir, err := os.UserHomeDir()
@@ -70,7 +91,7 @@ func (a *AppLauncher) isSafePath(filePath string) bool {
 	}
 
 	// Проверяем, находится ли файл в безопасной директории
-	for _, safeDir := range a.safeDirectories {
+	for _, safeDir := range a.GetSafeDirectories() {
 		safeAbs, _ := filepath.Abs(safeDir)
 		if strings.HasPrefix(absPath, safeAbs) {
 			return true
@@ -196,6 +217,8 @@ func (a *AppLauncher) OpenFile(filePath string) bool {
 
 // GetSafeDirectories - получение списка безопасных директорий (для отладки)
 func (a *AppLauncher) GetSafeDirectories() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
 	return a.safeDirectories
 }
 
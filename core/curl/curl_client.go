@@ -1,28 +1,82 @@
 package curl
 
 import (
+	"app/config"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
-	"time"
+	"sync"
 )
 
 // CurlClient - HTTP клиент для выполнения запросов
 type CurlClient struct {
-	client *http.Client
+	client       *http.Client
+	allowedHosts []string
+	mu           sync.RWMutex
 }
 
 func NewCurlClient() *CurlClient {
+	return NewCurlClientWithConfig(config.Default())
+}
+
+func NewCurlClientWithConfig(cfg *config.Config) *CurlClient {
 	return &CurlClient{
 		client: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout: cfg.Curl.Timeout.Std(),
 		},
+		allowedHosts: append([]string{}, cfg.Curl.AllowedHosts...),
+	}
+}
+
+// Reconfigure - замена таймаута и списка разрешенных хостов без пересоздания клиента
+func (c *CurlClient) Reconfigure(cfg *config.Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.client = &http.Client{
+		Timeout: cfg.Curl.Timeout.Std(),
 	}
+	c.allowedHosts = append([]string{}, cfg.Curl.AllowedHosts...)
+}
+
+func (c *CurlClient) httpClient() *http.Client {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.client
+}
+
+// checkHost - проверка URL по списку разрешенных хостов (пустой список разрешает все)
+func (c *CurlClient) checkHost(rawURL string) error {
+	c.mu.RLock()
+	allowedHosts := c.allowedHosts
+	c.mu.RUnlock()
+
+	if len(allowedHosts) == 0 {
+		return nil
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("некорректный URL: %v", err)
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	for _, allowed := range allowedHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("хост %s не входит в список разрешенных", host)
 }
 
 // Execute - выполнение HTTP запроса
 func (c *CurlClient) Execute(url string, headers map[string]string) (string, error) {
+	if err := c.checkHost(url); err != nil {
+		return "", err
+	}
+
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return "", fmt.Errorf("ошибка создания запроса: %v", err)
@@ -38,7 +92,7 @@ func (c *CurlClient) Execute(url string, headers map[string]string) (string, err
 		req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Calculator-Curl/1.0)")
 	}
 
-	response, err := c.client.Do(req)
+	response, err := c.httpClient().Do(req)
 	if err != nil {
 		return "", fmt.Errorf("ошибка выполнения запроса: %v", err)
 	}
@@ -58,6 +112,10 @@ func (c *CurlClient) Execute(url string, headers map[string]string) (string, err
 
 // ExecuteWithMethod - выполнение запроса с указанием метода
 func (c *CurlClient) ExecuteWithMethod(method, url string, headers map[string]string, body string) (string, error) {
+	if err := c.checkHost(url); err != nil {
+		return "", err
+	}
+
 	var reader io.Reader
 	if body != "" {
 		reader = strings.NewReader(body)
@@ -80,7 +138,7 @@ func (c *CurlClient) ExecuteWithMethod(method, url string, headers map[string]st
 		}
 	}
 
-	response, err := c.client.Do(req)
+	response, err := c.httpClient().Do(req)
 	if err != nil {
 		return "", fmt.Errorf("ошибка выполнения запроса: %v", err)
 	}
@@ -100,6 +158,10 @@ func (c *CurlClient) ExecuteWithMethod(method, url string, headers map[string]st
 
 // GetStatusCode - выполнение запроса с возвратом статус кода
 func (c *CurlClient) GetStatusCode(url string, headers map[string]string) (int, error) {
+	if err := c.checkHost(url); err != nil {
+		return 0, err
+	}
+
 	req, err := http.NewRequest("HEAD", url, nil)
 	if err != nil {
 		return 0, fmt.Errorf("ошибка создания запроса: %v", err)
@@ -109,7 +171,7 @@ func (c *CurlClient) GetStatusCode(url string, headers map[string]string) (int,
 		req.Header.Set(key, value)
 	}
 
-	response, err := c.client.Do(req)
+	response, err := c.httpClient().Do(req)
 	if err != nil {
 		return 0, fmt.Errorf("ошибка выполнения запроса: %v", err)
 	}
@@ -1,6 +1,7 @@
 package curl
 
 import (
+	"app/config"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -173,6 +174,32 @@ func TestInvalidURL(t *testing.T) {
 	}
 }
 
+func TestAllowedHosts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+
+	cfg := config.Default()
+	cfg.Curl.AllowedHosts = []string{"example.com"}
+	client := NewCurlClientWithConfig(cfg)
+
+	_, err := client.Execute(server.URL, nil)
+	if err == nil || !strings.Contains(err.Error(), "не входит в список разрешенных") {
+		t.Errorf("Expected host to be rejected, got: %v", err)
+	}
+
+	if err := client.checkHost("https://api.example.com/v1"); err != nil {
+		t.Errorf("Expected subdomain to be allowed, got: %v", err)
+	}
+
+	cfg.Curl.AllowedHosts = []string{"127.0.0.1"}
+	client = NewCurlClientWithConfig(cfg)
+	if result, err := client.Execute(server.URL, nil); err != nil || result != "OK" {
+		t.Errorf("Expected allowed request, got %q, %v", result, err)
+	}
+}
+
 func BenchmarkSimpleGET(b *testing.B) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("OK"))
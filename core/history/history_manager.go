@@ -1,47 +1,188 @@
 package history
 
 import (
+	"app/config"
 	. "app/core/persistence"
-	"strings"
+	"app/core/transfer"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 type HistoryManager struct {
-	persistence *PersistenceManager
-	maxHistory  int
+	persistence   *PersistenceManager
+	retention     Retention
+	archive       *Archive
+	responseLimit int
+	redactor      *Redactor
+	mu            sync.RWMutex
 }
 
 func NewHistoryManager(persistence *PersistenceManager) *HistoryManager {
-	return &HistoryManager{
+	return NewHistoryManagerWithConfig(persistence, config.Default())
+}
+
+func NewHistoryManagerWithConfig(persistence *PersistenceManager, cfg *config.Config) *HistoryManager {
+	hm := &HistoryManager{
 		persistence: persistence,
-		maxHistory:  100,
 	}
+	hm.Reconfigure(cfg)
+	return hm
+}
+
+// Reconfigure - новая политика хранения и правила маскирования; лишние записи отбрасываются при следующем добавлении
+func (hm *HistoryManager) Reconfigure(cfg *config.Config) {
+	redactor, err := NewRedactor(cfg.History.RedactPatterns)
+	if err != nil {
+		// Конфигурация проверяется заранее; на всякий случай не сохраняем секреты открытыми
+		log.Printf("❌ Некорректные history.redact_patterns, используются стандартные: %v", err)
+		redactor, _ = NewRedactor(config.DefaultRedactPatterns)
+	}
+
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	hm.retention = NewRetention(cfg)
+	hm.archive = NewArchive(cfg)
+	hm.responseLimit = cfg.History.ResponseLimit
+	hm.redactor = redactor
+}
+
+// sanitize - маскирование секретов и сокращение ответов AI и curl перед сохранением
+func (hm *HistoryManager) sanitize(entry HistoryEntry) HistoryEntry {
+	hm.mu.RLock()
+	redactor, responseLimit := hm.redactor, hm.responseLimit
+	hm.mu.RUnlock()
+
+	entry.Command = redactor.Redact(entry.Command)
+	entry.Error = redactor.Redact(entry.Error)
+
+	response, ok := entry.Result.(string)
+	if !ok {
+		return entry
+	}
+	response = redactor.Redact(response)
+	if entry.Kind != KindMath && entry.Kind != KindAssign {
+		switch {
+		case responseLimit == 0:
+			entry.Result = nil
+			return entry
+		case utf8.RuneCountInString(response) > responseLimit:
+			response = string([]rune(response)[:responseLimit]) + "…"
+		}
+	}
+	entry.Result = response
+	return entry
 }
 
 // AddCommand - добавление команды в историю с сохранением в JSON
 func (hm *HistoryManager) AddCommand(command string) {
-	data := hm.persistence.LoadData()
-	if data == nil {
-		data = &CalculatorData{
-			Variables: make(map[string]interface{}),
-			History:   make([]HistoryEntry, 0),
+	hm.Record(HistoryEntry{Command: command})
+}
+
+// Record - добавление записи с результатом выполнения; ID и время назначаются здесь.
+// Одновременные вызовы получают разные номера, записи не теряются.
+func (hm *HistoryManager) Record(entry HistoryEntry) {
+	now := time.Now()
+	entry = hm.sanitize(entry)
+	entry.Timestamp = now.Format(time.RFC3339)
+
+	hm.persistence.Update(func(data *CalculatorData) bool {
+		data.AppendHistory(entry)
+		hm.applyRetention(data, now)
+		return true
+	})
+}
+
+// applyRetention - обрезка истории по политике хранения; вызывается внутри Update.
+// Вышедшие за пределы политики записи уходят в архив, если он настроен.
+func (hm *HistoryManager) applyRetention(data *CalculatorData, now time.Time) {
+	hm.mu.RLock()
+	retention, archive := hm.retention, hm.archive
+	hm.mu.RUnlock()
+
+	_, trimmed := retention.Apply(data.History, now)
+	if archive != nil {
+		if err := archive.Append(trimmed); err != nil {
+			log.Printf("❌ Не удалось архивировать историю: %v", err)
 		}
 	}
+	data.TrimHistory(len(trimmed))
+}
 
-	// Создаем запись команды
-	commandEntry := HistoryEntry{
-		Command:   command,
-		Timestamp: time.Now().Format(time.RFC3339),
-		ID:        len(data.History) + 1,
+// Import - загрузка записей из файла по стратегии transfer.Strategy*. Записи с тем же
+// временем и командой, что и имеющиеся, считаются совпадающими: merge заменяет их
+// загруженными, skip оставляет прежними, overwrite заменяет ими и удаляет остальные.
+// Новые записи добавляются в конец по порядку времени и получают новые номера;
+// секреты в них маскируются так же, как при выполнении команд.
+func (hm *HistoryManager) Import(entries []HistoryEntry, strategy string) transfer.Stats {
+	now := time.Now()
+	imported := make([]HistoryEntry, len(entries))
+	for idx, entry := range entries {
+		entry = hm.sanitize(entry)
+		if entry.Timestamp == "" {
+			entry.Timestamp = now.Format(time.RFC3339)
+		}
+		imported[idx] = entry
 	}
+	sort.SliceStable(imported, func(a, b int) bool {
+		return entryTime(imported[a]).Before(entryTime(imported[b]))
+	})
 
-	data.History = append(data.History, commandEntry)
+	var stats transfer.Stats
+	hm.persistence.Update(func(data *CalculatorData) bool {
+		if strategy == transfer.StrategyOverwrite {
+			// Остаются только записи, которые есть в загруженных; их номера сохраняются
+			wanted := make(map[string]bool, len(imported))
+			for _, entry := range imported {
+				wanted[entryKey(entry)] = true
+			}
+			stats.Removed += data.FilterHistory(func(entry HistoryEntry) bool {
+				return wanted[entryKey(entry)]
+			})
+		}
+		positions := make(map[string]int, len(data.History))
+		for idx, entry := range data.History {
+			positions[entryKey(entry)] = idx
+		}
 
-	if len(data.History) > hm.maxHistory {
-		data.History = data.History[len(data.History)-hm.maxHistory:]
-	}
+		for _, entry := range imported {
+			idx, ok := positions[entryKey(entry)]
+			switch {
+			case !ok:
+				data.AppendHistory(entry)
+				positions[entryKey(entry)] = len(data.History) - 1
+				stats.Added++
+			case strategy == transfer.StrategySkip:
+				stats.Skipped++
+			default:
+				// Номер прежней записи сохраняется: на него ссылаются !N и history delete
+				entry.ID = data.History[idx].ID
+				if reflect.DeepEqual(entry, data.History[idx]) {
+					stats.Skipped++
+					continue
+				}
+				data.UpdateHistory(idx, entry)
+				stats.Updated++
+			}
+		}
 
-	hm.persistence.SaveData(data)
+		hm.applyRetention(data, now)
+		return stats.Changed()
+	})
+	return stats
+}
+
+// entryKey - признак совпадения записей при загрузке
+func entryKey(entry HistoryEntry) string {
+	return entry.Timestamp + "\x00" + entry.Command
+}
+
+func entryTime(entry HistoryEntry) time.Time {
+	t, _ := time.Parse(time.RFC3339, entry.Timestamp)
+	return t
 }
 
 // GetHistory - получение истории команд
@@ -55,11 +196,21 @@ type DetailedHistoryEntry struct {
 	Command   string `json:"command"`
 	Timestamp string `json:"timestamp"`
 	Time      string `json:"time"` // Форматированное время
+
+	Result     interface{} `json:"result,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Kind       string      `json:"kind,omitempty"`
+	DurationMs float64     `json:"duration_ms,omitempty"`
+	SessionID  string      `json:"session_id,omitempty"`
 }
 
 // GetDetailedHistory - получение подробной истории с timestamp
 func (hm *HistoryManager) GetDetailedHistory(limit int) []DetailedHistoryEntry {
-	history := hm.GetHistory(limit)
+	return ToDetailed(hm.GetHistory(limit))
+}
+
+// ToDetailed - записи истории с отформатированным временем
+func ToDetailed(history []HistoryEntry) []DetailedHistoryEntry {
 	detailed := make([]DetailedHistoryEntry, len(history))
 
 	for i, entry := range history {
@@ -72,10 +223,15 @@ func (hm *HistoryManager) GetDetailedHistory(limit int) []DetailedHistoryEntry {
 		}
 
 		detailed[i] = DetailedHistoryEntry{
-			ID:        entry.ID,
-			Command:   entry.Command,
-			Timestamp: entry.Timestamp,
-			Time:      formattedTime,
+			ID:         entry.ID,
+			Command:    entry.Command,
+			Timestamp:  entry.Timestamp,
+			Time:       formattedTime,
+			Result:     entry.Result,
+			Error:      entry.Error,
+			Kind:       entry.Kind,
+			DurationMs: entry.DurationMs,
+			SessionID:  entry.SessionID,
 		}
 	}
 
@@ -84,21 +240,12 @@ func (hm *HistoryManager) GetDetailedHistory(limit int) []DetailedHistoryEntry {
 
 // SetHistory - установка истории (для загрузки при старте)
 func (hm *HistoryManager) SetHistory(historyList []HistoryEntry) {
-	data := hm.persistence.LoadData()
-	if data == nil {
-		data = &CalculatorData{
-			Variables: make(map[string]interface{}),
-			History:   make([]HistoryEntry, 0),
-		}
-	}
-
-	// Форматируем историю
+	// Форматируем историю; существующие ID сохраняются, на них ссылаются !N и history delete
 	formattedHistory := make([]HistoryEntry, len(historyList))
 	for i, item := range historyList {
-		formattedHistory[i] = HistoryEntry{
-			Command:   item.Command,
-			Timestamp: item.Timestamp,
-			ID:        i + 1,
+		formattedHistory[i] = item
+		if formattedHistory[i].ID == 0 {
+			formattedHistory[i].ID = i + 1
 		}
 		// Если timestamp пустой, устанавливаем текущее время
 		if formattedHistory[i].Timestamp == "" {
@@ -106,8 +253,10 @@ func (hm *HistoryManager) SetHistory(historyList []HistoryEntry) {
 		}
 	}
 
-	data.History = formattedHistory
-	hm.persistence.SaveData(data)
+	hm.persistence.Update(func(data *CalculatorData) bool {
+		data.ReplaceHistory(formattedHistory)
+		return true
+	})
 }
 
 // ClearHistory - очистка всей истории
@@ -115,27 +264,35 @@ func (hm *HistoryManager) ClearHistory() bool {
 	return hm.persistence.ClearHistory()
 }
 
-// SearchHistory - поиск по истории команд
-func (hm *HistoryManager) SearchHistory(keyword string) []HistoryEntry {
-	history := hm.GetHistory(hm.maxHistory)
-	results := make([]HistoryEntry, 0)
-
-	for _, entry := range history {
-		if strings.Contains(strings.ToLower(entry.Command), strings.ToLower(keyword)) {
-			results = append(results, entry)
+// GetEntry - запись истории по ID
+func (hm *HistoryManager) GetEntry(id int) (HistoryEntry, bool) {
+	var found HistoryEntry
+	ok := false
+	hm.persistence.View(func(data *CalculatorData) {
+		for _, entry := range data.History {
+			if entry.ID == id {
+				found, ok = entry, true
+				return
+			}
 		}
-	}
+	})
+	return found, ok
+}
 
-	return results
+// DeleteEntry - удаление записи истории по ID, остальные записи сохраняют свои номера
+func (hm *HistoryManager) DeleteEntry(id int) bool {
+	return hm.persistence.Update(func(data *CalculatorData) bool {
+		return data.DeleteHistory(id)
+	})
 }
 
 // GetHistoryCount - получение количества записей в истории
 func (hm *HistoryManager) GetHistoryCount() int {
-	data := hm.persistence.LoadData()
-	if data == nil {
-		return 0
-	}
-	return len(data.History)
+	count := 0
+	hm.persistence.View(func(data *CalculatorData) {
+		count = len(data.History)
+	})
+	return count
 }
 
 // GetLastCommand - получение последней команды
@@ -0,0 +1,152 @@
+package history
+
+import (
+	"app/config"
+	"app/core/persistence"
+	"app/core/transfer"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordWriteBehind(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	cfg := config.Default()
+	cfg.Storage.DataFile = path
+	cfg.Storage.FlushInterval = config.Duration(time.Hour)
+
+	pm := persistence.NewPersistenceManagerWithConfig(cfg)
+	hm := NewHistoryManagerWithConfig(pm, cfg)
+	hm.AddCommand("1+1")
+	hm.AddCommand("2+2")
+
+	if hm.GetHistoryCount() != 2 || hm.GetLastCommand() != "2+2" {
+		t.Fatalf("Expected history in memory, got %v", hm.GetHistory(0))
+	}
+	if n := len(persistence.NewPersistenceManagerWithFile(path).GetRecentHistory(0)); n != 0 {
+		t.Errorf("Expected nothing on disk before flush, got %d entries", n)
+	}
+
+	if err := pm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(persistence.NewPersistenceManagerWithFile(path).GetRecentHistory(0)); n != 2 {
+		t.Errorf("Expected 2 entries on disk after flush, got %d", n)
+	}
+}
+
+func TestImportStrategies(t *testing.T) {
+	existing := []persistence.HistoryEntry{
+		{Command: "a", Timestamp: "2026-01-01T10:00:00Z", Result: 1.0},
+		{Command: "b", Timestamp: "2026-01-01T11:00:00Z", Result: 2.0},
+	}
+	imported := []persistence.HistoryEntry{
+		{Command: "c", Timestamp: "2026-01-01T12:30:00Z"},
+		{Command: "b", Timestamp: "2026-01-01T11:00:00Z", Result: 20.0},
+		{Command: "curl -H 'Authorization: Bearer topsecret' https://example.com", Timestamp: "2026-01-01T12:00:00Z"},
+	}
+
+	tests := []struct {
+		strategy string
+		commands string
+		results  string // результат записи b
+		stats    transfer.Stats
+	}{
+		{transfer.StrategyMerge, "a,b,curl,c", "20", transfer.Stats{Added: 2, Updated: 1}},
+		{transfer.StrategySkip, "a,b,curl,c", "2", transfer.Stats{Added: 2, Skipped: 1}},
+		{transfer.StrategyOverwrite, "b,curl,c", "20", transfer.Stats{Added: 2, Updated: 1, Removed: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			hm := NewHistoryManager(persistence.NewInMemoryPersistenceManager())
+			hm.SetHistory(existing)
+
+			if stats := hm.Import(imported, tt.strategy); stats != tt.stats {
+				t.Errorf("Expected %+v, got %+v", tt.stats, stats)
+			}
+			history := hm.GetHistory(0)
+			commands, result := "", ""
+			for idx, entry := range history {
+				if idx > 0 {
+					commands += ","
+				}
+				command := entry.Command
+				if strings.HasPrefix(command, "curl") {
+					command = "curl"
+					if entry.Command == imported[2].Command {
+						t.Error("Secrets in imported commands must be redacted")
+					}
+				}
+				commands += command
+				if entry.Command == "b" {
+					result = fmt.Sprint(entry.Result)
+					if entry.ID != 2 {
+						t.Errorf("Matching entry must keep its ID 2, got %d", entry.ID)
+					}
+				}
+			}
+			if commands != tt.commands || result != tt.results {
+				t.Errorf("Expected %s with b = %s, got %s with b = %s", tt.commands, tt.results, commands, result)
+			}
+			if last := history[len(history)-1]; last.ID != 4 {
+				t.Errorf("Expected new entries to get IDs after existing ones, got %d", last.ID)
+			}
+		})
+	}
+}
+
+// benchmarkHistory - добавление и чтение истории из 10 000 записей.
+// interval - задержка записи в файл, journal - журнал событий, after выполняется после каждой команды.
+func benchmarkHistory(b *testing.B, interval time.Duration, journal bool, after func(pm *persistence.PersistenceManager)) {
+	cfg := config.Default()
+	cfg.Storage.DataFile = filepath.Join(b.TempDir(), "data.json")
+	cfg.Storage.FlushInterval = config.Duration(interval)
+	cfg.Storage.Journal = journal
+	cfg.History.MaxEntries = 1 << 30
+
+	entries := make([]persistence.HistoryEntry, 10000)
+	for idx := range entries {
+		entries[idx] = persistence.HistoryEntry{
+			ID: idx + 1, Command: fmt.Sprintf("x = %d * 2", idx), Result: float64(idx * 2),
+			Kind: persistence.KindAssign, Timestamp: "2025-01-01T10:00:00Z",
+		}
+	}
+	pm := persistence.NewPersistenceManagerWithConfig(cfg)
+	pm.SaveData(&persistence.CalculatorData{Variables: map[string]interface{}{}, History: entries})
+	pm.Flush()
+	hm := NewHistoryManagerWithConfig(pm, cfg)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		// Как в одном /api/execute: запись команды и чтение последних записей
+		hm.Record(persistence.HistoryEntry{Command: "2+2", Result: 4.0, Kind: persistence.KindMath})
+		hm.GetDetailedHistory(10)
+		hm.GetHistoryCount()
+		after(pm)
+	}
+	b.StopTimer()
+	pm.Flush()
+}
+
+// BenchmarkHistory10kReload - прежнее поведение: файл читается и пишется при каждом обращении
+func BenchmarkHistory10kReload(b *testing.B) {
+	benchmarkHistory(b, 0, false, func(pm *persistence.PersistenceManager) { pm.Unload() })
+}
+
+// BenchmarkHistory10kWriteThrough - состояние в памяти, запись в файл при каждом изменении
+func BenchmarkHistory10kWriteThrough(b *testing.B) {
+	benchmarkHistory(b, 0, false, func(*persistence.PersistenceManager) {})
+}
+
+// BenchmarkHistory10kWriteBehind - состояние в памяти, изменения пишутся пачками раз в секунду
+func BenchmarkHistory10kWriteBehind(b *testing.B) {
+	benchmarkHistory(b, time.Second, false, func(*persistence.PersistenceManager) {})
+}
+
+// BenchmarkHistory10kJournal - как WriteBehind, но каждое изменение сразу дописывается в журнал событий;
+// стоимость команды не должна зависеть от размера истории
+func BenchmarkHistory10kJournal(b *testing.B) {
+	benchmarkHistory(b, time.Second, true, func(*persistence.PersistenceManager) {})
+}
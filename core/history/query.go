@@ -0,0 +1,314 @@
+package history
+
+import (
+	. "app/core/persistence"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// QuerySyntax - справка по синтаксису запроса к истории
+const QuerySyntax = `Синтаксис поиска (условия объединяются через И):
+  текст              подстрока без учета регистра
+  re:<выражение>     регулярное выражение, также /выражение/
+  ~текст             нечеткий поиск, результаты по убыванию похожести
+  since:<время>      не раньше: 2025-01-31, 2025-01-31T10:00, 2h, 7d
+  until:<время>      не позже (дата без времени включает весь день)
+  kind:<вид>         math, assign, curl, ai, browser, media (через запятую)
+  is:error / is:ok   только ошибки / только успешные
+  uses:<переменная>  команды, в которых используется переменная
+  limit:N offset:N   постраничный вывод
+Значения с пробелами берутся в кавычки: re:"a b"`
+
+// Query - запрос к истории
+type Query struct {
+	Text      string         // подстрока
+	Regex     *regexp.Regexp // регулярное выражение
+	Fuzzy     string         // нечеткий поиск
+	Since     time.Time
+	Until     time.Time
+	Kinds     []string
+	ErrorOnly bool
+	OKOnly    bool
+	Uses      []string
+	Offset    int
+	Limit     int // 0 - без ограничения
+}
+
+// SearchResult - страница результатов поиска
+type SearchResult struct {
+	Total   int                    `json:"total"`
+	Offset  int                    `json:"offset"`
+	Limit   int                    `json:"limit"`
+	Entries []DetailedHistoryEntry `json:"entries"`
+}
+
+// ParseQuery - разбор строки запроса; now нужен для относительного времени (since:2h)
+func ParseQuery(input string, now time.Time) (Query, error) {
+	q := Query{}
+	words := make([]string, 0)
+
+	for _, token := range tokenize(input) {
+		if len(token) > 2 && strings.HasPrefix(token, "/") && strings.HasSuffix(token, "/") {
+			token = "re:" + token[1:len(token)-1]
+		}
+		if strings.HasPrefix(token, "~") && len(token) > 1 {
+			token = "fuzzy:" + token[1:]
+		}
+
+		key, value, found := strings.Cut(token, ":")
+		if !found || value == "" {
+			words = append(words, token)
+			continue
+		}
+
+		var err error
+		switch strings.ToLower(key) {
+		case "re", "regex":
+			q.Regex, err = regexp.Compile(value)
+		case "fuzzy":
+			q.Fuzzy = value
+		case "since":
+			q.Since, err = parseTimeBound(value, now, false)
+		case "until":
+			q.Until, err = parseTimeBound(value, now, true)
+		case "kind":
+			for _, kind := range strings.Split(strings.ToLower(value), ",") {
+				if kind = strings.TrimSpace(kind); kind != "" {
+					q.Kinds = append(q.Kinds, kind)
+				}
+			}
+		case "is":
+			switch strings.ToLower(value) {
+			case "error", "err", "failed":
+				q.ErrorOnly = true
+			case "ok", "success":
+				q.OKOnly = true
+			default:
+				err = fmt.Errorf("ожидается is:error или is:ok")
+			}
+		case "uses":
+			q.Uses = append(q.Uses, value)
+		case "limit":
+			q.Limit, err = parseCount(value)
+		case "offset":
+			q.Offset, err = parseCount(value)
+		default:
+			// Двоеточие - часть искомого текста (например, https://...)
+			words = append(words, token)
+		}
+		if err != nil {
+			return Query{}, fmt.Errorf("%s: %v", token, err)
+		}
+	}
+
+	q.Text = strings.Join(words, " ")
+	return q, nil
+}
+
+// Search - поиск по истории с фильтрами и постраничным выводом.
+// Без нечеткого поиска записи идут в хронологическом порядке.
+func (hm *HistoryManager) Search(q Query) SearchResult {
+	type scored struct {
+		entry HistoryEntry
+		score int
+	}
+
+	matched := make([]scored, 0)
+	for _, entry := range hm.GetHistory(0) {
+		if !q.matches(entry) {
+			continue
+		}
+		score := 0
+		if q.Fuzzy != "" {
+			var ok bool
+			if score, ok = fuzzyScore(q.Fuzzy, entry.Command); !ok {
+				continue
+			}
+		}
+		matched = append(matched, scored{entry, score})
+	}
+
+	if q.Fuzzy != "" {
+		sort.SliceStable(matched, func(a, b int) bool {
+			return matched[a].score > matched[b].score
+		})
+	}
+
+	result := SearchResult{Total: len(matched), Offset: q.Offset, Limit: q.Limit}
+	start := min(q.Offset, len(matched))
+	end := len(matched)
+	if q.Limit > 0 {
+		end = min(start+q.Limit, len(matched))
+	}
+
+	page := make([]HistoryEntry, 0, end-start)
+	for _, item := range matched[start:end] {
+		page = append(page, item.entry)
+	}
+	result.Entries = ToDetailed(page)
+	return result
+}
+
+func (q Query) matches(entry HistoryEntry) bool {
+	if q.Text != "" && !strings.Contains(strings.ToLower(entry.Command), strings.ToLower(q.Text)) {
+		return false
+	}
+	if q.Regex != nil && !q.Regex.MatchString(entry.Command) {
+		return false
+	}
+	if q.ErrorOnly && entry.Error == "" {
+		return false
+	}
+	if q.OKOnly && entry.Error != "" {
+		return false
+	}
+	if len(q.Kinds) > 0 && !containsString(q.Kinds, entry.Kind) {
+		return false
+	}
+
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		t, err := time.Parse(time.RFC3339, entry.Timestamp)
+		if err != nil {
+			return false
+		}
+		if !q.Since.IsZero() && t.Before(q.Since) {
+			return false
+		}
+		if !q.Until.IsZero() && t.After(q.Until) {
+			return false
+		}
+	}
+
+	for _, name := range q.Uses {
+		if !usesVariable(entry, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// usesVariable - переменная встречается в выражении; для присваивания учитывается только правая часть
+func usesVariable(entry HistoryEntry, name string) bool {
+	if entry.Kind != KindMath && entry.Kind != KindAssign {
+		return false
+	}
+	expression := entry.Command
+	if entry.Kind == KindAssign {
+		if _, rhs, found := strings.Cut(expression, "="); found {
+			expression = rhs
+		}
+	}
+
+	pattern := `(^|[^A-Za-z0-9_])` + regexp.QuoteMeta(name) + `([^A-Za-z0-9_]|$)`
+	matched, _ := regexp.MatchString(pattern, expression)
+	return matched
+}
+
+// fuzzyScore - символы шаблона встречаются в тексте по порядку; бонусы за подряд идущие
+// совпадения и начала слов, небольшой штраф за длину текста
+func fuzzyScore(pattern, text string) (int, bool) {
+	p := []rune(strings.ToLower(pattern))
+	t := []rune(strings.ToLower(text))
+
+	score, pi, prev := 0, 0, -2
+	for ti := 0; ti < len(t) && pi < len(p); ti++ {
+		if t[ti] != p[pi] {
+			continue
+		}
+		score += 10
+		if ti == prev+1 {
+			score += 15
+		}
+		if ti == 0 || !isWordRune(t[ti-1]) {
+			score += 10
+		}
+		prev = ti
+		pi++
+	}
+	if pi < len(p) {
+		return 0, false
+	}
+	return score - len(t), true
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// parseTimeBound - абсолютная дата или длительность назад от now (2h, 30m, 7d)
+func parseTimeBound(value string, now time.Time, endOfDay bool) (time.Time, error) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		if n, err := strconv.Atoi(days); err == nil {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("ожидается дата 2006-01-02[T15:04] или длительность 2h, 7d")
+}
+
+func parseCount(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("ожидается неотрицательное число")
+	}
+	return n, nil
+}
+
+// tokenize - разбиение по пробелам с учетом кавычек: re:"a b" -> re:a b
+func tokenize(input string) []string {
+	tokens := make([]string, 0)
+	var current strings.Builder
+	inQuotes, hasToken := false, false
+
+	for _, r := range input {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			hasToken = true
+		case unicode.IsSpace(r) && !inQuotes:
+			if hasToken {
+				tokens = append(tokens, current.String())
+				current.Reset()
+				hasToken = false
+			}
+		default:
+			current.WriteRune(r)
+			hasToken = true
+		}
+	}
+	if hasToken {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
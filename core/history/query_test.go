@@ -0,0 +1,102 @@
+package history
+
+import (
+	"app/core/persistence"
+	"testing"
+	"time"
+)
+
+func newSearchManager(t *testing.T) *HistoryManager {
+	t.Helper()
+	pm := persistence.NewInMemoryPersistenceManager()
+	now := time.Now()
+	entries := []persistence.HistoryEntry{
+		{ID: 1, Command: "x = 5", Kind: persistence.KindAssign, Result: "x = 5", Timestamp: now.Add(-72 * time.Hour).Format(time.RFC3339)},
+		{ID: 2, Command: "x * 2", Kind: persistence.KindMath, Result: 10.0, Timestamp: now.Add(-48 * time.Hour).Format(time.RFC3339)},
+		{ID: 3, Command: "y = x + 1", Kind: persistence.KindAssign, Result: "y = 6", Timestamp: now.Add(-time.Hour).Format(time.RFC3339)},
+		{ID: 4, Command: "sin(y) / 0", Kind: persistence.KindMath, Error: "деление на ноль", Timestamp: now.Add(-time.Minute).Format(time.RFC3339)},
+		{ID: 5, Command: "curl https://example.com", Kind: persistence.KindCurl, Result: "ok", Timestamp: now.Format(time.RFC3339)},
+		{ID: 6, Command: "max(x, 2)", Kind: persistence.KindMath, Result: 5.0, Timestamp: now.Format(time.RFC3339)},
+	}
+	pm.SaveData(&persistence.CalculatorData{Variables: map[string]interface{}{}, History: entries})
+	return NewHistoryManager(pm)
+}
+
+func searchIDs(t *testing.T, hm *HistoryManager, query string) []int {
+	t.Helper()
+	q, err := ParseQuery(query, time.Now())
+	if err != nil {
+		t.Fatalf("ParseQuery(%q): %v", query, err)
+	}
+	result := hm.Search(q)
+	ids := make([]int, len(result.Entries))
+	for idx, entry := range result.Entries {
+		ids[idx] = entry.ID
+	}
+	return ids
+}
+
+func TestSearchFilters(t *testing.T) {
+	hm := newSearchManager(t)
+
+	tests := map[string][]int{
+		"X":                 {1, 2, 3, 5, 6},
+		"re:^[xy] =":        {1, 3},
+		"/\\d$/ kind:math":  {2, 4},
+		"kind:assign":       {1, 3},
+		"kind:curl,assign":  {1, 3, 5},
+		"is:error":          {4},
+		"uses:x":            {2, 3, 6},
+		"uses:y is:ok":      {},
+		"since:2h":          {3, 4, 5, 6},
+		"since:3d until:2h": {2},
+		"https://example":   {5},
+	}
+	for query, expected := range tests {
+		ids := searchIDs(t, hm, query)
+		if len(ids) != len(expected) {
+			t.Errorf("%q: expected %v, got %v", query, expected, ids)
+			continue
+		}
+		for idx := range ids {
+			if ids[idx] != expected[idx] {
+				t.Errorf("%q: expected %v, got %v", query, expected, ids)
+				break
+			}
+		}
+	}
+}
+
+func TestSearchFuzzyAndPaging(t *testing.T) {
+	hm := newSearchManager(t)
+
+	ids := searchIDs(t, hm, "~sin0")
+	if len(ids) != 1 || ids[0] != 4 {
+		t.Errorf("Expected fuzzy match on entry 4, got %v", ids)
+	}
+
+	// Подряд идущие совпадения ранжируются выше разбросанных
+	ids = searchIDs(t, hm, "~x2")
+	if len(ids) < 2 || ids[0] != 6 && ids[0] != 2 {
+		t.Errorf("Unexpected fuzzy ranking: %v", ids)
+	}
+
+	q, _ := ParseQuery("kind:math limit:1 offset:1", time.Now())
+	result := hm.Search(q)
+	if result.Total != 3 || len(result.Entries) != 1 || result.Entries[0].ID != 4 {
+		t.Errorf("Unexpected page: %+v", result)
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, query := range []string{"re:(", "since:yesterday", "is:maybe", "limit:-1"} {
+		if _, err := ParseQuery(query, time.Now()); err == nil {
+			t.Errorf("Expected error for %q", query)
+		}
+	}
+
+	q, err := ParseQuery(`re:"a b" kind:AI`, time.Now())
+	if err != nil || q.Regex.String() != "a b" || q.Kinds[0] != "ai" {
+		t.Errorf("Unexpected query: %+v, %v", q, err)
+	}
+}
@@ -0,0 +1,57 @@
+package history
+
+import (
+	"regexp"
+	"strings"
+)
+
+// RedactedValue - замена скрытого секрета
+const RedactedValue = "***"
+
+// Redactor - маскирование секретов в записях истории перед сохранением
+type Redactor struct {
+	patterns []*regexp.Regexp
+}
+
+// NewRedactor - маскировщик по регулярным выражениям.
+// Если в выражении есть группа, заменяется только ее текст, иначе все совпадение.
+func NewRedactor(patterns []string) (*Redactor, error) {
+	r := &Redactor{patterns: make([]*regexp.Regexp, 0, len(patterns))}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// Redact - текст со скрытыми секретами
+func (r *Redactor) Redact(text string) string {
+	for _, re := range r.patterns {
+		text = redactAll(re, text)
+	}
+	return text
+}
+
+func redactAll(re *regexp.Regexp, text string) string {
+	matches := re.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return text
+	}
+
+	var sb strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if len(m) >= 4 && m[2] >= 0 {
+			start, end = m[2], m[3]
+		}
+		sb.WriteString(text[last:start])
+		sb.WriteString(RedactedValue)
+		last = end
+	}
+	sb.WriteString(text[last:])
+	return sb.String()
+}
@@ -0,0 +1,61 @@
+package history
+
+import (
+	"app/config"
+	"app/core/persistence"
+	"strings"
+	"testing"
+)
+
+func TestRedactDefaultPatterns(t *testing.T) {
+	r, err := NewRedactor(config.DefaultRedactPatterns)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := map[string]string{
+		`curl https://api.example.com -H "Authorization: Bearer abc123"`: `curl https://api.example.com -H "Authorization: ***"`,
+		`curl https://example.com/?q=1&token=abc123&x=2`:                 `curl https://example.com/?q=1&token=***&x=2`,
+		`curl https://example.com -H X-Api-Key:secret`:                   `curl https://example.com -H X-Api-Key:***`,
+		`2 + 2`: `2 + 2`,
+	}
+	for input, expected := range tests {
+		if got := r.Redact(input); got != expected {
+			t.Errorf("Redact(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+func TestRedactWholeMatchWithoutGroup(t *testing.T) {
+	r, err := NewRedactor([]string{`sk-[a-z0-9]+`})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := r.Redact("key sk-abc1 and sk-def2"); got != "key *** and ***" {
+		t.Errorf("Unexpected result: %q", got)
+	}
+}
+
+func TestRecordSanitizesEntries(t *testing.T) {
+	cfg := config.Default()
+	cfg.History.ResponseLimit = 5
+	hm := NewHistoryManagerWithConfig(persistence.NewInMemoryPersistenceManager(), cfg)
+
+	hm.Record(persistence.HistoryEntry{
+		Command: `curl https://example.com -H "Authorization: Bearer abc"`,
+		Kind:    persistence.KindCurl,
+		Result:  "0123456789",
+	})
+	hm.Record(persistence.HistoryEntry{Command: "x = 1", Kind: persistence.KindAssign, Result: "x = 1234567"})
+
+	entries := hm.GetHistory(0)
+	if strings.Contains(entries[0].Command, "abc") {
+		t.Errorf("Secret saved in command: %q", entries[0].Command)
+	}
+	if entries[0].Result != "01234…" {
+		t.Errorf("Expected truncated response, got %q", entries[0].Result)
+	}
+	if entries[1].Result != "x = 1234567" {
+		t.Errorf("Math results must not be truncated, got %q", entries[1].Result)
+	}
+}
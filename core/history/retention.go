@@ -0,0 +1,324 @@
+package history
+
+import (
+	"app/config"
+	. "app/core/persistence"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Retention - политика хранения истории: по числу записей, возрасту и размеру.
+// Нулевые MaxAge и MaxBytes означают отсутствие ограничения.
+type Retention struct {
+	MaxEntries int
+	MaxAge     time.Duration
+	MaxBytes   int
+}
+
+// NewRetention - политика из конфигурации
+func NewRetention(cfg *config.Config) Retention {
+	return Retention{
+		MaxEntries: cfg.History.MaxEntries,
+		MaxAge:     cfg.History.MaxAge.Std(),
+		MaxBytes:   cfg.History.MaxBytes,
+	}
+}
+
+// Apply - разделение истории на оставшиеся и вышедшие за пределы политики записи.
+// Удаляются всегда самые старые записи, порядок сохраняется.
+func (r Retention) Apply(entries []HistoryEntry, now time.Time) (kept, trimmed []HistoryEntry) {
+	start := 0
+
+	if r.MaxAge > 0 {
+		cutoff := now.Add(-r.MaxAge)
+		for start < len(entries) {
+			t, err := time.Parse(time.RFC3339, entries[start].Timestamp)
+			if err != nil || !t.Before(cutoff) {
+				break
+			}
+			start++
+		}
+	}
+
+	if r.MaxEntries > 0 && len(entries)-start > r.MaxEntries {
+		start = len(entries) - r.MaxEntries
+	}
+
+	if r.MaxBytes > 0 {
+		size := 0
+		for _, entry := range entries[start:] {
+			size += entrySize(entry)
+		}
+		// Последнюю запись оставляем, даже если она одна больше лимита
+		for size > r.MaxBytes && start < len(entries)-1 {
+			size -= entrySize(entries[start])
+			start++
+		}
+	}
+
+	return entries[start:], entries[:start]
+}
+
+func entrySize(entry HistoryEntry) int {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+// ============================================================================
+// АРХИВ
+// ============================================================================
+
+// archiveMu - архив один на все рабочие пространства и сессии, дописывание и ротация идут по очереди
+var archiveMu sync.Mutex
+
+// sealedArchives - архивы, открытые записи в которых уже зашифрованы этим процессом
+var sealedArchives = make(map[string]bool)
+
+// Archive - JSONL файл с удаленными из истории записями и ротацией по размеру.
+// Если данные шифруются (storage.encryption_*), каждая запись архива шифруется тем же ключом.
+type Archive struct {
+	path     string
+	maxBytes int64
+	keep     int
+	// key - ключ шифрования записей; nil - записи пишутся открытыми
+	key *Key
+	// err - ключ задан, но не читается: архив не пишется, чтобы записи не легли открытыми
+	err error
+}
+
+// archiveLine - строка архива: открытая запись или зашифрованная целиком
+type archiveLine struct {
+	HistoryEntry
+	Encrypted *Encrypted `json:"encrypted,omitempty"`
+}
+
+// NewArchive - архив по конфигурации; nil, если архив не задан. С ключом шифрования
+// записи, сохраненные в архив до его включения, сразу шифруются.
+func NewArchive(cfg *config.Config) *Archive {
+	if cfg.History.ArchiveFile == "" {
+		return nil
+	}
+	key, err := NewKeyWithConfig(cfg)
+	a := &Archive{
+		path:     cfg.History.ArchiveFile,
+		maxBytes: int64(cfg.History.ArchiveMaxBytes),
+		keep:     cfg.History.ArchiveKeep,
+		key:      key,
+		err:      err,
+	}
+	if key != nil {
+		if err := a.seal(); err != nil {
+			log.Printf("❌ Не удалось зашифровать архив истории: %v", err)
+		}
+	}
+	return a
+}
+
+// Append - дописывание записей в архив; при превышении размера файл предварительно ротируется
+func (a *Archive) Append(entries []HistoryEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	if a.err != nil {
+		return a.err
+	}
+	content, err := encodeArchive(entries, a.key)
+	if err != nil {
+		return err
+	}
+
+	archiveMu.Lock()
+	defer archiveMu.Unlock()
+
+	if info, err := os.Stat(a.path); err == nil && info.Size() >= a.maxBytes {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(a.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(content)
+	return err
+}
+
+// rotate - archive -> archive.1 -> archive.2 ...; самый старый файл сверх keep удаляется
+func (a *Archive) rotate() error {
+	os.Remove(a.rotated(a.keep))
+	for n := a.keep - 1; n >= 1; n-- {
+		if err := os.Rename(a.rotated(n), a.rotated(n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(a.path, a.rotated(1))
+}
+
+func (a *Archive) rotated(n int) string {
+	return fmt.Sprintf("%s.%d", a.path, n)
+}
+
+// files - текущий и ротированные файлы архива
+func (a *Archive) files() []string {
+	files := []string{a.path}
+	for n := 1; n <= a.keep; n++ {
+		files = append(files, a.rotated(n))
+	}
+	return files
+}
+
+// seal - шифрование открытых записей во всех файлах архива (один раз за запуск)
+func (a *Archive) seal() error {
+	archiveMu.Lock()
+	defer archiveMu.Unlock()
+	if sealedArchives[a.path] {
+		return nil
+	}
+
+	for _, path := range a.files() {
+		entries, plain, err := readArchive(path, a.key)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if !plain {
+			continue
+		}
+		content, err := encodeArchive(entries, a.key)
+		if err != nil {
+			return err
+		}
+		if err := replaceFile(path, content); err != nil {
+			return err
+		}
+	}
+	sealedArchives[a.path] = true
+	return nil
+}
+
+// RekeyArchive - смена ключа архива истории вслед за persistence.Rekey. Все файлы архива
+// сначала читаются ключом from; если что-то не открывается, возвращается ошибка и ничего
+// не меняется. Возвращенная функция записывает архив ключом to (nil - расшифровать).
+func RekeyArchive(cfg *config.Config, from, to *Key) (func() error, error) {
+	if cfg.History.ArchiveFile == "" {
+		return func() error { return nil }, nil
+	}
+	a := &Archive{path: cfg.History.ArchiveFile, keep: cfg.History.ArchiveKeep}
+	files := make(map[string][]HistoryEntry)
+	for _, path := range a.files() {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		entries, _, err := readArchive(path, from)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		files[path] = entries
+	}
+
+	return func() error {
+		archiveMu.Lock()
+		defer archiveMu.Unlock()
+		for path, entries := range files {
+			content, err := encodeArchive(entries, to)
+			if err != nil {
+				return err
+			}
+			if err := replaceFile(path, content); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+// encodeArchive - строки архива; с ключом каждая запись шифруется отдельно
+func encodeArchive(entries []HistoryEntry, key *Key) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	for _, entry := range entries {
+		if key == nil {
+			if err := encoder.Encode(entry); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		plain, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		sealed, err := key.Seal(plain)
+		if err != nil {
+			return nil, err
+		}
+		if err := encoder.Encode(struct {
+			Encrypted *Encrypted `json:"encrypted"`
+		}{sealed}); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// readArchive - записи файла архива; plain - среди них есть открытые
+func readArchive(path string, key *Key) (entries []HistoryEntry, plain bool, err error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var line archiveLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, false, err
+		}
+		if line.Encrypted == nil {
+			plain = true
+			entries = append(entries, line.HistoryEntry)
+			continue
+		}
+		if key == nil {
+			return nil, false, ErrNoKey
+		}
+		content, err := key.Open(line.Encrypted)
+		if err != nil {
+			return nil, false, err
+		}
+		var entry HistoryEntry
+		if err := json.Unmarshal(content, &entry); err != nil {
+			return nil, false, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, plain, scanner.Err()
+}
+
+// replaceFile - замена содержимого файла через временный файл рядом
+func replaceFile(path string, content []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,127 @@
+package history
+
+import (
+	"app/config"
+	"app/core/persistence"
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testEntries(now time.Time, ages ...time.Duration) []persistence.HistoryEntry {
+	entries := make([]persistence.HistoryEntry, len(ages))
+	for idx, age := range ages {
+		entries[idx] = persistence.HistoryEntry{
+			ID:        idx + 1,
+			Command:   "1+1",
+			Timestamp: now.Add(-age).Format(time.RFC3339),
+		}
+	}
+	return entries
+}
+
+func TestRetentionApply(t *testing.T) {
+	now := time.Now()
+	entries := testEntries(now, 72*time.Hour, 48*time.Hour, time.Hour, time.Minute)
+
+	kept, trimmed := Retention{MaxEntries: 3}.Apply(entries, now)
+	if len(kept) != 3 || len(trimmed) != 1 || trimmed[0].ID != 1 {
+		t.Errorf("Count: kept %d, trimmed %v", len(kept), trimmed)
+	}
+
+	kept, trimmed = Retention{MaxEntries: 10, MaxAge: 24 * time.Hour}.Apply(entries, now)
+	if len(kept) != 2 || kept[0].ID != 3 || len(trimmed) != 2 {
+		t.Errorf("Age: kept %v, trimmed %v", kept, trimmed)
+	}
+
+	limit := entrySize(entries[0]) * 2
+	kept, _ = Retention{MaxEntries: 10, MaxBytes: limit}.Apply(entries, now)
+	if len(kept) != 2 || kept[1].ID != 4 {
+		t.Errorf("Size: kept %v", kept)
+	}
+}
+
+func TestRecordKeepsIDsUniqueAndArchives(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.History.MaxEntries = 2
+	cfg.History.ArchiveFile = filepath.Join(dir, "history.jsonl")
+	cfg.History.ArchiveMaxBytes = 1
+	cfg.History.ArchiveKeep = 2
+
+	hm := NewHistoryManagerWithConfig(persistence.NewInMemoryPersistenceManager(), cfg)
+	for n := 0; n < 5; n++ {
+		hm.AddCommand("1+1")
+	}
+	hm.ClearHistory()
+	hm.AddCommand("2+2")
+
+	history := hm.GetHistory(0)
+	if len(history) != 1 || history[0].ID != 6 {
+		t.Errorf("Expected single entry with ID 6, got %+v", history)
+	}
+
+	// 3 обрезки при лимите 1 байт: текущий архив и две ротированные копии
+	for _, name := range []string{"history.jsonl", "history.jsonl.1", "history.jsonl.2"} {
+		file, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Expected archive %s: %v", name, err)
+		}
+		lines := 0
+		for scanner := bufio.NewScanner(file); scanner.Scan(); {
+			lines++
+		}
+		file.Close()
+		if lines != 1 {
+			t.Errorf("Expected 1 archived entry in %s, got %d", name, lines)
+		}
+	}
+}
+
+func TestArchiveIsEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.History.MaxEntries = 1
+	cfg.History.ArchiveFile = filepath.Join(dir, "history.jsonl")
+	// Архив, записанный до включения шифрования
+	os.WriteFile(cfg.History.ArchiveFile+".1", []byte(`{"command":"old_secret = 1","id":1}`+"\n"), 0644)
+
+	key, _ := persistence.GenerateKey()
+	cfg.Storage.EncryptionKey = key
+	hm := NewHistoryManagerWithConfig(persistence.NewInMemoryPersistenceManager(), cfg)
+	hm.AddCommand("new_secret = 2")
+	hm.AddCommand("x = 3")
+
+	for _, secret := range []string{"old_secret", "new_secret"} {
+		for _, name := range []string{"history.jsonl", "history.jsonl.1"} {
+			if content, _ := os.ReadFile(filepath.Join(dir, name)); strings.Contains(string(content), secret) {
+				t.Errorf("%s contains %s in plaintext", name, secret)
+			}
+		}
+	}
+
+	parsed, _ := persistence.ParseKey(key)
+	entries, plain, err := readArchive(cfg.History.ArchiveFile, parsed)
+	if err != nil || plain || len(entries) != 1 || entries[0].Command != "new_secret = 2" {
+		t.Fatalf("Expected the encrypted entry, got %+v %v %v", entries, plain, err)
+	}
+
+	// Расшифровка вслед за calc rekey --decrypt
+	commit, err := RekeyArchive(cfg, parsed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RekeyArchive(cfg, nil, parsed); !errors.Is(err, persistence.ErrNoKey) {
+		t.Errorf("Expected ErrNoKey without the current key, got %v", err)
+	}
+	if err := commit(); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(cfg.History.ArchiveFile + ".1"); !strings.Contains(string(content), "old_secret") {
+		t.Errorf("Expected the archive decrypted, got %s", content)
+	}
+}
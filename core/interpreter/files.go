@@ -0,0 +1,58 @@
+package interpreter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ============================================================================
+// ФАЙЛЫ КОМАНД
+// ============================================================================
+
+// RestrictFiles - файлы в командах (history export, export/import) только внутри каталога
+// server.files_dir; если он не задан, команды с файлом отклоняются. Вызывается для
+// интерпретаторов веб-интерфейса: путь в команде задает клиент, а не владелец сервера.
+func (i *Interpreter) RestrictFiles() {
+	i.restrictFiles.Store(true)
+}
+
+// filePath - путь к файлу команды. Без ограничений путь не меняется; иначе относительный
+// путь разрешается внутри server.files_dir, а выход из каталога (.., абсолютный путь,
+// символическая ссылка) отклоняется.
+func (i *Interpreter) filePath(path string) (string, error) {
+	if !i.restrictFiles.Load() {
+		return path, nil
+	}
+	dir := i.Config().Server.FilesDir
+	if dir == "" {
+		return "", fmt.Errorf("файлы недоступны из веб-интерфейса: используйте /api/export и /api/import или задайте server.files_dir")
+	}
+	if !filepath.IsLocal(path) {
+		return "", fmt.Errorf("файл %s вне каталога server.files_dir: укажите путь относительно него", path)
+	}
+
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("каталог server.files_dir недоступен: %v", err)
+	}
+	full := filepath.Join(root, path)
+	parent, err := filepath.EvalSymlinks(filepath.Dir(full))
+	if err != nil {
+		return "", fmt.Errorf("каталог файла %s не найден", path)
+	}
+	if !within(root, parent) {
+		return "", fmt.Errorf("файл %s вне каталога server.files_dir", path)
+	}
+	if info, err := os.Lstat(full); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return "", fmt.Errorf("файл %s - символическая ссылка", path)
+	}
+	return full, nil
+}
+
+// within - path совпадает с root или лежит внутри него
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,310 @@
+package interpreter
+
+import (
+	"app/core/persistence"
+	"app/core/variables"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// ФОРМУЛЫ
+// ============================================================================
+
+// Переменная, привязанная к формуле (total := price * qty), хранит выражение и
+// пересчитывается, когда меняется любая переменная из него. Граф зависимостей не
+// хранится: он строится по формулам при каждом изменении.
+
+var (
+	bindingPattern    = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*:=\s*(.+)$`)
+	identifierPattern = regexp.MustCompile(`\{([a-zA-Z_][a-zA-Z0-9_]*)\}|([a-zA-Z_][a-zA-Z0-9_]*)`)
+)
+
+// parseBinding - name := expression
+func parseBinding(inputStr string) (bool, string, string) {
+	matches := bindingPattern.FindStringSubmatch(inputStr)
+	if matches == nil {
+		return false, "", ""
+	}
+	return true, matches[1], strings.TrimSpace(matches[2])
+}
+
+// formulaDependencies - имена переменных в выражении без повторов, в порядке появления;
+// разбор тот же, что при подстановке значений
+func formulaDependencies(expression string) []string {
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, m := range identifierPattern.FindAllStringSubmatchIndex(expression, -1) {
+		name := extractVariableName(expression, m)
+		if name == "" || isPartOfWord(expression, m[0], m[1]) || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// handleBinding - name := expression. Значение вычисляется сразу; формула, которая через
+// другие формулы зависит от самой себя, отклоняется.
+func (i *Interpreter) handleBinding(name, expression string) (interface{}, error) {
+	i.writes.Lock()
+	defer i.writes.Unlock()
+
+	if i.variables.IsConstant(name) {
+		return nil, fmt.Errorf("нельзя изменить %s: константа только для чтения", name)
+	}
+	formulas := i.variables.Formulas()
+	formulas[name] = expression
+	if cycle := findCycle(formulas, name); cycle != nil {
+		return nil, fmt.Errorf("цикл зависимостей: %s", strings.Join(cycle, " → "))
+	}
+	for _, dep := range formulaDependencies(expression) {
+		if _, ok := i.variables.LookupVariable(dep); !ok {
+			return nil, fmt.Errorf("переменная %s не определена", dep)
+		}
+	}
+	result, err := i.evaluateExpression(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	old, existed := i.variables.LookupVariable(name)
+	oldMeta := i.variables.Meta(name)
+	if err := i.variables.SetFormula(name, result, expression); err != nil {
+		return nil, err
+	}
+	i.journal.Record(persistence.VariableChange{
+		Name:      name,
+		Existed:   existed,
+		Old:       old,
+		New:       result,
+		Timestamp: time.Now().Format(time.RFC3339),
+		OldMeta:   oldMeta,
+		NewMeta:   i.variables.Meta(name),
+	})
+	recalculated := i.recalculate(name)
+	i.saveState()
+	return withRecalculated(fmt.Sprintf("%s = %v (формула %s)", name, result, expression), recalculated), nil
+}
+
+// findCycle - путь от start обратно к start по зависимостям формул; nil, если цикла нет
+func findCycle(formulas map[string]string, start string) []string {
+	visited := make(map[string]bool)
+	path := make([]string, 0)
+	var visit func(name string) bool
+	visit = func(name string) bool {
+		path = append(path, name)
+		for _, dep := range formulaDependencies(formulas[name]) {
+			if dep == start {
+				path = append(path, dep)
+				return true
+			}
+			if _, ok := formulas[dep]; ok && !visited[dep] {
+				visited[dep] = true
+				if visit(dep) {
+					return true
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	if visit(start) {
+		return path
+	}
+	return nil
+}
+
+// recalculationOrder - формулы, которые надо пересчитать после изменения changed
+// (сами changed, если это формулы, и все зависящие от них), в порядке зависимостей.
+// cyclic - формулы, попавшие в цикл: такой цикл может вернуть отмена.
+func recalculationOrder(formulas map[string]string, changed []string) (order, cyclic []string) {
+	dependents := make(map[string][]string)
+	for name, expression := range formulas {
+		for _, dep := range formulaDependencies(expression) {
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+
+	affected := make(map[string]bool)
+	queue := append([]string(nil), changed...)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if _, ok := formulas[name]; ok {
+			affected[name] = true
+		}
+		for _, dependent := range dependents[name] {
+			if !affected[dependent] {
+				affected[dependent] = true
+				queue = append(queue, dependent)
+			}
+		}
+	}
+
+	pending := make(map[string]int, len(affected))
+	for name := range affected {
+		pending[name] = 0
+		for _, dep := range formulaDependencies(formulas[name]) {
+			if affected[dep] {
+				pending[name]++
+			}
+		}
+	}
+	for len(pending) > 0 {
+		ready := make([]string, 0)
+		for name, count := range pending {
+			if count == 0 {
+				ready = append(ready, name)
+			}
+		}
+		if len(ready) == 0 {
+			break
+		}
+		sort.Strings(ready)
+		for _, name := range ready {
+			delete(pending, name)
+			order = append(order, name)
+			for _, dependent := range dependents[name] {
+				if _, ok := pending[dependent]; ok {
+					pending[dependent]--
+				}
+			}
+		}
+	}
+	for name := range pending {
+		cyclic = append(cyclic, name)
+	}
+	sort.Strings(cyclic)
+	return order, cyclic
+}
+
+// recalculate - пересчет формул, зависящих от changed; вызывается под writes.
+// Пересчет не попадает в журнал отмены: отмена изменения входной переменной снова
+// пересчитывает формулы. Возвращает строки о пересчитанных значениях и ошибках.
+func (i *Interpreter) recalculate(changed ...string) []string {
+	formulas := i.variables.Formulas()
+	if len(formulas) == 0 {
+		return nil
+	}
+	order, cyclic := recalculationOrder(formulas, changed)
+	lines := make([]string, 0)
+	for _, name := range order {
+		value, err := i.evaluateExpression(formulas[name])
+		if err != nil {
+			lines = append(lines, fmt.Sprintf("⚠️ %s не пересчитана: %v", name, err))
+			continue
+		}
+		if old, _ := i.variables.LookupVariable(name); reflect.DeepEqual(old, value) {
+			continue
+		}
+		if err := i.variables.SetFormula(name, value, formulas[name]); err != nil {
+			lines = append(lines, fmt.Sprintf("⚠️ %s не пересчитана: %v", name, err))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("↻ %s = %v", name, value))
+	}
+	if len(cyclic) > 0 {
+		lines = append(lines, fmt.Sprintf("⚠️ цикл зависимостей, не пересчитаны: %s", strings.Join(cyclic, ", ")))
+	}
+	return lines
+}
+
+// withRecalculated - ответ команды и строки о пересчитанных формулах
+func withRecalculated(result string, lines []string) string {
+	if len(lines) == 0 {
+		return result
+	}
+	return result + "\n" + strings.Join(lines, "\n")
+}
+
+// dependentsOf - формулы, в которых есть переменная name, по алфавиту
+func dependentsOf(formulas map[string]string, name string) []string {
+	names := make([]string, 0)
+	for formula, expression := range formulas {
+		for _, dep := range formulaDependencies(expression) {
+			if dep == name {
+				names = append(names, formula)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isExplainCommand - explain <имя> для существующей переменной или константы;
+// остальное, начинающееся с explain, - запрос в свободной форме
+func (i *Interpreter) isExplainCommand(trimmed string) bool {
+	fields := strings.Fields(trimmed)
+	if len(fields) != 2 || fields[0] != "explain" {
+		return false
+	}
+	_, exists := i.variables.LookupVariable(fields[1])
+	return exists
+}
+
+// handleExplainCommand - дерево зависимостей переменной и формулы, которые от нее зависят
+func (i *Interpreter) handleExplainCommand(trimmed string) (interface{}, error) {
+	name := strings.Fields(trimmed)[1]
+	formulas := i.variables.Formulas()
+
+	var b strings.Builder
+	b.WriteString(i.explainLabel(name, formulas))
+	i.explainTree(&b, formulas, name, "", map[string]bool{name: true})
+	if _, ok := formulas[name]; !ok {
+		b.WriteString("\n(значение, не формула)")
+	}
+	if dependents := dependentsOf(formulas, name); len(dependents) > 0 {
+		fmt.Fprintf(&b, "\nЗависят от %s: %s", name, strings.Join(dependents, ", "))
+	}
+	return b.String(), nil
+}
+
+// explainTree - зависимости формулы name с отступом indent; path - формулы на пути от
+// корня, чтобы цикл не разворачивался бесконечно
+func (i *Interpreter) explainTree(b *strings.Builder, formulas map[string]string, name, indent string, path map[string]bool) {
+	deps := formulaDependencies(formulas[name])
+	for idx, dep := range deps {
+		branch, next := "├── ", "│   "
+		if idx == len(deps)-1 {
+			branch, next = "└── ", "    "
+		}
+		b.WriteString("\n" + indent + branch + i.explainLabel(dep, formulas))
+		if path[dep] {
+			b.WriteString(" (цикл)")
+			continue
+		}
+		path[dep] = true
+		i.explainTree(b, formulas, dep, indent+next, path)
+		delete(path, dep)
+	}
+}
+
+// explainLabel - имя, значение с единицей и формула или вид переменной
+func (i *Interpreter) explainLabel(name string, formulas map[string]string) string {
+	value, ok := i.variables.LookupVariable(name)
+	if !ok {
+		return name + " (не определена)"
+	}
+	label := fmt.Sprintf("%s = %v", name, value)
+	meta := i.variables.Meta(name)
+	if meta != nil && meta.Unit != "" {
+		label += " " + meta.Unit
+	}
+	switch {
+	case meta == nil && variables.IsBuiltin(name):
+		label += " (встроенная константа)"
+	case i.variables.IsConstant(name):
+		label += " 🔒"
+	}
+	if expression, ok := formulas[name]; ok {
+		label += " := " + expression
+	}
+	return label
+}
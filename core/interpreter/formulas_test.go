@@ -0,0 +1,130 @@
+package interpreter
+
+import (
+	"app/config"
+	"app/core/persistence"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormulaRecalculation(t *testing.T) {
+	cfg := config.Default()
+	path := filepath.Join(t.TempDir(), "data.json")
+	interp := NewInterpreterWithConfig(cfg, persistence.NewPersistenceManagerWithFile(path))
+
+	interp.Execute("price = 10")
+	interp.Execute("qty = 3")
+	if result, err := interp.Execute("total := price * qty"); err != nil || result != "total = 30 (формула price * qty)" {
+		t.Fatalf("Unexpected binding result %v, %v", result, err)
+	}
+	interp.Execute("grand := total + 5")
+
+	result, err := interp.Execute("price = 20")
+	if err != nil || result != "price = 20\n↻ total = 60\n↻ grand = 65" {
+		t.Fatalf("Expected dependents recalculated in order, got %q, %v", result, err)
+	}
+
+	// Отмена входной переменной пересчитывает формулы
+	interp.Execute("undo")
+	if vars := interp.GetVariables(); vars["total"] != 30.0 || vars["grand"] != 35.0 {
+		t.Errorf("Expected formulas recalculated after undo, got %v", vars)
+	}
+
+	// Формулы сохраняются
+	restarted := NewInterpreterWithConfig(cfg, persistence.NewPersistenceManagerWithFile(path))
+	restarted.Execute("qty = 4")
+	if vars := restarted.GetVariables(); vars["total"] != 40.0 || vars["grand"] != 45.0 {
+		t.Errorf("Expected formulas after restart, got %v", vars)
+	}
+
+	// Обычное присваивание снимает привязку
+	restarted.Execute("total = 1")
+	restarted.Execute("qty = 5")
+	if vars := restarted.GetVariables(); vars["total"] != 1.0 || vars["grand"] != 6.0 {
+		t.Errorf("Expected total unbound by assignment, got %v", vars)
+	}
+	if meta := restarted.variables.Meta("total"); meta.Formula {
+		t.Errorf("Expected no formula after assignment, got %+v", meta)
+	}
+}
+
+func TestFormulaCycles(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("a = 1")
+	interp.Execute("b := a + 1")
+	interp.Execute("m := b * 2")
+
+	for _, command := range []string{"a := m + 1", "x := x + 1"} {
+		if _, err := interp.Execute(command); err == nil || !strings.Contains(err.Error(), "цикл") {
+			t.Errorf("%s: expected a cycle error, got %v", command, err)
+		}
+	}
+	if _, err := interp.Execute("a := m + 1"); err == nil || !strings.Contains(err.Error(), "a → m → b → a") {
+		t.Errorf("Expected the cycle path, got %v", err)
+	}
+	if _, err := interp.Execute("d := missing * 2"); err == nil {
+		t.Error("Expected an error for an undefined dependency")
+	}
+	if vars := interp.GetVariables(); vars["a"] != 1.0 || vars["m"] != 4.0 {
+		t.Errorf("Rejected bindings must not change variables, got %v", vars)
+	}
+}
+
+func TestRecalculationOrder(t *testing.T) {
+	formulas := map[string]string{
+		"b": "a + 1",
+		"c": "b + a",
+		"d": "c * {b}",
+		"x": "y",
+		"y": "x",
+	}
+	order, cyclic := recalculationOrder(formulas, []string{"a"})
+	if !reflect.DeepEqual(order, []string{"b", "c", "d"}) || cyclic != nil {
+		t.Errorf("Unexpected order %v, cyclic %v", order, cyclic)
+	}
+	if _, cyclic := recalculationOrder(formulas, []string{"x"}); !reflect.DeepEqual(cyclic, []string{"x", "y"}) {
+		t.Errorf("Expected x and y in a cycle, got %v", cyclic)
+	}
+}
+
+func TestDeleteFormulaInput(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("price = 10")
+	interp.Execute("total := price * 2")
+
+	if _, err := interp.Execute("del price"); err == nil || !strings.Contains(err.Error(), "total") {
+		t.Errorf("Expected price to be kept while total depends on it, got %v", err)
+	}
+	if _, err := interp.Execute("del price total"); err != nil {
+		t.Errorf("Expected price deleted together with total, got %v", err)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("price = 10")
+	interp.Execute("describe price --unit руб")
+	interp.Execute("qty = 3")
+	interp.Execute("total := price * qty")
+	interp.Execute("area := pi * total")
+
+	result, err := interp.Execute("explain area")
+	expected := `area = 94.24777960769379 := pi * total
+├── pi = 3.141592653589793 (встроенная константа)
+└── total = 30 := price * qty
+    ├── price = 10 руб
+    └── qty = 3`
+	if err != nil || result != expected {
+		t.Errorf("Unexpected explain output:\n%v\n%v", result, err)
+	}
+
+	result, _ = interp.Execute("explain price")
+	if !strings.Contains(result.(string), "(значение, не формула)\nЗависят от price: total") {
+		t.Errorf("Expected dependents of price, got:\n%v", result)
+	}
+	if interp.isExplainCommand("explain quantum physics") || interp.isExplainCommand("explain nothing") {
+		t.Error("Free-form text must not be taken for explain")
+	}
+}
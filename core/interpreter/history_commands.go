@@ -0,0 +1,152 @@
+package interpreter
+
+import (
+	"app/core/history"
+	"app/core/transfer"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// КОМАНДЫ ИСТОРИИ
+// ============================================================================
+
+var historyRecallPattern = regexp.MustCompile(`^!(!|\d+)$`)
+
+const historyUsage = `Команды истории:
+  history                  вся история
+  history N                последние N команд
+  history search <запрос>  поиск: текст, re:, ~нечетко, since:, until:, kind:, is:error, uses:x
+  history delete <ID>      удаление записи
+  history export [файл]    выгрузка в JSON, CSV, JSONL или Markdown (по расширению файла)
+  history replay [N..M]    повторное выполнение команд с разницей переменных до и после
+                           --fresh - с пустыми переменными, --dry-run - без сохранения
+  history clear            очистка истории
+  !N                       повторить команду с номером N
+  !!                       повторить последнюю команду`
+
+// isHistoryCommand - команды вида history ...; присваивание history = ... командой не считается
+func (i *Interpreter) isHistoryCommand(trimmed string) bool {
+	if i.isVariableAssignment(trimmed) {
+		return false
+	}
+	return trimmed == "history" || strings.HasPrefix(trimmed, "history ")
+}
+
+func isHistoryRecall(trimmed string) bool {
+	return historyRecallPattern.MatchString(trimmed)
+}
+
+// recallCommand - команда из истории для !N и !!
+func (i *Interpreter) recallCommand(trimmed string) (string, error) {
+	ref := historyRecallPattern.FindStringSubmatch(trimmed)[1]
+	if ref == "!" {
+		command := i.history.GetLastCommand()
+		if command == "" {
+			return "", fmt.Errorf("история пуста")
+		}
+		return command, nil
+	}
+
+	id, _ := strconv.Atoi(ref)
+	entry, ok := i.history.GetEntry(id)
+	if !ok {
+		return "", fmt.Errorf("команда #%d не найдена в истории", id)
+	}
+	return entry.Command, nil
+}
+
+func (i *Interpreter) handleHistoryCommand(trimmed string) (interface{}, error) {
+	args := strings.Fields(trimmed)[1:]
+	if len(args) == 0 {
+		return historyTable("История команд", i.history.GetDetailedHistory(i.Config().History.MaxEntries)), nil
+	}
+
+	rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(trimmed[len("history"):]), args[0]))
+
+	switch args[0] {
+	case "clear":
+		count := i.clearHistory()
+		return fmt.Sprintf("✅ История очищена, удалено записей: %d", count), nil
+
+	case "search":
+		if rest == "" {
+			return nil, fmt.Errorf("укажите запрос: history search <запрос>\n%s", history.QuerySyntax)
+		}
+		query, err := history.ParseQuery(rest, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("некорректный запрос: %v\n%s", err, history.QuerySyntax)
+		}
+		found := i.history.Search(query)
+		title := fmt.Sprintf("Поиск %q: найдено %d", rest, found.Total)
+		if len(found.Entries) < found.Total {
+			title += fmt.Sprintf(", показаны %d-%d", found.Offset+1, found.Offset+len(found.Entries))
+		}
+		return historyTable(title, found.Entries), nil
+
+	case "delete":
+		id, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, fmt.Errorf("укажите номер записи: history delete <ID>")
+		}
+		if !i.history.DeleteEntry(id) {
+			return nil, fmt.Errorf("команда #%d не найдена в истории", id)
+		}
+		return fmt.Sprintf("✅ Запись #%d удалена из истории", id), nil
+
+	case "export":
+		return i.exportHistory(rest)
+
+	case "replay":
+		return i.replayHistory(args[1:])
+
+	case "help":
+		return historyUsage + "\n\n" + history.QuerySyntax, nil
+	}
+
+	if n, err := strconv.Atoi(args[0]); err == nil && len(args) == 1 {
+		if n <= 0 {
+			return nil, fmt.Errorf("число записей должно быть положительным")
+		}
+		return historyTable(fmt.Sprintf("Последние %d команд", n), i.history.GetDetailedHistory(n)), nil
+	}
+
+	return nil, fmt.Errorf("неизвестная команда истории %q\n%s", trimmed, historyUsage)
+}
+
+func historyTable(title string, entries []history.DetailedHistoryEntry) *Table {
+	table := NewTable(title, "ID", "Время", "Команда", "Результат")
+	for _, entry := range entries {
+		table.AddRow(strconv.Itoa(entry.ID), entry.Time, entry.Command, historyOutcome(entry))
+	}
+	return table
+}
+
+// historyOutcome - результат записи одной строкой: значение или ошибка
+func historyOutcome(entry history.DetailedHistoryEntry) string {
+	return outcomeString(entry.Result, entry.Error)
+}
+
+// outcomeString - значение или текст ошибки одной строкой
+func outcomeString(result interface{}, errText string) string {
+	if errText != "" {
+		return "❌ " + errText
+	}
+	if result == nil {
+		return ""
+	}
+	return strings.ReplaceAll(fmt.Sprintf("%v", result), "\n", " ")
+}
+
+// exportHistory - выгрузка истории в файл (формат по расширению, см. export history)
+// либо JSON в ответе, если файл не указан
+func (i *Interpreter) exportHistory(path string) (interface{}, error) {
+	format, err := transfer.HistoryFormat("", path)
+	if err != nil {
+		return nil, err
+	}
+	return i.exportTo("history", path, format)
+}
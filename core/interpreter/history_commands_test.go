@@ -0,0 +1,181 @@
+package interpreter
+
+import (
+	"app/config"
+	"app/core/persistence"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newMemoryInterpreter() *Interpreter {
+	return NewInterpreterWithConfig(config.Default(), persistence.NewInMemoryPersistenceManager())
+}
+
+func TestHistoryCommands(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("1+1")
+	interp.Execute("x = 5")
+	interp.Execute("x*2")
+
+	result, err := interp.Execute("history")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	table, ok := result.(*Table)
+	if !ok || table.Type != TableResultType {
+		t.Fatalf("Expected table result, got %T", result)
+	}
+	if len(table.Rows) != 3 || table.Rows[2][2] != "x*2" {
+		t.Errorf("Unexpected rows: %v", table.Rows)
+	}
+
+	result, _ = interp.Execute("history 2")
+	if rows := result.(*Table).Rows; len(rows) != 2 || rows[0][2] != "x = 5" {
+		t.Errorf("Unexpected last 2 rows: %v", rows)
+	}
+
+	result, _ = interp.Execute("history search X")
+	if rows := result.(*Table).Rows; len(rows) != 2 {
+		t.Errorf("Expected 2 search results, got %v", rows)
+	}
+
+	// Команды истории сами в историю не попадают
+	if count := interp.history.GetHistoryCount(); count != 3 {
+		t.Errorf("Expected 3 entries, got %d", count)
+	}
+}
+
+func TestHistoryRecall(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("2+3")
+	interp.Execute("10/2")
+
+	result, err := interp.Execute("!1")
+	if err != nil || result != 5.0 {
+		t.Errorf("Expected !1 to give 5, got %v, %v", result, err)
+	}
+
+	result, err = interp.Execute("!!")
+	if err != nil || result != 5.0 {
+		t.Errorf("Expected !! to repeat last command, got %v, %v", result, err)
+	}
+
+	if _, err := interp.Execute("!99"); err == nil {
+		t.Error("Expected error for unknown history ID")
+	}
+}
+
+func TestHistoryDeleteKeepsIDs(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("1+1")
+	interp.Execute("2+2")
+	interp.Execute("3+3")
+
+	if _, err := interp.Execute("history delete 2"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := interp.Execute("history delete 2"); err == nil {
+		t.Error("Expected error when deleting missing entry")
+	}
+
+	interp.Execute("4+4")
+	entries := interp.GetDetailedHistory(10)
+	ids := make([]int, len(entries))
+	for idx, entry := range entries {
+		ids[idx] = entry.ID
+	}
+	if len(ids) != 3 || ids[0] != 1 || ids[1] != 3 || ids[2] != 4 {
+		t.Errorf("Expected IDs [1 3 4], got %v", ids)
+	}
+}
+
+func TestHistoryExportAndClear(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("1+1")
+
+	path := filepath.Join(t.TempDir(), "history.csv")
+	if _, err := interp.Execute("history export " + path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(data), "1+1") {
+		t.Errorf("Expected exported command, got %q, %v", data, err)
+	}
+
+	result, _ := interp.Execute("history clear")
+	if !strings.Contains(result.(string), "1") {
+		t.Errorf("Unexpected clear result: %v", result)
+	}
+	if count := interp.history.GetHistoryCount(); count != 0 {
+		t.Errorf("Expected empty history, got %d", count)
+	}
+}
+
+func TestHistoryAssignmentIsNotCommand(t *testing.T) {
+	interp := newMemoryInterpreter()
+	result, err := interp.Execute("history = 3")
+	if err != nil || !strings.Contains(result.(string), "history = 3") {
+		t.Errorf("Expected assignment, got %v, %v", result, err)
+	}
+}
+
+func TestHistoryRecordsResults(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("y = 4")
+	interp.Execute("y/0")
+
+	entries := interp.GetDetailedHistory(10)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Kind != persistence.KindAssign || entries[0].Result == nil || entries[0].Error != "" {
+		t.Errorf("Unexpected assignment entry: %+v", entries[0])
+	}
+	if entries[1].Kind != persistence.KindMath || entries[1].Error == "" {
+		t.Errorf("Expected error recorded for division by zero: %+v", entries[1])
+	}
+	if entries[0].SessionID == "" || entries[0].SessionID != interp.SessionID() {
+		t.Errorf("Expected session ID %q, got %q", interp.SessionID(), entries[0].SessionID)
+	}
+}
+
+func TestHistoryRecordsCurlAndAI(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello from server"))
+	}))
+	defer server.Close()
+
+	cfg := config.Default()
+	cfg.AI.Enabled = false
+	interp := NewInterpreterWithConfig(cfg, persistence.NewInMemoryPersistenceManager())
+
+	interp.Execute("curl " + server.URL + "/?token=abc123")
+	interp.Execute("открой мне что-нибудь интересное")
+
+	entries := interp.GetDetailedHistory(10)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %+v", entries)
+	}
+	if entries[0].Kind != persistence.KindCurl || entries[0].Result != "hello from server" {
+		t.Errorf("Unexpected curl entry: %+v", entries[0])
+	}
+	if strings.Contains(entries[0].Command, "abc123") {
+		t.Errorf("Token must be redacted: %q", entries[0].Command)
+	}
+	if entries[1].Kind != persistence.KindAI || entries[1].Error == "" {
+		t.Errorf("Expected AI entry with error when assistant disabled: %+v", entries[1])
+	}
+}
+
+func TestTableString(t *testing.T) {
+	table := NewTable("Тест", "ID", "Команда")
+	table.AddRow("1", "2+2")
+	expected := "Тест\nID  Команда\n--  -------\n1   2+2"
+	if table.String() != expected {
+		t.Errorf("Unexpected table:\n%s", table.String())
+	}
+}
@@ -1,6 +1,7 @@
 package interpreter
 
 import (
+	"app/config"
 	agent "app/core/ai"
 	"app/core/applauncher"
 	"app/core/curl"
@@ -8,21 +9,26 @@ import (
 	"app/core/history"
 	"app/core/persistence"
 	"app/core/variables"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // ============================================================================
 // КОНСТАНТЫ
 // ============================================================================
 
+// Остальные ограничения задаются в config.Config
 const (
-	MaxOutputLength     = 1000
-	MaxSummaryLength    = 500
-	MaxHistoryEntries   = 1000
-	MinUsernameLength   = 3
-	RecentCommandsCount = 10
+	MinUsernameLength = 3
 )
 
 // ============================================================================
@@ -37,13 +43,36 @@ type Classification struct {
 }
 
 type Interpreter struct {
+	config         atomic.Pointer[config.Config]
 	evaluator      *evaluator.Evaluator
 	variables      *variables.VariableStore
+	journal        *variables.Journal
 	persistence    *persistence.PersistenceManager
 	history        *history.HistoryManager
 	curlClient     *curl.CurlClient
 	deepseekClient *agent.DeepSeekClient
 	appLauncher    *applauncher.AppLauncher
+
+	// storage - корневое хранилище со всеми рабочими пространствами; persistence - текущее
+	storage    *persistence.PersistenceManager
+	workspace  string
+	baseConfig *config.Config // настройки без параметров рабочего пространства
+
+	// reload - команда выполняется целиком либо со старыми, либо с новыми настройками
+	// и целиком в одном рабочем пространстве
+	reload sync.RWMutex
+
+	// writes - команды, меняющие переменные (присваивание, undo/redo, повтор истории), выполняются
+	// по очереди: одновременные x = x + 1 не теряют обновлений, при гонке побеждает последнее.
+	// Вычисления без присваивания и запросы curl/AI идут параллельно.
+	writes sync.Mutex
+
+	sessionID atomic.Value // string
+
+	// restrictFiles - файлы команд только внутри server.files_dir (см. RestrictFiles)
+	restrictFiles atomic.Bool
+	// pinned - рабочее пространство не переключается (см. PinWorkspace)
+	pinned atomic.Bool
 }
 
 // ============================================================================
@@ -51,21 +80,77 @@ type Interpreter struct {
 // ============================================================================
 
 func NewInterpreter() *Interpreter {
+	interpreter := NewInterpreterWithPersistence(persistence.NewPersistenceManager())
+	interpreter.DisplayRecentHistory()
+	return interpreter
+}
+
+// NewInterpreterWithPersistence - интерпретатор с заданным хранилищем,
+// без вывода последних команд (для скриптов и одноразовых вычислений)
+func NewInterpreterWithPersistence(pm *persistence.PersistenceManager) *Interpreter {
+	return NewInterpreterWithConfig(config.Load(), pm)
+}
+
+// NewInterpreterWithConfig - интерпретатор с заданными настройками и хранилищем.
+// При выключенном cfg.AI.Enabled запросы в свободной форме не отправляются в AI.
+// Открывается рабочее пространство cfg.Storage.Workspace (по умолчанию default).
+func NewInterpreterWithConfig(cfg *config.Config, pm *persistence.PersistenceManager) *Interpreter {
 	interpreter := &Interpreter{
-		evaluator:      evaluator.NewEvaluator(),
-		variables:      variables.NewVariableStore(),
-		persistence:    persistence.NewPersistenceManager(),
-		curlClient:     curl.NewCurlClient(),
-		deepseekClient: agent.NewDeepSeekClient(),
-		appLauncher:    applauncher.NewAppLauncher(),
+		evaluator:   evaluator.NewEvaluator(),
+		storage:     pm,
+		baseConfig:  cfg,
+		curlClient:  curl.NewCurlClientWithConfig(cfg),
+		appLauncher: applauncher.NewAppLauncherWithConfig(cfg),
 	}
 
-	interpreter.history = history.NewHistoryManager(interpreter.persistence)
-	interpreter.loadState()
+	interpreter.sessionID.Store(newSessionID())
+
+	workspace := cfg.Storage.Workspace
+	if workspace == "" {
+		workspace = persistence.DefaultNamespace
+	}
+	if err := interpreter.openWorkspace(workspace); err != nil {
+		fmt.Printf("⚠️ %v, используется рабочее пространство %s\n", err, persistence.DefaultNamespace)
+		interpreter.openWorkspace(persistence.DefaultNamespace)
+	}
 
 	return interpreter
 }
 
+func aiEnabled(cfg *config.Config) bool {
+	return cfg.AI.Enabled && cfg.AI.URL != ""
+}
+
+// ApplyConfig - применение новых настроек без перезапуска.
+// Ждет завершения выполняемых команд, затем обновляет AI, curl, запуск приложений и историю.
+// Параметры сервера и хранилища применяются только при запуске.
+// Настройки текущего рабочего пространства по-прежнему действуют поверх новых.
+func (i *Interpreter) ApplyConfig(cfg *config.Config) {
+	i.reload.Lock()
+	defer i.reload.Unlock()
+
+	i.baseConfig = cfg
+	i.applyConfig(i.workspaceConfig(i.workspaceSettings()))
+}
+
+// applyConfig - обновление компонентов под новые настройки; вызывается под reload.Lock
+func (i *Interpreter) applyConfig(cfg *config.Config) {
+	// Без URL обращаться к AI некуда - считаем ассистента отключенным
+	switch {
+	case !aiEnabled(cfg):
+		i.deepseekClient = nil
+	case i.deepseekClient == nil:
+		i.deepseekClient = agent.NewDeepSeekClientWithConfig(cfg)
+	default:
+		i.deepseekClient.Reconfigure(cfg)
+	}
+	i.curlClient.Reconfigure(cfg)
+	i.appLauncher.Reconfigure(cfg)
+	i.history.Reconfigure(cfg)
+	i.journal.SetDepth(cfg.Variables.UndoDepth)
+	i.config.Store(cfg)
+}
+
 func (i *Interpreter) loadState() {
 	data := i.persistence.LoadData()
 	if data == nil {
@@ -79,17 +164,21 @@ func (i *Interpreter) loadState() {
 	if len(data.Variables) > 0 {
 		i.variables.SetVariables(data.Variables)
 	}
+	i.variables.SetConstants(data.Constants)
+	i.variables.SetMetadata(data.Metadata)
 
-	i.displayRecentHistory()
+	i.journal.Load(data.Undo, data.Redo)
 }
 
-func (i *Interpreter) displayRecentHistory() {
-	recentHistory := i.history.GetDetailedHistory(RecentCommandsCount)
+// DisplayRecentHistory - вывод последних команд в stdout
+func (i *Interpreter) DisplayRecentHistory() {
+	count := i.Config().History.RecentCount
+	recentHistory := i.history.GetDetailedHistory(count)
 	if len(recentHistory) == 0 {
 		return
 	}
 
-	fmt.Printf("Последние %d команд из истории:\n", RecentCommandsCount)
+	fmt.Printf("Последние %d команд из истории:\n", count)
 	fmt.Println(strings.Repeat("-", 50))
 	for _, entry := range recentHistory {
 		fmt.Printf("%3d. [%s] %s\n", entry.ID, entry.Time, entry.Command)
@@ -97,12 +186,25 @@ func (i *Interpreter) displayRecentHistory() {
 	fmt.Println(strings.Repeat("-", 50))
 }
 
+// Close - запись отложенных изменений всех рабочих пространств и закрытие хранилища;
+// вызывается при завершении программы
+func (i *Interpreter) Close() error {
+	return i.storage.Close()
+}
+
+// saveState - сохранение переменных, констант, описаний и журнала отмены; история и счетчик ее номеров ведутся HistoryManager
 func (i *Interpreter) saveState() {
-	data := &persistence.CalculatorData{
-		History:   i.history.GetHistory(MaxHistoryEntries),
-		Variables: i.variables.GetVariables(),
-	}
-	i.persistence.SaveData(data)
+	vars := i.variables.GetVariables()
+	constants := i.variables.Constants()
+	metadata := i.variables.Metadata()
+	undo, redo := i.journal.State()
+	i.persistence.Update(func(data *persistence.CalculatorData) bool {
+		data.Variables = vars
+		data.Constants = constants
+		data.Metadata = metadata
+		data.Undo, data.Redo = undo, redo
+		return true
+	})
 }
 
 // ============================================================================
@@ -110,28 +212,127 @@ func (i *Interpreter) saveState() {
 // ============================================================================
 
 func (i *Interpreter) Execute(inputStr string) (interface{}, error) {
+	trimmed := strings.TrimSpace(inputStr)
+
+	// Команды рабочих пространств заменяют состояние целиком и в историю не записываются
+	if i.isWorkspaceCommand(trimmed) {
+		i.reload.Lock()
+		defer i.reload.Unlock()
+		return i.handleWorkspaceCommand(trimmed)
+	}
+
+	i.reload.RLock()
+	defer i.reload.RUnlock()
+
+	// Повтор команды из истории: !N, !!
+	if isHistoryRecall(trimmed) {
+		command, err := i.recallCommand(trimmed)
+		if err != nil {
+			return nil, err
+		}
+		return i.execute(command)
+	}
+
+	// Команды истории в историю не записываются
+	if i.isHistoryCommand(trimmed) {
+		return i.handleHistoryCommand(trimmed)
+	}
+
+	// Выгрузка и загрузка переменных и истории тоже
+	if i.isTransferCommand(trimmed) {
+		return i.handleTransferCommand(trimmed)
+	}
+
+	// Как и список переменных и дерево зависимостей формулы
+	if i.isVarsCommand(trimmed) {
+		return i.handleVarsCommand(trimmed)
+	}
+	if i.isExplainCommand(trimmed) {
+		return i.handleExplainCommand(trimmed)
+	}
+
+	return i.execute(inputStr)
+}
+
+func (i *Interpreter) execute(inputStr string) (interface{}, error) {
+	start := time.Now()
+
+	// Отмена и повтор изменений переменных
+	if command := strings.ToLower(strings.TrimSpace(inputStr)); command == "undo" || command == "redo" {
+		i.writes.Lock()
+		result, err := i.handleUndo(command == "undo")
+		i.writes.Unlock()
+		i.recordHistory(inputStr, persistence.KindUndo, start, result, err)
+		return result, err
+	}
+
+	// Объявление констант и удаление переменных
+	if i.isVariableCommand(inputStr) {
+		result, err := i.handleVariableCommand(strings.TrimSpace(inputStr))
+		i.recordHistory(inputStr, persistence.KindAssign, start, result, err)
+		return result, err
+	}
 
 	// Обработка curl команд
 	if strings.HasPrefix(strings.TrimSpace(inputStr), "curl ") {
 		urlArgs := strings.TrimSpace(inputStr[5:])
-		return i.handleCurl(urlArgs), nil
+		result := i.handleCurl(urlArgs)
+		i.recordResponse(inputStr, persistence.KindCurl, start, result)
+		return result, nil
 	}
 
 	// Обработка свободной формы (AI)
 	if i.isFreeFormInput(inputStr) {
-		return i.handleFreeFormInput(inputStr), nil
+		result, kind := i.handleFreeFormInput(inputStr)
+		i.recordResponse(inputStr, kind, start, result)
+		return result, nil
 	}
 
-	// Добавление в историю для математических выражений
-	i.history.AddCommand(inputStr)
+	kind := persistence.KindMath
+	var result interface{}
+	var err error
 
-	// Обработка присваивания переменных
-	if match, varName, expression := i.parseAssignment(inputStr); match {
-		return i.handleAssignment(varName, expression)
+	if match, varName, expression := parseBinding(inputStr); match {
+		// Привязка переменной к формуле
+		kind = persistence.KindAssign
+		result, err = i.handleBinding(varName, expression)
+	} else if match, varName, expression := i.parseAssignment(inputStr); match {
+		// Обработка присваивания переменных
+		kind = persistence.KindAssign
+		result, err = i.handleAssignment(varName, expression)
+	} else {
+		// Обработка математических выражений
+		result, err = i.evaluateExpression(inputStr)
 	}
 
-	// Обработка математических выражений
-	return i.evaluateExpression(inputStr)
+	// Добавление в историю для математических выражений вместе с результатом
+	i.recordHistory(inputStr, kind, start, result, err)
+	return result, err
+}
+
+// recordResponse - запись команды, которая сообщает об ошибке текстом с префиксом ❌
+func (i *Interpreter) recordResponse(command, kind string, start time.Time, response string) {
+	if strings.HasPrefix(response, "❌") {
+		i.recordHistory(command, kind, start, nil, errors.New(strings.TrimSpace(strings.TrimPrefix(response, "❌"))))
+		return
+	}
+	i.recordHistory(command, kind, start, response, nil)
+}
+
+// recordHistory - запись выполненной команды с результатом или ошибкой и длительностью
+func (i *Interpreter) recordHistory(command, kind string, start time.Time, result interface{}, err error) {
+	entry := persistence.HistoryEntry{
+		Command:    command,
+		Kind:       kind,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		SessionID:  i.SessionID(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		entry.Result = result
+	}
+	i.history.Record(entry)
 }
 
 // ============================================================================
@@ -139,27 +340,51 @@ func (i *Interpreter) Execute(inputStr string) (interface{}, error) {
 // ============================================================================
 
 func (i *Interpreter) handleAssignment(varName, expression string) (interface{}, error) {
+	i.writes.Lock()
+	defer i.writes.Unlock()
+
+	if i.variables.IsConstant(varName) {
+		return nil, fmt.Errorf("нельзя изменить %s: константа только для чтения", varName)
+	}
 	result, err := i.evaluateExpression(expression)
 	if err != nil {
 		return nil, err
 	}
-	i.variables.SetVariable(varName, result)
+	old, existed := i.variables.LookupVariable(varName)
+	oldMeta := i.variables.Meta(varName)
+	if err := i.variables.SetVariableFrom(varName, result, strings.TrimSpace(expression)); err != nil {
+		return nil, err
+	}
+	i.journal.Record(persistence.VariableChange{
+		Name:      varName,
+		Existed:   existed,
+		Old:       old,
+		New:       result,
+		Timestamp: time.Now().Format(time.RFC3339),
+		OldMeta:   oldMeta,
+		NewMeta:   i.variables.Meta(varName),
+	})
+	recalculated := i.recalculate(varName)
 	i.saveState()
-	return fmt.Sprintf("%s = %v", varName, result), nil
+	return withRecalculated(fmt.Sprintf("%s = %v", varName, result), recalculated), nil
 }
 
-func (i *Interpreter) handleFreeFormInput(inputStr string) string {
+// handleFreeFormInput - ответ и вид команды для истории
+func (i *Interpreter) handleFreeFormInput(inputStr string) (string, string) {
+	if i.deepseekClient == nil {
+		return "❌ AI-ассистент отключен, команда не распознана: " + inputStr, persistence.KindAI
+	}
+
 	classification := i.classifyAndParseRequest(inputStr)
-	fmt.Printf("DEBUG: classification.Type = '%s', len=%d\n", classification.Type, len(classification.Type))
 	switch classification.Type {
 	case "browser":
-		return i.handleBrowser(classification, inputStr)
+		return i.handleBrowser(classification, inputStr), persistence.KindBrowser
 	case "media":
-		return i.handleMedia(classification)
+		return i.handleMedia(classification), persistence.KindMedia
 	case "curl":
-		return i.handleCurlRequest(classification, inputStr)
+		return i.handleCurlRequest(classification, inputStr), persistence.KindCurl
 	default:
-		return i.getAIResponse(inputStr)
+		return i.getAIResponse(inputStr), persistence.KindAI
 	}
 }
 
@@ -219,7 +444,7 @@ func (i *Interpreter) handleCurlRequest(classification Classification, inputStr
 		return fmt.Sprintf("❌ Ошибка curl запроса: %v", err)
 	}
 
-	result = limitOutput(result, MaxSummaryLength)
+	result = limitOutput(result, i.Config().Output.MaxSummaryLength)
 	summary := i.getContentSummary(inputStr, result)
 
 	if summary != "" {
@@ -339,7 +564,23 @@ func (i *Interpreter) isSpecialCommand(trimmed string) bool {
 	}
 
 	// Проверка на команды истории
-	if trimmed == "history" || trimmed == "history clear" || strings.HasPrefix(trimmed, "history search") {
+	if i.isHistoryCommand(trimmed) || isHistoryRecall(trimmed) {
+		return true
+	}
+
+	if i.isWorkspaceCommand(trimmed) {
+		return true
+	}
+
+	if i.isTransferCommand(trimmed) {
+		return true
+	}
+
+	if i.isVariableCommand(trimmed) || i.isVarsCommand(trimmed) || i.isExplainCommand(trimmed) {
+		return true
+	}
+
+	if match, _, _ := parseBinding(trimmed); match {
 		return true
 	}
 
@@ -398,7 +639,7 @@ func (i *Interpreter) handleCurl(urlArgs string) string {
 		return fmt.Sprintf("❌ Ошибка curl: %v", err)
 	}
 
-	return limitOutput(result, MaxOutputLength)
+	return limitOutput(result, i.Config().Output.MaxOutputLength)
 }
 
 func (i *Interpreter) parseCurlArgs(urlArgs string) (url, method string, headers map[string]string, body string, help bool) {
@@ -523,6 +764,12 @@ func (i *Interpreter) substituteVariables(expression string) (string, error) {
 // ============================================================================
 
 func (i *Interpreter) ClearHistory() int {
+	i.reload.RLock()
+	defer i.reload.RUnlock()
+	return i.clearHistory()
+}
+
+func (i *Interpreter) clearHistory() int {
 	count := i.history.GetHistoryCount()
 	i.history.ClearHistory()
 	i.saveState()
@@ -530,6 +777,8 @@ func (i *Interpreter) ClearHistory() int {
 }
 
 func (i *Interpreter) GetHistoryCommands(limit int) []string {
+	i.reload.RLock()
+	defer i.reload.RUnlock()
 	history := i.history.GetDetailedHistory(limit)
 	commands := make([]string, len(history))
 	for idx, entry := range history {
@@ -538,10 +787,84 @@ func (i *Interpreter) GetHistoryCommands(limit int) []string {
 	return commands
 }
 
+// SessionID - идентификатор сессии, которым помечаются записи истории.
+// По умолчанию у каждого интерпретатора свой случайный идентификатор.
+func (i *Interpreter) SessionID() string {
+	return i.sessionID.Load().(string)
+}
+
+// SetSessionID - привязка интерпретатора к внешней сессии (например, веб-клиента)
+func (i *Interpreter) SetSessionID(id string) {
+	i.sessionID.Store(id)
+}
+
+func newSessionID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Config - текущие настройки интерпретатора
+func (i *Interpreter) Config() *config.Config {
+	return i.config.Load()
+}
+
+// SearchHistory - поиск по истории (синтаксис запроса см. history.ParseQuery)
+func (i *Interpreter) SearchHistory(query history.Query) history.SearchResult {
+	i.reload.RLock()
+	defer i.reload.RUnlock()
+	return i.history.Search(query)
+}
+
+func (i *Interpreter) GetDetailedHistory(limit int) []history.DetailedHistoryEntry {
+	i.reload.RLock()
+	defer i.reload.RUnlock()
+	return i.history.GetDetailedHistory(limit)
+}
+
 func (i *Interpreter) GetVariables() map[string]interface{} {
+	i.reload.RLock()
+	defer i.reload.RUnlock()
 	return i.variables.GetVariables()
 }
 
+// ListVariables - переменные и встроенные константы с признаками констант, имена по алфавиту
+func (i *Interpreter) ListVariables() []variables.VariableInfo {
+	i.reload.RLock()
+	defer i.reload.RUnlock()
+	return i.variables.List()
+}
+
+// SetVariables - добавление переменных к текущему состоянию (calc eval --vars). Как и при
+// import vars, существующие перезаписываются, значения сохраняются и записываются в журнал
+// отмены, формулы от них пересчитываются. Константы не меняются; ошибка перечисляет пропущенные имена.
+func (i *Interpreter) SetVariables(vars map[string]interface{}) error {
+	i.reload.RLock()
+	defer i.reload.RUnlock()
+	i.writes.Lock()
+	defer i.writes.Unlock()
+
+	before := i.variables.GetVariables()
+	after := make(map[string]interface{}, len(before)+len(vars))
+	for name, value := range before {
+		after[name] = value
+	}
+	var errs []error
+	for _, name := range sortedNames(vars) {
+		if err := i.variables.Writable(name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		after[name] = vars[name]
+	}
+	if i.applyChanges(diffVariables(before, after), nil) > 0 {
+		i.saveState()
+	}
+	return errors.Join(errs...)
+}
+
 // ============================================================================
 // ВСПОМОГАТЕЛЬНЫЕ ФУНКЦИИ
 // ============================================================================
@@ -619,14 +942,25 @@ func isPartOfWord(expression string, start, end int) bool {
 	return false
 }
 
+func sortedNames(vars map[string]interface{}) []string {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func isAlphaNumeric(c byte) bool {
 	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_'
 }
 
+// formatVariableValue - значение для подстановки в выражение. Числа записываются без
+// экспоненты (299792458, а не 2.99792458e+08): экспоненту вычислитель не разбирает.
 func formatVariableValue(value interface{}) string {
 	switch v := value.(type) {
 	case float64:
-		return fmt.Sprintf("%v", v)
+		return strconv.FormatFloat(v, 'f', -1, 64)
 	case int:
 		return fmt.Sprintf("%d", v)
 	case string:
@@ -1,6 +1,8 @@
 package interpreter
 
 import (
+	"app/config"
+	"app/core/persistence"
 	"os"
 	"strings"
 	"testing"
@@ -316,6 +318,32 @@ func sprintf(format string, args ...interface{}) string {
 	return strings.Replace(format, "%.10f", "%.2f", 1)
 }
 
+func TestApplyConfig(t *testing.T) {
+	cfg := config.Default()
+	cfg.AI.URL = "http://localhost:9999"
+	interp := NewInterpreterWithConfig(cfg, persistence.NewInMemoryPersistenceManager())
+	if interp.deepseekClient == nil {
+		t.Fatal("Expected AI client to be created")
+	}
+
+	next := cfg.Clone()
+	next.AI.Enabled = false
+	next.Curl.AllowedHosts = []string{"example.com"}
+	next.History.RecentCount = 3
+	interp.ApplyConfig(next)
+
+	if interp.deepseekClient != nil {
+		t.Error("Expected AI client to be removed after disabling AI")
+	}
+	if interp.Config().History.RecentCount != 3 {
+		t.Errorf("Expected new config to be active, got %d", interp.Config().History.RecentCount)
+	}
+	result, _ := interp.Execute("curl https://other.org")
+	if !strings.Contains(result.(string), "other.org") {
+		t.Errorf("Expected curl to reject host from new allowlist, got %v", result)
+	}
+}
+
 func BenchmarkExecuteSimpleMath(b *testing.B) {
 	interp := setupTestInterpreter()
 	b.ResetTimer()
@@ -0,0 +1,279 @@
+package interpreter
+
+import (
+	"app/core/persistence"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// ПОВТОР ИСТОРИИ
+// ============================================================================
+
+const replayUsage = "использование: history replay [N..M] [--fresh] [--dry-run]"
+
+// replayableKinds - виды команд, которые можно безопасно выполнить повторно.
+// Запросы curl, AI и запуск приложений имеют внешние эффекты и пропускаются; undo/redo
+// тоже: у интерпретатора повтора свой пустой журнал отмены, и результат был бы другим.
+var replayableKinds = map[string]bool{
+	persistence.KindMath:   true,
+	persistence.KindAssign: true,
+}
+
+// replayOptions - диапазон номеров истории (0 - без границы) и режимы повтора
+type replayOptions struct {
+	from, to int
+	fresh    bool
+	dryRun   bool
+}
+
+func parseReplayArgs(args []string) (replayOptions, error) {
+	opts := replayOptions{}
+	hasRange := false
+
+	for _, arg := range args {
+		switch arg {
+		case "--fresh":
+			opts.fresh = true
+			continue
+		case "--dry-run":
+			opts.dryRun = true
+			continue
+		}
+		if hasRange {
+			return opts, fmt.Errorf("лишний аргумент %q\n%s", arg, replayUsage)
+		}
+		hasRange = true
+
+		from, to, isRange := strings.Cut(arg, "..")
+		if !isRange {
+			to = from
+		}
+		var err error
+		if opts.from, err = parseReplayBound(from); err != nil {
+			return opts, fmt.Errorf("некорректный диапазон %q\n%s", arg, replayUsage)
+		}
+		if opts.to, err = parseReplayBound(to); err != nil {
+			return opts, fmt.Errorf("некорректный диапазон %q\n%s", arg, replayUsage)
+		}
+		if !isRange && opts.from == 0 {
+			return opts, fmt.Errorf("некорректный диапазон %q\n%s", arg, replayUsage)
+		}
+		if opts.to > 0 && opts.from > opts.to {
+			return opts, fmt.Errorf("начало диапазона больше конца: %s", arg)
+		}
+	}
+	return opts, nil
+}
+
+func parseReplayBound(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("ожидается номер записи")
+	}
+	return n, nil
+}
+
+// replayHistory - повторное выполнение команд из диапазона истории в отдельном интерпретаторе.
+// С --fresh вычисления начинаются только с констант, иначе со всех текущих переменных;
+// в обоих случаях применяется только то, что повтор изменил относительно своего начала,
+// переменные вне диапазона остаются как есть. Без --dry-run итоговые переменные применяются к текущему состоянию через журнал отмены,
+// так что результат повтора можно откатить командой undo.
+func (i *Interpreter) replayHistory(args []string) (interface{}, error) {
+	opts, err := parseReplayArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Переменные не меняются между снимком "до" и применением результата
+	i.writes.Lock()
+	defer i.writes.Unlock()
+
+	entries := make([]persistence.HistoryEntry, 0)
+	for _, entry := range i.history.GetHistory(0) {
+		if (opts.from == 0 || entry.ID >= opts.from) && (opts.to == 0 || entry.ID <= opts.to) {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("в истории нет команд из диапазона %s", opts.rangeString())
+	}
+
+	// Черновой интерпретатор без хранилища и AI: повтор не трогает текущие историю и журнал
+	cfg := i.Config().Clone()
+	cfg.AI.Enabled = false
+	scratch := NewInterpreterWithConfig(cfg, persistence.NewInMemoryPersistenceManager())
+	scratch.SetSessionID(i.SessionID())
+
+	// Константы те же, что и в текущем состоянии: повтор не может их изменить. Описания
+	// и формулы тоже: формулы пересчитываются при повторе так же, как при выполнении.
+	before := i.variables.GetVariables()
+	constants := i.variables.Constants()
+	start := before
+	if opts.fresh {
+		start = make(map[string]interface{}, len(constants))
+		for _, name := range constants {
+			start[name] = before[name]
+		}
+	}
+	scratch.variables.SetVariables(start)
+	scratch.variables.SetMetadata(i.variables.Metadata())
+	scratch.variables.SetConstants(constants)
+
+	commands := NewTable("", "ID", "Команда", "Было", "Стало")
+	replayed, skipped, changed := 0, 0, 0
+	for _, entry := range entries {
+		if !replayableKinds[entry.Kind] {
+			skipped++
+			commands.AddRow(strconv.Itoa(entry.ID), entry.Command, outcomeString(entry.Result, entry.Error), "пропущено ("+entry.Kind+")")
+			continue
+		}
+
+		result, err := scratch.execute(entry.Command)
+		replayed++
+
+		errText := ""
+		if err != nil {
+			errText = err.Error()
+		}
+		was, now := outcomeString(entry.Result, entry.Error), outcomeString(result, errText)
+		if was != now {
+			changed++
+		} else {
+			now = "="
+		}
+		commands.AddRow(strconv.Itoa(entry.ID), entry.Command, was, now)
+	}
+	commands.Title = fmt.Sprintf("Повтор истории %s: выполнено %d, пропущено %d, изменились результаты %d",
+		opts.rangeString(), replayed, skipped, changed)
+
+	after := scratch.variables.GetVariables()
+	diff := rebaseChanges(diffVariables(start, after), before)
+
+	vars := NewTable("", "Переменная", "До", "После")
+	for _, change := range diff {
+		was, now := "—", "—"
+		if change.Existed {
+			was = formatVariableValue(change.Old)
+		}
+		if !change.Deleted {
+			now = formatVariableValue(change.New)
+		}
+		vars.AddRow(change.Name, was, now)
+	}
+
+	switch {
+	case len(diff) == 0:
+		vars.Title = "Переменные не изменились"
+	case opts.dryRun:
+		vars.Title = fmt.Sprintf("Изменений переменных: %d (--dry-run, не сохранено)", len(diff))
+	default:
+		applied := i.applyChanges(diff, scratch.variables.Meta)
+		i.saveState()
+		vars.Title = fmt.Sprintf("Изменений переменных: %d (сохранено, отмена - undo)", applied)
+	}
+
+	return Tables{commands, vars}, nil
+}
+
+func (o replayOptions) rangeString() string {
+	bound := func(n int) string {
+		if n == 0 {
+			return ""
+		}
+		return "#" + strconv.Itoa(n)
+	}
+	if o.from == 0 && o.to == 0 {
+		return "целиком"
+	}
+	if o.from == o.to {
+		return bound(o.from)
+	}
+	return bound(o.from) + ".." + bound(o.to)
+}
+
+// applyChanges - применение изменений к переменным с записью в журнал отмены и пересчетом
+// зависящих формул; изменения констант пропускаются. metaOf - откуда взято новое значение:
+// формула остается привязанной, у вычисленного значения сохраняется выражение. Без metaOf
+// (nil или nil от нее) новое значение заменяет формулу. Возвращает число примененных изменений.
+func (i *Interpreter) applyChanges(changes []persistence.VariableChange, metaOf func(name string) *persistence.VariableMeta) int {
+	applied := 0
+	names := make([]string, 0, len(changes))
+	for _, change := range changes {
+		change.OldMeta = i.variables.Meta(change.Name)
+		var meta *persistence.VariableMeta
+		if metaOf != nil && !change.Deleted {
+			meta = metaOf(change.Name)
+		}
+		var err error
+		switch {
+		case change.Deleted:
+			err = i.variables.DeleteVariable(change.Name)
+		case meta != nil && meta.Formula:
+			err = i.variables.SetFormula(change.Name, change.New, meta.Expression)
+		case meta != nil:
+			err = i.variables.SetVariableFrom(change.Name, change.New, meta.Expression)
+		default:
+			err = i.variables.SetVariable(change.Name, change.New)
+		}
+		if err != nil {
+			continue
+		}
+		change.NewMeta = i.variables.Meta(change.Name)
+		i.journal.Record(change)
+		names = append(names, change.Name)
+		applied++
+	}
+	i.recalculate(names...)
+	return applied
+}
+
+// rebaseChanges - изменения повтора относительно текущих переменных current: прежние
+// значения берутся из них, а изменения, которые ничего не меняют, пропускаются
+func rebaseChanges(changes []persistence.VariableChange, current map[string]interface{}) []persistence.VariableChange {
+	rebased := make([]persistence.VariableChange, 0, len(changes))
+	for _, change := range changes {
+		change.Old, change.Existed = current[change.Name]
+		if change.Deleted && !change.Existed {
+			continue
+		}
+		if !change.Deleted && change.Existed && reflect.DeepEqual(change.Old, change.New) {
+			continue
+		}
+		rebased = append(rebased, change)
+	}
+	return rebased
+}
+
+// diffVariables - изменения, переводящие переменные before в after, по именам
+func diffVariables(before, after map[string]interface{}) []persistence.VariableChange {
+	timestamp := time.Now().Format(time.RFC3339)
+	changes := make([]persistence.VariableChange, 0)
+
+	for name, value := range after {
+		old, existed := before[name]
+		if existed && reflect.DeepEqual(old, value) {
+			continue
+		}
+		changes = append(changes, persistence.VariableChange{
+			Name: name, Existed: existed, Old: old, New: value, Timestamp: timestamp,
+		})
+	}
+	for name, old := range before {
+		if _, exists := after[name]; !exists {
+			changes = append(changes, persistence.VariableChange{
+				Name: name, Existed: true, Old: old, Deleted: true, Timestamp: timestamp,
+			})
+		}
+	}
+
+	sort.Slice(changes, func(a, b int) bool { return changes[a].Name < changes[b].Name })
+	return changes
+}
@@ -0,0 +1,188 @@
+package interpreter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseReplayArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		from, to int
+		fresh    bool
+		dryRun   bool
+		wantErr  bool
+	}{
+		{args: nil},
+		{args: []string{"3..10"}, from: 3, to: 10},
+		{args: []string{"3.."}, from: 3},
+		{args: []string{"..10", "--dry-run"}, to: 10, dryRun: true},
+		{args: []string{"7", "--fresh"}, from: 7, to: 7, fresh: true},
+		{args: []string{"10..3"}, wantErr: true},
+		{args: []string{"a..b"}, wantErr: true},
+		{args: []string{"1..2", "3..4"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		opts, err := parseReplayArgs(tt.args)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%v: expected error", tt.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", tt.args, err)
+			continue
+		}
+		if opts.from != tt.from || opts.to != tt.to || opts.fresh != tt.fresh || opts.dryRun != tt.dryRun {
+			t.Errorf("%v: got %+v", tt.args, opts)
+		}
+	}
+}
+
+func TestReplayDryRun(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("rate = 2")
+	interp.Execute("total = rate * 10")
+	interp.Execute("rate = 3")
+
+	result, err := interp.Execute("history replay 2 --dry-run")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	tables, ok := result.(Tables)
+	if !ok || len(tables) != 2 {
+		t.Fatalf("Expected two tables, got %T", result)
+	}
+	if row := tables[0].Rows[0]; row[2] != "total = 20" || row[3] != "total = 30" {
+		t.Errorf("Unexpected command row: %v", row)
+	}
+	if rows := tables[1].Rows; len(rows) != 1 || rows[0][0] != "total" || rows[0][1] != "20" || rows[0][2] != "30" {
+		t.Errorf("Unexpected variable diff: %v", rows)
+	}
+
+	if total := interp.GetVariables()["total"]; total != 20.0 {
+		t.Errorf("Dry run must not change variables, total = %v", total)
+	}
+	if count := interp.history.GetHistoryCount(); count != 3 {
+		t.Errorf("Replay must not add history entries, got %d", count)
+	}
+}
+
+func TestReplayApplyAndUndo(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("a = 1")
+	interp.Execute("b = a + 1")
+	interp.Execute("a = 5")
+
+	if _, err := interp.Execute("history replay 2.."); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if b := interp.GetVariables()["b"]; b != 6.0 {
+		t.Errorf("Expected b = 6 after replay, got %v", b)
+	}
+
+	interp.Execute("undo")
+	if b := interp.GetVariables()["b"]; b != 2.0 {
+		t.Errorf("Expected undo to restore b = 2, got %v", b)
+	}
+}
+
+func TestReplayFresh(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("x = 1")
+	interp.Execute("y = 2")
+	interp.Execute("curl http://localhost:1")
+
+	result, err := interp.Execute("history replay 2.. --fresh")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	tables := result.(Tables)
+	if skipped := tables[0].Rows[1][3]; skipped != "пропущено (curl)" {
+		t.Errorf("Expected curl to be skipped, got %q", skipped)
+	}
+
+	vars := interp.GetVariables()
+	if vars["x"] != 1.0 || vars["y"] != 2.0 {
+		t.Errorf("Expected x kept and y = 2 after fresh replay, got %v", vars)
+	}
+}
+
+func TestReplayFreshKeepsOtherVariables(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("a = 5")
+	interp.Execute("b = 7")
+	interp.Execute("b = 1")
+
+	result, err := interp.Execute("history replay 2 --fresh")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rows := result.(Tables)[1].Rows; len(rows) != 1 || rows[0][0] != "b" || rows[0][1] != "1" || rows[0][2] != "7" {
+		t.Errorf("Expected only b in the variable diff, got %v", rows)
+	}
+	vars := interp.GetVariables()
+	if vars["a"] != 5.0 || vars["b"] != 7.0 {
+		t.Errorf("Expected a = 5 kept and b = 7, got %v", vars)
+	}
+
+	interp.Execute("undo")
+	if vars := interp.GetVariables(); vars["a"] != 5.0 || vars["b"] != 1.0 {
+		t.Errorf("Expected undo to restore b = 1, got %v", vars)
+	}
+}
+
+func TestReplayEmptyRange(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("1+1")
+	if _, err := interp.Execute("history replay 5..9"); err == nil {
+		t.Error("Expected error for empty range")
+	}
+}
+
+func TestReplaySkipsUndo(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("x = 1")
+	interp.Execute("x = 2")
+	interp.Execute("undo")
+
+	result, err := interp.Execute("history replay --dry-run")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rows := result.(Tables)[0].Rows
+	if len(rows) != 3 || rows[2][3] != "пропущено (undo)" {
+		t.Fatalf("Expected undo to be skipped, got %v", rows)
+	}
+}
+
+func TestReplayKeepsFormulas(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("price = 10")
+	interp.Execute("describe price --unit руб")
+	interp.Execute("qty = 3")
+	interp.Execute("total := price * qty")
+	interp.Execute("price = 20")
+
+	// Повтор до изменения цены: total снова 30 и остается формулой
+	if _, err := interp.Execute("history replay 1..4"); err != nil {
+		t.Fatal(err)
+	}
+	if vars := interp.GetVariables(); vars["price"] != 10.0 || vars["total"] != 30.0 {
+		t.Fatalf("Expected replayed values, got %v", vars)
+	}
+	result, _ := interp.Execute("explain total")
+	if !strings.Contains(result.(string), "total = 30 := price * qty") || strings.Contains(result.(string), "не формула") {
+		t.Errorf("Expected total to stay a formula, got:\n%v", result)
+	}
+	if meta := interp.variables.Meta("price"); meta == nil || meta.Unit != "руб" {
+		t.Errorf("Expected the description kept, got %+v", meta)
+	}
+
+	interp.Execute("qty = 4")
+	if vars := interp.GetVariables(); vars["total"] != 40.0 {
+		t.Errorf("Expected total recalculated after replay, got %v", vars)
+	}
+}
@@ -0,0 +1,87 @@
+package interpreter
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// TableResultType - значение поля type у табличного результата
+const TableResultType = "table"
+
+// Table - табличный результат команды.
+// В JSON передается как {"type": "table", ...}, веб-интерфейс выводит его таблицей,
+// терминал и пакетный режим - выровненным текстом через String.
+type Table struct {
+	Type    string     `json:"type"`
+	Title   string     `json:"title,omitempty"`
+	Columns []string   `json:"columns"`
+	Rows    [][]string `json:"rows"`
+}
+
+// NewTable - пустая таблица с заголовком и колонками
+func NewTable(title string, columns ...string) *Table {
+	return &Table{
+		Type:    TableResultType,
+		Title:   title,
+		Columns: columns,
+		Rows:    make([][]string, 0),
+	}
+}
+
+// AddRow - добавление строки; значения выравниваются по числу колонок
+func (t *Table) AddRow(values ...string) {
+	row := make([]string, len(t.Columns))
+	copy(row, values)
+	t.Rows = append(t.Rows, row)
+}
+
+func (t *Table) String() string {
+	widths := make([]int, len(t.Columns))
+	for col, name := range t.Columns {
+		widths[col] = utf8.RuneCountInString(name)
+	}
+	for _, row := range t.Rows {
+		for col, value := range row {
+			if n := utf8.RuneCountInString(value); n > widths[col] {
+				widths[col] = n
+			}
+		}
+	}
+
+	var sb strings.Builder
+	if t.Title != "" {
+		sb.WriteString(t.Title + "\n")
+	}
+	writeRow := func(values []string) {
+		cells := make([]string, len(values))
+		for col, value := range values {
+			cells[col] = value + strings.Repeat(" ", widths[col]-utf8.RuneCountInString(value))
+		}
+		sb.WriteString(strings.TrimRight(strings.Join(cells, "  "), " ") + "\n")
+	}
+
+	writeRow(t.Columns)
+	separators := make([]string, len(widths))
+	for col, width := range widths {
+		separators[col] = strings.Repeat("-", width)
+	}
+	writeRow(separators)
+	for _, row := range t.Rows {
+		writeRow(row)
+	}
+	if len(t.Rows) == 0 {
+		sb.WriteString("(пусто)\n")
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// Tables - результат из нескольких таблиц, выводятся по порядку
+type Tables []*Table
+
+func (ts Tables) String() string {
+	parts := make([]string, len(ts))
+	for idx, t := range ts {
+		parts[idx] = t.String()
+	}
+	return strings.Join(parts, "\n\n")
+}
@@ -0,0 +1,270 @@
+package interpreter
+
+import (
+	"app/core/transfer"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ============================================================================
+// ВЫГРУЗКА И ЗАГРУЗКА
+// ============================================================================
+
+const transferUsage = `Выгрузка и загрузка:
+  export vars [файл] [--format json|csv|env|yaml]
+  export history [файл] [--format json|csv|jsonl|markdown]
+  import vars <файл> [--format ...] [--strategy merge|overwrite|skip]
+  import history <файл> [--format ...] [--strategy merge|overwrite|skip]
+Без файла export выводит данные в ответ. Формат по умолчанию - по расширению файла, иначе json.
+Стратегии: merge - загруженное заменяет совпадающее (по умолчанию), skip - совпадающее
+остается прежним, overwrite - текущие переменные или история заменяются целиком.
+Загруженные переменные можно отменить командой undo.`
+
+// isTransferCommand - export/import vars|history; присваивание export = ... и
+// свободный текст, начинающийся с этих слов, командой не считаются
+func (i *Interpreter) isTransferCommand(trimmed string) bool {
+	if i.isVariableAssignment(trimmed) {
+		return false
+	}
+	fields := strings.Fields(trimmed)
+	if len(fields) == 0 || (fields[0] != "export" && fields[0] != "import") {
+		return false
+	}
+	if len(fields) == 1 {
+		return true
+	}
+	switch fields[1] {
+	case "vars", "history", "help":
+		return true
+	}
+	return false
+}
+
+// transferArgs - аргументы команды export/import
+type transferArgs struct {
+	command  string // export или import
+	what     string // vars или history
+	path     string
+	format   string
+	strategy string
+}
+
+func parseTransferArgs(trimmed string) (transferArgs, error) {
+	fields := strings.Fields(trimmed)
+	args := transferArgs{command: fields[0], what: fields[1]}
+
+	rest := fields[2:]
+	for idx := 0; idx < len(rest); idx++ {
+		name, value, inline := strings.Cut(rest[idx], "=")
+		var target *string
+		switch name {
+		case "--format":
+			target = &args.format
+		case "--strategy":
+			if args.command == "import" {
+				target = &args.strategy
+			}
+		default:
+			if strings.HasPrefix(name, "--") {
+				return args, fmt.Errorf("неизвестный флаг %s\n%s", name, transferUsage)
+			}
+			if args.path != "" {
+				return args, fmt.Errorf("лишний аргумент %q\n%s", rest[idx], transferUsage)
+			}
+			args.path = rest[idx]
+			continue
+		}
+		if target == nil {
+			return args, fmt.Errorf("флаг %s есть только у import\n%s", name, transferUsage)
+		}
+		if !inline {
+			if idx+1 >= len(rest) {
+				return args, fmt.Errorf("укажите значение %s", name)
+			}
+			idx++
+			value = rest[idx]
+		}
+		*target = value
+	}
+
+	if args.command == "import" && args.path == "" {
+		return args, fmt.Errorf("укажите файл: import %s <файл>", args.what)
+	}
+	return args, nil
+}
+
+// handleTransferCommand - вызывается под reload.RLock
+func (i *Interpreter) handleTransferCommand(trimmed string) (interface{}, error) {
+	if fields := strings.Fields(trimmed); len(fields) == 1 || fields[1] == "help" {
+		return transferUsage, nil
+	}
+	args, err := parseTransferArgs(trimmed)
+	if err != nil {
+		return nil, err
+	}
+
+	var format string
+	switch args.what {
+	case "vars":
+		format, err = transfer.VariableFormat(args.format, args.path)
+	case "history":
+		format, err = transfer.HistoryFormat(args.format, args.path)
+	default:
+		return nil, fmt.Errorf("неизвестный раздел %q, доступны: vars, history\n%s", args.what, transferUsage)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if args.command == "export" {
+		return i.exportTo(args.what, args.path, format)
+	}
+
+	strategy, err := transfer.ParseStrategy(args.strategy)
+	if err != nil {
+		return nil, err
+	}
+	full, err := i.filePath(args.path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(full)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия файла: %v", err)
+	}
+	defer file.Close()
+
+	var stats transfer.Stats
+	if args.what == "vars" {
+		stats, err = i.importVariables(file, format, strategy)
+	} else {
+		stats, err = i.importHistory(file, format, strategy)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", args.path, err)
+	}
+	what := "Переменные"
+	if args.what == "history" {
+		what = "История"
+	}
+	return fmt.Sprintf("✅ %s загружены из %s (%s, %s): %s", what, args.path, format, strategy, stats), nil
+}
+
+// exportTo - выгрузка в файл path или текстом в ответе, если файл не указан
+func (i *Interpreter) exportTo(what, path, format string) (interface{}, error) {
+	write := i.exportVariables
+	if what == "history" {
+		write = i.exportHistoryTo
+	}
+
+	if path == "" {
+		var buf bytes.Buffer
+		if err := write(&buf, format); err != nil {
+			return nil, err
+		}
+		return strings.TrimRight(buf.String(), "\n"), nil
+	}
+
+	full, err := i.filePath(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Create(full)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания файла: %v", err)
+	}
+	defer file.Close()
+	if err := write(file, format); err != nil {
+		return nil, fmt.Errorf("ошибка записи: %v", err)
+	}
+	if what == "history" {
+		return fmt.Sprintf("✅ История выгружена в %s (%s, записей: %d)", path, format, i.history.GetHistoryCount()), nil
+	}
+	return fmt.Sprintf("✅ Переменные выгружены в %s (%s, переменных: %d)", path, format, len(i.variables.GetVariables())), nil
+}
+
+func (i *Interpreter) exportVariables(w io.Writer, format string) error {
+	return transfer.WriteVariables(w, i.variables.GetVariables(), format)
+}
+
+func (i *Interpreter) exportHistoryTo(w io.Writer, format string) error {
+	return transfer.WriteHistory(w, i.history.GetHistory(0), format)
+}
+
+// importVariables - переменные из r по стратегии strategy. Каждое изменение попадает
+// в журнал отмены, как при history replay; константы не меняются.
+func (i *Interpreter) importVariables(r io.Reader, format, strategy string) (transfer.Stats, error) {
+	imported, err := transfer.ReadVariables(r, format)
+	if err != nil {
+		return transfer.Stats{}, err
+	}
+
+	i.writes.Lock()
+	defer i.writes.Unlock()
+	before := i.variables.GetVariables()
+	after, stats := transfer.MergeVariables(before, imported, strategy, i.importProtected(imported))
+	i.applyChanges(diffVariables(before, after), nil)
+	if stats.Changed() {
+		i.saveState()
+	}
+	return stats, nil
+}
+
+// importProtected - переменные, которые загрузка не меняет: константы и формулы (их значения
+// пересчитываются из загруженных переменных), а также переменные, от которых зависят формулы,
+// если их нет в файле: overwrite не удаляет их из-под формул. Вызывается под writes.
+func (i *Interpreter) importProtected(imported map[string]interface{}) func(name string) bool {
+	formulas := i.variables.Formulas()
+	inputs := make(map[string]bool)
+	for _, expression := range formulas {
+		for _, dep := range formulaDependencies(expression) {
+			inputs[dep] = true
+		}
+	}
+	return func(name string) bool {
+		if _, ok := formulas[name]; ok || i.variables.IsConstant(name) {
+			return true
+		}
+		_, inFile := imported[name]
+		return inputs[name] && !inFile
+	}
+}
+
+func (i *Interpreter) importHistory(r io.Reader, format, strategy string) (transfer.Stats, error) {
+	entries, err := transfer.ReadHistory(r, format)
+	if err != nil {
+		return transfer.Stats{}, err
+	}
+	return i.history.Import(entries, strategy), nil
+}
+
+// ExportVariables - выгрузка переменных текущего пространства в формате format
+func (i *Interpreter) ExportVariables(w io.Writer, format string) error {
+	i.reload.RLock()
+	defer i.reload.RUnlock()
+	return i.exportVariables(w, format)
+}
+
+// ImportVariables - загрузка переменных в формате format по стратегии strategy
+func (i *Interpreter) ImportVariables(r io.Reader, format, strategy string) (transfer.Stats, error) {
+	i.reload.RLock()
+	defer i.reload.RUnlock()
+	return i.importVariables(r, format, strategy)
+}
+
+// ExportHistory - выгрузка всей истории текущего пространства в формате format
+func (i *Interpreter) ExportHistory(w io.Writer, format string) error {
+	i.reload.RLock()
+	defer i.reload.RUnlock()
+	return i.exportHistoryTo(w, format)
+}
+
+// ImportHistory - загрузка истории в формате format по стратегии strategy
+func (i *Interpreter) ImportHistory(r io.Reader, format, strategy string) (transfer.Stats, error) {
+	i.reload.RLock()
+	defer i.reload.RUnlock()
+	return i.importHistory(r, format, strategy)
+}
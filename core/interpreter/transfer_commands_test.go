@@ -0,0 +1,135 @@
+package interpreter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportImportVariables(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("x = 2")
+	interp.Execute("y = x * 3")
+
+	path := filepath.Join(t.TempDir(), "vars.env")
+	if _, err := interp.Execute("export vars " + path); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(path)
+	if string(content) != "x=2\ny=6\n" {
+		t.Errorf("Unexpected env file:\n%s", content)
+	}
+
+	other := newMemoryInterpreter()
+	other.Execute("x = 100")
+	other.Execute("z = 1")
+	result, err := other.Execute("import vars " + path + " --strategy skip")
+	if err != nil || !strings.Contains(result.(string), "добавлено 1, обновлено 0, пропущено 1") {
+		t.Fatalf("Unexpected import result %v, %v", result, err)
+	}
+	if vars := other.GetVariables(); vars["x"] != 100.0 || vars["y"] != 6.0 {
+		t.Errorf("Expected x kept and y added, got %v", vars)
+	}
+
+	if _, err := other.Execute("import vars " + path + " --strategy=overwrite"); err != nil {
+		t.Fatal(err)
+	}
+	if vars := other.GetVariables(); len(vars) != 2 || vars["x"] != 2.0 {
+		t.Errorf("Expected variables replaced by the file, got %v", vars)
+	}
+
+	// Каждое изменение загрузки отменяется по отдельности
+	for range 2 {
+		if _, err := other.Execute("undo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if vars := other.GetVariables(); vars["x"] != 100.0 || vars["z"] != 1.0 {
+		t.Errorf("Expected overwrite undone, got %v", vars)
+	}
+}
+
+func TestExportToResponse(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("rate = 0.5")
+	interp.Execute("rate * 4")
+
+	result, err := interp.Execute("export vars --format yaml")
+	if err != nil || result != "rate: 0.5" {
+		t.Errorf("Expected YAML in the response, got %q, %v", result, err)
+	}
+	result, err = interp.Execute("export history --format markdown")
+	if err != nil || !strings.Contains(result.(string), "| rate * 4 | 2 |") {
+		t.Errorf("Expected a markdown table, got %v, %v", result, err)
+	}
+	if count := interp.history.GetHistoryCount(); count != 2 {
+		t.Errorf("Export commands must not be recorded in history, got %d entries", count)
+	}
+}
+
+func TestImportHistoryCommand(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("1+1")
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	if _, err := interp.Execute("export history " + path); err != nil {
+		t.Fatal(err)
+	}
+
+	other := newMemoryInterpreter()
+	other.Execute("2+2")
+	result, err := other.Execute("import history " + path)
+	if err != nil || !strings.Contains(result.(string), "добавлено 1") {
+		t.Fatalf("Unexpected import result %v, %v", result, err)
+	}
+	if commands := other.GetHistoryCommands(10); len(commands) != 2 || commands[1] != "1+1" {
+		t.Errorf("Expected imported command after 2+2, got %v", commands)
+	}
+}
+
+func TestTransferCommandErrors(t *testing.T) {
+	interp := newMemoryInterpreter()
+	tests := []struct {
+		input, message string
+	}{
+		{"import vars", "укажите файл"},
+		{"export vars --format markdown", "неизвестный формат"},
+		{"import history x.jsonl --strategy replace", "неизвестная стратегия"},
+		{"export vars a b", "лишний аргумент"},
+		{"export vars --strategy skip", "только у import"},
+		{"import vars " + filepath.Join(t.TempDir(), "none.json"), "ошибка открытия"},
+	}
+	for _, tt := range tests {
+		if _, err := interp.Execute(tt.input); err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%q: expected error with %q, got %v", tt.input, tt.message, err)
+		}
+	}
+
+	if result, err := interp.Execute("export"); err != nil || !strings.Contains(result.(string), "import vars") {
+		t.Errorf("Expected usage, got %v, %v", result, err)
+	}
+	if result, err := interp.Execute("export = 3"); err != nil || result != "export = 3" {
+		t.Errorf("Expected assignment, got %v, %v", result, err)
+	}
+}
+
+func TestImportKeepsFormulas(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("price = 10")
+	interp.Execute("qty = 3")
+	interp.Execute("total := price * qty")
+
+	path := filepath.Join(t.TempDir(), "vars.json")
+	os.WriteFile(path, []byte(`{"price": 20, "total": 1}`), 0644)
+	result, err := interp.Execute("import vars " + path + " --strategy overwrite")
+	if err != nil || !strings.Contains(result.(string), "обновлено 1, пропущено 1") {
+		t.Fatalf("Unexpected import result %v, %v", result, err)
+	}
+	// total - формула: не перезаписана файлом, а пересчитана; qty нужна формуле и не удалена
+	if vars := interp.GetVariables(); vars["price"] != 20.0 || vars["qty"] != 3.0 || vars["total"] != 60.0 {
+		t.Errorf("Expected total recalculated from the imported price, got %v", vars)
+	}
+	if meta := interp.variables.Meta("total"); meta == nil || !meta.Formula {
+		t.Errorf("Expected total to stay a formula, got %+v", meta)
+	}
+}
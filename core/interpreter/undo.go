@@ -0,0 +1,63 @@
+package interpreter
+
+import (
+	"fmt"
+)
+
+// ============================================================================
+// ОТМЕНА И ПОВТОР
+// ============================================================================
+
+// handleUndo - команды undo и redo; журнал изменений сохраняется вместе с переменными.
+// Формулы, зависящие от восстановленной переменной, пересчитываются.
+func (i *Interpreter) handleUndo(undo bool) (interface{}, error) {
+	result, name, err := i.undoOrRedo(undo)
+	if err != nil {
+		return nil, err
+	}
+	recalculated := i.recalculate(name)
+	i.saveState()
+	return withRecalculated(result, recalculated), nil
+}
+
+// undoOrRedo - ответ команды и имя измененной переменной
+func (i *Interpreter) undoOrRedo(undo bool) (string, string, error) {
+	if undo {
+		change, ok, err := i.journal.Undo(i.variables)
+		if !ok {
+			return "", "", fmt.Errorf("нечего отменять")
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("нельзя отменить: %v", err)
+		}
+		if change.Deleted {
+			return fmt.Sprintf("↩️ %s = %v (отменено удаление)", change.Name, change.Old), change.Name, nil
+		}
+		if !change.Existed {
+			return fmt.Sprintf("↩️ Переменная %s удалена (отменено %s = %v)", change.Name, change.Name, change.New), change.Name, nil
+		}
+		return fmt.Sprintf("↩️ %s = %v (отменено %s = %v)", change.Name, change.Old, change.Name, change.New), change.Name, nil
+	}
+
+	change, ok, err := i.journal.Redo(i.variables)
+	if !ok {
+		return "", "", fmt.Errorf("нечего повторять")
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("нельзя повторить: %v", err)
+	}
+	if change.Deleted {
+		return fmt.Sprintf("↪️ Переменная %s удалена", change.Name), change.Name, nil
+	}
+	return fmt.Sprintf("↪️ %s = %v", change.Name, change.New), change.Name, nil
+}
+
+// Undo - отмена последнего изменения переменной, как команда undo
+func (i *Interpreter) Undo() (interface{}, error) {
+	return i.Execute("undo")
+}
+
+// Redo - повтор отмененного изменения, как команда redo
+func (i *Interpreter) Redo() (interface{}, error) {
+	return i.Execute("redo")
+}
@@ -0,0 +1,95 @@
+package interpreter
+
+import (
+	"app/config"
+	"app/core/persistence"
+	"path/filepath"
+	"testing"
+)
+
+func TestUndoRedoAssignment(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("rate = 0.05")
+	interp.Execute("rate = 5")
+
+	if _, err := interp.Execute("undo"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rate := interp.GetVariables()["rate"]; rate != 0.05 {
+		t.Errorf("Expected rate restored to 0.05, got %v", rate)
+	}
+
+	interp.Execute("undo")
+	if _, exists := interp.GetVariables()["rate"]; exists {
+		t.Error("Expected rate to be removed after undoing its creation")
+	}
+	if _, err := interp.Execute("undo"); err == nil {
+		t.Error("Expected error when journal is empty")
+	}
+
+	interp.Execute("redo")
+	interp.Execute("redo")
+	if rate := interp.GetVariables()["rate"]; rate != 5.0 {
+		t.Errorf("Expected rate = 5 after redo, got %v", rate)
+	}
+
+	// Новое присваивание очищает redo
+	interp.Execute("undo")
+	interp.Execute("other = 1")
+	if _, err := interp.Execute("redo"); err == nil {
+		t.Error("Expected redo to be cleared by new assignment")
+	}
+}
+
+func TestUndoDepthAndPersistence(t *testing.T) {
+	cfg := config.Default()
+	cfg.Variables.UndoDepth = 2
+	path := filepath.Join(t.TempDir(), "data.json")
+
+	interp := NewInterpreterWithConfig(cfg, persistence.NewPersistenceManagerWithFile(path))
+	interp.Execute("a = 1")
+	interp.Execute("a = 2")
+	interp.Execute("a = 3")
+
+	// После перезапуска журнал восстанавливается из файла
+	restarted := NewInterpreterWithConfig(cfg, persistence.NewPersistenceManagerWithFile(path))
+	restarted.Execute("undo")
+	restarted.Execute("undo")
+	if a := restarted.GetVariables()["a"]; a != 1.0 {
+		t.Errorf("Expected a = 1 after two undos, got %v", a)
+	}
+	if _, err := restarted.Execute("undo"); err == nil {
+		t.Error("Expected journal depth of 2")
+	}
+
+	entries := restarted.GetDetailedHistory(2)
+	if entries[1].Kind != persistence.KindUndo || entries[1].Error == "" {
+		t.Errorf("Expected failed undo recorded in history: %+v", entries[1])
+	}
+}
+
+func TestSetVariablesSavedAndUndone(t *testing.T) {
+	cfg := config.Default()
+	path := filepath.Join(t.TempDir(), "data.json")
+
+	interp := NewInterpreterWithConfig(cfg, persistence.NewPersistenceManagerWithFile(path))
+	interp.Execute("x = 1")
+	interp.Execute("const k = 2")
+	if err := interp.SetVariables(map[string]interface{}{"x": 10.0, "y": 20.0, "k": 3.0}); err == nil {
+		t.Error("Expected an error for the constant k")
+	}
+
+	// Значения из --vars сохраняются сразу, без последующих присваиваний
+	restarted := NewInterpreterWithConfig(cfg, persistence.NewPersistenceManagerWithFile(path))
+	vars := restarted.GetVariables()
+	if vars["x"] != 10.0 || vars["y"] != 20.0 || vars["k"] != 2.0 {
+		t.Fatalf("Expected x = 10, y = 20 and constant k = 2 after restart, got %v", vars)
+	}
+
+	restarted.Execute("undo")
+	restarted.Execute("undo")
+	vars = restarted.GetVariables()
+	if _, exists := vars["y"]; exists || vars["x"] != 1.0 {
+		t.Errorf("Expected undo to revert x and y, got %v", vars)
+	}
+}
@@ -0,0 +1,287 @@
+package interpreter
+
+import (
+	"app/core/persistence"
+	"app/core/variables"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// КОНСТАНТЫ, УДАЛЕНИЕ И ОПИСАНИЯ ПЕРЕМЕННЫХ
+// ============================================================================
+
+const describeUsage = `Описание переменной:
+  describe <имя>                         показать описание
+  describe <имя> <текст>                 задать описание ("" - очистить)
+  describe <имя> --unit <единица>        единица измерения (--unit= - очистить)
+  describe <имя> --tags <тег,тег>        теги через запятую (--tags= - очистить)
+Список переменных: vars [--tag <тег>]`
+
+var (
+	constPattern = regexp.MustCompile(`^const\s+([a-zA-Z_][a-zA-Z0-9_]*)\s*=\s*(.+)$`)
+	delPattern   = regexp.MustCompile(`^del\s+([a-zA-Z_][a-zA-Z0-9_]*(?:\s+[a-zA-Z_][a-zA-Z0-9_]*)*)$`)
+)
+
+// isVariableCommand - const x = ..., del x [y ...] и describe x ...; присваивание
+// const = ... и del = ... командой не считается. describe - команда, только если такая
+// переменная есть: иначе это может быть запрос в свободной форме.
+func (i *Interpreter) isVariableCommand(trimmed string) bool {
+	trimmed = strings.TrimSpace(trimmed)
+	if i.isVariableAssignment(trimmed) {
+		return false
+	}
+	fields := strings.Fields(trimmed)
+	if len(fields) < 2 {
+		return false
+	}
+	switch fields[0] {
+	case "const", "del":
+		return true
+	case "describe":
+		_, exists := i.variables.LookupVariable(fields[1])
+		return exists || fields[1] == "help"
+	}
+	return false
+}
+
+func (i *Interpreter) handleVariableCommand(trimmed string) (interface{}, error) {
+	if strings.HasPrefix(trimmed, "describe") {
+		return i.describeVariable(strings.Fields(trimmed)[1:])
+	}
+	if strings.HasPrefix(trimmed, "const") {
+		matches := constPattern.FindStringSubmatch(trimmed)
+		if matches == nil {
+			return nil, fmt.Errorf("использование: const <имя> = <выражение>")
+		}
+		return i.defineConstant(matches[1], matches[2])
+	}
+	matches := delPattern.FindStringSubmatch(trimmed)
+	if matches == nil {
+		return nil, fmt.Errorf("использование: del <имя> [имя ...]")
+	}
+	return i.deleteVariables(strings.Fields(matches[1]))
+}
+
+// defineConstant - const name = expression. Константу нельзя изменить, удалить и
+// вернуть отменой, поэтому ее прежние изменения убираются из журнала отмены.
+func (i *Interpreter) defineConstant(name, expression string) (interface{}, error) {
+	i.writes.Lock()
+	defer i.writes.Unlock()
+
+	if i.variables.IsConstant(name) {
+		return nil, fmt.Errorf("%s уже константа", name)
+	}
+	result, err := i.evaluateExpression(expression)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.variables.DefineConstant(name, result, expression); err != nil {
+		return nil, err
+	}
+	i.journal.Forget(name)
+	recalculated := i.recalculate(name)
+	i.saveState()
+	return withRecalculated(fmt.Sprintf("%s = %v (константа)", name, result), recalculated), nil
+}
+
+// deleteVariables - del x [y ...]; удаление можно отменить командой undo.
+// Если хотя бы одну переменную удалить нельзя, не удаляется ни одна. Переменную, от
+// которой зависят формулы, можно удалить только вместе с ними.
+func (i *Interpreter) deleteVariables(names []string) (interface{}, error) {
+	i.writes.Lock()
+	defer i.writes.Unlock()
+
+	vars := i.variables.GetVariables()
+	metas := make(map[string]*persistence.VariableMeta, len(names))
+	for _, name := range names {
+		_, exists := vars[name]
+		switch {
+		case !exists && variables.IsBuiltin(name):
+			return nil, fmt.Errorf("нельзя удалить %s: встроенная константа", name)
+		case !exists:
+			return nil, fmt.Errorf("переменная %s не найдена", name)
+		case i.variables.IsConstant(name) && !variables.IsBuiltin(name):
+			// Переменная с именем встроенной константы из старых файлов удаляется
+			return nil, fmt.Errorf("нельзя удалить %s: константа только для чтения", name)
+		}
+		metas[name] = i.variables.Meta(name)
+	}
+	formulas := i.variables.Formulas()
+	for _, name := range names {
+		for _, dependent := range dependentsOf(formulas, name) {
+			if _, deleted := metas[dependent]; !deleted {
+				return nil, fmt.Errorf("нельзя удалить %s: от нее зависит формула %s := %s", name, dependent, formulas[dependent])
+			}
+		}
+	}
+
+	timestamp := time.Now().Format(time.RFC3339)
+	for _, name := range names {
+		if err := i.variables.DeleteVariable(name); err != nil {
+			return nil, err
+		}
+		i.journal.Record(persistence.VariableChange{
+			Name: name, Existed: true, Old: vars[name], Deleted: true, Timestamp: timestamp, OldMeta: metas[name],
+		})
+	}
+	i.saveState()
+	if len(names) == 1 {
+		return fmt.Sprintf("Переменная %s удалена", names[0]), nil
+	}
+	return fmt.Sprintf("Переменные удалены: %s", strings.Join(names, ", ")), nil
+}
+
+// describeVariable - describe <имя> [текст] [--unit u] [--tags a,b]
+func (i *Interpreter) describeVariable(args []string) (interface{}, error) {
+	if args[0] == "help" {
+		return describeUsage, nil
+	}
+	name := args[0]
+	var description, unit, tags *string
+	words := make([]string, 0)
+	for idx := 1; idx < len(args); idx++ {
+		flag, value, inline := strings.Cut(args[idx], "=")
+		var target **string
+		switch flag {
+		case "--unit":
+			target = &unit
+		case "--tags":
+			target = &tags
+		default:
+			if strings.HasPrefix(flag, "--") {
+				return nil, fmt.Errorf("неизвестный флаг %s\n%s", flag, describeUsage)
+			}
+			words = append(words, args[idx])
+			continue
+		}
+		if !inline {
+			if idx+1 >= len(args) {
+				return nil, fmt.Errorf("укажите значение %s", flag)
+			}
+			idx++
+			value = args[idx]
+		}
+		*target = &value
+	}
+	if len(words) > 0 {
+		text := strings.Join(words, " ")
+		if text == `""` {
+			text = ""
+		}
+		description = &text
+	}
+
+	if description == nil && unit == nil && tags == nil {
+		if i.variables.Meta(name) == nil {
+			return nil, fmt.Errorf("%s: встроенная константа, описания нет", name)
+		}
+		return i.variablesTable(name, "", ""), nil
+	}
+
+	i.writes.Lock()
+	defer i.writes.Unlock()
+	err := i.variables.Describe(name, func(meta *persistence.VariableMeta) {
+		if description != nil {
+			meta.Description = *description
+		}
+		if unit != nil {
+			meta.Unit = strings.TrimSpace(*unit)
+		}
+		if tags != nil {
+			meta.Tags = parseTags(*tags)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	i.saveState()
+	return i.variablesTable(name, "", ""), nil
+}
+
+// parseTags - теги через запятую без пустых и повторов, в порядке ввода
+func parseTags(value string) []string {
+	tags := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "#")
+		if tag == "" || seen[strings.ToLower(tag)] {
+			continue
+		}
+		seen[strings.ToLower(tag)] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	return tags
+}
+
+// isVarsCommand - vars и vars --tag <тег>
+func (i *Interpreter) isVarsCommand(trimmed string) bool {
+	fields := strings.Fields(trimmed)
+	return len(fields) > 0 && fields[0] == "vars" && (len(fields) == 1 || strings.HasPrefix(fields[1], "--"))
+}
+
+// handleVarsCommand - таблица переменных с описаниями, с --tag - только с этим тегом
+func (i *Interpreter) handleVarsCommand(trimmed string) (interface{}, error) {
+	fields := strings.Fields(trimmed)[1:]
+	tag := ""
+	for idx := 0; idx < len(fields); idx++ {
+		flag, value, inline := strings.Cut(fields[idx], "=")
+		if flag != "--tag" {
+			return nil, fmt.Errorf("неизвестный аргумент %s\n%s", fields[idx], describeUsage)
+		}
+		if !inline {
+			if idx+1 >= len(fields) {
+				return nil, fmt.Errorf("укажите тег: vars --tag <тег>")
+			}
+			idx++
+			value = fields[idx]
+		}
+		tag = strings.TrimPrefix(value, "#")
+	}
+
+	title := fmt.Sprintf("Встроенные константы: %s", strings.Join(builtinNames(), ", "))
+	if tag != "" {
+		title = fmt.Sprintf("Переменные с тегом %s", tag)
+	}
+	return i.variablesTable("", tag, title), nil
+}
+
+// variablesTable - переменные (только name, если задано; только с тегом tag, если задан)
+// без встроенных констант
+func (i *Interpreter) variablesTable(name, tag, title string) *Table {
+	table := NewTable(title, "Имя", "Значение", "Единица", "Выражение", "Теги", "Описание", "Изменена")
+	for _, v := range i.variables.List() {
+		if v.Builtin || (name != "" && v.Name != name) || (tag != "" && !v.HasTag(tag)) {
+			continue
+		}
+		label := v.Name
+		if v.Constant {
+			label += " 🔒"
+		}
+		updated := v.Updated
+		if t, err := time.Parse(time.RFC3339, v.Updated); err == nil {
+			updated = t.Local().Format("2006-01-02 15:04:05")
+		}
+		expression := v.Expression
+		if v.Formula {
+			expression = ":= " + expression
+		}
+		table.AddRow(label, fmt.Sprintf("%v", v.Value), v.Unit, expression, strings.Join(v.Tags, ", "), v.Description, updated)
+	}
+	return table
+}
+
+func builtinNames() []string {
+	names := make([]string, 0, len(variables.Builtins))
+	for name := range variables.Builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,175 @@
+package interpreter
+
+import (
+	"app/config"
+	"app/core/persistence"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinConstants(t *testing.T) {
+	interp := newMemoryInterpreter()
+
+	tests := map[string]float64{
+		"2 * pi":   6.283185307179586,
+		"c / 1000": 299792.458,
+		"g * 2":    19.6133,
+	}
+	for expression, expected := range tests {
+		if result, err := interp.Execute(expression); err != nil || result != expected {
+			t.Errorf("%s: expected %v, got %v, %v", expression, expected, result, err)
+		}
+	}
+
+	if _, err := interp.Execute("pi = 3"); err == nil || !strings.Contains(err.Error(), "константа") {
+		t.Errorf("Expected builtin pi to be read-only, got %v", err)
+	}
+	if _, err := interp.Execute("del e"); err == nil {
+		t.Error("Expected builtin e not to be deleted")
+	}
+	if len(interp.GetVariables()) != 0 {
+		t.Errorf("Builtins must not be stored as variables, got %v", interp.GetVariables())
+	}
+}
+
+func TestConstDeclaration(t *testing.T) {
+	cfg := config.Default()
+	path := filepath.Join(t.TempDir(), "data.json")
+	interp := NewInterpreterWithConfig(cfg, persistence.NewPersistenceManagerWithFile(path))
+
+	interp.Execute("rate = 0.1")
+	if result, err := interp.Execute("const rate = 0.2"); err != nil || result != "rate = 0.2 (константа)" {
+		t.Fatalf("Unexpected const result %v, %v", result, err)
+	}
+	for _, command := range []string{"rate = 0.3", "const rate = 0.3", "del rate", "undo"} {
+		if _, err := interp.Execute(command); err == nil {
+			t.Errorf("%s: expected an error for a constant", command)
+		}
+	}
+	if result, _ := interp.Execute("rate * 10"); result != 2.0 {
+		t.Errorf("Expected rate * 10 = 2, got %v", result)
+	}
+
+	// Признак константы сохраняется
+	restarted := NewInterpreterWithConfig(cfg, persistence.NewPersistenceManagerWithFile(path))
+	if _, err := restarted.Execute("rate = 1"); err == nil {
+		t.Error("Expected rate to stay constant after restart")
+	}
+	if vars := restarted.GetVariables(); vars["rate"] != 0.2 {
+		t.Errorf("Expected rate = 0.2 after restart, got %v", vars)
+	}
+}
+
+func TestDeleteVariable(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("x = 1")
+	interp.Execute("y = 2")
+
+	if result, err := interp.Execute("del x y"); err != nil || result != "Переменные удалены: x, y" {
+		t.Fatalf("Unexpected del result %v, %v", result, err)
+	}
+	if len(interp.GetVariables()) != 0 {
+		t.Errorf("Expected no variables, got %v", interp.GetVariables())
+	}
+	if _, err := interp.Execute("del z"); err == nil {
+		t.Error("Expected an error for a missing variable")
+	}
+
+	interp.Execute("undo")
+	if vars := interp.GetVariables(); vars["y"] != 2.0 || len(vars) != 1 {
+		t.Errorf("Expected y restored by undo, got %v", vars)
+	}
+
+	// del как имя переменной остается присваиванием
+	if _, err := interp.Execute("del = 5"); err != nil || interp.GetVariables()["del"] != 5.0 {
+		t.Errorf("Expected assignment to del, got %v", err)
+	}
+}
+
+func TestImportKeepsConstants(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("const rate = 0.2")
+	interp.Execute("x = 1")
+
+	path := filepath.Join(t.TempDir(), "vars.env")
+	if err := os.WriteFile(path, []byte("rate=0.5\npi=3\ny=2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := interp.Execute("import vars " + path + " --strategy overwrite")
+	if err != nil || !strings.Contains(result.(string), "добавлено 1, обновлено 0, пропущено 2, удалено 1") {
+		t.Fatalf("Unexpected import result %v, %v", result, err)
+	}
+	if vars := interp.GetVariables(); len(vars) != 2 || vars["rate"] != 0.2 || vars["y"] != 2.0 {
+		t.Errorf("Expected rate kept and x replaced by y, got %v", vars)
+	}
+}
+
+func TestVariableMetadata(t *testing.T) {
+	cfg := config.Default()
+	path := filepath.Join(t.TempDir(), "data.json")
+	interp := NewInterpreterWithConfig(cfg, persistence.NewPersistenceManagerWithFile(path))
+
+	interp.Execute("price = 100 * 1.2")
+	if _, err := interp.Execute("describe price Цена за единицу --unit руб --tags финансы,#цены,финансы"); err != nil {
+		t.Fatal(err)
+	}
+	interp.Execute("qty = 3")
+
+	meta := interp.variables.Meta("price")
+	if meta.Description != "Цена за единицу" || meta.Unit != "руб" || !reflect.DeepEqual(meta.Tags, []string{"финансы", "цены"}) {
+		t.Errorf("Unexpected metadata %+v", meta)
+	}
+	if meta.Expression != "100 * 1.2" || meta.Created == "" || meta.Updated == "" {
+		t.Errorf("Expected expression and timestamps, got %+v", meta)
+	}
+
+	// Новое значение меняет выражение, но не описание
+	interp.Execute("price = 150")
+	if meta := interp.variables.Meta("price"); meta.Expression != "150" || meta.Unit != "руб" || meta.Created == "" {
+		t.Errorf("Unexpected metadata after assignment %+v", meta)
+	}
+
+	result, err := interp.Execute("vars --tag Финансы")
+	table, ok := result.(*Table)
+	if err != nil || !ok || len(table.Rows) != 1 || table.Rows[0][0] != "price" || table.Rows[0][2] != "руб" {
+		t.Fatalf("Expected only price with the tag, got %v, %v", result, err)
+	}
+	if result, _ := interp.Execute("vars"); len(result.(*Table).Rows) != 2 {
+		t.Errorf("Expected all variables, got %v", result)
+	}
+
+	// Отмена удаления возвращает и описание
+	interp.Execute("del price")
+	interp.Execute("undo")
+	if meta := interp.variables.Meta("price"); meta == nil || meta.Description != "Цена за единицу" {
+		t.Errorf("Expected description restored by undo, got %+v", meta)
+	}
+
+	restarted := NewInterpreterWithConfig(cfg, persistence.NewPersistenceManagerWithFile(path))
+	if meta := restarted.variables.Meta("price"); meta == nil || !meta.HasTag("цены") || meta.Expression != "150" {
+		t.Errorf("Expected metadata after restart, got %+v", meta)
+	}
+
+	interp.Execute("describe price --unit= --tags=")
+	interp.Execute(`describe price ""`)
+	if meta := interp.variables.Meta("price"); meta.Description != "" || meta.Unit != "" || meta.Tags != nil {
+		t.Errorf("Expected metadata cleared, got %+v", meta)
+	}
+}
+
+func TestDescribeIsCommandOnlyForVariables(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("x = 1")
+	if !interp.isVariableCommand("describe x длина") {
+		t.Error("Expected describe of an existing variable to be a command")
+	}
+	if interp.isVariableCommand("describe the weather") {
+		t.Error("Free-form text must not be taken for describe")
+	}
+	if _, err := interp.Execute("describe pi число пи"); err == nil {
+		t.Error("Expected builtin constants not to be described")
+	}
+}
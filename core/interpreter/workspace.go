@@ -0,0 +1,415 @@
+package interpreter
+
+import (
+	"app/config"
+	"app/core/history"
+	"app/core/persistence"
+	"app/core/variables"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// РАБОЧИЕ ПРОСТРАНСТВА
+// ============================================================================
+
+const workspaceUsage = `Команды рабочих пространств:
+  workspace                      список пространств
+  workspace new <имя>            создать пустое пространство и перейти в него
+  workspace use <имя>            перейти в пространство
+  workspace clone [из] <в>       копия пространства (по умолчанию текущего)
+  workspace rename <старое> <новое>
+  workspace delete <имя>         удалить пространство со всеми данными
+  workspace settings             настройки текущего пространства
+  workspace set <ключ> <знач>    настройка поверх общей конфигурации, например history.max_entries 500
+  workspace unset <ключ>         вернуть общую настройку
+Настраиваются параметры history.*, variables.*, output.* и ai.enabled`
+
+// WorkspaceInfo - сведения о рабочем пространстве для списка
+type WorkspaceInfo struct {
+	Name      string            `json:"name"`
+	Current   bool              `json:"current"`
+	Variables int               `json:"variables"`
+	History   int               `json:"history"`
+	Settings  map[string]string `json:"settings,omitempty"`
+}
+
+// workspaceSettingAllowed - параметры, которые можно переопределить в пространстве.
+// Сеть, хранилище, curl и запуск приложений остаются общими для всего сервера.
+func workspaceSettingAllowed(key string) bool {
+	for _, prefix := range []string{"history.", "variables.", "output."} {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return key == "ai.enabled"
+}
+
+// isWorkspaceCommand - команды вида workspace ...; присваивание workspace = ... командой не считается
+func (i *Interpreter) isWorkspaceCommand(trimmed string) bool {
+	if i.isVariableAssignment(trimmed) {
+		return false
+	}
+	return trimmed == "workspace" || strings.HasPrefix(trimmed, "workspace ")
+}
+
+// handleWorkspaceCommand - вызывается под reload.Lock
+func (i *Interpreter) handleWorkspaceCommand(trimmed string) (interface{}, error) {
+	args := strings.Fields(trimmed)[1:]
+	if len(args) == 0 || (args[0] == "list" && len(args) == 1) {
+		return i.workspaceTable()
+	}
+
+	command, args := args[0], args[1:]
+	expect := func(n int, usage string) error {
+		if len(args) != n {
+			return fmt.Errorf("использование: workspace %s %s", command, usage)
+		}
+		return nil
+	}
+
+	switch command {
+	case "new":
+		if err := expect(1, "<имя>"); err != nil {
+			return nil, err
+		}
+		if err := i.createWorkspace(args[0]); err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("✅ Создано рабочее пространство %s", args[0]), nil
+
+	case "use":
+		if err := expect(1, "<имя>"); err != nil {
+			return nil, err
+		}
+		if err := i.useWorkspace(args[0]); err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("✅ Рабочее пространство %s: переменных %d, записей истории %d",
+			args[0], len(i.variables.GetVariables()), i.history.GetHistoryCount()), nil
+
+	case "clone":
+		if len(args) == 1 {
+			args = []string{i.workspace, args[0]}
+		}
+		if err := expect(2, "[из] <в>"); err != nil {
+			return nil, err
+		}
+		if err := i.storage.CopyNamespace(args[0], args[1]); err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("✅ Пространство %s скопировано в %s", args[0], args[1]), nil
+
+	case "rename":
+		if err := expect(2, "<старое> <новое>"); err != nil {
+			return nil, err
+		}
+		if err := i.renameWorkspace(args[0], args[1]); err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("✅ Пространство %s переименовано в %s", args[0], args[1]), nil
+
+	case "delete":
+		if err := expect(1, "<имя>"); err != nil {
+			return nil, err
+		}
+		if err := i.deleteWorkspace(args[0]); err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("✅ Пространство %s удалено", args[0]), nil
+
+	case "settings":
+		settings := i.workspaceSettings()
+		table := NewTable("Настройки рабочего пространства "+i.workspace, "Параметр", "Значение")
+		for _, key := range sortedSettingKeys(settings) {
+			table.AddRow(key, settings[key])
+		}
+		return table, nil
+
+	case "set":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("использование: workspace set <ключ> <значение>")
+		}
+		value := strings.Join(args[1:], " ")
+		if err := i.setWorkspaceSetting(args[0], value); err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("✅ %s = %s в пространстве %s", args[0], value, i.workspace), nil
+
+	case "unset":
+		if err := expect(1, "<ключ>"); err != nil {
+			return nil, err
+		}
+		if err := i.unsetWorkspaceSetting(args[0]); err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("✅ %s в пространстве %s берется из общей конфигурации", args[0], i.workspace), nil
+
+	case "help":
+		return workspaceUsage, nil
+	}
+
+	return nil, fmt.Errorf("неизвестная команда %q\n%s", trimmed, workspaceUsage)
+}
+
+func (i *Interpreter) workspaceTable() (*Table, error) {
+	workspaces, err := i.listWorkspaces()
+	if err != nil {
+		return nil, err
+	}
+	table := NewTable("Рабочие пространства (текущее: "+i.workspace+")", "", "Имя", "Переменных", "Записей", "Настройки")
+	for _, ws := range workspaces {
+		mark := ""
+		if ws.Current {
+			mark = "*"
+		}
+		settings := make([]string, 0, len(ws.Settings))
+		for _, key := range sortedSettingKeys(ws.Settings) {
+			settings = append(settings, key+"="+ws.Settings[key])
+		}
+		table.AddRow(mark, ws.Name, strconv.Itoa(ws.Variables), strconv.Itoa(ws.History), strings.Join(settings, ", "))
+	}
+	return table, nil
+}
+
+// openWorkspace - замена переменных, истории и журнала отмены на данные пространства
+// и применение его настроек. Вызывается при создании интерпретатора и под reload.Lock.
+func (i *Interpreter) openWorkspace(name string) error {
+	if err := persistence.ValidateNamespace(name); err != nil {
+		return err
+	}
+	if !i.storage.NamespaceExists(name) {
+		return fmt.Errorf("рабочее пространство %q не найдено", name)
+	}
+
+	pm := i.storage.Namespace(name)
+	if err := pm.Check(); err != nil {
+		return err
+	}
+	settings := make(map[string]string)
+	if data := pm.LoadData(); data != nil && data.Settings != nil {
+		settings = data.Settings
+	}
+
+	i.workspace = name
+	i.persistence = pm
+	cfg := i.workspaceConfig(settings)
+	i.variables = variables.NewVariableStore()
+	i.journal = variables.NewJournal(cfg.Variables.UndoDepth)
+	i.history = history.NewHistoryManagerWithConfig(pm, cfg)
+	i.applyConfig(cfg)
+	i.loadState()
+	return nil
+}
+
+// workspaceConfig - общие настройки с параметрами пространства поверх них.
+// Если параметры пространства стали некорректными, используются общие настройки.
+func (i *Interpreter) workspaceConfig(settings map[string]string) *config.Config {
+	cfg, err := overlaySettings(i.baseConfig, settings)
+	if err != nil {
+		fmt.Printf("⚠️ Настройки рабочего пространства %s не применены: %v\n", i.workspace, err)
+		return i.baseConfig
+	}
+	return cfg
+}
+
+func overlaySettings(base *config.Config, settings map[string]string) (*config.Config, error) {
+	if len(settings) == 0 {
+		return base, nil
+	}
+	cfg := base.Clone()
+	for _, key := range sortedSettingKeys(settings) {
+		if !workspaceSettingAllowed(key) {
+			return nil, fmt.Errorf("параметр %s нельзя задать для рабочего пространства", key)
+		}
+		if err := cfg.Set(key, settings[key]); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (i *Interpreter) workspaceSettings() map[string]string {
+	data := i.persistence.LoadData()
+	if data == nil || data.Settings == nil {
+		return map[string]string{}
+	}
+	return data.Settings
+}
+
+// PinWorkspace - запрет перехода в другое пространство (workspace use, workspace new).
+// Вызывается для общего интерпретатора веб-интерфейса без сессий: переход одного клиента
+// сменил бы пространство у всех. Остальные команды пространств доступны.
+func (i *Interpreter) PinWorkspace() {
+	i.pinned.Store(true)
+}
+
+// checkSwitch - ошибка, если переход в пространство name запрещен (см. PinWorkspace)
+func (i *Interpreter) checkSwitch(name string) error {
+	if i.pinned.Load() && name != i.workspace {
+		return fmt.Errorf("рабочее пространство общее для всех клиентов веб-интерфейса и не переключается; " +
+			"включите sessions.enabled, чтобы у каждого браузера было свое")
+	}
+	return nil
+}
+
+func (i *Interpreter) useWorkspace(name string) error {
+	if err := i.checkSwitch(name); err != nil {
+		return err
+	}
+	return i.openWorkspace(name)
+}
+
+func (i *Interpreter) createWorkspace(name string) error {
+	if err := i.checkSwitch(name); err != nil {
+		return err
+	}
+	if err := i.storage.CreateNamespace(name); err != nil {
+		return err
+	}
+	return i.openWorkspace(name)
+}
+
+func (i *Interpreter) renameWorkspace(from, to string) error {
+	if err := i.storage.RenameNamespace(from, to); err != nil {
+		return err
+	}
+	if from == i.workspace {
+		return i.openWorkspace(to)
+	}
+	return nil
+}
+
+func (i *Interpreter) deleteWorkspace(name string) error {
+	if name == i.workspace {
+		return fmt.Errorf("нельзя удалить текущее рабочее пространство, сначала перейдите в другое")
+	}
+	return i.storage.DeleteNamespace(name)
+}
+
+func (i *Interpreter) setWorkspaceSetting(key, value string) error {
+	settings := make(map[string]string)
+	for k, v := range i.workspaceSettings() {
+		settings[k] = v
+	}
+	settings[key] = value
+
+	cfg, err := overlaySettings(i.baseConfig, settings)
+	if err != nil {
+		return err
+	}
+	if err := i.saveWorkspaceSettings(settings); err != nil {
+		return err
+	}
+	i.applyConfig(cfg)
+	return nil
+}
+
+func (i *Interpreter) unsetWorkspaceSetting(key string) error {
+	settings := i.workspaceSettings()
+	if _, ok := settings[key]; !ok {
+		return fmt.Errorf("параметр %s не задан в пространстве %s", key, i.workspace)
+	}
+	delete(settings, key)
+	if err := i.saveWorkspaceSettings(settings); err != nil {
+		return err
+	}
+	i.applyConfig(i.workspaceConfig(settings))
+	return nil
+}
+
+func (i *Interpreter) saveWorkspaceSettings(settings map[string]string) error {
+	saved := i.persistence.Update(func(data *persistence.CalculatorData) bool {
+		data.Settings = settings
+		return true
+	})
+	if !saved {
+		return fmt.Errorf("не удалось сохранить рабочее пространство %s", i.workspace)
+	}
+	return nil
+}
+
+func (i *Interpreter) listWorkspaces() ([]WorkspaceInfo, error) {
+	names, err := i.storage.Namespaces()
+	if err != nil {
+		return nil, err
+	}
+	workspaces := make([]WorkspaceInfo, 0, len(names))
+	for _, name := range names {
+		info := WorkspaceInfo{Name: name, Current: name == i.workspace}
+		if data := i.storage.Namespace(name).LoadData(); data != nil {
+			info.Variables = len(data.Variables)
+			info.History = len(data.History)
+			info.Settings = data.Settings
+		}
+		workspaces = append(workspaces, info)
+	}
+	return workspaces, nil
+}
+
+func sortedSettingKeys(settings map[string]string) []string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ============================================================================
+// ПУБЛИЧНЫЕ МЕТОДЫ РАБОЧИХ ПРОСТРАНСТВ
+// ============================================================================
+
+// Workspace - имя текущего рабочего пространства
+func (i *Interpreter) Workspace() string {
+	i.reload.RLock()
+	defer i.reload.RUnlock()
+	return i.workspace
+}
+
+// Workspaces - все рабочие пространства хранилища
+func (i *Interpreter) Workspaces() ([]WorkspaceInfo, error) {
+	i.reload.RLock()
+	defer i.reload.RUnlock()
+	return i.listWorkspaces()
+}
+
+// CreateWorkspace - новое пустое пространство; интерпретатор переходит в него
+func (i *Interpreter) CreateWorkspace(name string) error {
+	i.reload.Lock()
+	defer i.reload.Unlock()
+	return i.createWorkspace(name)
+}
+
+// UseWorkspace - переход в существующее пространство
+func (i *Interpreter) UseWorkspace(name string) error {
+	i.reload.Lock()
+	defer i.reload.Unlock()
+	return i.useWorkspace(name)
+}
+
+// CloneWorkspace - копия пространства from под именем to
+func (i *Interpreter) CloneWorkspace(from, to string) error {
+	i.reload.Lock()
+	defer i.reload.Unlock()
+	return i.storage.CopyNamespace(from, to)
+}
+
+// RenameWorkspace - переименование пространства (в том числе текущего)
+func (i *Interpreter) RenameWorkspace(from, to string) error {
+	i.reload.Lock()
+	defer i.reload.Unlock()
+	return i.renameWorkspace(from, to)
+}
+
+// DeleteWorkspace - удаление пространства, кроме текущего и default
+func (i *Interpreter) DeleteWorkspace(name string) error {
+	i.reload.Lock()
+	defer i.reload.Unlock()
+	return i.deleteWorkspace(name)
+}
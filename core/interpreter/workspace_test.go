@@ -0,0 +1,100 @@
+package interpreter
+
+import (
+	"app/config"
+	"app/core/persistence"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkspaceIsolation(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("x = 1")
+
+	if _, err := interp.Execute("workspace new budget"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if interp.Workspace() != "budget" {
+		t.Errorf("Expected to switch to budget, got %s", interp.Workspace())
+	}
+	if vars := interp.GetVariables(); len(vars) != 0 {
+		t.Errorf("New workspace must be empty, got %v", vars)
+	}
+	interp.Execute("rent = 500")
+
+	interp.Execute("workspace use default")
+	if vars := interp.GetVariables(); vars["x"] != 1.0 || vars["rent"] != nil {
+		t.Errorf("Unexpected default variables %v", vars)
+	}
+	if count := interp.history.GetHistoryCount(); count != 1 {
+		t.Errorf("Expected only default history, got %d entries", count)
+	}
+
+	interp.Execute("workspace clone budget plan")
+	interp.Execute("workspace rename plan plan2")
+	workspaces, _ := interp.Workspaces()
+	if len(workspaces) != 3 || workspaces[2].Name != "plan2" || workspaces[2].Variables != 1 {
+		t.Errorf("Unexpected workspaces %+v", workspaces)
+	}
+
+	if _, err := interp.Execute("workspace delete default"); err == nil {
+		t.Error("Expected error deleting default workspace")
+	}
+	if _, err := interp.Execute("workspace use missing"); err == nil {
+		t.Error("Expected error for missing workspace")
+	}
+}
+
+func TestWorkspaceSettings(t *testing.T) {
+	interp := newMemoryInterpreter()
+	interp.Execute("workspace new small")
+
+	if _, err := interp.Execute("workspace set history.max_entries 2"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := interp.Execute("workspace set launcher.safe_directories /"); err == nil {
+		t.Error("Expected launcher settings to be rejected")
+	}
+	if _, err := interp.Execute("workspace set history.max_entries -1"); err == nil {
+		t.Error("Expected invalid value to be rejected")
+	}
+
+	for _, cmd := range []string{"1+1", "2+2", "3+3"} {
+		interp.Execute(cmd)
+	}
+	if count := interp.history.GetHistoryCount(); count != 2 {
+		t.Errorf("Expected workspace retention of 2 entries, got %d", count)
+	}
+
+	interp.Execute("workspace use default")
+	if limit := interp.Config().History.MaxEntries; limit != config.Default().History.MaxEntries {
+		t.Errorf("Workspace setting leaked into default: %d", limit)
+	}
+
+	// Общая перезагрузка конфигурации не отменяет настройки пространства
+	interp.Execute("workspace use small")
+	interp.ApplyConfig(config.Default())
+	if limit := interp.Config().History.MaxEntries; limit != 2 {
+		t.Errorf("Expected workspace setting after reload, got %d", limit)
+	}
+}
+
+func TestWorkspaceFromConfig(t *testing.T) {
+	pm := persistence.NewPersistenceManagerWithFile(filepath.Join(t.TempDir(), "calc.json"))
+	if err := pm.CreateNamespace("budget"); err != nil {
+		t.Fatalf("CreateNamespace: %v", err)
+	}
+	pm.Namespace("budget").SaveVariables(map[string]interface{}{"rent": 500.0})
+
+	cfg := config.Default()
+	cfg.Storage.Workspace = "budget"
+	interp := NewInterpreterWithConfig(cfg, pm)
+	if interp.Workspace() != "budget" || interp.GetVariables()["rent"] != 500.0 {
+		t.Errorf("Expected budget workspace, got %s %v", interp.Workspace(), interp.GetVariables())
+	}
+
+	cfg.Storage.Workspace = "missing"
+	if interp := NewInterpreterWithConfig(cfg, pm); interp.Workspace() != persistence.DefaultNamespace {
+		t.Errorf("Expected fallback to default, got %s", interp.Workspace())
+	}
+}
@@ -0,0 +1,267 @@
+package persistence
+
+import (
+	"app/config"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// ============================================================================
+// ШИФРОВАНИЕ ДАННЫХ
+// ============================================================================
+
+// ErrWrongKey - данные зашифрованы другим ключом или паролем
+var ErrWrongKey = errors.New("неверный ключ шифрования")
+
+// ErrBadKey - ключ задан в конфигурации, но не читается
+var ErrBadKey = errors.New("ключ шифрования не читается")
+
+// ErrNoKey - данные зашифрованы, а ключ не задан
+var ErrNoKey = errors.New("данные зашифрованы, ключ не задан (storage.encryption_key, storage.encryption_key_file или storage.encryption_passphrase)")
+
+// Алгоритмы конверта Encrypted
+const (
+	CipherAES256GCM = "aes-256-gcm"
+	// KDFScrypt - ключ выведен из пароля через scrypt с параметрами scryptN, scryptR, scryptP
+	KDFScrypt = "scrypt"
+)
+
+const (
+	keySize  = 32
+	saltSize = 16
+	scryptN  = 1 << 15
+	scryptR  = 8
+	scryptP  = 1
+)
+
+// Encrypted - зашифрованное состояние или событие журнала. Хранится вместо открытых
+// данных, в JSON двоичные поля записываются в base64.
+type Encrypted struct {
+	Cipher string `json:"cipher"`
+	KDF    string `json:"kdf,omitempty"`
+	Salt   []byte `json:"salt,omitempty"`
+	// KeyID - отпечаток ключа: по нему неверный ключ отличается от поврежденных данных
+	KeyID string `json:"key_id"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// Key - ключ шифрования AES-256-GCM: 32 байта или пароль, из которого ключ
+// выводится через scrypt. Для пароля соль своя у каждого запуска и хранится в конверте.
+type Key struct {
+	raw        []byte
+	passphrase string
+
+	mu sync.Mutex
+	// salt - соль новых записей; derived - выведенные ключи по соли (scrypt медленный)
+	salt    []byte
+	derived map[string][]byte
+}
+
+// NewKey - ключ из 32 байт
+func NewKey(raw []byte) (*Key, error) {
+	if len(raw) != keySize {
+		return nil, fmt.Errorf("ключ должен быть длиной %d байт, получено %d", keySize, len(raw))
+	}
+	return &Key{raw: append([]byte(nil), raw...)}, nil
+}
+
+// NewPassphraseKey - ключ, выводимый из пароля
+func NewPassphraseKey(passphrase string) (*Key, error) {
+	if passphrase == "" {
+		return nil, errors.New("пустой пароль")
+	}
+	return &Key{passphrase: passphrase, derived: make(map[string][]byte)}, nil
+}
+
+// ParseKey - ключ в hex (64 символа) или base64
+func ParseKey(text string) (*Key, error) {
+	text = strings.TrimSpace(text)
+	if raw, err := hex.DecodeString(text); err == nil && len(raw) == keySize {
+		return NewKey(raw)
+	}
+	if raw, err := base64.StdEncoding.DecodeString(text); err == nil {
+		return NewKey(raw)
+	}
+	return nil, fmt.Errorf("ключ должен быть %d байтами в hex или base64", keySize)
+}
+
+// ReadKeyFile - ключ из файла: текст в hex или base64 либо ровно 32 байта
+func ReadKeyFile(path string) (*Key, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == keySize {
+		if key, err := ParseKey(string(content)); err == nil {
+			return key, nil
+		}
+		return NewKey(content)
+	}
+	key, err := ParseKey(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return key, nil
+}
+
+// NewKeyWithConfig - ключ из storage.encryption_key, storage.encryption_key_file или
+// storage.encryption_passphrase; nil, если шифрование не настроено
+func NewKeyWithConfig(cfg *config.Config) (*Key, error) {
+	storage := cfg.Storage
+	switch {
+	case storage.EncryptionKey != "":
+		key, err := ParseKey(storage.EncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("%w: storage.encryption_key: %v", ErrBadKey, err)
+		}
+		return key, nil
+	case storage.EncryptionKeyFile != "":
+		key, err := ReadKeyFile(storage.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: storage.encryption_key_file: %v", ErrBadKey, err)
+		}
+		return key, nil
+	case storage.EncryptionPassphrase != "":
+		return NewPassphraseKey(storage.EncryptionPassphrase)
+	}
+	return nil, nil
+}
+
+// GenerateKey - случайный ключ в hex для storage.encryption_key или файла ключа
+func GenerateKey() (string, error) {
+	raw := make([]byte, keySize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// key - ключ AES для соли salt (для ключа из 32 байт соль не используется)
+func (k *Key) key(salt []byte) ([]byte, error) {
+	if k.passphrase == "" {
+		return k.raw, nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key, ok := k.derived[string(salt)]; ok {
+		return key, nil
+	}
+	key, err := scrypt.Key([]byte(k.passphrase), salt, scryptN, scryptR, scryptP, keySize)
+	if err != nil {
+		return nil, err
+	}
+	k.derived[string(salt)] = key
+	return key, nil
+}
+
+// newSalt - соль новых записей: одна на ключ, чтобы scrypt считался один раз
+func (k *Key) newSalt() ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.salt == nil {
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		k.salt = salt
+	}
+	return k.salt, nil
+}
+
+func keyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte("calc key id:"), key...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Seal - шифрование plain
+func (k *Key) Seal(plain []byte) (*Encrypted, error) {
+	sealed := &Encrypted{Cipher: CipherAES256GCM}
+	if k.passphrase != "" {
+		salt, err := k.newSalt()
+		if err != nil {
+			return nil, err
+		}
+		sealed.KDF, sealed.Salt = KDFScrypt, salt
+	}
+	key, err := k.key(sealed.Salt)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed.KeyID = keyID(key)
+	sealed.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(sealed.Nonce); err != nil {
+		return nil, err
+	}
+	sealed.Data = gcm.Seal(nil, sealed.Nonce, plain, nil)
+	return sealed, nil
+}
+
+// Open - расшифровка; ErrWrongKey, если данные зашифрованы другим ключом
+func (k *Key) Open(sealed *Encrypted) ([]byte, error) {
+	if sealed.Cipher != CipherAES256GCM {
+		return nil, fmt.Errorf("неизвестный алгоритм шифрования %q", sealed.Cipher)
+	}
+	switch {
+	case sealed.KDF == KDFScrypt && k.passphrase == "":
+		return nil, fmt.Errorf("%w: данные зашифрованы паролем, а задан ключ", ErrWrongKey)
+	case sealed.KDF == "" && k.passphrase != "":
+		return nil, fmt.Errorf("%w: данные зашифрованы ключом, а задан пароль", ErrWrongKey)
+	case sealed.KDF != "" && sealed.KDF != KDFScrypt:
+		return nil, fmt.Errorf("неизвестный способ получения ключа %q", sealed.KDF)
+	}
+
+	key, err := k.key(sealed.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if id := keyID(key); id != sealed.KeyID {
+		if k.passphrase != "" {
+			return nil, fmt.Errorf("%w: неверный пароль", ErrWrongKey)
+		}
+		return nil, fmt.Errorf("%w: данные зашифрованы ключом %s, задан ключ %s", ErrWrongKey, sealed.KeyID, id)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, sealed.Nonce, sealed.Data, nil)
+	if err != nil {
+		// Ключ совпал по отпечатку - значит, изменены сами данные
+		return nil, fmt.Errorf("зашифрованные данные повреждены: %v", err)
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sameKey - один и тот же ключ (для rekey); пароли сравниваются как строки
+func sameKey(a, b *Key) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.passphrase == b.passphrase && bytes.Equal(a.raw, b.raw)
+}
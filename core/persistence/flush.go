@@ -0,0 +1,174 @@
+package persistence
+
+import (
+	"fmt"
+	"time"
+)
+
+// ============================================================================
+// ОТЛОЖЕННАЯ ЗАПИСЬ
+// ============================================================================
+
+// changedLocked - данные изменились: сохранение сразу или через flushInterval.
+// Изменения, сделанные до сохранения, уходят в хранилище одной пачкой.
+func (pm *PersistenceManager) changedLocked() bool {
+	pm.dirty = true
+	if pm.flushInterval <= 0 {
+		return pm.flushLocked() == nil
+	}
+	if pm.flush == nil {
+		pm.flush = time.AfterFunc(pm.flushInterval, pm.flushLater)
+	}
+	return true
+}
+
+func (pm *PersistenceManager) flushLater() {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pm.flush = nil
+	if pm.flushLocked() != nil && pm.dirty {
+		// Диск заполнен или недоступен - пробуем снова, данные пока только в памяти
+		pm.flush = time.AfterFunc(pm.flushInterval, pm.flushLater)
+	}
+}
+
+func (pm *PersistenceManager) flushLocked() error {
+	if !pm.dirty || pm.data == nil {
+		return nil
+	}
+
+	pm.data.SchemaVersion = SchemaVersion
+	if pm.journal != nil {
+		pm.data.JournalSeq = pm.journal.seq
+	}
+	if err := pm.store.Save(pm.key, pm.data); err != nil {
+		fmt.Printf("Ошибка сохранения: %v\n", err)
+		return err
+	}
+	pm.dirty = false
+
+	if pm.journal != nil {
+		// Снимок содержит все события - журнал можно ротировать
+		if err := pm.journal.compacted(); err != nil {
+			fmt.Printf("Ошибка ротации журнала: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// Flush - немедленная запись отложенных изменений этого хранилища, его рабочих
+// пространств и сессий. Вызывается при завершении программы.
+func (pm *PersistenceManager) Flush() error {
+	pm.mu.Lock()
+	err := pm.flushLocked()
+	if err == nil {
+		pm.stopTimerLocked()
+	}
+	pm.mu.Unlock()
+
+	for _, child := range pm.children() {
+		if childErr := child.Flush(); err == nil {
+			err = childErr
+		}
+	}
+	return err
+}
+
+// Close - запись отложенных изменений; основное состояние при этом закрывает хранилище.
+// Вызывается при завершении программы.
+func (pm *PersistenceManager) Close() error {
+	err := pm.Flush()
+	pm.closeJournals()
+	if pm.key != "" {
+		return err
+	}
+	if closeErr := pm.store.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Unload - запись изменений и освобождение памяти вместе с открытыми рабочими
+// пространствами и сессиями; следующее обращение снова прочитает хранилище
+func (pm *PersistenceManager) Unload() error {
+	pm.mu.Lock()
+	err := pm.flushLocked()
+	if err == nil {
+		pm.stopTimerLocked()
+		pm.closeJournalLocked()
+		pm.journal = nil
+		pm.data = nil
+		pm.refused = nil
+	}
+	pm.mu.Unlock()
+
+	for _, child := range pm.children() {
+		if childErr := child.Unload(); err == nil {
+			err = childErr
+		}
+	}
+	return err
+}
+
+// discard - забыть данные без записи (состояние удалено или переименовано)
+func (pm *PersistenceManager) discard() {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.stopTimerLocked()
+	pm.dirty = false
+	pm.closeJournalLocked()
+	pm.journal = nil
+	pm.data = nil
+	pm.refused = nil
+}
+
+func (pm *PersistenceManager) stopTimerLocked() {
+	if pm.flush != nil {
+		pm.flush.Stop()
+		pm.flush = nil
+	}
+}
+
+// children - открытые рабочие пространства и сессии
+func (pm *PersistenceManager) children() []*PersistenceManager {
+	pm.spacesMu.Lock()
+	defer pm.spacesMu.Unlock()
+
+	children := make([]*PersistenceManager, 0, len(pm.spaces)+len(pm.sessions))
+	for _, child := range pm.spaces {
+		children = append(children, child)
+	}
+	for _, child := range pm.sessions {
+		children = append(children, child)
+	}
+	return children
+}
+
+// cloneData - копия для вызывающего: изменения в ней не затрагивают состояние хранилища.
+// Значения переменных и результатов - числа и строки, их копировать не нужно.
+func cloneData(data *CalculatorData) *CalculatorData {
+	clone := *data
+	clone.Variables = make(map[string]interface{}, len(data.Variables))
+	for name, value := range data.Variables {
+		clone.Variables[name] = value
+	}
+	clone.Constants = append([]string(nil), data.Constants...)
+	if data.Metadata != nil {
+		clone.Metadata = make(map[string]VariableMeta, len(data.Metadata))
+		for name, meta := range data.Metadata {
+			meta.Tags = append([]string(nil), meta.Tags...)
+			clone.Metadata[name] = meta
+		}
+	}
+	clone.History = append(make([]HistoryEntry, 0, len(data.History)), data.History...)
+	clone.Undo = append([]VariableChange(nil), data.Undo...)
+	clone.Redo = append([]VariableChange(nil), data.Redo...)
+	if data.Settings != nil {
+		clone.Settings = make(map[string]string, len(data.Settings))
+		for key, value := range data.Settings {
+			clone.Settings[key] = value
+		}
+	}
+	return &clone
+}
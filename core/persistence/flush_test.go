@@ -0,0 +1,110 @@
+package persistence
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFlushAfterInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.json")
+	pm := NewPersistenceManagerWithBackups(path, 0)
+	pm.flushInterval = 20 * time.Millisecond
+
+	for x := 1.0; x <= 3; x++ {
+		saveX(t, pm, x)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Expected no file before the interval, got %v", err)
+	}
+	if vars := pm.LoadVariables(); vars["x"] != 3.0 {
+		t.Errorf("Expected in-memory state x = 3, got %v", vars)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if vars := NewPersistenceManagerWithFile(path).LoadVariables(); vars["x"] == 3.0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Changes were not flushed after the interval")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLoadDataReturnsCopy(t *testing.T) {
+	pm := NewInMemoryPersistenceManager()
+	saveX(t, pm, 1)
+
+	data := pm.LoadData()
+	data.Variables["x"] = 2.0
+	data.History = append(data.History, HistoryEntry{Command: "1+1"})
+
+	if vars := pm.LoadVariables(); vars["x"] != 1.0 {
+		t.Errorf("Changing a loaded copy must not change the state, got %v", vars)
+	}
+	if n := len(pm.GetRecentHistory(0)); n != 0 {
+		t.Errorf("Expected empty history, got %d entries", n)
+	}
+}
+
+func TestUnloadRereadsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.json")
+	pm := NewPersistenceManagerWithBackups(path, 0)
+	pm.flushInterval = time.Hour
+	saveX(t, pm, 1)
+
+	if err := pm.Unload(); err != nil {
+		t.Fatal(err)
+	}
+	// Файл изменен снаружи - после выгрузки читается заново
+	NewPersistenceManagerWithBackups(path, 0).SaveVariables(map[string]interface{}{"x": 5.0})
+	if vars := pm.LoadVariables(); vars["x"] != 5.0 {
+		t.Errorf("Expected state to be re-read from file, got %v", vars)
+	}
+}
+
+func TestUnloadReleasesSessionWorkspaces(t *testing.T) {
+	root := journaled(filepath.Join(t.TempDir(), "calc.json"), 1<<20, 0)
+	session := root.Session("s1")
+	if err := session.CreateNamespace("w"); err != nil {
+		t.Fatal(err)
+	}
+	space := session.Namespace("w")
+	saveX(t, space, 1)
+
+	if err := session.Unload(); err != nil {
+		t.Fatal(err)
+	}
+	space.mu.Lock()
+	loaded, timer, journal := space.data != nil, space.flush != nil, space.journal != nil
+	space.mu.Unlock()
+	if loaded || timer || journal {
+		t.Errorf("Expected the session workspace to be unloaded, got data %v, timer %v, journal %v", loaded, timer, journal)
+	}
+	if vars := space.LoadVariables(); vars["x"] != 1.0 {
+		t.Errorf("Expected workspace changes to be written on unload, got %v", vars)
+	}
+}
+
+func TestDeletedNamespaceIsNotFlushed(t *testing.T) {
+	dir := t.TempDir()
+	root := NewPersistenceManagerWithBackups(filepath.Join(dir, "calc.json"), 0)
+	root.flushInterval = time.Hour
+	if err := root.CreateNamespace("a"); err != nil {
+		t.Fatal(err)
+	}
+	saveX(t, root.Namespace("a"), 1)
+
+	if err := root.DeleteNamespace("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if root.NamespaceExists("a") {
+		t.Error("Pending changes must not bring a deleted namespace back")
+	}
+}
@@ -0,0 +1,883 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// ЖУРНАЛ СОБЫТИЙ
+// ============================================================================
+
+// Event - изменение состояния в журнале событий. Событие дописывается в журнал сразу
+// при изменении, а снимок состояния сохраняется реже (flush_interval) и помнит номер
+// последнего вошедшего в него события. При чтении к снимку применяются более поздние
+// события, так что после аварийного завершения изменения не теряются.
+type Event struct {
+	Seq  int64  `json:"seq"`
+	Time string `json:"time"`
+	// Actor - кто изменил: repl:<пользователь>, web, session:<id> и т.п.
+	Actor string `json:"actor,omitempty"`
+	Type  string `json:"type"`
+	// Name, Value, Old - переменная, новое и прежнее значение (var.set, var.delete)
+	Name  string      `json:"name,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+	Old   interface{} `json:"old,omitempty"`
+	// Meta - новое описание переменной (var.meta); nil - описание удалено
+	Meta *VariableMeta `json:"meta,omitempty"`
+	// Entry - новая запись истории (history.append) или новое содержимое записи (history.update)
+	Entry *HistoryEntry `json:"entry,omitempty"`
+	// IDs - удаленные записи истории (history.drop)
+	IDs []int `json:"ids,omitempty"`
+	// Undo, Redo - изменение журнала отмены (undo)
+	Undo *ListDelta `json:"undo,omitempty"`
+	Redo *ListDelta `json:"redo,omitempty"`
+	// Settings - настройки пространства целиком (settings)
+	Settings map[string]string `json:"settings,omitempty"`
+	// Constants - имена констант целиком (constants)
+	Constants []string `json:"constants,omitempty"`
+	// Encrypted - событие целиком, если данные шифруются; открыт только номер
+	Encrypted *Encrypted `json:"encrypted,omitempty"`
+}
+
+// Виды событий
+const (
+	EventVarSet        = "var.set"
+	EventVarDelete     = "var.delete"
+	EventVarMeta       = "var.meta"
+	EventHistoryAppend = "history.append"
+	EventHistoryDrop   = "history.drop"
+	EventHistoryUpdate = "history.update"
+	EventHistoryClear  = "history.clear"
+	EventUndo          = "undo"
+	EventSettings      = "settings"
+	EventConstants     = "constants"
+)
+
+// Describe - событие одной строкой для вывода журнала
+func (e Event) Describe() string {
+	switch e.Type {
+	case EventVarSet:
+		if e.Old == nil {
+			return fmt.Sprintf("%s = %v (новая)", e.Name, e.Value)
+		}
+		return fmt.Sprintf("%s = %v (было %v)", e.Name, e.Value, e.Old)
+	case EventVarDelete:
+		return fmt.Sprintf("%s удалена (было %v)", e.Name, e.Old)
+	case EventVarMeta:
+		if e.Meta == nil {
+			return fmt.Sprintf("%s: описание удалено", e.Name)
+		}
+		parts := make([]string, 0, 3)
+		if e.Meta.Description != "" {
+			parts = append(parts, strconv.Quote(e.Meta.Description))
+		}
+		if e.Meta.Unit != "" {
+			parts = append(parts, "["+e.Meta.Unit+"]")
+		}
+		if len(e.Meta.Tags) > 0 {
+			parts = append(parts, "#"+strings.Join(e.Meta.Tags, " #"))
+		}
+		if len(parts) == 0 {
+			return fmt.Sprintf("%s: описание", e.Name)
+		}
+		return fmt.Sprintf("%s: %s", e.Name, strings.Join(parts, " "))
+	case EventHistoryAppend:
+		if e.Entry != nil {
+			return fmt.Sprintf("история #%d: %s", e.Entry.ID, e.Entry.Command)
+		}
+	case EventHistoryUpdate:
+		if e.Entry != nil {
+			return fmt.Sprintf("история #%d изменена: %s", e.Entry.ID, e.Entry.Command)
+		}
+	case EventHistoryDrop:
+		ids := make([]string, 0, len(e.IDs))
+		for _, id := range e.IDs {
+			ids = append(ids, fmt.Sprintf("#%d", id))
+		}
+		return "из истории удалены " + strings.Join(ids, ", ")
+	case EventHistoryClear:
+		return "история очищена"
+	case EventUndo:
+		if e.Undo != nil && len(e.Undo.Push) > 0 {
+			return "журнал отмены: " + e.Undo.Push[len(e.Undo.Push)-1].Name
+		}
+		return "журнал отмены"
+	case EventSettings:
+		keys := make([]string, 0, len(e.Settings))
+		for key := range e.Settings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, 0, len(keys))
+		for _, key := range keys {
+			pairs = append(pairs, key+"="+e.Settings[key])
+		}
+		return "настройки: " + strings.Join(pairs, ", ")
+	case EventConstants:
+		if len(e.Constants) == 0 {
+			return "констант нет"
+		}
+		return "константы: " + strings.Join(e.Constants, ", ")
+	}
+	return e.Type
+}
+
+// ListDelta - изменение списка: новый = прежний[Drop:len-Pop] + Push.
+// Так запись в журнал отмены стоит одного элемента, а не всего списка.
+type ListDelta struct {
+	Drop int              `json:"drop,omitempty"`
+	Pop  int              `json:"pop,omitempty"`
+	Push []VariableChange `json:"push,omitempty"`
+}
+
+// diffList - изменение от old к new; nil, если списки равны
+func diffList(old, new []VariableChange) *ListDelta {
+	best := &ListDelta{Drop: len(old), Push: new}
+	kept := 0
+	for drop := 0; drop < len(old); drop++ {
+		tail := old[drop:]
+		k := 0
+		for k < len(tail) && k < len(new) && reflect.DeepEqual(tail[k], new[k]) {
+			k++
+		}
+		if k > kept {
+			kept = k
+			best = &ListDelta{Drop: drop, Pop: len(tail) - k, Push: new[k:]}
+		}
+		if k == len(tail) {
+			break
+		}
+	}
+	if best.Drop == 0 && best.Pop == 0 && len(best.Push) == 0 {
+		return nil
+	}
+	best.Push = append([]VariableChange(nil), best.Push...)
+	return best
+}
+
+func (d *ListDelta) apply(list []VariableChange) []VariableChange {
+	start := min(d.Drop, len(list))
+	end := max(len(list)-d.Pop, start)
+	return append(append([]VariableChange(nil), list[start:end]...), d.Push...)
+}
+
+// stateCapture - то, с чем сравнивается состояние после изменения. История не
+// копируется: в ней могут быть тысячи записей, а меняют ее методы CalculatorData
+// (AppendHistory, TrimHistory, ...), которые сами записывают свои события в history.
+type stateCapture struct {
+	vars      map[string]interface{}
+	history   []Event
+	shape     historyShape
+	undo      []VariableChange
+	redo      []VariableChange
+	settings  map[string]string
+	constants []string
+	meta      map[string]VariableMeta
+}
+
+// historyShape - число записей истории и номера первой и последней: по ним видно,
+// что историю заменили в обход методов CalculatorData
+type historyShape struct {
+	count, first, last int
+}
+
+func shapeOf(history []HistoryEntry) historyShape {
+	if len(history) == 0 {
+		return historyShape{}
+	}
+	return historyShape{count: len(history), first: history[0].ID, last: history[len(history)-1].ID}
+}
+
+func captureState(data *CalculatorData) *stateCapture {
+	c := &stateCapture{
+		vars:      make(map[string]interface{}, len(data.Variables)),
+		shape:     shapeOf(data.History),
+		undo:      append([]VariableChange(nil), data.Undo...),
+		redo:      append([]VariableChange(nil), data.Redo...),
+		settings:  make(map[string]string, len(data.Settings)),
+		constants: append([]string(nil), data.Constants...),
+		meta:      make(map[string]VariableMeta, len(data.Metadata)),
+	}
+	for name, value := range data.Variables {
+		c.vars[name] = value
+	}
+	for key, value := range data.Settings {
+		c.settings[key] = value
+	}
+	for name, meta := range data.Metadata {
+		meta.Tags = append([]string(nil), meta.Tags...)
+		c.meta[name] = meta
+	}
+	return c
+}
+
+// diffState - события, переводящие состояние before в data
+func diffState(before *stateCapture, data *CalculatorData) []Event {
+	events := make([]Event, 0)
+
+	names := make([]string, 0)
+	for name := range before.vars {
+		if _, ok := data.Variables[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		events = append(events, Event{Type: EventVarDelete, Name: name, Old: before.vars[name]})
+	}
+
+	names = names[:0]
+	for name, value := range data.Variables {
+		if old, ok := before.vars[name]; !ok || !reflect.DeepEqual(old, value) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		events = append(events, Event{Type: EventVarSet, Name: name, Value: data.Variables[name], Old: before.vars[name]})
+	}
+
+	// Описание удаленной переменной удаляется вместе с ней (var.delete)
+	names = names[:0]
+	for name, meta := range data.Metadata {
+		if old, ok := before.meta[name]; !ok || !reflect.DeepEqual(old, meta) {
+			names = append(names, name)
+		}
+	}
+	for name := range before.meta {
+		_, kept := data.Metadata[name]
+		_, exists := data.Variables[name]
+		if !kept && exists {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var meta *VariableMeta
+		if value, ok := data.Metadata[name]; ok {
+			value.Tags = append([]string(nil), value.Tags...)
+			meta = &value
+		}
+		events = append(events, Event{Type: EventVarMeta, Name: name, Meta: meta})
+	}
+
+	switch {
+	case len(before.history) > 0:
+		events = append(events, before.history...)
+	case shapeOf(data.History) != before.shape:
+		// История заменена присваиванием: прежних записей уже нет, журнал получает ее целиком
+		events = append(events, Event{Type: EventHistoryClear})
+		events = append(events, historyEvents(nil, data.History)...)
+	}
+
+	undo, redo := diffList(before.undo, data.Undo), diffList(before.redo, data.Redo)
+	if undo != nil || redo != nil {
+		events = append(events, Event{Type: EventUndo, Undo: undo, Redo: redo})
+	}
+
+	if len(before.settings) != len(data.Settings) || (len(data.Settings) > 0 && !reflect.DeepEqual(before.settings, data.Settings)) {
+		settings := make(map[string]string, len(data.Settings))
+		for key, value := range data.Settings {
+			settings[key] = value
+		}
+		events = append(events, Event{Type: EventSettings, Settings: settings})
+	}
+
+	if len(before.constants) != len(data.Constants) || (len(data.Constants) > 0 && !reflect.DeepEqual(before.constants, data.Constants)) {
+		events = append(events, Event{Type: EventConstants, Constants: append([]string(nil), data.Constants...)})
+	}
+	return events
+}
+
+// historyEvents - события, переводящие историю old в history (замена данных целиком в SaveData)
+func historyEvents(old, history []HistoryEntry) []Event {
+	if len(history) == 0 {
+		if len(old) == 0 {
+			return nil
+		}
+		return []Event{{Type: EventHistoryClear}}
+	}
+	events := make([]Event, 0)
+	dropped, updated, appended := diffHistory(old, history)
+	if len(dropped) > 0 {
+		events = append(events, Event{Type: EventHistoryDrop, IDs: dropped})
+	}
+	for idx := range updated {
+		events = append(events, Event{Type: EventHistoryUpdate, Entry: &updated[idx]})
+	}
+	for idx := range appended {
+		events = append(events, Event{Type: EventHistoryAppend, Entry: &appended[idx]})
+	}
+	return events
+}
+
+// diffHistory - удаленные номера, записи с прежним номером и другим содержимым (например,
+// после импорта истории) и новые записи. Номера в истории идут по возрастанию,
+// поэтому хватает одного прохода по обоим спискам.
+func diffHistory(old, history []HistoryEntry) ([]int, []HistoryEntry, []HistoryEntry) {
+	dropped := make([]int, 0)
+	updated := make([]HistoryEntry, 0)
+	appended := make([]HistoryEntry, 0)
+	i, j := 0, 0
+	for i < len(old) && j < len(history) {
+		switch id := history[j].ID; {
+		case old[i].ID == id:
+			if !reflect.DeepEqual(old[i], history[j]) {
+				updated = append(updated, history[j])
+			}
+			i++
+			j++
+		case old[i].ID < id:
+			dropped = append(dropped, old[i].ID)
+			i++
+		default:
+			appended = append(appended, history[j])
+			j++
+		}
+	}
+	for _, entry := range old[i:] {
+		dropped = append(dropped, entry.ID)
+	}
+	appended = append(appended, history[j:]...)
+	return dropped, updated, appended
+}
+
+// applyEvent - повторение события при чтении журнала
+func applyEvent(data *CalculatorData, e Event) {
+	switch e.Type {
+	case EventVarSet:
+		if data.Variables == nil {
+			data.Variables = make(map[string]interface{})
+		}
+		data.Variables[e.Name] = e.Value
+	case EventVarDelete:
+		delete(data.Variables, e.Name)
+		delete(data.Metadata, e.Name)
+	case EventVarMeta:
+		if e.Meta == nil {
+			delete(data.Metadata, e.Name)
+			break
+		}
+		if data.Metadata == nil {
+			data.Metadata = make(map[string]VariableMeta)
+		}
+		data.Metadata[e.Name] = *e.Meta
+	case EventHistoryAppend:
+		if e.Entry != nil {
+			data.History = append(data.History, *e.Entry)
+			data.NextHistoryID = max(data.NextHistoryID, e.Entry.ID+1)
+		}
+	case EventHistoryUpdate:
+		if e.Entry != nil {
+			for idx := range data.History {
+				if data.History[idx].ID == e.Entry.ID {
+					data.History[idx] = *e.Entry
+				}
+			}
+		}
+	case EventHistoryDrop:
+		dropped := make(map[int]bool, len(e.IDs))
+		for _, id := range e.IDs {
+			dropped[id] = true
+		}
+		kept := make([]HistoryEntry, 0, len(data.History))
+		for _, entry := range data.History {
+			if !dropped[entry.ID] {
+				kept = append(kept, entry)
+			}
+		}
+		data.History = kept
+	case EventHistoryClear:
+		data.History = make([]HistoryEntry, 0)
+	case EventUndo:
+		if e.Undo != nil {
+			data.Undo = e.Undo.apply(data.Undo)
+		}
+		if e.Redo != nil {
+			data.Redo = e.Redo.apply(data.Redo)
+		}
+	case EventSettings:
+		data.Settings = e.Settings
+	case EventConstants:
+		data.Constants = e.Constants
+	}
+}
+
+// journalOptions - где лежат журналы и когда они ротируются; общие для всех состояний
+type journalOptions struct {
+	// file - журнал основного состояния (см. journalFile)
+	file     string
+	maxBytes int64
+	keep     int
+	// key - ключ шифрования событий; nil - события пишутся открытыми
+	key *Key
+}
+
+// journalFile - журнал основного состояния хранилища backend: calculator_data.journal,
+// calculator_data.jsonl.journal, calculator_data.db.journal. У каждого хранилища свой
+// журнал: события одного не применяются к снимку другого после смены storage.backend.
+func journalFile(backend, dataFile string) string {
+	base := strings.TrimSuffix(dataFile, filepath.Ext(dataFile))
+	switch backend {
+	case BackendJSONL:
+		return base + ".jsonl.journal"
+	case BackendBolt:
+		return base + ".db.journal"
+	}
+	return base + ".journal"
+}
+
+// path - журнал состояния key: calculator_data.journal, calculator_data.workspaces/<имя>.journal и т.д.
+func (o *journalOptions) path(key string) string {
+	if key == "" {
+		return o.file
+	}
+	return statePath(o.file, key, ".journal")
+}
+
+// files - ротированные журналы от старых к новым и текущий
+func (o *journalOptions) files(key string) []string {
+	path := o.path(key)
+	files := make([]string, 0, o.keep+1)
+	for n := o.keep; n >= 1; n-- {
+		files = append(files, rotatedJournal(path, n))
+	}
+	return append(files, path)
+}
+
+func rotatedJournal(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// rename - перенос журналов состояния и вложенных состояний вслед за хранилищем
+func (o *journalOptions) rename(from, to string) error {
+	src, dst := o.path(from), o.path(to)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	for _, name := range o.files(from) {
+		target := dst + strings.TrimPrefix(name, src)
+		if err := os.Rename(name, target); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	for _, kind := range []string{KindWorkspaces, KindSessions} {
+		// JSON хранилище уже перенесло общий каталог вместе с журналами
+		if err := os.Rename(nestedDir(src, kind), nestedDir(dst, kind)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove - удаление журналов состояния и вложенных состояний
+func (o *journalOptions) remove(key string) error {
+	path := o.path(key)
+	for _, kind := range []string{KindWorkspaces, KindSessions} {
+		if err := os.RemoveAll(nestedDir(path, kind)); err != nil {
+			return err
+		}
+	}
+	for _, name := range o.files(key) {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// nestedKeys - вложенные в parent состояния, у которых есть журнал или свои вложенные,
+// в том числе еще ни разу не сохранявшие снимок
+func (o *journalOptions) nestedKeys(parent string) ([]string, error) {
+	keys := make([]string, 0)
+	for _, kind := range []string{KindWorkspaces, KindSessions} {
+		entries, err := os.ReadDir(nestedDir(o.path(parent), kind))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		names := make(map[string]bool)
+		for _, entry := range entries {
+			name := entry.Name()
+			if strings.HasPrefix(name, ".") {
+				continue
+			}
+			if idx := strings.Index(name, ".journal"); idx > 0 && !entry.IsDir() {
+				names[name[:idx]] = true
+			}
+			for _, nested := range []string{KindWorkspaces, KindSessions} {
+				if owner, ok := strings.CutSuffix(name, "."+nested); ok && entry.IsDir() {
+					names[owner] = true
+				}
+			}
+		}
+		for name := range names {
+			key := childKey(parent, kind, name)
+			nested, err := o.nestedKeys(key)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(append(keys, key), nested...)
+		}
+	}
+	return keys, nil
+}
+
+// journal - журнал событий одного состояния; используется под блокировкой PersistenceManager
+type journal struct {
+	opts *journalOptions
+	path string
+	file *os.File
+	// seq - номер последнего записанного события
+	seq  int64
+	size int64
+}
+
+func newJournal(opts *journalOptions, key string) *journal {
+	return &journal{opts: opts, path: opts.path(key)}
+}
+
+// replay - применение к снимку событий, которых в нем еще нет; возвращает их число
+func (j *journal) replay(data *CalculatorData) (int, error) {
+	j.seq = data.JournalSeq
+	events, size, err := readJournal(j.path, true, j.opts.key)
+	j.size = size
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, e := range events {
+		if e.Seq > data.JournalSeq {
+			applyEvent(data, e)
+			applied++
+		}
+		j.seq = max(j.seq, e.Seq)
+	}
+	return applied, nil
+}
+
+// append - дописывание событий одной записью. Запись без fsync: события переживают
+// аварийное завершение программы, при отключении питания на диск попадет последний снимок.
+func (j *journal) append(events []Event, actor string) error {
+	if j.file == nil {
+		if err := os.MkdirAll(filepath.Dir(j.path), 0755); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(j.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		j.file = file
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	for idx := range events {
+		j.seq++
+		events[idx].Seq = j.seq
+		events[idx].Time = now
+		events[idx].Actor = actor
+	}
+	content, err := encodeEvents(events, j.opts.key)
+	if err != nil {
+		return err
+	}
+
+	n, err := j.file.Write(content)
+	j.size += int64(n)
+	return err
+}
+
+// compacted - все события вошли в сохраненный снимок. Разросшийся журнал уходит
+// в <журнал>.1, .2, ... - для чтения состояния он больше не нужен, но остается
+// историей изменений (calc audit).
+func (j *journal) compacted() error {
+	if j.opts.maxBytes <= 0 || j.size < j.opts.maxBytes {
+		return nil
+	}
+	j.close()
+
+	if j.opts.keep <= 0 {
+		j.size = 0
+		return os.Remove(j.path)
+	}
+	os.Remove(rotatedJournal(j.path, j.opts.keep))
+	for n := j.opts.keep - 1; n >= 1; n-- {
+		if err := os.Rename(rotatedJournal(j.path, n), rotatedJournal(j.path, n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(j.path, rotatedJournal(j.path, 1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	j.size = 0
+	return nil
+}
+
+func (j *journal) close() {
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+}
+
+// encodeEvents - строки журнала; с ключом каждое событие шифруется отдельно
+func encodeEvents(events []Event, key *Key) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	for _, e := range events {
+		if key != nil {
+			plain, err := json.Marshal(e)
+			if err != nil {
+				return nil, err
+			}
+			sealed, err := key.Seal(plain)
+			if err != nil {
+				return nil, err
+			}
+			e = Event{Seq: e.Seq, Encrypted: sealed}
+		}
+		if err := encoder.Encode(e); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// openEvent - расшифровка события; открытые события (записанные до включения
+// шифрования) возвращаются как есть
+func openEvent(e Event, key *Key) (Event, error) {
+	if e.Encrypted == nil {
+		return e, nil
+	}
+	if key == nil {
+		return e, ErrNoKey
+	}
+	plain, err := key.Open(e.Encrypted)
+	if err != nil {
+		return e, err
+	}
+	var opened Event
+	if err := json.Unmarshal(plain, &opened); err != nil {
+		return e, err
+	}
+	return opened, nil
+}
+
+// readJournal - события файла журнала и размер его целой части. Оборванная при сбое
+// последняя строка отбрасывается, а при truncate еще и отрезается от файла,
+// чтобы следующая запись к ней не приклеилась.
+func readJournal(path string, truncate bool, key *Key) ([]Event, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	events := make([]Event, 0)
+	good := int64(0)
+	reader := bufio.NewReader(file)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return nil, good, readErr
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			var e Event
+			if err := json.Unmarshal(line, &e); err != nil {
+				if readErr == io.EOF {
+					fmt.Printf("⚠️  %s: отброшена недописанная последняя строка\n", path)
+					if truncate {
+						if err := os.Truncate(path, good); err != nil {
+							return nil, good, err
+						}
+					}
+					break
+				}
+				return nil, good, fmt.Errorf("%s, событие после №%d: %v", path, lastSeq(events), err)
+			}
+			if e, err = openEvent(e, key); err != nil {
+				return nil, good, fmt.Errorf("%s, событие №%d: %w", path, e.Seq, err)
+			}
+			events = append(events, e)
+		}
+		good += int64(len(line))
+		if readErr == io.EOF {
+			break
+		}
+	}
+	return events, good, nil
+}
+
+func lastSeq(events []Event) int64 {
+	if len(events) == 0 {
+		return 0
+	}
+	return events[len(events)-1].Seq
+}
+
+// captureLocked - состояние до изменения; nil, если журнал выключен
+func (pm *PersistenceManager) captureLocked(data *CalculatorData) *stateCapture {
+	if pm.journalOpts == nil {
+		return nil
+	}
+	if data == nil {
+		data = emptyData()
+	}
+	return captureState(data)
+}
+
+// recordLocked - запись в журнал событий, которые перевели состояние before в текущее
+func (pm *PersistenceManager) recordLocked(before *stateCapture) {
+	if before == nil {
+		return
+	}
+	events := diffState(before, pm.data)
+	if len(events) == 0 {
+		return
+	}
+	if pm.journal == nil {
+		pm.journal = newJournal(pm.journalOpts, pm.key)
+	}
+	if err := pm.journal.append(events, pm.actor); err != nil {
+		// Изменение не потеряно: оно в памяти и попадет в следующий снимок
+		fmt.Printf("Ошибка записи журнала: %v\n", err)
+	}
+}
+
+// replayLocked - применение к только что прочитанному снимку событий журнала после него.
+// Журнал, который не читается, - ошибка: без его событий состояние неполное, а новые
+// события получили бы номера уже записанных.
+func (pm *PersistenceManager) replayLocked() (int, error) {
+	if pm.journalOpts == nil {
+		return 0, nil
+	}
+	pm.closeJournalLocked()
+	pm.journal = newJournal(pm.journalOpts, pm.key)
+	applied, err := pm.journal.replay(pm.data)
+	if err != nil {
+		pm.journal = nil
+		return 0, fmt.Errorf("журнал не прочитан: %w", err)
+	}
+	if applied > 0 {
+		fmt.Printf("📜 %s: из журнала восстановлено событий: %d\n", pm.journal.path, applied)
+	}
+	return applied, nil
+}
+
+// sealJournalsLocked - события, записанные до включения шифрования, шифруются во всех
+// журналах состояния, включая ротированные: открытыми они не остаются
+func (pm *PersistenceManager) sealJournalsLocked() error {
+	key := pm.journalOpts.key
+	for _, path := range pm.journalOpts.files(pm.key) {
+		plain, err := plaintextJournal(path)
+		if err != nil || !plain {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		events, _, err := readJournal(path, false, key)
+		if err != nil {
+			return err
+		}
+		content, err := encodeEvents(events, key)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path, content); err != nil {
+			return err
+		}
+		if pm.journal != nil && pm.journal.path == path {
+			pm.journal.close()
+			pm.journal.size = int64(len(content))
+		}
+	}
+	return nil
+}
+
+// plaintextJournal - в файле журнала есть открытые события
+func plaintextJournal(path string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		var e struct {
+			Encrypted *Encrypted `json:"encrypted"`
+		}
+		if json.Unmarshal(line, &e) == nil && e.Encrypted == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (pm *PersistenceManager) closeJournalLocked() {
+	if pm.journal != nil {
+		pm.journal.close()
+	}
+}
+
+// closeJournals - закрытие файлов журналов этого состояния и вложенных
+func (pm *PersistenceManager) closeJournals() {
+	pm.mu.Lock()
+	pm.closeJournalLocked()
+	pm.mu.Unlock()
+
+	for _, child := range pm.children() {
+		child.closeJournals()
+	}
+}
+
+// Audit - события журнала этого состояния от старых к новым, включая ротированные файлы
+func (pm *PersistenceManager) Audit() ([]Event, error) {
+	if pm.journalOpts == nil {
+		return nil, fmt.Errorf("журнал событий выключен (storage.journal)")
+	}
+	events := make([]Event, 0)
+	for _, path := range pm.journalOpts.files(pm.key) {
+		fileEvents, _, err := readJournal(path, false, pm.journalOpts.key)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, fileEvents...)
+	}
+	return events, nil
+}
+
+// renameJournal, removeJournal - журналы следуют за переименованием и удалением состояния
+func (pm *PersistenceManager) renameJournal(from, to string) error {
+	if pm.journalOpts == nil {
+		return nil
+	}
+	return pm.journalOpts.rename(from, to)
+}
+
+func (pm *PersistenceManager) removeJournal(key string) error {
+	if pm.journalOpts == nil {
+		return nil
+	}
+	return pm.journalOpts.remove(key)
+}
@@ -0,0 +1,384 @@
+package persistence
+
+import (
+	"app/config"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// journaled - хранилище с журналом событий, которое само не сохраняет снимок:
+// без Flush все изменения есть только в журнале, как после аварийного завершения
+func journaled(path string, maxBytes int64, keep int) *PersistenceManager {
+	pm := NewPersistenceManagerWithBackups(path, 0)
+	pm.flushInterval = time.Hour
+	pm.journalOpts = &journalOptions{file: journalFile(BackendJSON, path), maxBytes: maxBytes, keep: keep}
+	return pm
+}
+
+// crash - завершение без сохранения снимка: файлы журналов просто закрываются
+func crash(pm *PersistenceManager) {
+	pm.mu.Lock()
+	pm.stopTimerLocked()
+	pm.mu.Unlock()
+	pm.closeJournals()
+}
+
+func appendCommand(pm *PersistenceManager, command string) {
+	pm.Update(func(data *CalculatorData) bool {
+		data.AppendHistory(HistoryEntry{Command: command, Result: "ok"})
+		data.Undo = append(data.Undo, VariableChange{Name: "x", New: 1.0})
+		return true
+	})
+}
+
+func TestJournalReplaysChangesAfterCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.json")
+	pm := journaled(path, 1<<20, 0)
+	saveX(t, pm, 1)
+	pm.Flush()
+
+	saveX(t, pm, 2)
+	appendCommand(pm, "x = 2")
+	pm.Update(func(data *CalculatorData) bool {
+		data.Settings = map[string]string{"history.max_entries": "10"}
+		data.Constants = []string{"x"}
+		return true
+	})
+	crash(pm)
+
+	restored := journaled(path, 1<<20, 0)
+	defer restored.Close()
+	data := restored.LoadData()
+	if data.Variables["x"] != 2.0 {
+		t.Errorf("Expected x = 2 from the journal, got %v", data.Variables)
+	}
+	if got := historyCommands(data); got != "x = 2" {
+		t.Errorf("Expected history from the journal, got %q", got)
+	}
+	if len(data.Undo) != 1 || data.Settings["history.max_entries"] != "10" || !reflect.DeepEqual(data.Constants, []string{"x"}) {
+		t.Errorf("Expected undo, settings and constants from the journal, got %+v", data)
+	}
+	if data.NextHistoryID != 2 {
+		t.Errorf("Expected next history ID 2, got %d", data.NextHistoryID)
+	}
+}
+
+func TestJournalIsNotAppliedTwice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.json")
+	pm := journaled(path, 1<<20, 0)
+	appendCommand(pm, "a")
+	appendCommand(pm, "b")
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := NewPersistenceManagerWithBackups(path, 0).LoadData()
+	if snapshot.JournalSeq == 0 {
+		t.Fatal("Snapshot must remember the last journal event")
+	}
+
+	// Журнал не ротирован, но все его события уже в снимке
+	pm = journaled(path, 1<<20, 0)
+	appendCommand(pm, "c")
+	crash(pm)
+
+	data := journaled(path, 1<<20, 0).LoadData()
+	if got := historyCommands(data); got != "a,b,c" {
+		t.Errorf("Expected a,b,c, got %q", got)
+	}
+	if len(data.Undo) != 3 {
+		t.Errorf("Expected 3 undo entries, got %d", len(data.Undo))
+	}
+}
+
+func TestJournalRecordsDeletionsAndDrops(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.json")
+	pm := journaled(path, 1<<20, 0)
+	pm.SaveVariables(map[string]interface{}{"x": 1.0, "y": 2.0})
+	appendCommand(pm, "a")
+	appendCommand(pm, "b")
+	pm.Flush()
+
+	pm.Update(func(data *CalculatorData) bool {
+		data.Metadata = map[string]VariableMeta{"x": {Unit: "м"}, "y": {Description: "длина", Tags: []string{"размер"}}}
+		return true
+	})
+	pm.SaveVariables(map[string]interface{}{"y": 3.0})
+	pm.Update(func(data *CalculatorData) bool {
+		data.History = data.History[1:]
+		return true
+	})
+	crash(pm)
+
+	data := journaled(path, 1<<20, 0).LoadData()
+	if _, ok := data.Variables["x"]; ok || data.Variables["y"] != 3.0 {
+		t.Errorf("Expected x deleted and y = 3, got %v", data.Variables)
+	}
+	if _, ok := data.Metadata["x"]; ok || !data.Metadata["y"].HasTag("размер") {
+		t.Errorf("Expected metadata of x deleted with it and y kept, got %v", data.Metadata)
+	}
+	if got := historyCommands(data); got != "b" {
+		t.Errorf("Expected history b, got %q", got)
+	}
+
+	pm = journaled(path, 1<<20, 0)
+	if !pm.ClearHistory() {
+		t.Fatal("ClearHistory failed")
+	}
+	crash(pm)
+	if history := journaled(path, 1<<20, 0).GetRecentHistory(0); len(history) != 0 {
+		t.Errorf("Expected cleared history, got %v", history)
+	}
+}
+
+func TestJournalActors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.json")
+	pm := journaled(path, 1<<20, 0)
+	pm.SetActor("repl:ann")
+	saveX(t, pm, 1)
+	saveX(t, pm.Session("s1"), 2)
+	defer pm.Close()
+
+	events, err := pm.Audit()
+	if err != nil || len(events) != 1 {
+		t.Fatalf("Expected one event, got %v, %v", events, err)
+	}
+	if e := events[0]; e.Actor != "repl:ann" || e.Type != EventVarSet || e.Name != "x" || e.Value != 1.0 {
+		t.Errorf("Unexpected event %+v", e)
+	}
+	events, _ = pm.Session("s1").Audit()
+	if len(events) != 1 || events[0].Actor != "session:s1" {
+		t.Errorf("Expected a session event, got %+v", events)
+	}
+}
+
+func TestJournalRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.json")
+	pm := journaled(path, 1, 2)
+	defer pm.Close()
+	for x := 1.0; x <= 4; x++ {
+		saveX(t, pm, x)
+		if err := pm.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	journalPath := pm.journalOpts.path("")
+	if _, err := os.Stat(journalPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the journal to be rotated after the snapshot, got %v", err)
+	}
+	if _, err := os.Stat(rotatedJournal(journalPath, 3)); !os.IsNotExist(err) {
+		t.Error("Expected only 2 rotated journals")
+	}
+	events, err := pm.Audit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Value != 3.0 || events[1].Value != 4.0 {
+		t.Errorf("Expected events of the last two rotated journals, got %+v", events)
+	}
+
+	// Номера событий продолжаются после ротации
+	saveX(t, pm, 5)
+	events, _ = pm.Audit()
+	if last := events[len(events)-1]; last.Seq != 5 {
+		t.Errorf("Expected seq 5 after rotation, got %d", last.Seq)
+	}
+}
+
+func TestJournalDropsTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.json")
+	pm := journaled(path, 1<<20, 0)
+	saveX(t, pm, 1)
+	crash(pm)
+
+	file, err := os.OpenFile(pm.journalOpts.path(""), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"seq":2,"type":"var.set","na`)
+	file.Close()
+
+	pm = journaled(path, 1<<20, 0)
+	if vars := pm.LoadVariables(); vars["x"] != 1.0 {
+		t.Errorf("Expected x = 1, got %v", vars)
+	}
+	saveX(t, pm, 2)
+	crash(pm)
+	if vars := journaled(path, 1<<20, 0).LoadVariables(); vars["x"] != 2.0 {
+		t.Errorf("Expected x = 2 after the torn line, got %v", vars)
+	}
+}
+
+func TestUnreadableJournalRefusesWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.json")
+	pm := journaled(path, 1<<20, 0)
+	saveX(t, pm, 1)
+	saveX(t, pm, 2)
+	crash(pm)
+
+	// Поврежденная строка не последняя: события после нее не прочитать
+	journalPath := pm.journalOpts.path("")
+	content, _ := os.ReadFile(journalPath)
+	broken := append([]byte("{broken\n"), content...)
+	os.WriteFile(journalPath, broken, 0644)
+
+	pm = journaled(path, 1<<20, 0)
+	if err := pm.Check(); err == nil || !strings.Contains(err.Error(), "журнал") {
+		t.Errorf("Expected a journal error, got %v", err)
+	}
+	if pm.SaveVariables(map[string]interface{}{"x": 3.0}) {
+		t.Error("Saving with an unreadable journal must be refused")
+	}
+	crash(pm)
+	if content, _ := os.ReadFile(journalPath); string(content) != string(broken) {
+		t.Errorf("Expected the journal untouched, got %s", content)
+	}
+}
+
+func TestJournalRecordsHistoryUpdates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.json")
+	pm := journaled(path, 1<<20, 0)
+	appendCommand(pm, "a")
+	appendCommand(pm, "b")
+	pm.Flush()
+
+	// Импорт истории меняет записи на месте, не меняя номеров
+	pm.Update(func(data *CalculatorData) bool {
+		data.UpdateHistory(1, HistoryEntry{Command: "b2", Kind: KindMath})
+		return true
+	})
+	crash(pm)
+
+	data := journaled(path, 1<<20, 0).LoadData()
+	if got := historyCommands(data); got != "a,b2" || data.History[1].Kind != KindMath {
+		t.Errorf("Expected the updated entry from the journal, got %q %+v", got, data.History[1])
+	}
+}
+
+func TestJournalRecordsHistoryMethods(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.json")
+	pm := journaled(path, 1<<20, 0)
+	for _, command := range []string{"a", "b", "c", "d", "e"} {
+		appendCommand(pm, command)
+	}
+	pm.Flush()
+
+	pm.Update(func(data *CalculatorData) bool {
+		data.TrimHistory(1)
+		data.DeleteHistory(3)
+		data.FilterHistory(func(entry HistoryEntry) bool { return entry.Command != "d" })
+		data.AppendHistory(HistoryEntry{Command: "f"})
+		return true
+	})
+	crash(pm)
+
+	data := journaled(path, 1<<20, 0).LoadData()
+	if got := historyCommands(data); got != "b,e,f" || data.History[2].ID != 6 {
+		t.Errorf("Expected history b,e,f from the journal, got %q %+v", got, data.History)
+	}
+
+	pm = journaled(path, 1<<20, 0)
+	pm.Update(func(data *CalculatorData) bool {
+		data.ReplaceHistory([]HistoryEntry{{ID: 10, Command: "x"}})
+		return true
+	})
+	crash(pm)
+	data = journaled(path, 1<<20, 0).LoadData()
+	if got := historyCommands(data); got != "x" || data.NextHistoryID != 11 {
+		t.Errorf("Expected replaced history from the journal, got %q, next ID %d", got, data.NextHistoryID)
+	}
+}
+
+func TestJournalPerBackend(t *testing.T) {
+	cfg := config.Default()
+	cfg.Storage.DataFile = filepath.Join(t.TempDir(), "calc.json")
+	cfg.Storage.FlushInterval = config.Duration(time.Hour)
+	cfg.Storage.Backend = BackendJSONL
+	pm := NewPersistenceManagerWithConfig(cfg)
+	saveX(t, pm, 1)
+	crash(pm)
+	pm.store.Close()
+
+	cfg.Storage.Backend = BackendJSON
+	pm = NewPersistenceManagerWithConfig(cfg)
+	defer pm.Close()
+	if vars := pm.LoadVariables(); len(vars) != 0 {
+		t.Errorf("Expected events of the jsonl store not applied to the json store, got %v", vars)
+	}
+
+	files := map[string]bool{}
+	for _, backend := range []string{BackendJSON, BackendJSONL, BackendBolt} {
+		files[journalFile(backend, cfg.Storage.DataFile)] = true
+	}
+	if len(files) != 3 {
+		t.Errorf("Expected a journal per backend, got %v", files)
+	}
+}
+
+func TestJournalFollowsNamespace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.json")
+	pm := journaled(path, 1<<20, 0)
+	defer pm.Close()
+	if err := pm.CreateNamespace("a"); err != nil {
+		t.Fatal(err)
+	}
+	saveX(t, pm.Namespace("a"), 1)
+
+	if err := pm.RenameNamespace("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if events, _ := pm.Namespace("b").Audit(); len(events) != 1 {
+		t.Errorf("Expected the journal to follow the workspace, got %+v", events)
+	}
+	if events, _ := pm.Namespace("a").Audit(); len(events) != 0 {
+		t.Errorf("Expected no journal for the old name, got %+v", events)
+	}
+
+	if err := pm.DeleteNamespace("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(pm.journalOpts.path(childKey("", KindWorkspaces, "b"))); !os.IsNotExist(err) {
+		t.Errorf("Expected the journal to be deleted with the workspace, got %v", err)
+	}
+}
+
+func TestDiffList(t *testing.T) {
+	a, b, c, d := VariableChange{Name: "a"}, VariableChange{Name: "b"}, VariableChange{Name: "c"}, VariableChange{Name: "d"}
+	tests := []struct {
+		old, new []VariableChange
+		delta    *ListDelta
+	}{
+		{[]VariableChange{a, b}, []VariableChange{a, b}, nil},
+		{[]VariableChange{a, b}, []VariableChange{a, b, c}, &ListDelta{Push: []VariableChange{c}}},
+		{[]VariableChange{a, b, c}, []VariableChange{a, b}, &ListDelta{Pop: 1}},
+		{[]VariableChange{a, b, c}, []VariableChange{b, c, d}, &ListDelta{Drop: 1, Push: []VariableChange{d}}},
+		{[]VariableChange{a, b}, nil, &ListDelta{Drop: 2}},
+		{nil, []VariableChange{a}, &ListDelta{Push: []VariableChange{a}}},
+	}
+
+	for _, tt := range tests {
+		delta := diffList(tt.old, tt.new)
+		if !reflect.DeepEqual(delta, tt.delta) {
+			t.Errorf("diffList(%v, %v) = %+v, expected %+v", tt.old, tt.new, delta, tt.delta)
+			continue
+		}
+		if delta == nil {
+			continue
+		}
+		if got := delta.apply(tt.old); len(got) != len(tt.new) || (len(got) > 0 && !reflect.DeepEqual(got, tt.new)) {
+			t.Errorf("apply(%v) = %v, expected %v", tt.old, got, tt.new)
+		}
+	}
+}
+
+func TestEventDescribe(t *testing.T) {
+	e := Event{Type: EventVarSet, Name: "x", Value: 2.0, Old: 1.0}
+	if got := e.Describe(); !strings.Contains(got, "x = 2") || !strings.Contains(got, "было 1") {
+		t.Errorf("Unexpected description %q", got)
+	}
+}
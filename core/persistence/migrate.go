@@ -0,0 +1,266 @@
+package persistence
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// ВЕРСИИ СХЕМЫ ДАННЫХ
+// ============================================================================
+
+// SchemaVersion - версия формата CalculatorData, которую пишет эта версия калькулятора.
+// Файлы без schema_version считаются версией 0.
+const SchemaVersion = 6
+
+// ErrNewerSchema - данные записаны более новой версией калькулятора; читать и
+// перезаписывать их нельзя, иначе пропадут поля, о которых эта версия не знает
+var ErrNewerSchema = errors.New("данные записаны более новой версией калькулятора")
+
+// migration - переход со схемы version-1 на version. Миграции работают с документом
+// как с JSON объектом: старые форматы не обязаны ложиться в CalculatorData.
+type migration struct {
+	version     int
+	description string
+	apply       func(doc map[string]interface{}) error
+}
+
+// migrations - все миграции по порядку; новая миграция добавляется в конец вместе
+// с увеличением SchemaVersion и файлом-образцом прежнего формата в testdata
+var migrations = []migration{
+	{1, "записи истории в виде строк становятся объектами, у записей появляются номер и время", migrateHistoryEntries},
+	{2, "вид записей истории и счетчик номеров", migrateHistoryKinds},
+	{3, "снимок помнит номер последнего события журнала", migrateJournalSeq},
+	{4, "список констант", migrateConstants},
+	{5, "описания переменных", migrateVariableMetadata},
+	{6, "формулы переменных", migrateFormulas},
+}
+
+// migrateDocument - перевод документа на текущую схему; возвращает исходную версию
+func migrateDocument(doc map[string]interface{}) (int, error) {
+	from, err := documentVersion(doc)
+	if err != nil {
+		return 0, err
+	}
+	if from > SchemaVersion {
+		return from, fmt.Errorf("схема %d, поддерживается до %d: %w", from, SchemaVersion, ErrNewerSchema)
+	}
+
+	for _, m := range migrations {
+		if m.version <= from {
+			continue
+		}
+		if err := m.apply(doc); err != nil {
+			return from, fmt.Errorf("миграция на схему %d (%s): %v", m.version, m.description, err)
+		}
+		doc["schema_version"] = float64(m.version)
+	}
+	return from, nil
+}
+
+func documentVersion(doc map[string]interface{}) (int, error) {
+	raw, ok := doc["schema_version"]
+	if !ok || raw == nil {
+		return 0, nil
+	}
+	version, ok := raw.(float64)
+	if !ok || version < 0 || version != float64(int(version)) {
+		return 0, fmt.Errorf("некорректная schema_version: %v", raw)
+	}
+	return int(version), nil
+}
+
+// upgradeData - перевод уже разобранных данных на текущую схему
+// (для хранилищ, которые собирают CalculatorData сами, а не из одного JSON документа)
+func upgradeData(data *CalculatorData) error {
+	if data.SchemaVersion == SchemaVersion {
+		return nil
+	}
+	content, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	upgraded, _, err := decodeDocument(content)
+	if err != nil {
+		return err
+	}
+	*data = *upgraded
+	return nil
+}
+
+// decodeDocument - разбор JSON документа любой известной схемы; возвращает
+// данные в текущей схеме и исходную версию
+func decodeDocument(content []byte) (*CalculatorData, int, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return nil, 0, err
+	}
+	if doc == nil {
+		return nil, 0, errors.New("ожидался JSON объект")
+	}
+	from, err := migrateDocument(doc)
+	if err != nil {
+		return nil, from, err
+	}
+	if from == SchemaVersion {
+		// Документ уже в текущей схеме - разбираем исходный текст без лишнего круга
+		var data CalculatorData
+		if err := json.Unmarshal(content, &data); err != nil {
+			return nil, from, err
+		}
+		return &data, from, nil
+	}
+
+	migrated, err := json.Marshal(doc)
+	if err != nil {
+		return nil, from, err
+	}
+	var data CalculatorData
+	if err := json.Unmarshal(migrated, &data); err != nil {
+		return nil, from, err
+	}
+	return &data, from, nil
+}
+
+// historyItems - записи истории документа; отсутствующая история - пустая
+func historyItems(doc map[string]interface{}) ([]interface{}, error) {
+	raw, ok := doc["history"]
+	if !ok || raw == nil {
+		return []interface{}{}, nil
+	}
+	items, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("history: ожидался массив, получено %T", raw)
+	}
+	return items, nil
+}
+
+func entryID(entry map[string]interface{}) int {
+	id, _ := entry["id"].(float64)
+	return int(id)
+}
+
+// migrateHistoryEntries - схема 1. Самые первые версии хранили историю списком строк,
+// следующие - объектами {command, timestamp, id}, где время и номер могли быть пустыми.
+func migrateHistoryEntries(doc map[string]interface{}) error {
+	if doc["variables"] == nil {
+		doc["variables"] = map[string]interface{}{}
+	}
+	items, err := historyItems(doc)
+	if err != nil {
+		return err
+	}
+
+	maxID := 0
+	for _, item := range items {
+		if entry, ok := item.(map[string]interface{}); ok {
+			maxID = max(maxID, entryID(entry))
+		}
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	history := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		var entry map[string]interface{}
+		switch value := item.(type) {
+		case string:
+			entry = map[string]interface{}{"command": value}
+		case map[string]interface{}:
+			entry = value
+		default:
+			return fmt.Errorf("history: неизвестный формат записи %T", item)
+		}
+
+		// Пустые записи в старых файлах - следы ошибок записи, их не показать и не повторить
+		if command, _ := entry["command"].(string); command == "" {
+			continue
+		}
+		if timestamp, _ := entry["timestamp"].(string); timestamp == "" {
+			entry["timestamp"] = now
+		}
+		if entryID(entry) == 0 {
+			maxID++
+			entry["id"] = float64(maxID)
+		}
+		history = append(history, entry)
+	}
+	doc["history"] = history
+	return nil
+}
+
+// migrateHistoryKinds - схема 2: у записей появился вид (math, assign, ...),
+// а номера выдаются счетчиком next_history_id и не повторяются после очистки
+func migrateHistoryKinds(doc map[string]interface{}) error {
+	items, err := historyItems(doc)
+	if err != nil {
+		return err
+	}
+
+	maxID := 0
+	for _, item := range items {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("history: неизвестный формат записи %T", item)
+		}
+		if kind, _ := entry["kind"].(string); kind == "" {
+			command, _ := entry["command"].(string)
+			entry["kind"] = inferKind(command)
+		}
+		maxID = max(maxID, entryID(entry))
+	}
+
+	next, _ := doc["next_history_id"].(float64)
+	if int(next) <= maxID {
+		doc["next_history_id"] = float64(maxID + 1)
+	}
+	return nil
+}
+
+// migrateJournalSeq - схема 3: в снимке появился journal_seq. Старый снимок не содержит
+// ни одного события журнала, его отсутствие и значит 0, так что менять нечего. Версия
+// поднята, чтобы прежние версии калькулятора не перезаписали снимок без journal_seq:
+// тогда при следующем чтении события журнала применились бы к нему повторно.
+func migrateJournalSeq(doc map[string]interface{}) error {
+	return nil
+}
+
+// migrateConstants - схема 4: появился список констант constants. В старых файлах
+// констант нет; версия поднята, чтобы прежние версии калькулятора не перезаписали
+// файл без списка и константы не стали обычными переменными.
+func migrateConstants(doc map[string]interface{}) error {
+	return nil
+}
+
+// migrateVariableMetadata - схема 5: у переменных появились описания metadata
+// (единица, теги, время создания и изменения, выражение). Для старых переменных они
+// неизвестны и остаются пустыми; версия поднята, чтобы прежние версии калькулятора
+// не перезаписали файл без описаний.
+func migrateVariableMetadata(doc map[string]interface{}) error {
+	return nil
+}
+
+// migrateFormulas - схема 6: переменная может быть привязана к формуле (formula в описании).
+// В старых файлах формул нет; версия поднята, чтобы прежние версии калькулятора не
+// перезаписали файл без признака и формулы не стали обычными значениями.
+func migrateFormulas(doc map[string]interface{}) error {
+	return nil
+}
+
+var assignmentPattern = regexp.MustCompile(`^\s*[a-zA-Z_][a-zA-Z0-9_]*\s*=[^=]`)
+
+// inferKind - вид команды для записей, сохраненных до появления поля kind.
+// Раньше в историю попадали только вычисления и присваивания.
+func inferKind(command string) string {
+	switch {
+	case strings.HasPrefix(strings.TrimSpace(command), "curl "):
+		return KindCurl
+	case assignmentPattern.MatchString(command):
+		return KindAssign
+	default:
+		return KindMath
+	}
+}
@@ -0,0 +1,185 @@
+package persistence
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// copyFixture - копия файла-образца из testdata во временный каталог
+func copyFixture(t *testing.T, name string) string {
+	content, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "calc.json")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMigrationsAreOrdered(t *testing.T) {
+	for idx, m := range migrations {
+		if m.version != idx+1 {
+			t.Errorf("Migration %d has version %d, expected %d", idx, m.version, idx+1)
+		}
+	}
+	if migrations[len(migrations)-1].version != SchemaVersion {
+		t.Errorf("Last migration must lead to SchemaVersion %d", SchemaVersion)
+	}
+}
+
+// TestLoadMigratesFixtures - файлы всех прежних форматов, включая calculator_data.json
+// из корня репозитория и из core/interpreter (schema0_repo_*.json)
+func TestLoadMigratesFixtures(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		from     int
+		vars     map[string]float64
+		commands []string // первые команды истории
+		entries  int
+		nextID   int
+	}{
+		{"schema0_strings.json", 0, map[string]float64{"x": 1}, []string{"2+2", "x = 1", "curl https://example.com"}, 3, 4},
+		{"schema0_entries.json", 0, map[string]float64{}, []string{"x = 1", "x*2", "curl https://example.com"}, 3, 5},
+		{"schema0_repo_root.json", 0, map[string]float64{"t": 10, "x": 111, "y": 7, "z": 5}, []string{"0.000000000001+0.00000000000001/3", "2", "x=3"}, 100, 101},
+		{"schema0_repo_interpreter.json", 0, map[string]float64{"x": 42, "y": 100, "z": 30}, []string{"2+2", "3+3", "4+4"}, 9, 10},
+		{"schema1.json", 1, map[string]float64{"y": 2}, []string{"y = 2", "y^2"}, 2, 8},
+		{"schema2.json", 2, map[string]float64{"z": 3}, []string{"z = 3"}, 1, 12},
+		{"schema3.json", 3, map[string]float64{"z": 3}, []string{"z = 3"}, 1, 12},
+		{"schema4.json", 4, map[string]float64{"z": 3, "rate": 0.2}, []string{"z = 3", "const rate = 0.2"}, 2, 12},
+		{"schema5.json", 5, map[string]float64{"price": 120, "rate": 0.2}, []string{"price = 100 * 1.2", "const rate = 0.2"}, 2, 12},
+		{"schema6.json", 6, map[string]float64{"price": 20, "total": 60}, []string{"total := price * qty", "price = 20"}, 2, 22},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			path := copyFixture(t, tt.fixture)
+			original, _ := os.ReadFile(path)
+
+			data := NewPersistenceManagerWithBackups(path, 0).LoadData()
+			if data == nil {
+				t.Fatal("LoadData returned nil")
+			}
+			if data.SchemaVersion != SchemaVersion {
+				t.Errorf("Expected schema %d, got %d", SchemaVersion, data.SchemaVersion)
+			}
+			for name, value := range tt.vars {
+				if data.Variables[name] != value {
+					t.Errorf("Expected %s = %v, got %v", name, value, data.Variables[name])
+				}
+			}
+			if len(data.History) != tt.entries {
+				t.Fatalf("Expected %d history entries, got %d", tt.entries, len(data.History))
+			}
+			ids := make(map[int]bool)
+			for idx, entry := range data.History {
+				if idx < len(tt.commands) && entry.Command != tt.commands[idx] {
+					t.Errorf("Entry %d: expected %q, got %q", idx, tt.commands[idx], entry.Command)
+				}
+				if entry.ID == 0 || ids[entry.ID] || entry.Timestamp == "" || entry.Kind == "" {
+					t.Errorf("Entry %d is incomplete after migration: %+v", idx, entry)
+				}
+				ids[entry.ID] = true
+			}
+			if data.NextHistoryID != tt.nextID {
+				t.Errorf("Expected next history ID %d, got %d", tt.nextID, data.NextHistoryID)
+			}
+
+			backup := schemaBackupFile(path, tt.from)
+			if tt.from == SchemaVersion {
+				if _, err := os.Stat(backup); !os.IsNotExist(err) {
+					t.Errorf("Current schema must not be backed up, got %v", err)
+				}
+				return
+			}
+			saved, err := os.ReadFile(backup)
+			if err != nil || !bytes.Equal(saved, original) {
+				t.Errorf("Expected the original file in %s, got %v", backup, err)
+			}
+			rewritten, _ := os.ReadFile(path)
+			if !strings.Contains(string(rewritten), fmt.Sprintf(`"schema_version": %d`, SchemaVersion)) {
+				t.Errorf("Expected the file to be rewritten with the current schema:\n%s", rewritten)
+			}
+		})
+	}
+}
+
+func TestMigrationKindsOfOldEntries(t *testing.T) {
+	data := NewPersistenceManagerWithBackups(copyFixture(t, "schema0_entries.json"), 0).LoadData()
+	expected := []string{KindAssign, KindMath, KindCurl}
+	for idx, kind := range expected {
+		if data.History[idx].Kind != kind {
+			t.Errorf("Entry %d: expected kind %s, got %s", idx, kind, data.History[idx].Kind)
+		}
+	}
+	// Пустая запись отброшена, но ее номер не переиспользуется
+	if data.History[2].ID != 4 {
+		t.Errorf("Expected ID 4 for the entry without ID, got %d", data.History[2].ID)
+	}
+}
+
+func TestMigratedFileLoadsWithoutSecondMigration(t *testing.T) {
+	path := copyFixture(t, "schema0_strings.json")
+	NewPersistenceManagerWithBackups(path, 0).LoadData()
+	if err := os.Remove(schemaBackupFile(path, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	data := NewPersistenceManagerWithBackups(path, 0).LoadData()
+	if data == nil || len(data.History) != 3 {
+		t.Fatalf("Expected migrated data, got %+v", data)
+	}
+	if _, err := os.Stat(schemaBackupFile(path, 0)); !os.IsNotExist(err) {
+		t.Error("Migrated file must not be migrated again")
+	}
+}
+
+func TestNewerSchemaIsRefused(t *testing.T) {
+	path := copyFixture(t, "schema99.json")
+	original, _ := os.ReadFile(path)
+
+	pm := NewPersistenceManagerWithBackups(path, 3)
+	if err := pm.Check(); !errors.Is(err, ErrNewerSchema) {
+		t.Fatalf("Expected ErrNewerSchema, got %v", err)
+	}
+	if data := pm.LoadData(); data != nil {
+		t.Errorf("Expected no data, got %+v", data)
+	}
+	if pm.SaveVariables(map[string]interface{}{"x": 2.0}) {
+		t.Error("Saving over a newer file must be refused")
+	}
+	if err := pm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ := os.ReadFile(path)
+	if !bytes.Equal(content, original) {
+		t.Error("File written by a newer version must stay untouched")
+	}
+	if _, err := os.Stat(path + ".corrupt"); !os.IsNotExist(err) {
+		t.Error("File of a newer version is not corrupt")
+	}
+}
+
+func TestNewerSchemaIsRefusedByEveryBackend(t *testing.T) {
+	for name, open := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store := open()
+			defer store.Close()
+			data := testData(1)
+			data.SchemaVersion = SchemaVersion + 1
+			if err := store.Save("", data); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Load(""); !errors.Is(err, ErrNewerSchema) && name != "memory" {
+				t.Errorf("Expected ErrNewerSchema, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package persistence
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// ============================================================================
+// РАБОЧИЕ ПРОСТРАНСТВА
+// ============================================================================
+
+// DefaultNamespace - рабочее пространство по умолчанию, хранится в основном состоянии
+const DefaultNamespace = "default"
+
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidateNamespace - имя пространства: латиница, цифры, _ и -, до 64 символов
+func ValidateNamespace(name string) error {
+	if !namespacePattern.MatchString(name) {
+		return fmt.Errorf("некорректное имя рабочего пространства %q: допустимы латинские буквы, цифры, _ и -", name)
+	}
+	return nil
+}
+
+// Namespace - хранилище рабочего пространства. Пространство default - это основное
+// состояние, остальные хранятся под ключом workspaces/<имя> (у JSON хранилища -
+// в каталоге calculator_data.workspaces/<имя>.json рядом с основным файлом).
+func (pm *PersistenceManager) Namespace(name string) *PersistenceManager {
+	if name == "" || name == DefaultNamespace {
+		return pm
+	}
+	return pm.child(&pm.spaces, name, childKey(pm.key, KindWorkspaces, name), pm.actor)
+}
+
+// child - хранилище пространства или сессии. На каждое имя один экземпляр,
+// поэтому все записи одного состояния проходят через одну блокировку.
+func (pm *PersistenceManager) child(children *map[string]*PersistenceManager, name, key, actor string) *PersistenceManager {
+	pm.spacesMu.Lock()
+	defer pm.spacesMu.Unlock()
+	if *children == nil {
+		*children = make(map[string]*PersistenceManager)
+	}
+	if child, ok := (*children)[name]; ok {
+		return child
+	}
+
+	child := &PersistenceManager{
+		store:         pm.store,
+		key:           key,
+		flushInterval: pm.flushInterval,
+		journalOpts:   pm.journalOpts,
+		actor:         actor,
+	}
+	(*children)[name] = child
+	return child
+}
+
+// forgetChild - забыть экземпляр и его несохраненные изменения после удаления или переименования
+func (pm *PersistenceManager) forgetChild(children *map[string]*PersistenceManager, name string) {
+	pm.spacesMu.Lock()
+	child, ok := (*children)[name]
+	delete(*children, name)
+	pm.spacesMu.Unlock()
+	if ok {
+		child.discard()
+	}
+}
+
+// NamespaceExists - создано ли пространство; default существует всегда
+func (pm *PersistenceManager) NamespaceExists(name string) bool {
+	if name == DefaultNamespace {
+		return true
+	}
+	return pm.store.Exists(childKey(pm.key, KindWorkspaces, name))
+}
+
+// Namespaces - имена всех пространств по алфавиту, default первым
+func (pm *PersistenceManager) Namespaces() ([]string, error) {
+	spaces, err := pm.store.List(pm.key, KindWorkspaces)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(spaces))
+	for name := range spaces {
+		if ValidateNamespace(name) == nil && name != DefaultNamespace {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return append([]string{DefaultNamespace}, names...), nil
+}
+
+// CreateNamespace - новое пустое пространство
+func (pm *PersistenceManager) CreateNamespace(name string) error {
+	return pm.createNamespace(name, &CalculatorData{
+		Variables: make(map[string]interface{}),
+		History:   make([]HistoryEntry, 0),
+	})
+}
+
+// CopyNamespace - новое пространство to с копией переменных, истории и настроек from
+func (pm *PersistenceManager) CopyNamespace(from, to string) error {
+	if err := pm.checkExists(from); err != nil {
+		return err
+	}
+	data := pm.Namespace(from).LoadData()
+	if data == nil {
+		return fmt.Errorf("не удалось прочитать рабочее пространство %q", from)
+	}
+	return pm.createNamespace(to, data)
+}
+
+func (pm *PersistenceManager) createNamespace(name string, data *CalculatorData) error {
+	if err := ValidateNamespace(name); err != nil {
+		return err
+	}
+	if pm.NamespaceExists(name) {
+		return fmt.Errorf("рабочее пространство %q уже существует", name)
+	}
+	space := pm.Namespace(name)
+	if !space.SaveData(data) {
+		return fmt.Errorf("не удалось сохранить рабочее пространство %q", name)
+	}
+	// Состояние пространства нужно сразу: по нему проверяется существование
+	return space.Flush()
+}
+
+// RenameNamespace - переименование пространства; default не переименовывается
+func (pm *PersistenceManager) RenameNamespace(from, to string) error {
+	if from == DefaultNamespace {
+		return fmt.Errorf("рабочее пространство %s нельзя переименовать", DefaultNamespace)
+	}
+	if err := pm.checkExists(from); err != nil {
+		return err
+	}
+	if err := ValidateNamespace(to); err != nil {
+		return err
+	}
+	if pm.NamespaceExists(to) {
+		return fmt.Errorf("рабочее пространство %q уже существует", to)
+	}
+
+	if err := pm.Namespace(from).Flush(); err != nil {
+		return err
+	}
+	pm.forgetChild(&pm.spaces, from)
+	defer pm.forgetChild(&pm.spaces, to)
+	fromKey, toKey := childKey(pm.key, KindWorkspaces, from), childKey(pm.key, KindWorkspaces, to)
+	if err := pm.store.Rename(fromKey, toKey); err != nil {
+		return err
+	}
+	return pm.renameJournal(fromKey, toKey)
+}
+
+// DeleteNamespace - удаление пространства со всеми данными; default не удаляется
+func (pm *PersistenceManager) DeleteNamespace(name string) error {
+	if name == DefaultNamespace {
+		return fmt.Errorf("рабочее пространство %s нельзя удалить", DefaultNamespace)
+	}
+	if err := pm.checkExists(name); err != nil {
+		return err
+	}
+
+	pm.forgetChild(&pm.spaces, name)
+	key := childKey(pm.key, KindWorkspaces, name)
+	if err := pm.store.Delete(key); err != nil {
+		return err
+	}
+	return pm.removeJournal(key)
+}
+
+func (pm *PersistenceManager) checkExists(name string) error {
+	if err := ValidateNamespace(name); err != nil {
+		return err
+	}
+	if !pm.NamespaceExists(name) {
+		return fmt.Errorf("рабочее пространство %q не найдено", name)
+	}
+	return nil
+}
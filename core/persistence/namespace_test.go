@@ -0,0 +1,72 @@
+package persistence
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileNamespaces(t *testing.T) {
+	root := NewPersistenceManagerWithFile(filepath.Join(t.TempDir(), "calc.json"))
+	root.SaveData(&CalculatorData{Variables: map[string]interface{}{"x": 1.0}})
+
+	if err := root.CreateNamespace("budget"); err != nil {
+		t.Fatalf("CreateNamespace: %v", err)
+	}
+	if err := root.CreateNamespace("budget"); err == nil {
+		t.Error("Expected error for existing namespace")
+	}
+	if err := root.CreateNamespace("../evil"); err == nil {
+		t.Error("Expected error for invalid name")
+	}
+
+	budget := root.Namespace("budget")
+	budget.SaveVariables(map[string]interface{}{"rent": 500.0})
+	if vars := root.LoadVariables(); vars["rent"] != nil || vars["x"] != 1.0 {
+		t.Errorf("Namespaces must be isolated, default has %v", vars)
+	}
+
+	if err := root.CopyNamespace("budget", "budget2"); err != nil {
+		t.Fatalf("CopyNamespace: %v", err)
+	}
+	if err := root.RenameNamespace("budget2", "plan"); err != nil {
+		t.Fatalf("RenameNamespace: %v", err)
+	}
+	if vars := root.Namespace("plan").LoadVariables(); vars["rent"] != 500.0 {
+		t.Errorf("Expected copied variables, got %v", vars)
+	}
+
+	names, err := root.Namespaces()
+	if err != nil || !reflect.DeepEqual(names, []string{"default", "budget", "plan"}) {
+		t.Errorf("Unexpected namespaces %v, %v", names, err)
+	}
+
+	if err := root.DeleteNamespace(DefaultNamespace); err == nil {
+		t.Error("Expected error deleting default namespace")
+	}
+	if err := root.DeleteNamespace("plan"); err != nil {
+		t.Fatalf("DeleteNamespace: %v", err)
+	}
+	if root.NamespaceExists("plan") {
+		t.Error("Expected plan to be deleted")
+	}
+}
+
+func TestInMemoryNamespaces(t *testing.T) {
+	root := NewInMemoryPersistenceManager()
+	if err := root.CreateNamespace("a"); err != nil {
+		t.Fatalf("CreateNamespace: %v", err)
+	}
+	root.Namespace("a").SaveVariables(map[string]interface{}{"y": 2.0})
+	if err := root.RenameNamespace("a", "b"); err != nil {
+		t.Fatalf("RenameNamespace: %v", err)
+	}
+
+	names, _ := root.Namespaces()
+	if !reflect.DeepEqual(names, []string{"default", "b"}) {
+		t.Errorf("Unexpected namespaces %v", names)
+	}
+	if vars := root.Namespace("b").LoadVariables(); vars["y"] != 2.0 {
+		t.Errorf("Expected variables to follow rename, got %v", vars)
+	}
+}
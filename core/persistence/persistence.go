@@ -1,187 +1,508 @@
 package persistence
 
 import (
-	"encoding/json"
+	"app/config"
+	"errors"
 	"fmt"
-	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
+// Виды команд в истории
+const (
+	KindMath    = "math"
+	KindAssign  = "assign"
+	KindCurl    = "curl"
+	KindAI      = "ai"
+	KindBrowser = "browser"
+	KindMedia   = "media"
+	KindUndo    = "undo"
+)
+
 // HistoryEntry - структура для записи истории
 type HistoryEntry struct {
 	Command   string `json:"command"`
 	Timestamp string `json:"timestamp"`
 	ID        int    `json:"id"`
+	// Result - значение результата как есть (число остается числом); при ошибке пусто
+	Result     interface{} `json:"result,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Kind       string      `json:"kind,omitempty"`
+	DurationMs float64     `json:"duration_ms,omitempty"`
+	SessionID  string      `json:"session_id,omitempty"`
 }
 
 // CalculatorData - структура для хранения всех данных
 type CalculatorData struct {
-	Variables map[string]interface{} `json:"variables"`
-	History   []HistoryEntry         `json:"history"`
+	// SchemaVersion - версия формата (см. migrate.go); при сохранении всегда текущая
+	SchemaVersion int                    `json:"schema_version"`
+	Variables     map[string]interface{} `json:"variables"`
+	// Constants - имена переменных, объявленных константами (const x = ...)
+	Constants []string `json:"constants,omitempty"`
+	// Metadata - описания переменных по именам: единица, теги, время, выражение
+	Metadata map[string]VariableMeta `json:"metadata,omitempty"`
+	History  []HistoryEntry          `json:"history"`
+	// NextHistoryID - номер следующей записи; номера не повторяются после обрезки, очистки и перезапуска
+	NextHistoryID int `json:"next_history_id,omitempty"`
+	// JournalSeq - номер последнего события журнала, вошедшего в снимок (см. journal.go)
+	JournalSeq int64 `json:"journal_seq,omitempty"`
+	// Undo, Redo - журнал изменений переменных для отмены и повтора
+	Undo []VariableChange `json:"undo,omitempty"`
+	Redo []VariableChange `json:"redo,omitempty"`
+	// Settings - параметры конфигурации рабочего пространства поверх общих ("history.max_entries": "500")
+	Settings map[string]string `json:"settings,omitempty"`
+	// Workspace - рабочее пространство, открытое в веб-сессии при ее выгрузке (только у сессий)
+	Workspace string `json:"workspace,omitempty"`
+	// Encrypted - зашифрованное состояние целиком (см. EncryptedStore); остальные поля тогда пустые
+	Encrypted *Encrypted `json:"encrypted,omitempty"`
+
+	// changes - события журнала от методов изменения истории; задается на время Update,
+	// nil - журнал выключен или данные меняются вне Update
+	changes *[]Event
 }
 
-type PersistenceManager struct {
-	dataFile string
+// VariableChange - изменение переменной: прежнее и новое значение
+type VariableChange struct {
+	Name string `json:"name"`
+	// Existed - была ли переменная до изменения; если нет, отмена удаляет ее
+	Existed bool        `json:"existed"`
+	Old     interface{} `json:"old,omitempty"`
+	New     interface{} `json:"new"`
+	// Deleted - изменение удалило переменную; повтор тоже удаляет ее
+	Deleted   bool   `json:"deleted,omitempty"`
+	Timestamp string `json:"timestamp"`
+	// OldMeta, NewMeta - описание переменной до и после изменения; отмена возвращает
+	// и его (в том числе описание удаленной переменной)
+	OldMeta *VariableMeta `json:"old_meta,omitempty"`
+	NewMeta *VariableMeta `json:"new_meta,omitempty"`
 }
 
-func NewPersistenceManager() *PersistenceManager {
-	return &PersistenceManager{
-		dataFile: "calculator_data.json",
+// VariableMeta - описание переменной
+type VariableMeta struct {
+	Description string   `json:"description,omitempty"`
+	Unit        string   `json:"unit,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	// Created, Updated - время создания и последнего изменения значения (RFC 3339)
+	Created string `json:"created,omitempty"`
+	Updated string `json:"updated,omitempty"`
+	// Expression - выражение, из которого вычислено значение; пустое, если значение
+	// задано не присваиванием (загрузка, повтор истории)
+	Expression string `json:"expression,omitempty"`
+	// Formula - значение привязано к Expression (x := ...) и пересчитывается
+	// при изменении переменных из выражения
+	Formula bool `json:"formula,omitempty"`
+}
+
+// HasTag - есть ли у переменной тег tag (без учета регистра)
+func (m VariableMeta) HasTag(tag string) bool {
+	for _, t := range m.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
 	}
+	return false
 }
 
-func NewPersistenceManagerWithFile(dataFile string) *PersistenceManager {
-	return &PersistenceManager{
-		dataFile: dataFile,
+// Историю внутри Update меняют только методы ниже: каждый сразу записывает событие
+// журнала, и при изменении не нужно сравнивать всю историю с прежней.
+
+// AppendHistory - добавление записи с новым уникальным номером
+func (d *CalculatorData) AppendHistory(entry HistoryEntry) HistoryEntry {
+	// В файлах до появления счетчика продолжаем с максимального номера
+	if d.NextHistoryID == 0 {
+		for _, existing := range d.History {
+			d.NextHistoryID = max(d.NextHistoryID, existing.ID+1)
+		}
 	}
+	// Номера идут по возрастанию, достаточно последней записи
+	if n := len(d.History); n > 0 && d.History[n-1].ID >= d.NextHistoryID {
+		d.NextHistoryID = d.History[n-1].ID + 1
+	}
+	if d.NextHistoryID == 0 {
+		d.NextHistoryID = 1
+	}
+
+	entry.ID = d.NextHistoryID
+	d.NextHistoryID++
+	d.History = append(d.History, entry)
+	d.record(Event{Type: EventHistoryAppend, Entry: &entry})
+	return entry
 }
 
-// SaveData - сохранение данных в JSON файл
-func (pm *PersistenceManager) SaveData(data *CalculatorData) bool {
-	// Добавляем timestamp к каждой команде в истории
-	timestampedHistory := make([]HistoryEntry, len(data.History))
-	for i, entry := range data.History {
-		if entry.Timestamp == "" {
-			timestampedHistory[i] = HistoryEntry{
-				Command:   entry.Command,
-				Timestamp: time.Now().Format(time.RFC3339),
-				ID:        i + 1,
-			}
+// UpdateHistory - замена записи с индексом idx; номер записи остается прежним
+func (d *CalculatorData) UpdateHistory(idx int, entry HistoryEntry) {
+	entry.ID = d.History[idx].ID
+	d.History[idx] = entry
+	d.record(Event{Type: EventHistoryUpdate, Entry: &entry})
+}
+
+// TrimHistory - удаление n самых старых записей (обрезка по политике хранения)
+func (d *CalculatorData) TrimHistory(n int) {
+	n = min(n, len(d.History))
+	if n <= 0 {
+		return
+	}
+	ids := make([]int, n)
+	for idx, entry := range d.History[:n] {
+		ids[idx] = entry.ID
+	}
+	d.History = d.History[n:]
+	d.record(Event{Type: EventHistoryDrop, IDs: ids})
+}
+
+// DeleteHistory - удаление записи с номером id; false, если ее нет
+func (d *CalculatorData) DeleteHistory(id int) bool {
+	for idx, entry := range d.History {
+		if entry.ID == id {
+			d.History = append(d.History[:idx], d.History[idx+1:]...)
+			d.record(Event{Type: EventHistoryDrop, IDs: []int{id}})
+			return true
+		}
+	}
+	return false
+}
+
+// FilterHistory - удаление записей, для которых keep возвращает false; число удаленных
+func (d *CalculatorData) FilterHistory(keep func(entry HistoryEntry) bool) int {
+	kept := make([]HistoryEntry, 0, len(d.History))
+	ids := make([]int, 0)
+	for _, entry := range d.History {
+		if keep(entry) {
+			kept = append(kept, entry)
 		} else {
-			timestampedHistory[i] = entry
+			ids = append(ids, entry.ID)
 		}
 	}
-	data.History = timestampedHistory
+	d.History = kept
+	if len(ids) > 0 {
+		d.record(Event{Type: EventHistoryDrop, IDs: ids})
+	}
+	return len(ids)
+}
+
+// ClearHistory - удаление всех записей; номера новых записей продолжают прежние
+func (d *CalculatorData) ClearHistory() {
+	d.History = []HistoryEntry{}
+	d.record(Event{Type: EventHistoryClear})
+}
 
-	file, err := os.Create(pm.dataFile)
+// ReplaceHistory - замена всей истории записями entries с их номерами
+func (d *CalculatorData) ReplaceHistory(entries []HistoryEntry) {
+	d.ClearHistory()
+	d.History = append(d.History, entries...)
+	for idx := range entries {
+		d.NextHistoryID = max(d.NextHistoryID, entries[idx].ID+1)
+		d.record(Event{Type: EventHistoryAppend, Entry: &entries[idx]})
+	}
+}
+
+// record - событие для журнала, если данные меняются внутри Update
+func (d *CalculatorData) record(e Event) {
+	if d.changes != nil {
+		*d.changes = append(*d.changes, e)
+	}
+}
+
+// DefaultBackups - сколько предыдущих версий файла данных хранится по умолчанию
+const DefaultBackups = 3
+
+// PersistenceManager - состояние калькулятора поверх хранилища Store.
+// Состояние читается из хранилища один раз, дальше живет в памяти, а изменения
+// сохраняются через flushInterval после первого изменения (см. Flush).
+// Безопасно для одновременного использования: чтение, запись и Update выполняются по очереди.
+type PersistenceManager struct {
+	store Store
+	key   string // ключ состояния в хранилище, "" - основное
+	// flushInterval - задержка сохранения изменений; 0 - сохранение при каждом изменении
+	flushInterval time.Duration
+
+	mu sync.Mutex
+	// data - текущее состояние; nil - еще не прочитано
+	data  *CalculatorData
+	dirty bool
+	flush *time.Timer
+	// refused - данные записаны более новой версией, зашифрованы другим ключом, не
+	// прочитаны и не восстановлены из резервной копии или не читается их журнал:
+	// перезаписывать их нельзя
+	refused error
+
+	// journalOpts - журнал событий; nil - выключен. journal открывается при чтении состояния.
+	journalOpts *journalOptions
+	journal     *journal
+	// actor - кто вносит изменения, записывается в события журнала
+	actor string
+
+	// spaces, sessions - открытые рабочие пространства и сессии этого состояния
+	spacesMu sync.Mutex
+	spaces   map[string]*PersistenceManager
+	sessions map[string]*PersistenceManager
+}
+
+func NewPersistenceManager() *PersistenceManager {
+	return NewPersistenceManagerWithFile("calculator_data.json")
+}
+
+func NewPersistenceManagerWithFile(dataFile string) *PersistenceManager {
+	return NewPersistenceManagerWithBackups(dataFile, DefaultBackups)
+}
+
+// NewPersistenceManagerWithBackups - состояние в JSON файле, рядом хранится backups
+// предыдущих версий файла (<файл>.bak.1 - самая свежая); 0 - без резервных копий
+func NewPersistenceManagerWithBackups(dataFile string, backups int) *PersistenceManager {
+	return NewPersistenceManagerWithStore(NewJSONStore(dataFile, backups))
+}
+
+// NewPersistenceManagerWithConfig - хранилище storage.backend для storage.data_file
+// с отложенным сохранением через storage.flush_interval
+func NewPersistenceManagerWithConfig(cfg *config.Config) *PersistenceManager {
+	store, err := NewStoreWithConfig(cfg)
+	if err != nil {
+		// Конфигурация проверяется заранее; на всякий случай не теряем данные
+		fmt.Printf("⚠️ %v, используется %s\n", err, BackendJSON)
+		store = NewJSONStore(cfg.Storage.DataFile, cfg.Storage.Backups)
+	}
+	key, err := NewKeyWithConfig(cfg)
 	if err != nil {
-		fmt.Printf("Ошибка сохранения: %v\n", err)
+		// Ключ задан, но не читается: данные нельзя ни прочитать, ни записать открытыми
+		store = &EncryptedStore{inner: store, err: err}
+	} else if key != nil {
+		store = NewEncryptedStore(store, key)
+	}
+	pm := NewPersistenceManagerWithStore(store)
+	pm.flushInterval = cfg.Storage.FlushInterval.Std()
+	if cfg.Storage.Journal {
+		pm.journalOpts = &journalOptions{
+			file:     journalFile(cfg.Storage.Backend, cfg.Storage.DataFile),
+			maxBytes: int64(cfg.Storage.JournalMaxBytes),
+			keep:     cfg.Storage.JournalKeep,
+			key:      key,
+		}
+	}
+	return pm
+}
+
+// NewInMemoryPersistenceManager - хранилище без файла, данные живут до завершения процесса
+func NewInMemoryPersistenceManager() *PersistenceManager {
+	return NewPersistenceManagerWithStore(NewMemoryStore())
+}
+
+// NewPersistenceManagerWithStore - основное состояние хранилища store
+func NewPersistenceManagerWithStore(store Store) *PersistenceManager {
+	return &PersistenceManager{store: store}
+}
+
+// SetActor - кто вносит изменения (repl:<пользователь>, web, ...); попадает в журнал событий.
+// Вызывается до начала работы; рабочие пространства получают его при открытии,
+// сессии записываются как session:<id>.
+func (pm *PersistenceManager) SetActor(actor string) {
+	pm.actor = actor
+}
+
+// SaveData - замена всех данных; в хранилище они попадут через flushInterval
+func (pm *PersistenceManager) SaveData(data *CalculatorData) bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	current := pm.state()
+	if pm.refused != nil {
 		return false
 	}
-	defer file.Close()
+	before := pm.captureLocked(current)
+	pm.data = cloneData(data)
+	fillTimestamps(pm.data)
+	if before != nil {
+		// Прежние данные не менялись, историю можно сравнить с ними
+		var old []HistoryEntry
+		if current != nil {
+			old = current.History
+		}
+		before.history = historyEvents(old, pm.data.History)
+	}
+	pm.recordLocked(before)
+	return pm.changedLocked()
+}
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	encoder.SetEscapeHTML(false)
+// Update - чтение, изменение и сохранение данных как одна операция: другие записи
+// в это хранилище ждут ее завершения. fn меняет данные на месте и возвращает false,
+// если ничего не изменилось и сохранять не нужно.
+func (pm *PersistenceManager) Update(fn func(data *CalculatorData) bool) bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
 
-	if err := encoder.Encode(data); err != nil {
-		fmt.Printf("Ошибка кодирования JSON: %v\n", err)
+	data := pm.state()
+	if pm.refused != nil {
 		return false
 	}
+	if data == nil {
+		data = emptyData()
+	}
+	before := pm.captureLocked(data)
+	if before != nil {
+		data.changes = &before.history
+	}
+	changed := fn(data)
+	data.changes = nil
+	if !changed {
+		return false
+	}
+	pm.data = data
+	pm.recordLocked(before)
+	return pm.changedLocked()
+}
 
-	return true
+// View - чтение данных без копирования; fn не должна их менять или сохранять ссылки на них
+func (pm *PersistenceManager) View(fn func(data *CalculatorData)) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	data := pm.state()
+	if data == nil {
+		data = emptyData()
+	}
+	fn(data)
 }
 
-// LoadData - загрузка данных из JSON файла
-func (pm *PersistenceManager) LoadData() *CalculatorData {
-	file, err := os.Open(pm.dataFile)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// Файл не существует - возвращаем пустые данные
-			return &CalculatorData{
-				Variables: make(map[string]interface{}),
-				History:   make([]HistoryEntry, 0),
-			}
+// fillTimestamps - время для записей истории без него; остальные поля и номера
+// записей не меняются
+func fillTimestamps(data *CalculatorData) {
+	now := time.Now().Format(time.RFC3339)
+	for i := range data.History {
+		if data.History[i].Timestamp == "" {
+			data.History[i].Timestamp = now
 		}
-		fmt.Printf("Ошибка загрузки: %v\n", err)
-		return nil
 	}
-	defer file.Close()
+}
 
-	var data CalculatorData
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&data); err != nil {
-		fmt.Printf("Ошибка декодирования JSON: %v\n", err)
-		return nil
+func emptyData() *CalculatorData {
+	return &CalculatorData{
+		SchemaVersion: SchemaVersion,
+		Variables:     make(map[string]interface{}),
+		History:       make([]HistoryEntry, 0),
 	}
+}
 
-	// Конвертируем старый формат истории в новый (если нужно)
-	pm.migrateHistoryFormat(&data)
+// LoadData - копия текущих данных; nil, если файл не читается и восстановить его не удалось
+func (pm *PersistenceManager) LoadData() *CalculatorData {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
 
-	return &data
+	data := pm.state()
+	if data == nil {
+		return nil
+	}
+	return cloneData(data)
 }
 
-// migrateHistoryFormat - конвертация старого формата истории
-func (pm *PersistenceManager) migrateHistoryFormat(data *CalculatorData) {
-	if len(data.History) == 0 {
-		return
-	}
+// Check - чтение состояния сразу, а не при первом обращении. Ошибка, если данные
+// записаны более новой версией калькулятора или не читаются: с ними эта версия не работает.
+func (pm *PersistenceManager) Check() error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
 
-	// Проверяем, есть ли записи в старом формате (просто строки)
-	// В Go это сложнее определить, так как типы строгие
-	// Вместо этого полагаемся на структуру HistoryEntry
+	pm.state()
+	return pm.refused
+}
 
-	// Создаем новую историю с правильным форматом
-	newHistory := make([]HistoryEntry, 0, len(data.History))
-	for i, entry := range data.History {
-		// Если запись уже в правильном формате, оставляем как есть
-		if entry.Command != "" {
-			if entry.Timestamp == "" {
-				entry.Timestamp = time.Now().Format(time.RFC3339)
-			}
-			if entry.ID == 0 {
-				entry.ID = i + 1
+// state - текущие данные под pm.mu; при первом обращении читаются из хранилища
+// вместе с событиями журнала, не вошедшими в снимок
+func (pm *PersistenceManager) state() *CalculatorData {
+	if pm.data == nil && pm.refused == nil {
+		pm.data = pm.loadData()
+		if pm.data == nil {
+			return nil
+		}
+		applied, err := pm.replayLocked()
+		if err != nil {
+			// Как и нечитаемый снимок: данные не перезаписываются
+			pm.refused = err
+			pm.data = nil
+			fmt.Printf("Ошибка загрузки: %v\n", err)
+			return nil
+		}
+		if applied > 0 {
+			// Восстановленные из журнала изменения - в новый снимок
+			pm.changedLocked()
+		}
+		if pm.journalOpts != nil && pm.journalOpts.key != nil {
+			if err := pm.sealJournalsLocked(); err != nil {
+				fmt.Printf("Ошибка шифрования журнала: %v\n", err)
 			}
-			newHistory = append(newHistory, entry)
 		}
 	}
-	data.History = newHistory
+	return pm.data
 }
 
-// GetRecentHistory - получение последних N команд из истории
-func (pm *PersistenceManager) GetRecentHistory(limit int) []HistoryEntry {
-	data := pm.LoadData()
-	if data == nil || len(data.History) == 0 {
-		return []HistoryEntry{}
+func (pm *PersistenceManager) loadData() *CalculatorData {
+	data, err := pm.store.Load(pm.key)
+	if err == nil && data != nil && data.Encrypted != nil {
+		err = ErrNoKey
 	}
-
-	if limit <= 0 || limit > len(data.History) {
-		// Возвращаем всю историю (копию)
-		historyCopy := make([]HistoryEntry, len(data.History))
-		copy(historyCopy, data.History)
-		return historyCopy
+	if errors.Is(err, ErrWrongKey) || errors.Is(err, ErrNoKey) {
+		err = fmt.Errorf("%s: %w", describeKey(pm.key), err)
+	}
+	if errors.Is(err, ErrNewerSchema) || errors.Is(err, ErrWrongKey) || errors.Is(err, ErrNoKey) || errors.Is(err, ErrBadKey) {
+		// Сообщает Check и вызывающий; записи в это состояние отклоняются
+		pm.refused = err
+		return nil
+	}
+	if err != nil {
+		// Пустое состояние на месте нечитаемого файла стерло бы его при следующей записи
+		pm.refused = fmt.Errorf("данные не прочитаны: %w", err)
+		fmt.Printf("Ошибка загрузки: %v\n", err)
+		return nil
+	}
+	if data == nil {
+		// Состояние еще не сохранялось - возвращаем пустые данные
+		return emptyData()
 	}
+	return data
+}
 
-	// Возвращаем последние limit записей
-	start := len(data.History) - limit
-	return data.History[start:]
+// GetRecentHistory - получение последних N команд из истории
+func (pm *PersistenceManager) GetRecentHistory(limit int) []HistoryEntry {
+	var recent []HistoryEntry
+	pm.View(func(data *CalculatorData) {
+		start := 0
+		if limit > 0 && limit < len(data.History) {
+			start = len(data.History) - limit
+		}
+		recent = append([]HistoryEntry{}, data.History[start:]...)
+	})
+	return recent
 }
 
 // ClearHistory - очистка истории
 func (pm *PersistenceManager) ClearHistory() bool {
-	data := pm.LoadData()
-	if data == nil {
-		data = &CalculatorData{
-			Variables: make(map[string]interface{}),
-			History:   make([]HistoryEntry, 0),
-		}
-	}
-
-	data.History = []HistoryEntry{}
-	return pm.SaveData(data)
+	return pm.Update(func(data *CalculatorData) bool {
+		data.ClearHistory()
+		return true
+	})
 }
 
 // SaveVariables - сохранение только переменных
 func (pm *PersistenceManager) SaveVariables(variables map[string]interface{}) bool {
-	data := pm.LoadData()
-	if data == nil {
-		data = &CalculatorData{
-			Variables: make(map[string]interface{}),
-			History:   make([]HistoryEntry, 0),
+	return pm.Update(func(data *CalculatorData) bool {
+		data.Variables = variables
+		// Описания удаленных переменных удаляются вместе с ними
+		for name := range data.Metadata {
+			if _, ok := variables[name]; !ok {
+				delete(data.Metadata, name)
+			}
 		}
-	}
-
-	data.Variables = variables
-	return pm.SaveData(data)
+		return true
+	})
 }
 
 // LoadVariables - загрузка только переменных
 func (pm *PersistenceManager) LoadVariables() map[string]interface{} {
-	data := pm.LoadData()
-	if data == nil {
-		return make(map[string]interface{})
-	}
-	return data.Variables
+	variables := make(map[string]interface{})
+	pm.View(func(data *CalculatorData) {
+		for name, value := range data.Variables {
+			variables[name] = value
+		}
+	})
+	return variables
 }
 
 // // Пример использования
@@ -0,0 +1,73 @@
+package persistence
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadDataMigratesOldHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	old := `{"variables": {"x": 1}, "history": [
+		{"command": "x = 1", "timestamp": "2025-01-01T10:00:00Z", "id": 1},
+		{"command": "x*2", "timestamp": "2025-01-01T10:00:01Z", "id": 2},
+		{"command": "curl https://example.com", "timestamp": "", "id": 0}
+	]}`
+	if err := os.WriteFile(path, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data := NewPersistenceManagerWithFile(path).LoadData()
+	if data == nil || len(data.History) != 3 {
+		t.Fatalf("Expected 3 entries, got %+v", data)
+	}
+
+	expected := []string{KindAssign, KindMath, KindCurl}
+	for idx, kind := range expected {
+		if data.History[idx].Kind != kind {
+			t.Errorf("Entry %d: expected kind %s, got %s", idx, kind, data.History[idx].Kind)
+		}
+	}
+	if data.History[2].ID != 3 || data.History[2].Timestamp == "" {
+		t.Errorf("Expected ID and timestamp to be filled: %+v", data.History[2])
+	}
+}
+
+func TestHistoryEntryRoundTrip(t *testing.T) {
+	pm := NewInMemoryPersistenceManager()
+	pm.SaveData(&CalculatorData{
+		Variables: map[string]interface{}{},
+		History: []HistoryEntry{
+			{Command: "2+2", ID: 1, Timestamp: "2025-01-01T10:00:00Z", Kind: KindMath, Result: 4.0, DurationMs: 0.25, SessionID: "abc"},
+			{Command: "1/0", ID: 2, Timestamp: "2025-01-01T10:00:01Z", Kind: KindMath, Error: "деление на ноль"},
+		},
+	})
+
+	data := pm.LoadData()
+	if data.History[0].Result != 4.0 || data.History[0].SessionID != "abc" || data.History[0].DurationMs != 0.25 {
+		t.Errorf("Unexpected entry: %+v", data.History[0])
+	}
+	if data.History[1].Result != nil || data.History[1].Error == "" {
+		t.Errorf("Unexpected error entry: %+v", data.History[1])
+	}
+}
+
+func TestSaveDataKeepsEntriesWithoutTimestamp(t *testing.T) {
+	pm := NewInMemoryPersistenceManager()
+	pm.SaveData(&CalculatorData{
+		Variables: map[string]interface{}{},
+		History: []HistoryEntry{
+			{Command: "2+2", ID: 7, Kind: KindMath, Result: 4.0},
+			{Command: "1/0", ID: 9, Kind: KindMath, Error: "деление на ноль"},
+		},
+	})
+
+	data := pm.LoadData()
+	first, second := data.History[0], data.History[1]
+	if first.ID != 7 || first.Kind != KindMath || first.Result != 4.0 || first.Timestamp == "" {
+		t.Errorf("Expected fields and ID kept, timestamp filled: %+v", first)
+	}
+	if second.ID != 9 || second.Error != "деление на ноль" || second.Timestamp == "" {
+		t.Errorf("Expected fields and ID kept, timestamp filled: %+v", second)
+	}
+}
@@ -0,0 +1,63 @@
+package persistence
+
+import (
+	"time"
+)
+
+// ============================================================================
+// СЕССИИ ВЕБ-СЕРВЕРА
+// ============================================================================
+
+// Session - отдельное хранилище состояния веб-сессии под ключом sessions/<id>
+// (у JSON хранилища - calculator_data.sessions/<id>.json), рабочие пространства сессии
+// вложены в него. Идентификатор проверяет вызывающий.
+func (pm *PersistenceManager) Session(id string) *PersistenceManager {
+	return pm.child(&pm.sessions, id, childKey(pm.key, KindSessions, id), "session:"+id)
+}
+
+// SessionExists - сохранялось ли что-нибудь в сессии
+func (pm *PersistenceManager) SessionExists(id string) bool {
+	return pm.store.Exists(childKey(pm.key, KindSessions, id))
+}
+
+// Sessions - сохраненные сессии и время их последнего изменения
+func (pm *PersistenceManager) Sessions() (map[string]time.Time, error) {
+	return pm.store.List(pm.key, KindSessions)
+}
+
+// CurrentNamespace - рабочее пространство, которое было открыто в сессии при ее выгрузке
+func (pm *PersistenceManager) CurrentNamespace() string {
+	name := ""
+	pm.View(func(data *CalculatorData) {
+		name = data.Workspace
+	})
+	if name == "" {
+		return DefaultNamespace
+	}
+	return name
+}
+
+// SetCurrentNamespace - запомнить открытое в сессии рабочее пространство, чтобы после
+// выгрузки или перезапуска сервера сессия вернулась в него
+func (pm *PersistenceManager) SetCurrentNamespace(name string) bool {
+	if name == DefaultNamespace {
+		name = ""
+	}
+	return pm.Update(func(data *CalculatorData) bool {
+		if data.Workspace == name {
+			return false
+		}
+		data.Workspace = name
+		return true
+	})
+}
+
+// DeleteSession - удаление состояния сессии вместе с ее рабочими пространствами
+func (pm *PersistenceManager) DeleteSession(id string) error {
+	pm.forgetChild(&pm.sessions, id)
+	key := childKey(pm.key, KindSessions, id)
+	if err := pm.store.Delete(key); err != nil {
+		return err
+	}
+	return pm.removeJournal(key)
+}
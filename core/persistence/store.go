@@ -0,0 +1,147 @@
+package persistence
+
+import (
+	"app/config"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// ХРАНИЛИЩА
+// ============================================================================
+
+// Store - место хранения состояний калькулятора: переменных, истории, журнала отмены
+// и настроек (CalculatorData). Состояний несколько, у каждого свой ключ:
+//
+//	""                        основное состояние (рабочее пространство default)
+//	"workspaces/<имя>"        рабочее пространство
+//	"sessions/<id>"           сессия веб-сервера
+//	"sessions/<id>/workspaces/<имя>"
+//
+// PersistenceManager держит состояние в памяти и сохраняет его через Store целиком;
+// хранилище само решает, что из этого действительно нужно записать.
+type Store interface {
+	// Load - состояние по ключу; nil без ошибки, если его еще нет
+	Load(key string) (*CalculatorData, error)
+	// Save - сохранение состояния целиком
+	Save(key string, data *CalculatorData) error
+	// Exists - сохранялось ли состояние
+	Exists(key string) bool
+	// List - вложенные состояния вида kind ("workspaces", "sessions") и время их изменения
+	List(parent, kind string) (map[string]time.Time, error)
+	// Rename - перенос состояния на другой ключ
+	Rename(from, to string) error
+	// Delete - удаление состояния вместе с вложенными
+	Delete(key string) error
+	// Close - освобождение файлов хранилища
+	Close() error
+}
+
+// Хранилища, доступные через storage.backend
+const (
+	BackendJSON  = "json"
+	BackendJSONL = "jsonl"
+	BackendBolt  = "bolt"
+)
+
+// Виды вложенных состояний
+const (
+	KindWorkspaces = "workspaces"
+	KindSessions   = "sessions"
+)
+
+// NewStore - хранилище backend с файлом данных dataFile. Расширение файла заменяется
+// на принятое у хранилища: calculator_data.json, calculator_data.jsonl, calculator_data.db.
+func NewStore(backend, dataFile string, backups int) (Store, error) {
+	base := strings.TrimSuffix(dataFile, filepath.Ext(dataFile))
+	switch backend {
+	case BackendJSON, "":
+		return NewJSONStore(dataFile, backups), nil
+	case BackendJSONL:
+		return NewJSONLStore(base + ".jsonl"), nil
+	case BackendBolt:
+		return NewBoltStore(base + ".db"), nil
+	default:
+		return nil, fmt.Errorf("неизвестное хранилище %q: доступны %s, %s, %s", backend, BackendJSON, BackendJSONL, BackendBolt)
+	}
+}
+
+// NewStoreWithConfig - хранилище storage.backend для storage.data_file
+func NewStoreWithConfig(cfg *config.Config) (Store, error) {
+	return NewStore(cfg.Storage.Backend, cfg.Storage.DataFile, cfg.Storage.Backups)
+}
+
+// childKey - ключ вложенного состояния
+func childKey(parent, kind, name string) string {
+	if parent == "" {
+		return kind + "/" + name
+	}
+	return parent + "/" + kind + "/" + name
+}
+
+// splitKey - пары (вид, имя) ключа: "sessions/a/workspaces/b" -> [sessions a workspaces b]
+func splitKey(key string) []string {
+	if key == "" {
+		return nil
+	}
+	return strings.Split(key, "/")
+}
+
+// childName - имя прямого потомка parent вида kind; false, если key им не является
+func childName(key, parent, kind string) (string, bool) {
+	prefix := kind + "/"
+	if parent != "" {
+		prefix = parent + "/" + prefix
+	}
+	name, ok := strings.CutPrefix(key, prefix)
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return "", false
+	}
+	return name, true
+}
+
+// CopyStore - перенос всех состояний (основного, рабочих пространств, сессий) из from в to.
+// Возвращает число перенесенных состояний.
+func CopyStore(from, to Store) (int, error) {
+	return copyState(from, to, "")
+}
+
+func copyState(from, to Store, key string) (int, error) {
+	copied := 0
+	if from.Exists(key) || key == "" {
+		data, err := from.Load(key)
+		if err != nil {
+			return copied, fmt.Errorf("%s: %w", describeKey(key), err)
+		}
+		if data != nil {
+			if err := to.Save(key, data); err != nil {
+				return copied, fmt.Errorf("%s: %w", describeKey(key), err)
+			}
+			copied++
+		}
+	}
+
+	for _, kind := range []string{KindWorkspaces, KindSessions} {
+		children, err := from.List(key, kind)
+		if err != nil {
+			return copied, err
+		}
+		for name := range children {
+			n, err := copyState(from, to, childKey(key, kind, name))
+			copied += n
+			if err != nil {
+				return copied, err
+			}
+		}
+	}
+	return copied, nil
+}
+
+func describeKey(key string) string {
+	if key == "" {
+		return "основное состояние"
+	}
+	return key
+}
@@ -0,0 +1,358 @@
+package persistence
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+)
+
+// ============================================================================
+// ХРАНИЛИЩЕ BBOLT
+// ============================================================================
+
+// BoltStore - все состояния в одном файле базы bbolt (calculator_data.db), каждое
+// в своем разделе: переменные, журнал отмены и настройки - одним значением, история -
+// по записи на номер. Сохранение пишет только новые записи истории и удаляет
+// исчезнувшие; каждое сохранение - транзакция, сбой не оставляет базу наполовину записанной.
+// Файл базы одновременно открывает только один процесс.
+type BoltStore struct {
+	path string
+
+	mu sync.Mutex
+	db *bolt.DB
+}
+
+var (
+	boltMeta    = []byte("meta")
+	boltUpdated = []byte("updated")
+	boltHistory = []byte("history")
+)
+
+// boltOpenTimeout - сколько ждать, пока базу освободит другой процесс
+const boltOpenTimeout = time.Second
+
+func NewBoltStore(path string) *BoltStore {
+	return &BoltStore{path: path}
+}
+
+// open - база открывается при первом обращении
+func (s *BoltStore) open() (*bolt.DB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db != nil {
+		return s.db, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(s.path, 0644, &bolt.Options{Timeout: boltOpenTimeout})
+	if errors.Is(err, berrors.ErrTimeout) {
+		return nil, fmt.Errorf("база %s открыта другим процессом", s.path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.db = db
+	return db, nil
+}
+
+// bucketName - раздел состояния key
+func bucketName(key string) []byte {
+	if key == "" {
+		return []byte(DefaultNamespace)
+	}
+	return []byte(key)
+}
+
+func bucketKey(name []byte) string {
+	if string(name) == DefaultNamespace {
+		return ""
+	}
+	return string(name)
+}
+
+func historyKey(id int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+func (s *BoltStore) Load(key string) (*CalculatorData, error) {
+	db, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+
+	var data *CalculatorData
+	err = db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName(key))
+		if bucket == nil {
+			return nil
+		}
+
+		data = emptyData()
+		if meta := bucket.Get(boltMeta); meta != nil {
+			if err := json.Unmarshal(meta, data); err != nil {
+				return err
+			}
+		}
+		data.History = make([]HistoryEntry, 0)
+		if history := bucket.Bucket(boltHistory); history != nil {
+			return history.ForEach(func(_, value []byte) error {
+				var entry HistoryEntry
+				if err := json.Unmarshal(value, &entry); err != nil {
+					return err
+				}
+				data.History = append(data.History, entry)
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		if err := upgradeData(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", s.path, err)
+		}
+	}
+	return data, nil
+}
+
+func (s *BoltStore) Save(key string, data *CalculatorData) error {
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+
+	meta := *data
+	meta.History = nil
+	metaJSON, err := json.Marshal(&meta)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(bucketName(key))
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(boltMeta, metaJSON); err != nil {
+			return err
+		}
+		if err := bucket.Put(boltUpdated, []byte(time.Now().Format(time.RFC3339Nano))); err != nil {
+			return err
+		}
+
+		history, err := bucket.CreateBucketIfNotExists(boltHistory)
+		if err != nil {
+			return err
+		}
+		// Записи истории не меняются: удаляем исчезнувшие и добавляем новые
+		current := historyIDs(data.History)
+		stored := make(map[int]bool)
+		removed := make([][]byte, 0)
+		history.ForEach(func(k, _ []byte) error {
+			if id := int(binary.BigEndian.Uint64(k)); current[id] {
+				stored[id] = true
+			} else {
+				removed = append(removed, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		for _, k := range removed {
+			if err := history.Delete(k); err != nil {
+				return err
+			}
+		}
+		for _, entry := range data.History {
+			if stored[entry.ID] {
+				continue
+			}
+			value, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if err := history.Put(historyKey(entry.ID), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Exists(key string) bool {
+	db, err := s.open()
+	if err != nil {
+		return false
+	}
+	exists := false
+	db.View(func(tx *bolt.Tx) error {
+		exists = tx.Bucket(bucketName(key)) != nil
+		return nil
+	})
+	return exists
+}
+
+func (s *BoltStore) List(parent, kind string) (map[string]time.Time, error) {
+	db, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[string]time.Time)
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			child, ok := childName(bucketKey(name), parent, kind)
+			if !ok {
+				return nil
+			}
+			updated, _ := time.Parse(time.RFC3339Nano, string(bucket.Get(boltUpdated)))
+			children[child] = updated
+			return nil
+		})
+	})
+	return children, err
+}
+
+func (s *BoltStore) Rename(from, to string) error {
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, name := range matchingBuckets(tx, from) {
+			target := bucketName(to + strings.TrimPrefix(bucketKey(name), from))
+			if err := copyBucket(tx.Bucket(name), tx, target); err != nil {
+				return err
+			}
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Delete(key string) error {
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, name := range matchingBuckets(tx, key) {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
+}
+
+// purge - база переписывается заново: освобожденные страницы с прежними версиями
+// данных в новый файл не попадают. Переписывается вся база, keys не важны.
+func (s *BoltStore) purge(keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := os.Stat(s.path); os.IsNotExist(err) {
+		return nil
+	}
+	if s.db != nil {
+		if err := s.db.Close(); err != nil {
+			return err
+		}
+		// Откроется заново при следующем обращении
+		s.db = nil
+	}
+
+	src, err := bolt.Open(s.path, 0644, &bolt.Options{Timeout: boltOpenTimeout, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".compact"
+	os.Remove(tmp)
+	dst, err := bolt.Open(tmp, 0644, nil)
+	if err != nil {
+		src.Close()
+		return err
+	}
+	err = bolt.Compact(dst, src, 0)
+	src.Close()
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// Прежний файл затирается только после того, как новый занял его место
+	old := s.path + ".old"
+	os.Remove(old)
+	linked := os.Link(s.path, old) == nil
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(old)
+		return err
+	}
+	syncDir(filepath.Dir(s.path))
+	if linked {
+		return wipeFile(old)
+	}
+	return nil
+}
+
+// matchingBuckets - разделы состояния key и вложенных в него
+func matchingBuckets(tx *bolt.Tx, key string) [][]byte {
+	names := make([][]byte, 0)
+	tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+		existing := bucketKey(name)
+		if existing == key || strings.HasPrefix(existing, key+"/") {
+			names = append(names, append([]byte(nil), name...))
+		}
+		return nil
+	})
+	return names
+}
+
+func copyBucket(src *bolt.Bucket, tx *bolt.Tx, name []byte) error {
+	dst, err := tx.CreateBucket(name)
+	if err != nil {
+		return err
+	}
+	return copyBucketContents(src, dst)
+}
+
+func copyBucketContents(src, dst *bolt.Bucket) error {
+	return src.ForEach(func(key, value []byte) error {
+		if value != nil {
+			return dst.Put(key, value)
+		}
+		nested, err := dst.CreateBucket(key)
+		if err != nil {
+			return err
+		}
+		return copyBucketContents(src.Bucket(key), nested)
+	})
+}
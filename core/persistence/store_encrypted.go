@@ -0,0 +1,183 @@
+package persistence
+
+import (
+	"app/config"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ============================================================================
+// ШИФРОВАНИЕ ПОВЕРХ ХРАНИЛИЩА
+// ============================================================================
+
+// EncryptedStore - шифрование состояний поверх любого хранилища. Состояние целиком
+// (переменные, история, журнал отмены, настройки) шифруется AES-256-GCM, во вложенное
+// хранилище попадает только конверт: schema_version и поле encrypted. Перечень рабочих
+// пространств и сессий остается открытым - это имена файлов или разделов базы.
+type EncryptedStore struct {
+	inner Store
+	key   *Key
+	// err - ключ не удалось получить: чтение и запись отклоняются
+	err error
+}
+
+// NewEncryptedStore - хранилище inner, данные в котором шифруются ключом key
+func NewEncryptedStore(inner Store, key *Key) *EncryptedStore {
+	return &EncryptedStore{inner: inner, key: key}
+}
+
+// purger - хранилище, в котором после перезаписи состояния остаются его прежние версии:
+// резервные копии, дописанные строки, освобожденные страницы базы
+type purger interface {
+	// purge - удаление прежних версий состояний keys; сами состояния не меняются
+	purge(keys []string) error
+}
+
+// purgeStore - удаление прежних версий после шифрования или смены ключа: в них данные
+// открыты или зашифрованы прежним ключом
+func purgeStore(store Store, keys []string) error {
+	if p, ok := store.(purger); ok {
+		return p.purge(keys)
+	}
+	return nil
+}
+
+func (s *EncryptedStore) Load(key string) (*CalculatorData, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	envelope, err := s.inner.Load(key)
+	if err != nil || envelope == nil {
+		return envelope, err
+	}
+	if envelope.Encrypted == nil {
+		// Данные сохранены до включения шифрования - сразу шифруем их
+		if err := s.Save(key, envelope); err != nil {
+			return nil, fmt.Errorf("%s: не удалось зашифровать: %v", describeKey(key), err)
+		}
+		if err := purgeStore(s.inner, []string{key}); err != nil {
+			fmt.Printf("⚠️  %s: открытые копии данных не удалены: %v\n", describeKey(key), err)
+		}
+		fmt.Printf("🔒 %s: данные зашифрованы\n", describeKey(key))
+		return envelope, nil
+	}
+
+	plain, err := s.key.Open(envelope.Encrypted)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := decodeDocument(plain)
+	return data, err
+}
+
+func (s *EncryptedStore) Save(key string, data *CalculatorData) error {
+	if s.err != nil {
+		return s.err
+	}
+	plain, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	sealed, err := s.key.Seal(plain)
+	if err != nil {
+		return err
+	}
+	return s.inner.Save(key, &CalculatorData{SchemaVersion: SchemaVersion, Encrypted: sealed})
+}
+
+func (s *EncryptedStore) Exists(key string) bool {
+	return s.inner.Exists(key)
+}
+
+func (s *EncryptedStore) List(parent, kind string) (map[string]time.Time, error) {
+	return s.inner.List(parent, kind)
+}
+
+func (s *EncryptedStore) Rename(from, to string) error {
+	return s.inner.Rename(from, to)
+}
+
+func (s *EncryptedStore) Delete(key string) error {
+	return s.inner.Delete(key)
+}
+
+func (s *EncryptedStore) Close() error {
+	return s.inner.Close()
+}
+
+// keyedStore - store с шифрованием ключом key; nil - без шифрования
+func keyedStore(store Store, key *Key) Store {
+	if key == nil {
+		return store
+	}
+	return NewEncryptedStore(store, key)
+}
+
+// Rekey - смена ключа шифрования всех состояний (основного, рабочих пространств, сессий)
+// и их журналов событий; прежние версии состояний (резервные копии и т.п.) удаляются. Данные читаются ключом from и записываются ключом to; nil вместо
+// from - данные еще не зашифрованы, вместо to - расшифровать. Сначала читается все:
+// если что-то не открывается ключом from, ничего не меняется. Возвращает число состояний.
+func Rekey(cfg *config.Config, from, to *Key) (int, error) {
+	if sameKey(from, to) {
+		return 0, fmt.Errorf("новый ключ совпадает с текущим")
+	}
+	inner, err := NewStoreWithConfig(cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer inner.Close()
+
+	states := NewMemoryStore()
+	if _, err := CopyStore(keyedStore(inner, from), states); err != nil {
+		return 0, err
+	}
+	for key, state := range states.states {
+		if state.data.Encrypted != nil {
+			return 0, fmt.Errorf("%s: %w", describeKey(key), ErrNoKey)
+		}
+	}
+
+	// Журнал может быть и у состояния, которое еще не успело сохранить снимок
+	opts := &journalOptions{file: journalFile(cfg.Storage.Backend, cfg.Storage.DataFile), keep: cfg.Storage.JournalKeep}
+	keys, err := opts.nestedKeys("")
+	if err != nil {
+		return 0, err
+	}
+	keys = append(keys, "")
+	for key := range states.states {
+		keys = append(keys, key)
+	}
+	journals := make(map[string][]Event)
+	for _, key := range keys {
+		for _, path := range opts.files(key) {
+			events, _, err := readJournal(path, false, from)
+			if err != nil {
+				return 0, err
+			}
+			if len(events) > 0 {
+				journals[path] = events
+			}
+		}
+	}
+
+	copied, err := CopyStore(states, keyedStore(inner, to))
+	if err != nil {
+		return copied, err
+	}
+	for path, events := range journals {
+		content, err := encodeEvents(events, to)
+		if err != nil {
+			return copied, err
+		}
+		if err := writeFileAtomic(path, content); err != nil {
+			return copied, err
+		}
+	}
+
+	purged := make([]string, 0, len(states.states))
+	for key := range states.states {
+		purged = append(purged, key)
+	}
+	return copied, purgeStore(inner, purged)
+}
@@ -0,0 +1,371 @@
+package persistence
+
+import (
+	"app/config"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKey(t *testing.T) *Key {
+	t.Helper()
+	text, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseKey(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// encryptedConfig - конфигурация JSON хранилища с журналом в каталоге теста
+func encryptedConfig(t *testing.T, key string) *config.Config {
+	cfg := config.Default()
+	cfg.Storage.DataFile = filepath.Join(t.TempDir(), "calc.json")
+	cfg.Storage.FlushInterval = 0
+	cfg.Storage.EncryptionKey = key
+	return cfg
+}
+
+// assertNoPlaintext - секрет не встречается ни в одном файле каталога
+func assertNoPlaintext(t *testing.T, dir, secret string) {
+	t.Helper()
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		content, _ := os.ReadFile(path)
+		if strings.Contains(string(content), secret) {
+			t.Errorf("%s contains %q in plaintext", filepath.Base(path), secret)
+		}
+		return nil
+	})
+}
+
+func TestEncryptedStoreOnEveryBackend(t *testing.T) {
+	key := testKey(t)
+	for _, backend := range []string{BackendJSON, BackendJSONL, BackendBolt} {
+		t.Run(backend, func(t *testing.T) {
+			dir := t.TempDir()
+			open := func() Store {
+				store, err := NewStore(backend, filepath.Join(dir, "calc.json"), 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return NewEncryptedStore(store, key)
+			}
+
+			store := open()
+			if err := store.Save("", testData(1, "curl -H 'X-Token: topsecret' https://example.com")); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Save("workspaces/w", testData(2, "topsecret = 1")); err != nil {
+				t.Fatal(err)
+			}
+			store.Close()
+			assertNoPlaintext(t, dir, "topsecret")
+
+			store = open()
+			defer store.Close()
+			data, err := store.Load("")
+			if err != nil || data == nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+			if data.Variables["x"] != 1.0 || !strings.Contains(historyCommands(data), "topsecret") {
+				t.Errorf("Unexpected decrypted data %+v", data)
+			}
+			if spaces, _ := store.List("", KindWorkspaces); len(spaces) != 1 {
+				t.Errorf("Expected workspace w, got %v", spaces)
+			}
+		})
+	}
+}
+
+func TestWrongKeyIsRefused(t *testing.T) {
+	cfg := encryptedConfig(t, "")
+	key := testKey(t)
+	saveX(t, NewPersistenceManagerWithStore(NewEncryptedStore(NewJSONStore(cfg.Storage.DataFile, 0), key)), 1)
+	original, _ := os.ReadFile(cfg.Storage.DataFile)
+
+	other, _ := GenerateKey()
+	tests := []struct {
+		name    string
+		set     func(cfg *config.Config)
+		err     error
+		message string
+	}{
+		{"other key", func(cfg *config.Config) { cfg.Storage.EncryptionKey = other }, ErrWrongKey, "зашифрованы ключом"},
+		{"passphrase", func(cfg *config.Config) { cfg.Storage.EncryptionPassphrase = "secret" }, ErrWrongKey, "задан пароль"},
+		{"no key", func(cfg *config.Config) {}, ErrNoKey, "ключ не задан"},
+		{"missing key file", func(cfg *config.Config) { cfg.Storage.EncryptionKeyFile = filepath.Join(t.TempDir(), "none") }, ErrBadKey, "encryption_key_file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := cfg.Clone()
+			tt.set(cfg)
+			pm := NewPersistenceManagerWithConfig(cfg)
+			err := pm.Check()
+			if !errors.Is(err, tt.err) || !strings.Contains(err.Error(), tt.message) {
+				t.Fatalf("Expected %v with %q, got %v", tt.err, tt.message, err)
+			}
+			if pm.SaveVariables(map[string]interface{}{"x": 2.0}) {
+				t.Error("Saving with a wrong key must be refused")
+			}
+			saved := pm.Session("s").SaveVariables(map[string]interface{}{"x": 2.0})
+			if tt.err == ErrBadKey && saved {
+				t.Error("Sessions must not be saved without the configured key")
+			}
+			pm.Close()
+
+			if content, _ := os.ReadFile(cfg.Storage.DataFile); string(content) != string(original) {
+				t.Error("Encrypted file must stay untouched")
+			}
+		})
+	}
+}
+
+func TestPassphraseKey(t *testing.T) {
+	cfg := encryptedConfig(t, "")
+	cfg.Storage.EncryptionPassphrase = "correct horse"
+	pm := NewPersistenceManagerWithConfig(cfg)
+	saveX(t, pm, 1)
+	pm.Close()
+
+	if vars := NewPersistenceManagerWithConfig(cfg).LoadVariables(); vars["x"] != 1.0 {
+		t.Errorf("Expected x = 1 with the same passphrase, got %v", vars)
+	}
+	cfg.Storage.EncryptionPassphrase = "wrong horse"
+	if err := NewPersistenceManagerWithConfig(cfg).Check(); !errors.Is(err, ErrWrongKey) || !strings.Contains(err.Error(), "неверный пароль") {
+		t.Errorf("Expected wrong passphrase error, got %v", err)
+	}
+}
+
+func TestPlaintextIsEncryptedOnLoad(t *testing.T) {
+	cfg := encryptedConfig(t, "")
+	saveX(t, NewPersistenceManagerWithBackups(cfg.Storage.DataFile, 0), 7)
+
+	cfg.Storage.EncryptionKey, _ = GenerateKey()
+	if vars := NewPersistenceManagerWithConfig(cfg).LoadVariables(); vars["x"] != 7.0 {
+		t.Fatalf("Expected plaintext x = 7, got %v", vars)
+	}
+	content, _ := os.ReadFile(cfg.Storage.DataFile)
+	if !strings.Contains(string(content), `"encrypted"`) {
+		t.Errorf("Expected the file to be encrypted after loading:\n%s", content)
+	}
+}
+
+func TestEncryptedJournal(t *testing.T) {
+	key, _ := GenerateKey()
+	cfg := encryptedConfig(t, key)
+	cfg.Storage.FlushInterval = config.Duration(time.Hour)
+	pm := NewPersistenceManagerWithConfig(cfg)
+	pm.Update(func(data *CalculatorData) bool {
+		data.AppendHistory(HistoryEntry{Command: "curl https://example.com/?token=topsecret"})
+		return true
+	})
+	crash(pm)
+	assertNoPlaintext(t, filepath.Dir(cfg.Storage.DataFile), "topsecret")
+
+	restored := NewPersistenceManagerWithConfig(cfg)
+	defer restored.Close()
+	if history := restored.GetRecentHistory(0); len(history) != 1 || !strings.Contains(history[0].Command, "topsecret") {
+		t.Errorf("Expected history replayed from the encrypted journal, got %v", history)
+	}
+	events, err := restored.Audit()
+	if err != nil || len(events) != 1 || events[0].Type != EventHistoryAppend {
+		t.Errorf("Expected one decrypted event, got %v, %v", events, err)
+	}
+}
+
+func TestRekey(t *testing.T) {
+	cfg := encryptedConfig(t, "")
+	cfg.Storage.FlushInterval = config.Duration(time.Hour)
+	pm := NewPersistenceManagerWithConfig(cfg)
+	saveX(t, pm, 1)
+	saveX(t, pm.Session("s"), 2)
+	pm.Flush()
+	// Последние изменения только в журналах, у сессии j нет даже снимка
+	saveX(t, pm, 3)
+	saveX(t, pm.Session("j").Namespace("w"), 4)
+	crash(pm)
+
+	first, _ := GenerateKey()
+	second, _ := GenerateKey()
+	steps := []struct {
+		from, to string
+	}{
+		{"", first},
+		{first, second},
+		{second, ""},
+	}
+	for _, step := range steps {
+		from, to := keyOrNil(t, step.from), keyOrNil(t, step.to)
+		if n, err := Rekey(cfg, from, to); err != nil || n != 2 {
+			t.Fatalf("Rekey: %d states, %v", n, err)
+		}
+
+		cfg.Storage.EncryptionKey = step.to
+		pm := NewPersistenceManagerWithConfig(cfg)
+		if vars := pm.LoadVariables(); vars["x"] != 3.0 {
+			t.Errorf("Expected x = 3 after rekey, got %v", vars)
+		}
+		if vars := pm.Session("s").LoadVariables(); vars["x"] != 2.0 {
+			t.Errorf("Expected session x = 2 after rekey, got %v", vars)
+		}
+		if vars := pm.Session("j").Namespace("w").LoadVariables(); vars["x"] != 4.0 {
+			t.Errorf("Expected journaled session workspace x = 4 after rekey, got %v", vars)
+		}
+		crash(pm)
+	}
+}
+
+func TestRekeyWithWrongKeyChangesNothing(t *testing.T) {
+	key, _ := GenerateKey()
+	cfg := encryptedConfig(t, key)
+	pm := NewPersistenceManagerWithConfig(cfg)
+	saveX(t, pm, 1)
+	pm.Close()
+	original, _ := os.ReadFile(cfg.Storage.DataFile)
+
+	if _, err := Rekey(cfg, testKey(t), testKey(t)); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Expected ErrWrongKey, got %v", err)
+	}
+	if _, err := Rekey(cfg, nil, testKey(t)); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Expected ErrNoKey, got %v", err)
+	}
+	if content, _ := os.ReadFile(cfg.Storage.DataFile); string(content) != string(original) {
+		t.Error("Failed rekey must not change the data")
+	}
+}
+
+func keyOrNil(t *testing.T, text string) *Key {
+	if text == "" {
+		return nil
+	}
+	key, err := ParseKey(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestParseKey(t *testing.T) {
+	valid := []string{
+		strings.Repeat("ab", 32),
+		"  " + strings.Repeat("AB", 32) + "\n",
+		"q83vEjRWeJq83vEjRWeJq83vEjRWeJq83vEjRWeJq80=",
+	}
+	for _, text := range valid {
+		if _, err := ParseKey(text); err != nil {
+			t.Errorf("ParseKey(%q): %v", text, err)
+		}
+	}
+	for _, text := range []string{"", "abcd", strings.Repeat("ab", 16), "not a key"} {
+		if _, err := ParseKey(text); err == nil {
+			t.Errorf("ParseKey(%q) must fail", text)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "raw.key")
+	os.WriteFile(path, []byte(strings.Repeat("\x01", keySize)), 0600)
+	if _, err := ReadKeyFile(path); err != nil {
+		t.Errorf("Expected a raw 32 byte key file to be accepted, got %v", err)
+	}
+}
+
+func TestTamperedDataIsNotWrongKey(t *testing.T) {
+	key := testKey(t)
+	sealed, err := key.Seal([]byte(`{"variables":{}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed.Data[0] ^= 0xff
+	if _, err := key.Open(sealed); err == nil || errors.Is(err, ErrWrongKey) || !strings.Contains(err.Error(), "повреждены") {
+		t.Errorf("Expected a corruption error, got %v", err)
+	}
+}
+
+// plaintextState - открытые данные с секретом во всех видах файлов: снимки с резервными
+// копиями, журнал, копия до обновления схемы и поврежденная версия
+func plaintextState(t *testing.T, cfg *config.Config, secret string) {
+	t.Helper()
+	pm := NewPersistenceManagerWithConfig(cfg)
+	for n := 1; n <= 3; n++ {
+		pm.Update(func(data *CalculatorData) bool {
+			data.Variables["x"] = float64(n)
+			data.AppendHistory(HistoryEntry{Command: fmt.Sprintf("curl https://example.com/?token=%s&n=%d", secret, n)})
+			return true
+		})
+		pm.Flush()
+	}
+	pm.Session("s").Update(func(data *CalculatorData) bool {
+		data.AppendHistory(HistoryEntry{Command: secret})
+		return true
+	})
+	crash(pm)
+	pm.store.Close()
+	if cfg.Storage.Backend == BackendJSON {
+		for _, name := range []string{".corrupt", ".v2.bak"} {
+			os.WriteFile(cfg.Storage.DataFile+name, []byte(secret), 0644)
+		}
+	}
+}
+
+func TestNoPlaintextAfterEncryption(t *testing.T) {
+	for _, backend := range []string{BackendJSON, BackendJSONL, BackendBolt} {
+		t.Run(backend, func(t *testing.T) {
+			cfg := encryptedConfig(t, "")
+			cfg.Storage.Backend = backend
+			cfg.Storage.FlushInterval = config.Duration(time.Hour)
+			plaintextState(t, cfg, "topsecret")
+
+			cfg.Storage.EncryptionKey, _ = GenerateKey()
+			pm := NewPersistenceManagerWithConfig(cfg)
+			if history := pm.GetRecentHistory(0); len(history) != 3 {
+				t.Fatalf("Expected 3 history entries, got %v", history)
+			}
+			if history := pm.Session("s").GetRecentHistory(0); len(history) != 1 {
+				t.Fatalf("Expected the session history, got %v", history)
+			}
+			if err := pm.Close(); err != nil {
+				t.Fatal(err)
+			}
+			assertNoPlaintext(t, filepath.Dir(cfg.Storage.DataFile), "topsecret")
+		})
+	}
+}
+
+func TestNoOldKeyAfterRekey(t *testing.T) {
+	for _, backend := range []string{BackendJSON, BackendJSONL, BackendBolt} {
+		t.Run(backend, func(t *testing.T) {
+			cfg := encryptedConfig(t, "")
+			cfg.Storage.Backend = backend
+			cfg.Storage.FlushInterval = config.Duration(time.Hour)
+			plaintextState(t, cfg, "topsecret")
+
+			old, _ := GenerateKey()
+			if _, err := Rekey(cfg, nil, keyOrNil(t, old)); err != nil {
+				t.Fatal(err)
+			}
+			assertNoPlaintext(t, filepath.Dir(cfg.Storage.DataFile), "topsecret")
+
+			cfg.Storage.EncryptionKey = old
+			pm := NewPersistenceManagerWithConfig(cfg)
+			saveX(t, pm, 5)
+			pm.Close()
+
+			if _, err := Rekey(cfg, keyOrNil(t, old), testKey(t)); err != nil {
+				t.Fatal(err)
+			}
+			// Отпечаток прежнего ключа есть в каждом зашифрованном им конверте
+			assertNoPlaintext(t, filepath.Dir(cfg.Storage.DataFile), keyID(keyOrNil(t, old).raw))
+		})
+	}
+}
@@ -0,0 +1,463 @@
+package persistence
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// ХРАНИЛИЩЕ В JSON ФАЙЛАХ
+// ============================================================================
+
+// JSONStore - каждое состояние в своем JSON файле: основное - в calculator_data.json,
+// рабочие пространства - в calculator_data.workspaces/<имя>.json, сессии -
+// в calculator_data.sessions/<id>.json и т.д. Файлы пишутся атомарно, рядом хранятся
+// backups предыдущих версий для восстановления поврежденного файла.
+type JSONStore struct {
+	dataFile string
+	backups  int
+
+	mu sync.Mutex
+	// verified - файл прочитан или записан без ошибок, его можно класть в резервные копии
+	verified map[string]bool
+}
+
+// NewJSONStore - хранилище с основным файлом dataFile и backups резервными копиями
+// каждого файла (<файл>.bak.1 - самая свежая); 0 - без резервных копий
+func NewJSONStore(dataFile string, backups int) *JSONStore {
+	return &JSONStore{
+		dataFile: dataFile,
+		backups:  backups,
+		verified: make(map[string]bool),
+	}
+}
+
+// path - файл состояния key
+func (s *JSONStore) path(key string) string {
+	return statePath(s.dataFile, key, ".json")
+}
+
+// statePath - файл состояния key: вложенные состояния лежат в каталогах рядом с файлом
+// родителя, <файл без расширения>.<вид>/<имя><ext>
+func statePath(dataFile, key, ext string) string {
+	path := dataFile
+	parts := splitKey(key)
+	for idx := 0; idx+1 < len(parts); idx += 2 {
+		path = filepath.Join(nestedDir(path, parts[idx]), parts[idx+1]+ext)
+	}
+	return path
+}
+
+// nestedDir - каталог вложенных состояний вида kind рядом с файлом
+func nestedDir(path, kind string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "." + kind
+}
+
+func (s *JSONStore) Load(key string) (*CalculatorData, error) {
+	path := s.path(key)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.setVerified(key, false)
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	data, from, err := decodeDocument(content)
+	if errors.Is(err, ErrNewerSchema) {
+		// Файл целый, просто не для этой версии: восстанавливать из копий нельзя
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err != nil {
+		fmt.Printf("Ошибка декодирования JSON в %s: %v\n", path, err)
+		s.setVerified(key, false)
+		if data := s.recoverFromBackup(key, content); data != nil {
+			return data, nil
+		}
+		return nil, err
+	}
+	s.setVerified(key, true)
+	if from < SchemaVersion {
+		if err := s.upgradeFile(key, content, from, data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// upgradeFile - файл старой схемы: исходное содержимое сохраняется в <файл>.v<версия>.bak,
+// затем файл переписывается в текущей схеме. Без копии файл не трогается.
+func (s *JSONStore) upgradeFile(key string, content []byte, from int, data *CalculatorData) error {
+	path := s.path(key)
+	backup := schemaBackupFile(path, from)
+	if _, err := os.Stat(backup); os.IsNotExist(err) {
+		// Копию той же версии уже могла оставить прерванная миграция - ее не перезаписываем
+		if err := writeFileAtomic(backup, content); err != nil {
+			return fmt.Errorf("%s: не удалось сохранить копию перед обновлением схемы: %v", path, err)
+		}
+	}
+
+	data.SchemaVersion = SchemaVersion
+	if err := s.Save(key, data); err != nil {
+		return fmt.Errorf("%s: не удалось записать обновленную схему: %v", path, err)
+	}
+	fmt.Printf("📦 Файл %s обновлен со схемы %d до %d (прежняя версия - %s)\n", path, from, SchemaVersion, backup)
+	return nil
+}
+
+func schemaBackupFile(path string, version int) string {
+	return fmt.Sprintf("%s.v%d.bak", path, version)
+}
+
+func (s *JSONStore) Save(key string, data *CalculatorData) error {
+	var buf bytes.Buffer
+	if err := encodeData(&buf, data); err != nil {
+		return err
+	}
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return s.writeFile(key, buf.Bytes())
+}
+
+func (s *JSONStore) Exists(key string) bool {
+	_, err := os.Stat(s.path(key))
+	return err == nil
+}
+
+func (s *JSONStore) List(parent, kind string) (map[string]time.Time, error) {
+	return listFiles(nestedDir(s.path(parent), kind), ".json")
+}
+
+// listFiles - имена файлов каталога с расширением ext и время их изменения
+func listFiles(dir, ext string) (map[string]time.Time, error) {
+	files := make(map[string]time.Time)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return files, nil
+		}
+		return nil, err
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ext)
+		if !ok || entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			files[name] = info.ModTime()
+		}
+	}
+	return files, nil
+}
+
+func (s *JSONStore) Rename(from, to string) error {
+	src, dst := s.path(from), s.path(to)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := renameFiles(src, dst); err != nil {
+		return err
+	}
+	for _, kind := range []string{KindWorkspaces, KindSessions} {
+		if err := os.Rename(nestedDir(src, kind), nestedDir(dst, kind)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	s.setVerified(from, false)
+	return nil
+}
+
+func (s *JSONStore) Delete(key string) error {
+	path := s.path(key)
+	for _, kind := range []string{KindWorkspaces, KindSessions} {
+		if err := os.RemoveAll(nestedDir(path, kind)); err != nil {
+			return err
+		}
+	}
+	s.setVerified(key, false)
+	return removeFiles(path)
+}
+
+func (s *JSONStore) Close() error {
+	return nil
+}
+
+func (s *JSONStore) isVerified(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.verified[key]
+}
+
+func (s *JSONStore) setVerified(key string, verified bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if verified {
+		s.verified[key] = true
+	} else {
+		delete(s.verified, key)
+	}
+}
+
+// ReadDataFile - чтение JSON файла данных любой известной схемы без записи в него
+func ReadDataFile(path string) (*CalculatorData, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeData(content)
+}
+
+func encodeData(buf *bytes.Buffer, data *CalculatorData) error {
+	encoder := json.NewEncoder(buf)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(data)
+}
+
+func decodeData(content []byte) (*CalculatorData, error) {
+	data, _, err := decodeDocument(content)
+	return data, err
+}
+
+// writeFile - запись файла без риска оставить его пустым или недописанным:
+// содержимое пишется во временный файл в том же каталоге, сбрасывается на диск
+// и атомарно подменяет основной файл. Предыдущая целая версия уходит в <файл>.bak.1.
+func (s *JSONStore) writeFile(key string, content []byte) error {
+	path := s.path(key)
+	if !s.isVerified(key) {
+		// Файл записан другим процессом или еще не читался - проверяем перед копированием
+		if existing, err := os.ReadFile(path); err == nil {
+			_, err = decodeData(existing)
+			s.setVerified(key, err == nil)
+		}
+	}
+	if s.isVerified(key) {
+		// Без резервной копии сохранение все равно выполняется
+		if err := s.rotateBackups(path); err != nil {
+			fmt.Printf("Ошибка резервного копирования %s: %v\n", path, err)
+		}
+	}
+
+	if err := writeFileAtomic(path, content); err != nil {
+		return err
+	}
+	s.setVerified(key, true)
+	return nil
+}
+
+// writeFileAtomic - запись через временный файл рядом с path, fsync и переименование
+func writeFileAtomic(path string, content []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// После успешного переименования временного файла уже нет
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// rotateBackups - сдвиг копий .bak.1 -> .bak.2 -> ... и текущий файл в .bak.1.
+// Копия делается жесткой ссылкой: основной файл не меняется на месте, а подменяется новым.
+func (s *JSONStore) rotateBackups(path string) error {
+	if s.backups <= 0 {
+		return nil
+	}
+
+	if err := os.Remove(backupFile(path, s.backups)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for n := s.backups - 1; n >= 1; n-- {
+		if err := os.Rename(backupFile(path, n), backupFile(path, n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := os.Link(path, backupFile(path, 1)); err == nil || os.IsNotExist(err) {
+		return nil
+	}
+	// Файловая система без жестких ссылок
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(backupFile(path, 1), content, 0644)
+}
+
+// recoverFromBackup - файл поврежден: данные берутся из самой свежей целой
+// резервной копии и записываются на место файла. Поврежденное содержимое
+// сохраняется в <файл>.corrupt, чтобы его можно было разобрать вручную.
+func (s *JSONStore) recoverFromBackup(key string, corrupt []byte) *CalculatorData {
+	path := s.path(key)
+	corruptFile := path + ".corrupt"
+	if err := os.WriteFile(corruptFile, corrupt, 0644); err != nil {
+		fmt.Printf("Ошибка сохранения поврежденного файла: %v\n", err)
+	}
+
+	for n := 1; ; n++ {
+		content, err := os.ReadFile(backupFile(path, n))
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			continue
+		}
+		data, err := decodeData(content)
+		if err != nil {
+			continue
+		}
+
+		if err := s.writeFile(key, content); err != nil {
+			fmt.Printf("Ошибка восстановления %s: %v\n", path, err)
+		}
+		fmt.Printf("⚠️  Файл %s поврежден, данные восстановлены из %s (поврежденная версия - %s)\n",
+			path, backupFile(path, n), corruptFile)
+		return data
+	}
+
+	fmt.Printf("❌ Файл %s поврежден, целых резервных копий нет (содержимое сохранено в %s)\n",
+		path, corruptFile)
+	return nil
+}
+
+func backupFile(path string, n int) string {
+	return fmt.Sprintf("%s.bak.%d", path, n)
+}
+
+// purge - удаление резервных копий, копии до обновления схемы и поврежденной версии
+// состояний keys
+func (s *JSONStore) purge(keys []string) error {
+	for _, key := range keys {
+		path := s.path(key)
+		files := []string{path + ".corrupt"}
+		for n := 1; ; n++ {
+			if _, err := os.Lstat(backupFile(path, n)); err != nil {
+				break
+			}
+			files = append(files, backupFile(path, n))
+		}
+		for version := 0; version < SchemaVersion; version++ {
+			files = append(files, schemaBackupFile(path, version))
+		}
+
+		current, _ := os.Stat(path)
+		for _, name := range files {
+			if info, err := os.Stat(name); err == nil && current != nil && os.SameFile(info, current) {
+				// Копия - жесткая ссылка на сам файл данных: затирать нельзя
+				if err := os.Remove(name); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := wipeFile(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// wipeFile - файл перезаписывается нулями и удаляется, чтобы его содержимое не осталось
+// на диске (на файловых системах, которые пишут на место)
+func wipeFile(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().IsRegular() {
+		file, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(make([]byte, info.Size()))
+		if err == nil {
+			err = file.Sync()
+		}
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return os.Remove(path)
+}
+
+// removeFiles - удаление файла данных вместе с резервными копиями
+func removeFiles(path string) error {
+	for n := 1; ; n++ {
+		if err := os.Remove(backupFile(path, n)); err != nil {
+			if os.IsNotExist(err) {
+				break
+			}
+			return err
+		}
+	}
+	os.Remove(path + ".corrupt")
+	for version := 0; version < SchemaVersion; version++ {
+		os.Remove(schemaBackupFile(path, version))
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// renameFiles - переименование файла данных вместе с резервными копиями
+func renameFiles(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	for version := 0; version < SchemaVersion; version++ {
+		os.Rename(schemaBackupFile(from, version), schemaBackupFile(to, version))
+	}
+	for n := 1; ; n++ {
+		if err := os.Rename(backupFile(from, n), backupFile(to, n)); err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// syncDir - сброс на диск записи каталога, чтобы переименование пережило сбой питания
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	d.Sync()
+}
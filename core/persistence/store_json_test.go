@@ -0,0 +1,137 @@
+package persistence
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func saveX(t *testing.T, pm *PersistenceManager, x float64) {
+	t.Helper()
+	if !pm.SaveVariables(map[string]interface{}{"x": x}) {
+		t.Fatalf("SaveVariables(%v) failed", x)
+	}
+}
+
+func TestSaveKeepsRollingBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "calc.json")
+	pm := NewPersistenceManagerWithBackups(path, 2)
+
+	for x := 1.0; x <= 4; x++ {
+		saveX(t, pm, x)
+	}
+
+	expected := map[string]float64{path: 4, path + ".bak.1": 3, path + ".bak.2": 2}
+	for file, x := range expected {
+		data := NewPersistenceManagerWithBackups(file, 0).LoadData()
+		if data == nil || data.Variables["x"] != x {
+			t.Errorf("%s: expected x = %v, got %+v", filepath.Base(file), x, data)
+		}
+	}
+	if _, err := os.Stat(path + ".bak.3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 backups, .bak.3: %v", err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp-") {
+			t.Errorf("Temporary file left behind: %s", entry.Name())
+		}
+	}
+}
+
+func TestLoadRecoversFromNewestValidBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.json")
+	pm := NewPersistenceManagerWithBackups(path, 3)
+	for x := 1.0; x <= 3; x++ {
+		saveX(t, pm, x)
+	}
+
+	// Запись оборвалась на середине, самая свежая копия тоже испорчена
+	content, _ := os.ReadFile(path)
+	os.WriteFile(path, content[:len(content)/2], 0644)
+	os.WriteFile(path+".bak.1", nil, 0644)
+
+	restarted := NewPersistenceManagerWithBackups(path, 3)
+	data := restarted.LoadData()
+	if data == nil || data.Variables["x"] != 1.0 {
+		t.Fatalf("Expected recovery from .bak.2 (x = 1), got %+v", data)
+	}
+
+	if corrupt, err := os.ReadFile(path + ".corrupt"); err != nil || len(corrupt) != len(content)/2 {
+		t.Errorf("Expected corrupt content to be preserved: %v", err)
+	}
+	if data := NewPersistenceManagerWithBackups(path, 0).LoadData(); data == nil || data.Variables["x"] != 1.0 {
+		t.Errorf("Expected main file to be rewritten with recovered data, got %+v", data)
+	}
+
+	// Поврежденная версия не попадает в резервные копии
+	saveX(t, restarted, 5)
+	if data := NewPersistenceManagerWithBackups(path+".bak.1", 0).LoadData(); data == nil || data.Variables["x"] != 1.0 {
+		t.Errorf("Expected recovered version in .bak.1, got %+v", data)
+	}
+}
+
+func TestLoadCorruptWithoutBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.json")
+	os.WriteFile(path, []byte(`{"variables": {"x": 1`), 0644)
+
+	if data := NewPersistenceManagerWithBackups(path, 3).LoadData(); data != nil {
+		t.Errorf("Expected nil without valid backups, got %+v", data)
+	}
+	if _, err := os.Stat(path + ".corrupt"); err != nil {
+		t.Errorf("Expected corrupt content to be preserved: %v", err)
+	}
+}
+
+func TestCorruptFileIsNotOverwritten(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.json")
+	corrupt := []byte(`{"variables": {"x": 1`)
+	os.WriteFile(path, corrupt, 0644)
+
+	pm := NewPersistenceManagerWithBackups(path, 3)
+	if err := pm.Check(); err == nil {
+		t.Error("Expected an error for a corrupt file without backups")
+	}
+	if pm.SaveVariables(map[string]interface{}{"y": 2.0}) {
+		t.Error("Saving over a corrupt file must be refused")
+	}
+	if pm.ClearHistory() {
+		t.Error("Clearing history of a corrupt file must be refused")
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(path); !bytes.Equal(content, corrupt) {
+		t.Errorf("Expected the corrupt file untouched, got %s", content)
+	}
+}
+
+func TestNamespaceBackupsFollowRenameAndDelete(t *testing.T) {
+	dir := t.TempDir()
+	root := NewPersistenceManagerWithBackups(filepath.Join(dir, "calc.json"), 2)
+	if err := root.CreateNamespace("a"); err != nil {
+		t.Fatal(err)
+	}
+	saveX(t, root.Namespace("a"), 1)
+	saveX(t, root.Namespace("a"), 2)
+
+	if err := root.RenameNamespace("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	backup := filepath.Join(dir, "calc.workspaces", "b.json.bak.1")
+	if data := NewPersistenceManagerWithBackups(backup, 0).LoadData(); data == nil || data.Variables["x"] != 1.0 {
+		t.Errorf("Expected backup to follow rename, got %+v", data)
+	}
+
+	if err := root.DeleteNamespace("b"); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, "calc.workspaces"))
+	if len(entries) != 0 {
+		t.Errorf("Expected namespace files and backups to be removed, found %d", len(entries))
+	}
+}
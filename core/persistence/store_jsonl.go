@@ -0,0 +1,374 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// ХРАНИЛИЩЕ В ЖУРНАЛЕ JSONL
+// ============================================================================
+
+// JSONLStore - каждое состояние в своем журнале calculator_data.jsonl (вложенные -
+// в calculator_data.workspaces/<имя>.jsonl и т.д.). Журнал только дописывается:
+// новые записи истории, удаленные номера, изменившиеся переменные и настройки.
+// Когда записей в журнале становится заметно больше, чем в состоянии, он переписывается
+// одним снимком. Оборванная при сбое последняя строка отбрасывается при чтении.
+type JSONLStore struct {
+	dataFile string
+
+	mu   sync.Mutex
+	logs map[string]*logState
+}
+
+// logState - что уже записано в журнал состояния
+type logState struct {
+	ids   map[int]bool // номера записей истории
+	meta  []byte       // остальные поля состояния
+	lines int
+}
+
+// logRecord - строка журнала
+type logRecord struct {
+	Op    string          `json:"op"`
+	Time  string          `json:"time"`
+	Data  *CalculatorData `json:"data,omitempty"`
+	Entry *HistoryEntry   `json:"entry,omitempty"`
+	IDs   []int           `json:"ids,omitempty"`
+}
+
+// Операции журнала
+const (
+	opSnapshot = "snapshot" // состояние целиком
+	opState    = "state"    // все поля, кроме истории
+	opAppend   = "append"   // новая запись истории
+	opDrop     = "drop"     // удаленные записи истории
+)
+
+// logCompactMin - журнал короче этого не сжимается
+const logCompactMin = 100
+
+func NewJSONLStore(dataFile string) *JSONLStore {
+	return &JSONLStore{
+		dataFile: dataFile,
+		logs:     make(map[string]*logState),
+	}
+}
+
+func (s *JSONLStore) path(key string) string {
+	return statePath(s.dataFile, key, ".jsonl")
+}
+
+func (s *JSONLStore) Load(key string) (*CalculatorData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, state, err := s.read(key)
+	if err != nil || data == nil {
+		delete(s.logs, key)
+		return nil, err
+	}
+	s.logs[key] = state
+	return data, nil
+}
+
+// read - сборка состояния из журнала
+func (s *JSONLStore) read(key string) (*CalculatorData, *logState, error) {
+	path := s.path(key)
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	data := emptyData()
+	lines, good, maxID := 0, int64(0), 0
+	reader := bufio.NewReader(file)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return nil, nil, readErr
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			var record logRecord
+			if err := json.Unmarshal(line, &record); err != nil {
+				if readErr == io.EOF {
+					// Запись оборвалась при сбое - отрезаем хвост, иначе следующая строка к нему приклеится
+					fmt.Printf("⚠️  %s: отброшена недописанная последняя строка\n", path)
+					if err := os.Truncate(path, good); err != nil {
+						return nil, nil, err
+					}
+					break
+				}
+				return nil, nil, fmt.Errorf("%s, строка %d: %v", path, lines+1, err)
+			}
+			maxID = max(maxID, applyLogRecord(data, record))
+			lines++
+		}
+		good += int64(len(line))
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	data.NextHistoryID = max(data.NextHistoryID, maxID+1)
+	if err := upgradeData(data); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	meta, err := stateMeta(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, &logState{ids: historyIDs(data.History), meta: meta, lines: lines}, nil
+}
+
+// applyLogRecord - применение строки журнала; возвращает наибольший упомянутый номер истории
+func applyLogRecord(data *CalculatorData, record logRecord) int {
+	maxID := 0
+	switch record.Op {
+	case opSnapshot:
+		if record.Data != nil {
+			*data = *record.Data
+		}
+	case opState:
+		if record.Data != nil {
+			history := data.History
+			*data = *record.Data
+			data.History = history
+		}
+	case opAppend:
+		if record.Entry != nil {
+			data.History = append(data.History, *record.Entry)
+		}
+	case opDrop:
+		dropped := make(map[int]bool, len(record.IDs))
+		for _, id := range record.IDs {
+			dropped[id] = true
+		}
+		kept := data.History[:0]
+		for _, entry := range data.History {
+			if !dropped[entry.ID] {
+				kept = append(kept, entry)
+			}
+		}
+		data.History = kept
+	}
+
+	for _, id := range record.IDs {
+		maxID = max(maxID, id)
+	}
+	if record.Entry != nil {
+		maxID = max(maxID, record.Entry.ID)
+	}
+	return maxID
+}
+
+func (s *JSONLStore) Save(key string, data *CalculatorData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.logs[key]
+	if !ok {
+		// Журнал еще не читался: дописывать нужно относительно того, что в нем уже есть
+		var err error
+		if _, state, err = s.read(key); err != nil {
+			return err
+		}
+	}
+
+	meta, err := stateMeta(data)
+	if err != nil {
+		return err
+	}
+	if state == nil || state.lines > 2*len(data.History)+logCompactMin {
+		return s.compact(key, data, meta)
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	records := make([]logRecord, 0)
+	current := historyIDs(data.History)
+	dropped := make([]int, 0)
+	for id := range state.ids {
+		if !current[id] {
+			dropped = append(dropped, id)
+		}
+	}
+	if len(dropped) > 0 {
+		sort.Ints(dropped)
+		records = append(records, logRecord{Op: opDrop, Time: now, IDs: dropped})
+	}
+	for idx := range data.History {
+		if !state.ids[data.History[idx].ID] {
+			records = append(records, logRecord{Op: opAppend, Time: now, Entry: &data.History[idx]})
+		}
+	}
+	if !bytes.Equal(meta, state.meta) {
+		withoutHistory := *data
+		withoutHistory.History = nil
+		records = append(records, logRecord{Op: opState, Time: now, Data: &withoutHistory})
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	path := s.path(key)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	s.logs[key] = &logState{ids: current, meta: meta, lines: state.lines + len(records)}
+	return nil
+}
+
+// compact - журнал переписывается одним снимком
+func (s *JSONLStore) compact(key string, data *CalculatorData, meta []byte) error {
+	line, err := json.Marshal(logRecord{Op: opSnapshot, Time: time.Now().Format(time.RFC3339), Data: data})
+	if err != nil {
+		return err
+	}
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, append(line, '\n')); err != nil {
+		return err
+	}
+	s.logs[key] = &logState{ids: historyIDs(data.History), meta: meta, lines: 1}
+	return nil
+}
+
+// purge - журналы состояний keys переписываются одним снимком: дописанные строки
+// с прежними версиями данных из них уходят
+func (s *JSONLStore) purge(keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		data, _, err := s.read(key)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			continue
+		}
+		meta, err := stateMeta(data)
+		if err != nil {
+			return err
+		}
+		if err := s.compact(key, data, meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *JSONLStore) Exists(key string) bool {
+	_, err := os.Stat(s.path(key))
+	return err == nil
+}
+
+func (s *JSONLStore) List(parent, kind string) (map[string]time.Time, error) {
+	return listFiles(nestedDir(s.path(parent), kind), ".jsonl")
+}
+
+func (s *JSONLStore) Rename(from, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	src, dst := s.path(from), s.path(to)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	for _, kind := range []string{KindWorkspaces, KindSessions} {
+		if err := os.Rename(nestedDir(src, kind), nestedDir(dst, kind)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	s.forget(from)
+	s.forget(to)
+	return nil
+}
+
+func (s *JSONLStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.path(key)
+	for _, kind := range []string{KindWorkspaces, KindSessions} {
+		if err := os.RemoveAll(nestedDir(path, kind)); err != nil {
+			return err
+		}
+	}
+	s.forget(key)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// forget - забыть прочитанное состояние key и вложенных
+func (s *JSONLStore) forget(key string) {
+	for existing := range s.logs {
+		if existing == key || strings.HasPrefix(existing, key+"/") {
+			delete(s.logs, existing)
+		}
+	}
+}
+
+func (s *JSONLStore) Close() error {
+	return nil
+}
+
+// stateMeta - поля состояния кроме истории и счетчика ее номеров: по ним видно,
+// изменились ли переменные, журнал отмены или настройки
+func stateMeta(data *CalculatorData) ([]byte, error) {
+	meta := *data
+	meta.History = nil
+	meta.NextHistoryID = 0
+	return json.Marshal(&meta)
+}
+
+func historyIDs(history []HistoryEntry) map[int]bool {
+	ids := make(map[int]bool, len(history))
+	for _, entry := range history {
+		ids[entry.ID] = true
+	}
+	return ids
+}
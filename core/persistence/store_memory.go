@@ -0,0 +1,93 @@
+package persistence
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// ХРАНИЛИЩЕ В ПАМЯТИ
+// ============================================================================
+
+// MemoryStore - состояния живут до завершения процесса (--no-persist, тесты, черновики)
+type MemoryStore struct {
+	mu     sync.Mutex
+	states map[string]memoryState
+}
+
+type memoryState struct {
+	data    *CalculatorData
+	modTime time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{states: make(map[string]memoryState)}
+}
+
+func (s *MemoryStore) Load(key string) (*CalculatorData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.states[key]
+	if !ok {
+		return nil, nil
+	}
+	return cloneData(state.data), nil
+}
+
+func (s *MemoryStore) Save(key string, data *CalculatorData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states[key] = memoryState{data: cloneData(data), modTime: time.Now()}
+	return nil
+}
+
+func (s *MemoryStore) Exists(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.states[key]
+	return ok
+}
+
+func (s *MemoryStore) List(parent, kind string) (map[string]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	children := make(map[string]time.Time)
+	for key, state := range s.states {
+		if name, ok := childName(key, parent, kind); ok {
+			children[name] = state.modTime
+		}
+	}
+	return children, nil
+}
+
+func (s *MemoryStore) Rename(from, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	moved := make(map[string]memoryState)
+	for key, state := range s.states {
+		if key == from || strings.HasPrefix(key, from+"/") {
+			delete(s.states, key)
+			moved[to+strings.TrimPrefix(key, from)] = state
+		}
+	}
+	for key, state := range moved {
+		s.states[key] = state
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for existing := range s.states {
+		if existing == key || strings.HasPrefix(existing, key+"/") {
+			delete(s.states, existing)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,287 @@
+package persistence
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openStores - все хранилища над одним каталогом; каждый вызов открывает хранилище заново
+func openStores(t *testing.T) map[string]func() Store {
+	dir := t.TempDir()
+	memory := NewMemoryStore()
+	stores := map[string]func() Store{
+		"memory": func() Store { return memory },
+	}
+	for _, backend := range []string{BackendJSON, BackendJSONL, BackendBolt} {
+		backend := backend
+		stores[backend] = func() Store {
+			store, err := NewStore(backend, filepath.Join(dir, backend, "calc.json"), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		}
+	}
+	return stores
+}
+
+func testData(x float64, commands ...string) *CalculatorData {
+	data := emptyData()
+	data.Variables["x"] = x
+	for _, command := range commands {
+		data.AppendHistory(HistoryEntry{Command: command, Result: "ok"})
+	}
+	return data
+}
+
+func historyCommands(data *CalculatorData) string {
+	commands := make([]string, 0, len(data.History))
+	for _, entry := range data.History {
+		commands = append(commands, entry.Command)
+	}
+	return strings.Join(commands, ",")
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	for name, open := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store := open()
+			if data, err := store.Load(""); err != nil || data != nil {
+				t.Fatalf("Expected no state in an empty store, got %v, %v", data, err)
+			}
+			if store.Exists("") {
+				t.Error("Empty store must not report the root state")
+			}
+
+			data := testData(1, "a", "b", "c")
+			if err := store.Save("", data); err != nil {
+				t.Fatal(err)
+			}
+			// Удаление старой записи и новая запись в одном сохранении
+			data.History = data.History[1:]
+			data.AppendHistory(HistoryEntry{Command: "d"})
+			data.Variables["x"] = 2.0
+			if err := store.Save("", data); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			store = open()
+			defer store.Close()
+			loaded, err := store.Load("")
+			if err != nil || loaded == nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+			if loaded.Variables["x"] != 2.0 {
+				t.Errorf("Expected x = 2, got %v", loaded.Variables)
+			}
+			if got := historyCommands(loaded); got != "b,c,d" {
+				t.Errorf("Expected history b,c,d, got %s", got)
+			}
+			if loaded.NextHistoryID != 5 {
+				t.Errorf("Expected next history ID 5, got %d", loaded.NextHistoryID)
+			}
+		})
+	}
+}
+
+func TestStoreNestedStates(t *testing.T) {
+	for name, open := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store := open()
+			defer store.Close()
+
+			keys := []string{"", "workspaces/a", "sessions/s1", "sessions/s1/workspaces/b"}
+			for idx, key := range keys {
+				if err := store.Save(key, testData(float64(idx))); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			spaces, err := store.List("", KindWorkspaces)
+			if err != nil || len(spaces) != 1 || spaces["a"].IsZero() {
+				t.Errorf("Expected workspace a, got %v, %v", spaces, err)
+			}
+			sessionSpaces, _ := store.List("sessions/s1", KindWorkspaces)
+			if _, ok := sessionSpaces["b"]; !ok || len(sessionSpaces) != 1 {
+				t.Errorf("Expected session workspace b, got %v", sessionSpaces)
+			}
+
+			if err := store.Rename("sessions/s1", "sessions/s2"); err != nil {
+				t.Fatal(err)
+			}
+			if store.Exists("sessions/s1") || !store.Exists("sessions/s2/workspaces/b") {
+				t.Error("Rename must move the state together with nested states")
+			}
+			if data, _ := store.Load("sessions/s2/workspaces/b"); data == nil || data.Variables["x"] != 3.0 {
+				t.Errorf("Expected moved nested state x = 3, got %v", data)
+			}
+
+			if err := store.Delete("sessions/s2"); err != nil {
+				t.Fatal(err)
+			}
+			if store.Exists("sessions/s2") || store.Exists("sessions/s2/workspaces/b") {
+				t.Error("Delete must remove nested states")
+			}
+			if !store.Exists("workspaces/a") || !store.Exists("") {
+				t.Error("Delete must not touch other states")
+			}
+		})
+	}
+}
+
+func TestPersistenceManagerOnEveryBackend(t *testing.T) {
+	for name, open := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+			pm := NewPersistenceManagerWithStore(open())
+			saveX(t, pm, 1)
+			if err := pm.CreateNamespace("work"); err != nil {
+				t.Fatal(err)
+			}
+			saveX(t, pm.Namespace("work"), 2)
+			saveX(t, pm.Session("abc"), 3)
+			if err := pm.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			pm = NewPersistenceManagerWithStore(open())
+			defer pm.Close()
+			if names, _ := pm.Namespaces(); strings.Join(names, ",") != "default,work" {
+				t.Errorf("Expected default,work, got %v", names)
+			}
+			if vars := pm.Namespace("work").LoadVariables(); vars["x"] != 2.0 {
+				t.Errorf("Expected workspace x = 2, got %v", vars)
+			}
+			if !pm.SessionExists("abc") || pm.Session("abc").LoadVariables()["x"] != 3.0 {
+				t.Error("Expected session state to survive reopening")
+			}
+			if err := pm.RenameNamespace("work", "play"); err != nil {
+				t.Fatal(err)
+			}
+			if vars := pm.Namespace("play").LoadVariables(); vars["x"] != 2.0 {
+				t.Errorf("Expected renamed workspace x = 2, got %v", vars)
+			}
+		})
+	}
+}
+
+func TestJSONLStoreDropsTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.jsonl")
+	store := NewJSONLStore(path)
+	if err := store.Save("", testData(1, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save("", testData(1, "a", "b")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Сбой посреди записи: последняя строка не дописана
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"op":"append","entry":{"id":3,"comm`)
+	file.Close()
+
+	data, err := NewJSONLStore(path).Load("")
+	if err != nil || data == nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := historyCommands(data); got != "a,b" {
+		t.Errorf("Expected history a,b, got %s", got)
+	}
+
+	// Следующая запись не должна приклеиться к оборванной строке
+	store = NewJSONLStore(path)
+	store.Load("")
+	if err := store.Save("", testData(1, "a", "b", "c")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := NewJSONLStore(path).Load(""); err != nil || historyCommands(data) != "a,b,c" {
+		t.Errorf("Expected history a,b,c after the torn line, got %v, %v", data, err)
+	}
+}
+
+func TestJSONLStoreCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.jsonl")
+	store := NewJSONLStore(path)
+	data := testData(0)
+	for x := 1; x <= 3*logCompactMin; x++ {
+		data.Variables["x"] = float64(x)
+		if err := store.Save("", data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(content), "\n"); lines > logCompactMin+1 {
+		t.Errorf("Expected the log to be compacted, got %d lines", lines)
+	}
+	if loaded, _ := NewJSONLStore(path).Load(""); loaded.Variables["x"] != float64(3*logCompactMin) {
+		t.Errorf("Expected the last value after compaction, got %v", loaded.Variables)
+	}
+}
+
+func TestBoltStoreIsExclusive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.db")
+	first := NewBoltStore(path)
+	defer first.Close()
+	if err := first.Save("", testData(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	second := NewBoltStore(path)
+	if _, err := second.Load(""); err == nil || !strings.Contains(err.Error(), "другим процессом") {
+		t.Errorf("Expected a lock error, got %v", err)
+	}
+}
+
+func TestCopyStoreJSONToBolt(t *testing.T) {
+	dir := t.TempDir()
+	source := NewPersistenceManagerWithBackups(filepath.Join(dir, "calc.json"), 0)
+	saveX(t, source, 1)
+	source.Update(func(data *CalculatorData) bool {
+		data.AppendHistory(HistoryEntry{Command: "x = 1"})
+		return true
+	})
+	if err := source.CreateNamespace("work"); err != nil {
+		t.Fatal(err)
+	}
+	saveX(t, source.Session("abc"), 3)
+	saveX(t, source.Session("abc").Namespace("nested"), 2)
+	source.Session("abc").CreateNamespace("other")
+	if err := source.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	target := NewBoltStore(filepath.Join(dir, "calc.db"))
+	copied, err := CopyStore(NewJSONStore(filepath.Join(dir, "calc.json"), 0), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != 5 {
+		t.Errorf("Expected 5 copied states, got %d", copied)
+	}
+
+	pm := NewPersistenceManagerWithStore(target)
+	defer pm.Close()
+	if vars := pm.LoadVariables(); vars["x"] != 1.0 {
+		t.Errorf("Expected x = 1, got %v", vars)
+	}
+	if history := pm.GetRecentHistory(0); len(history) != 1 || history[0].Command != "x = 1" {
+		t.Errorf("Expected copied history, got %v", history)
+	}
+	if !pm.NamespaceExists("work") {
+		t.Error("Expected workspace work to be copied")
+	}
+	if vars := pm.Session("abc").Namespace("nested").LoadVariables(); vars["x"] != 2.0 {
+		t.Errorf("Expected nested session workspace x = 2, got %v", vars)
+	}
+}
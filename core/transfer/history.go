@@ -0,0 +1,253 @@
+package transfer
+
+import (
+	"app/core/persistence"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// ИСТОРИЯ
+// ============================================================================
+
+// historyColumns - столбцы CSV истории
+var historyColumns = []string{"id", "timestamp", "kind", "command", "result", "error", "duration_ms", "session_id"}
+
+// markdownHeader - заголовок таблицы Markdown; при загрузке столбцы берутся в этом порядке
+var markdownHeader = []string{"ID", "Время", "Вид", "Команда", "Результат"}
+
+// WriteHistory - выгрузка записей истории в формате format
+func WriteHistory(w io.Writer, entries []persistence.HistoryEntry, format string) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		if entries == nil {
+			entries = []persistence.HistoryEntry{}
+		}
+		return encoder.Encode(entries)
+
+	case FormatJSONL:
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		for _, entry := range entries {
+			if err := encoder.Encode(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		writer.Write(historyColumns)
+		for _, entry := range entries {
+			result := ""
+			if entry.Result != nil {
+				result = scalarString(entry.Result)
+			}
+			writer.Write([]string{
+				strconv.Itoa(entry.ID), entry.Timestamp, entry.Kind, entry.Command, result, entry.Error,
+				strconv.FormatFloat(entry.DurationMs, 'f', -1, 64), entry.SessionID,
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+
+	case FormatMarkdown:
+		buf := bufio.NewWriter(w)
+		fmt.Fprintf(buf, "| %s |\n", strings.Join(markdownHeader, " | "))
+		fmt.Fprintln(buf, "|---:|---|---|---|---|")
+		for _, entry := range entries {
+			outcome := ""
+			switch {
+			case entry.Error != "":
+				outcome = "❌ " + entry.Error
+			case entry.Result != nil:
+				outcome = scalarString(entry.Result)
+			}
+			fmt.Fprintf(buf, "| %d | %s | %s | %s | %s |\n", entry.ID,
+				markdownCell(entry.Timestamp), markdownCell(entry.Kind), markdownCell(entry.Command), markdownCell(outcome))
+		}
+		return buf.Flush()
+	}
+	return fmt.Errorf("неизвестный формат истории %q", format)
+}
+
+// ReadHistory - записи истории из r в формате format. Номера записей из файла
+// не сохраняются: при загрузке записи получают новые номера (см. HistoryManager.Import).
+func ReadHistory(r io.Reader, format string) ([]persistence.HistoryEntry, error) {
+	var entries []persistence.HistoryEntry
+	var err error
+	switch format {
+	case FormatJSON:
+		if err := json.NewDecoder(r).Decode(&entries); err != nil {
+			return nil, fmt.Errorf("ошибка разбора JSON: %v", err)
+		}
+	case FormatJSONL:
+		entries, err = readHistoryJSONL(r)
+	case FormatCSV:
+		entries, err = readHistoryCSV(r)
+	case FormatMarkdown:
+		entries, err = readHistoryMarkdown(r)
+	default:
+		return nil, fmt.Errorf("неизвестный формат истории %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for idx, entry := range entries {
+		if strings.TrimSpace(entry.Command) == "" {
+			return nil, fmt.Errorf("запись %d: пустая команда", idx+1)
+		}
+		if entry.Timestamp != "" {
+			if _, err := time.Parse(time.RFC3339, entry.Timestamp); err != nil {
+				return nil, fmt.Errorf("запись %d: время %q не в формате RFC 3339", idx+1, entry.Timestamp)
+			}
+		}
+	}
+	return entries, nil
+}
+
+func readHistoryJSONL(r io.Reader) ([]persistence.HistoryEntry, error) {
+	entries := make([]persistence.HistoryEntry, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var entry persistence.HistoryEntry
+		if err := json.Unmarshal([]byte(text), &entry); err != nil {
+			return nil, fmt.Errorf("строка %d: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// readHistoryCSV - столбцы по заголовку; обязателен только command
+func readHistoryCSV(r io.Reader) ([]persistence.HistoryEntry, error) {
+	reader := csv.NewReader(r)
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка разбора CSV: %v", err)
+	}
+	if len(records) == 0 {
+		return []persistence.HistoryEntry{}, nil
+	}
+
+	columns := make(map[string]int)
+	for idx, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = idx
+	}
+	if _, ok := columns["command"]; !ok {
+		return nil, fmt.Errorf("в заголовке CSV нет столбца command")
+	}
+	field := func(record []string, name string) string {
+		if idx, ok := columns[name]; ok && idx < len(record) {
+			return record[idx]
+		}
+		return ""
+	}
+
+	entries := make([]persistence.HistoryEntry, 0, len(records)-1)
+	for _, record := range records[1:] {
+		entry := persistence.HistoryEntry{
+			Command:   field(record, "command"),
+			Timestamp: field(record, "timestamp"),
+			Kind:      field(record, "kind"),
+			Error:     field(record, "error"),
+			SessionID: field(record, "session_id"),
+		}
+		if result := field(record, "result"); result != "" && entry.Error == "" {
+			entry.Result = parseScalar(result)
+		}
+		if duration, err := strconv.ParseFloat(field(record, "duration_ms"), 64); err == nil {
+			entry.DurationMs = duration
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// readHistoryMarkdown - таблица в формате WriteHistory: ID, время, вид, команда, результат
+func readHistoryMarkdown(r io.Reader) ([]persistence.HistoryEntry, error) {
+	entries := make([]persistence.HistoryEntry, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line, header := 0, false
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(text, "|") {
+			continue
+		}
+		cells := splitMarkdownRow(text)
+		if !header {
+			if len(cells) != len(markdownHeader) {
+				return nil, fmt.Errorf("строка %d: ожидается таблица со столбцами %s", line, strings.Join(markdownHeader, ", "))
+			}
+			header = true
+			continue
+		}
+		if strings.Trim(strings.Join(cells, ""), ":- ") == "" {
+			continue
+		}
+		if len(cells) != len(markdownHeader) {
+			return nil, fmt.Errorf("строка %d: ожидается %d столбцов, получено %d", line, len(markdownHeader), len(cells))
+		}
+
+		entry := persistence.HistoryEntry{Timestamp: cells[1], Kind: cells[2], Command: cells[3]}
+		switch outcome := cells[4]; {
+		case strings.HasPrefix(outcome, "❌"):
+			entry.Error = strings.TrimSpace(strings.TrimPrefix(outcome, "❌"))
+		case outcome != "":
+			entry.Result = parseScalar(outcome)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// markdownCell - текст ячейки таблицы: | экранируется, переводы строк заменяются на <br>
+func markdownCell(text string) string {
+	text = strings.ReplaceAll(text, `\`, `\\`)
+	text = strings.ReplaceAll(text, "|", `\|`)
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.ReplaceAll(text, "\n", "<br>")
+}
+
+// splitMarkdownRow - ячейки строки таблицы с обратной заменой markdownCell
+func splitMarkdownRow(row string) []string {
+	row = strings.TrimSuffix(strings.TrimPrefix(row, "|"), "|")
+	cells := make([]string, 0)
+	var cell strings.Builder
+	for idx := 0; idx < len(row); idx++ {
+		switch {
+		case row[idx] == '\\' && idx+1 < len(row) && (row[idx+1] == '|' || row[idx+1] == '\\'):
+			idx++
+			cell.WriteByte(row[idx])
+		case row[idx] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(row[idx])
+		}
+	}
+	cells = append(cells, strings.TrimSpace(cell.String()))
+	for idx, text := range cells {
+		cells[idx] = strings.ReplaceAll(text, "<br>", "\n")
+	}
+	return cells
+}
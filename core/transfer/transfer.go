@@ -0,0 +1,167 @@
+package transfer
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// ФОРМАТЫ И СТРАТЕГИИ
+// ============================================================================
+
+// Форматы выгрузки и загрузки
+const (
+	FormatJSON     = "json"
+	FormatCSV      = "csv"
+	FormatEnv      = "env"
+	FormatYAML     = "yaml"
+	FormatJSONL    = "jsonl"
+	FormatMarkdown = "markdown"
+)
+
+// VariableFormats - форматы переменных, HistoryFormats - форматы истории
+var (
+	VariableFormats = []string{FormatJSON, FormatCSV, FormatEnv, FormatYAML}
+	HistoryFormats  = []string{FormatJSON, FormatCSV, FormatJSONL, FormatMarkdown}
+)
+
+// Стратегии загрузки при совпадении с уже имеющимися данными
+const (
+	// StrategyMerge - загруженное дополняет текущее и заменяет совпадающее
+	StrategyMerge = "merge"
+	// StrategyOverwrite - текущие данные заменяются загруженными целиком
+	StrategyOverwrite = "overwrite"
+	// StrategySkip - загруженное только дополняет, совпадающее остается прежним
+	StrategySkip = "skip"
+)
+
+// Strategies - все стратегии загрузки, merge по умолчанию
+var Strategies = []string{StrategyMerge, StrategyOverwrite, StrategySkip}
+
+var extensionFormats = map[string]string{
+	".json":     FormatJSON,
+	".csv":      FormatCSV,
+	".env":      FormatEnv,
+	".yaml":     FormatYAML,
+	".yml":      FormatYAML,
+	".jsonl":    FormatJSONL,
+	".ndjson":   FormatJSONL,
+	".md":       FormatMarkdown,
+	".markdown": FormatMarkdown,
+}
+
+var variableNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// VariableFormat - формат переменных: заданный явно, по расширению файла path или JSON
+func VariableFormat(format, path string) (string, error) {
+	return resolveFormat(format, path, VariableFormats, "переменных")
+}
+
+// HistoryFormat - формат истории: заданный явно, по расширению файла path или JSON
+func HistoryFormat(format, path string) (string, error) {
+	return resolveFormat(format, path, HistoryFormats, "истории")
+}
+
+func resolveFormat(format, path string, allowed []string, what string) (string, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	switch format {
+	case "md":
+		format = FormatMarkdown
+	case "yml":
+		format = FormatYAML
+	case "ndjson":
+		format = FormatJSONL
+	case "":
+		if path == "" {
+			return FormatJSON, nil
+		}
+		detected, ok := extensionFormats[strings.ToLower(filepath.Ext(path))]
+		if !ok || !contains(allowed, detected) {
+			// .env в начале имени (.env, .env.local) - тоже файл переменных окружения
+			if contains(allowed, FormatEnv) && strings.HasPrefix(filepath.Base(path), ".env") {
+				return FormatEnv, nil
+			}
+			return FormatJSON, nil
+		}
+		return detected, nil
+	}
+	if !contains(allowed, format) {
+		return "", fmt.Errorf("неизвестный формат %s %q, доступны: %s", what, format, strings.Join(allowed, ", "))
+	}
+	return format, nil
+}
+
+// ParseStrategy - стратегия загрузки; пустая строка - merge
+func ParseStrategy(strategy string) (string, error) {
+	strategy = strings.ToLower(strings.TrimSpace(strategy))
+	if strategy == "" {
+		return StrategyMerge, nil
+	}
+	if !contains(Strategies, strategy) {
+		return "", fmt.Errorf("неизвестная стратегия %q, доступны: %s", strategy, strings.Join(Strategies, ", "))
+	}
+	return strategy, nil
+}
+
+// Stats - итог загрузки
+type Stats struct {
+	Added   int `json:"added"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+	Removed int `json:"removed"`
+}
+
+// Changed - загрузка что-то изменила
+func (s Stats) Changed() bool {
+	return s.Added+s.Updated+s.Removed > 0
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("добавлено %d, обновлено %d, пропущено %d, удалено %d", s.Added, s.Updated, s.Skipped, s.Removed)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// formatNumber - число без лишних нулей, читается обратно без потерь
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// parseScalar - число, если строка читается как конечное число, иначе строка
+func parseScalar(text string) interface{} {
+	if value, err := strconv.ParseFloat(strings.TrimSpace(text), 64); err == nil && !math.IsInf(value, 0) && !math.IsNaN(value) {
+		return value
+	}
+	return text
+}
+
+// formatFiles - расширение файла и тип содержимого для выгрузки через HTTP
+var formatFiles = map[string]struct{ extension, contentType string }{
+	FormatJSON:     {"json", "application/json"},
+	FormatCSV:      {"csv", "text/csv; charset=utf-8"},
+	FormatEnv:      {"env", "text/plain; charset=utf-8"},
+	FormatYAML:     {"yaml", "application/yaml"},
+	FormatJSONL:    {"jsonl", "application/x-ndjson"},
+	FormatMarkdown: {"md", "text/markdown; charset=utf-8"},
+}
+
+// FileName - имя файла выгрузки: base и расширение формата
+func FileName(base, format string) string {
+	return base + "." + formatFiles[format].extension
+}
+
+// ContentType - тип содержимого выгрузки в формате format
+func ContentType(format string) string {
+	return formatFiles[format].contentType
+}
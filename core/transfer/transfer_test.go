@@ -0,0 +1,227 @@
+package transfer
+
+import (
+	"app/core/persistence"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVariablesRoundTrip(t *testing.T) {
+	vars := map[string]interface{}{
+		"x":     2.0,
+		"rate":  0.075,
+		"big":   1e21,
+		"label": "total, \"net\"",
+		"code":  "42",
+	}
+
+	for _, format := range VariableFormats {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteVariables(&buf, vars, format); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadVariables(&buf, format)
+			if err != nil {
+				t.Fatalf("ReadVariables: %v\n%s", err, buf.String())
+			}
+			expected := vars
+			if format == FormatCSV {
+				// В CSV нет типов: строка из цифр читается как число
+				expected = copyVars(vars)
+				expected["code"] = 42.0
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("Expected %v, got %v", expected, got)
+			}
+		})
+	}
+}
+
+func copyVars(vars map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(vars))
+	for name, value := range vars {
+		copied[name] = value
+	}
+	return copied
+}
+
+func TestReadVariablesEnv(t *testing.T) {
+	input := `# переменные
+export X=1.5
+name = 'Alice'
+quoted="a # b"
+plain=hello # комментарий
+
+`
+	vars, err := ReadVariables(strings.NewReader(input), FormatEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"X": 1.5, "name": "Alice", "quoted": "a # b", "plain": "hello"}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Errorf("Expected %v, got %v", expected, vars)
+	}
+}
+
+func TestReadVariablesErrors(t *testing.T) {
+	tests := []struct {
+		format, input, message string
+	}{
+		{FormatEnv, "1x=2", "недопустимое имя"},
+		{FormatEnv, "x", "строка 1"},
+		{FormatEnv, `x="open`, "кавычки"},
+		{FormatCSV, "name,value\nx,1,2", "строка 2"},
+		{FormatJSON, `{"x": [1]}`, "числом или строкой"},
+		{FormatJSON, `{"a-b": 1}`, "недопустимое имя"},
+		{FormatYAML, "x:\n  y: 1", "числом или строкой"},
+		{FormatJSON, `[1]`, "JSON"},
+	}
+	for _, tt := range tests {
+		_, err := ReadVariables(strings.NewReader(tt.input), tt.format)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s %q: expected error with %q, got %v", tt.format, tt.input, tt.message, err)
+		}
+	}
+}
+
+func TestReadVariablesYAMLIntegers(t *testing.T) {
+	vars, err := ReadVariables(strings.NewReader("x: 3\ny: 2.5\nname: abc\n"), FormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars["x"] != 3.0 || vars["y"] != 2.5 || vars["name"] != "abc" {
+		t.Errorf("Unexpected variables %v", vars)
+	}
+}
+
+func TestMergeVariables(t *testing.T) {
+	current := map[string]interface{}{"a": 1.0, "b": 2.0, "c": 3.0}
+	imported := map[string]interface{}{"b": 20.0, "c": 3.0, "d": 4.0}
+
+	tests := []struct {
+		strategy string
+		expected map[string]interface{}
+		stats    Stats
+	}{
+		{StrategyMerge, map[string]interface{}{"a": 1.0, "b": 20.0, "c": 3.0, "d": 4.0}, Stats{Added: 1, Updated: 1, Skipped: 1}},
+		{StrategySkip, map[string]interface{}{"a": 1.0, "b": 2.0, "c": 3.0, "d": 4.0}, Stats{Added: 1, Skipped: 2}},
+		{StrategyOverwrite, map[string]interface{}{"b": 20.0, "c": 3.0, "d": 4.0}, Stats{Added: 1, Updated: 1, Skipped: 1, Removed: 1}},
+	}
+	for _, tt := range tests {
+		merged, stats := MergeVariables(current, imported, tt.strategy, nil)
+		if !reflect.DeepEqual(merged, tt.expected) || stats != tt.stats {
+			t.Errorf("%s: expected %v %+v, got %v %+v", tt.strategy, tt.expected, tt.stats, merged, stats)
+		}
+	}
+	if len(current) != 3 || current["b"] != 2.0 {
+		t.Errorf("Current variables must not change, got %v", current)
+	}
+}
+
+func TestMergeVariablesReadOnly(t *testing.T) {
+	current := map[string]interface{}{"rate": 0.2, "x": 1.0}
+	imported := map[string]interface{}{"rate": 0.5, "pi": 3.0, "y": 2.0}
+	readOnly := func(name string) bool { return name == "rate" || name == "pi" }
+
+	merged, stats := MergeVariables(current, imported, StrategyOverwrite, readOnly)
+	expected := map[string]interface{}{"rate": 0.2, "y": 2.0}
+	if !reflect.DeepEqual(merged, expected) || stats != (Stats{Added: 1, Skipped: 2, Removed: 1}) {
+		t.Errorf("Expected %v, got %v %+v", expected, merged, stats)
+	}
+}
+
+func TestHistoryRoundTrip(t *testing.T) {
+	entries := []persistence.HistoryEntry{
+		{ID: 1, Command: "2+2", Timestamp: "2026-01-02T10:00:00Z", Kind: persistence.KindMath, Result: 4.0, DurationMs: 0.5, SessionID: "s1"},
+		{ID: 2, Command: "x = 1 | 2", Timestamp: "2026-01-02T10:01:00Z", Kind: persistence.KindAssign, Result: "x = 3"},
+		{ID: 3, Command: "1/0", Timestamp: "2026-01-02T10:02:00Z", Kind: persistence.KindMath, Error: "деление на ноль"},
+		{ID: 4, Command: "curl https://example.com", Timestamp: "2026-01-02T10:03:00Z", Kind: persistence.KindCurl, Result: "line 1\nline \\2"},
+	}
+
+	for _, format := range HistoryFormats {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteHistory(&buf, entries, format); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadHistory(&buf, format)
+			if err != nil {
+				t.Fatalf("ReadHistory: %v\n%s", err, buf.String())
+			}
+			if len(got) != len(entries) {
+				t.Fatalf("Expected %d entries, got %d:\n%s", len(entries), len(got), buf.String())
+			}
+			for idx, entry := range got {
+				want := entries[idx]
+				if format == FormatMarkdown {
+					// В таблице нет длительности и сессии, номер назначается при загрузке
+					want.DurationMs, want.SessionID = 0, ""
+				}
+				want.ID = entry.ID
+				if !reflect.DeepEqual(entry, want) {
+					t.Errorf("Entry %d: expected %+v, got %+v", idx, want, entry)
+				}
+			}
+		})
+	}
+}
+
+func TestReadHistoryErrors(t *testing.T) {
+	tests := []struct {
+		format, input, message string
+	}{
+		{FormatCSV, "id,result\n1,2", "command"},
+		{FormatJSONL, `{"command":"1"}` + "\n{", "строка 2"},
+		{FormatJSON, `[{"command":""}]`, "пустая команда"},
+		{FormatJSON, `[{"command":"1","timestamp":"вчера"}]`, "RFC 3339"},
+		{FormatMarkdown, "| a | b |\n|---|---|", "столбцами"},
+	}
+	for _, tt := range tests {
+		_, err := ReadHistory(strings.NewReader(tt.input), tt.format)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s %q: expected error with %q, got %v", tt.format, tt.input, tt.message, err)
+		}
+	}
+}
+
+func TestResolveFormat(t *testing.T) {
+	tests := []struct {
+		format, path, expected string
+		history                bool
+	}{
+		{"", "", FormatJSON, false},
+		{"", "vars.YML", FormatYAML, false},
+		{"", "/tmp/.env", FormatEnv, false},
+		{"", ".env.local", FormatEnv, false},
+		{"", "vars.txt", FormatJSON, false},
+		{"CSV", "vars.json", FormatCSV, false},
+		{"", "history.md", FormatMarkdown, true},
+		{"md", "", FormatMarkdown, true},
+		{"", "history.ndjson", FormatJSONL, true},
+	}
+	for _, tt := range tests {
+		resolve := VariableFormat
+		if tt.history {
+			resolve = HistoryFormat
+		}
+		if got, err := resolve(tt.format, tt.path); err != nil || got != tt.expected {
+			t.Errorf("format %q, path %q: expected %s, got %s, %v", tt.format, tt.path, tt.expected, got, err)
+		}
+	}
+
+	if _, err := VariableFormat("markdown", ""); err == nil {
+		t.Error("Markdown is not a variable format")
+	}
+	if _, err := HistoryFormat("env", ""); err == nil {
+		t.Error("env is not a history format")
+	}
+	if _, err := ParseStrategy("replace"); err == nil {
+		t.Error("Expected an unknown strategy error")
+	}
+	if strategy, _ := ParseStrategy(""); strategy != StrategyMerge {
+		t.Errorf("Expected merge by default, got %s", strategy)
+	}
+}
@@ -0,0 +1,263 @@
+package transfer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// ============================================================================
+// ПЕРЕМЕННЫЕ
+// ============================================================================
+
+// WriteVariables - выгрузка переменных в формате format, имена по алфавиту.
+// В CSV и env числа записываются как есть, в env строки - в кавычках.
+func WriteVariables(w io.Writer, vars map[string]interface{}, format string) error {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(vars)
+
+	case FormatYAML:
+		ordered := make(yaml.MapSlice, len(names))
+		for idx, name := range names {
+			ordered[idx] = yaml.MapItem{Key: name, Value: vars[name]}
+		}
+		data, err := yaml.Marshal(ordered)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"name", "value"})
+		for _, name := range names {
+			writer.Write([]string{name, scalarString(vars[name])})
+		}
+		writer.Flush()
+		return writer.Error()
+
+	case FormatEnv:
+		buf := bufio.NewWriter(w)
+		for _, name := range names {
+			value := scalarString(vars[name])
+			if _, ok := vars[name].(float64); !ok {
+				value = strconv.Quote(value)
+			}
+			fmt.Fprintf(buf, "%s=%s\n", name, value)
+		}
+		return buf.Flush()
+	}
+	return fmt.Errorf("неизвестный формат переменных %q", format)
+}
+
+// ReadVariables - переменные из r в формате format. Значения - числа или строки;
+// имя переменной должно быть допустимым в выражениях калькулятора.
+func ReadVariables(r io.Reader, format string) (map[string]interface{}, error) {
+	switch format {
+	case FormatJSON:
+		raw := make(map[string]interface{})
+		if err := json.NewDecoder(r).Decode(&raw); err != nil {
+			return nil, fmt.Errorf("ошибка разбора JSON: %v", err)
+		}
+		return checkVariables(raw)
+
+	case FormatYAML:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		raw := make(map[string]interface{})
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("ошибка разбора YAML: %v", err)
+		}
+		return checkVariables(raw)
+
+	case FormatCSV:
+		return readVariablesCSV(r)
+
+	case FormatEnv:
+		return readVariablesEnv(r)
+	}
+	return nil, fmt.Errorf("неизвестный формат переменных %q", format)
+}
+
+// readVariablesCSV - столбцы name и value; строка заголовка необязательна
+func readVariablesCSV(r io.Reader) (map[string]interface{}, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка разбора CSV: %v", err)
+	}
+
+	vars := make(map[string]interface{})
+	for idx, record := range records {
+		if idx == 0 && len(record) >= 1 && strings.EqualFold(strings.TrimSpace(record[0]), "name") {
+			continue
+		}
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		if len(record) != 2 {
+			return nil, fmt.Errorf("строка %d: ожидается два столбца name,value", idx+1)
+		}
+		name := strings.TrimSpace(record[0])
+		if err := checkName(name); err != nil {
+			return nil, fmt.Errorf("строка %d: %v", idx+1, err)
+		}
+		vars[name] = parseScalar(record[1])
+	}
+	return vars, nil
+}
+
+// readVariablesEnv - строки ИМЯ=значение; пустые строки, комментарии # и префикс export
+// пропускаются. Значение в кавычках - строка, без кавычек - число, если читается как число.
+func readVariablesEnv(r io.Reader) (map[string]interface{}, error) {
+	vars := make(map[string]interface{})
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimSpace(strings.TrimPrefix(text, "export "))
+
+		name, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("строка %d: ожидается ИМЯ=значение", line)
+		}
+		name = strings.TrimSpace(name)
+		if err := checkName(name); err != nil {
+			return nil, fmt.Errorf("строка %d: %v", line, err)
+		}
+
+		value = strings.TrimSpace(value)
+		switch {
+		case strings.HasPrefix(value, `"`):
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("строка %d: незакрытые кавычки", line)
+			}
+			vars[name] = unquoted
+		case len(value) >= 2 && strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'"):
+			vars[name] = value[1 : len(value)-1]
+		default:
+			// Комментарий после значения без кавычек
+			if idx := strings.Index(value, " #"); idx >= 0 {
+				value = strings.TrimSpace(value[:idx])
+			}
+			vars[name] = parseScalar(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// checkVariables - проверка имен и приведение чисел к float64, как у вычисленных значений
+func checkVariables(raw map[string]interface{}) (map[string]interface{}, error) {
+	vars := make(map[string]interface{}, len(raw))
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := checkName(name); err != nil {
+			return nil, err
+		}
+		switch value := raw[name].(type) {
+		case float64:
+			if math.IsInf(value, 0) || math.IsNaN(value) {
+				return nil, fmt.Errorf("%s: значение должно быть конечным числом", name)
+			}
+			vars[name] = value
+		case int:
+			vars[name] = float64(value)
+		case int64:
+			vars[name] = float64(value)
+		case uint64:
+			vars[name] = float64(value)
+		case string:
+			vars[name] = value
+		default:
+			return nil, fmt.Errorf("%s: значение должно быть числом или строкой", name)
+		}
+	}
+	return vars, nil
+}
+
+func checkName(name string) error {
+	if !variableNamePattern.MatchString(name) {
+		return fmt.Errorf("недопустимое имя переменной %q", name)
+	}
+	return nil
+}
+
+func scalarString(value interface{}) string {
+	if number, ok := value.(float64); ok {
+		return formatNumber(number)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// MergeVariables - переменные после загрузки imported поверх current по стратегии strategy.
+// Имена, для которых readOnly возвращает true (константы), не меняются и не удаляются
+// ни при какой стратегии; readOnly может быть nil. current не меняется.
+func MergeVariables(current, imported map[string]interface{}, strategy string, readOnly func(name string) bool) (map[string]interface{}, Stats) {
+	var stats Stats
+	protected := func(name string) bool { return readOnly != nil && readOnly(name) }
+	merged := make(map[string]interface{}, len(current)+len(imported))
+	for name, value := range current {
+		if strategy != StrategyOverwrite || protected(name) {
+			merged[name] = value
+			continue
+		}
+		if _, ok := imported[name]; !ok {
+			stats.Removed++
+		}
+	}
+
+	for name, value := range imported {
+		old, existed := current[name]
+		switch {
+		case existed && old == value:
+			stats.Skipped++
+		case protected(name):
+			stats.Skipped++
+			continue
+		case !existed:
+			stats.Added++
+		case strategy == StrategySkip:
+			stats.Skipped++
+			continue
+		default:
+			stats.Updated++
+		}
+		merged[name] = value
+	}
+	return merged, stats
+}
@@ -0,0 +1,131 @@
+package variables
+
+import (
+	"app/core/persistence"
+	"sync"
+)
+
+// Journal - журнал изменений переменных для undo/redo.
+// Хранит не больше depth последних изменений; новое изменение очищает redo.
+// Безопасен для одновременного использования; Undo и Redo меняют переменную и журнал вместе.
+type Journal struct {
+	mu    sync.Mutex
+	undo  []persistence.VariableChange
+	redo  []persistence.VariableChange
+	depth int
+}
+
+func NewJournal(depth int) *Journal {
+	return &Journal{
+		undo:  make([]persistence.VariableChange, 0),
+		redo:  make([]persistence.VariableChange, 0),
+		depth: depth,
+	}
+}
+
+// Record - запись изменения
+func (j *Journal) Record(change persistence.VariableChange) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.undo = trimChanges(append(j.undo, change), j.depth)
+	j.redo = j.redo[:0]
+}
+
+// Undo - возврат прежнего значения и описания последней измененной переменной.
+// Если переменная стала константой, изменение остается в журнале и возвращается ошибка.
+func (j *Journal) Undo(vs *VariableStore) (persistence.VariableChange, bool, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.undo) == 0 {
+		return persistence.VariableChange{}, false, nil
+	}
+	change := j.undo[len(j.undo)-1]
+
+	var err error
+	if change.Existed {
+		err = vs.restore(change.Name, change.Old, change.OldMeta)
+	} else {
+		err = vs.DeleteVariable(change.Name)
+	}
+	if err != nil {
+		return change, true, err
+	}
+	j.undo = j.undo[:len(j.undo)-1]
+	j.redo = append(j.redo, change)
+	return change, true, nil
+}
+
+// Redo - повтор последнего отмененного изменения
+func (j *Journal) Redo(vs *VariableStore) (persistence.VariableChange, bool, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.redo) == 0 {
+		return persistence.VariableChange{}, false, nil
+	}
+	change := j.redo[len(j.redo)-1]
+
+	var err error
+	if change.Deleted {
+		err = vs.DeleteVariable(change.Name)
+	} else {
+		err = vs.restore(change.Name, change.New, change.NewMeta)
+	}
+	if err != nil {
+		return change, true, err
+	}
+	j.redo = j.redo[:len(j.redo)-1]
+	j.undo = append(j.undo, change)
+	return change, true, nil
+}
+
+// Forget - удаление из журнала всех изменений переменной name (она стала константой)
+func (j *Journal) Forget(name string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.undo = forgetChanges(j.undo, name)
+	j.redo = forgetChanges(j.redo, name)
+}
+
+// SetDepth - новая глубина журнала; лишние старые изменения отбрасываются
+func (j *Journal) SetDepth(depth int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.depth = depth
+	j.undo = trimChanges(j.undo, depth)
+	j.redo = trimChanges(j.redo, depth)
+}
+
+// Load - восстановление журнала из сохраненного состояния
+func (j *Journal) Load(undo, redo []persistence.VariableChange) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.undo = trimChanges(append([]persistence.VariableChange{}, undo...), j.depth)
+	j.redo = trimChanges(append([]persistence.VariableChange{}, redo...), j.depth)
+}
+
+// State - копии стеков undo и redo для сохранения
+func (j *Journal) State() (undo, redo []persistence.VariableChange) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]persistence.VariableChange{}, j.undo...), append([]persistence.VariableChange{}, j.redo...)
+}
+
+func trimChanges(changes []persistence.VariableChange, depth int) []persistence.VariableChange {
+	if depth <= 0 {
+		return changes[:0]
+	}
+	if len(changes) > depth {
+		return append(changes[:0], changes[len(changes)-depth:]...)
+	}
+	return changes
+}
+
+func forgetChanges(changes []persistence.VariableChange, name string) []persistence.VariableChange {
+	kept := changes[:0]
+	for _, change := range changes {
+		if change.Name != name {
+			kept = append(kept, change)
+		}
+	}
+	return kept
+}
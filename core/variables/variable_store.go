@@ -1,42 +1,338 @@
 package variables
 
-// VariableStore - хранилище переменных
+import (
+	"app/core/persistence"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// КОНСТАНТЫ
+// ============================================================================
+
+// Builtins - встроенные константы. Они доступны в выражениях, пока нет переменной
+// с тем же именем (такие могли остаться в старых файлах, их можно удалить командой del),
+// и не сохраняются вместе с переменными.
+var Builtins = map[string]float64{
+	"pi":  math.Pi,
+	"e":   math.E,
+	"phi": math.Phi,
+	"c":   299792458, // скорость света, м/с
+	"g":   9.80665,   // ускорение свободного падения, м/с²
+}
+
+// ErrConstant - попытка изменить или удалить константу
+var ErrConstant = errors.New("константа только для чтения")
+
+// IsBuiltin - имя встроенной константы
+func IsBuiltin(name string) bool {
+	_, ok := Builtins[name]
+	return ok
+}
+
+// ============================================================================
+// ХРАНИЛИЩЕ
+// ============================================================================
+
+// VariableStore - хранилище переменных, безопасное для одновременного использования.
+// У каждой переменной, кроме значения, есть описание (persistence.VariableMeta).
+// Константы, объявленные пользователем, хранятся вместе с переменными и отмечены в constants.
 
 type VariableStore struct {
-	variables map[string]interface{}
+	mu        sync.RWMutex
+	variables map[string]*variable
+	constants map[string]bool
+}
+
+// variable - значение и описание переменной
+type variable struct {
+	value interface{}
+	meta  persistence.VariableMeta
 }
 
 func NewVariableStore() *VariableStore {
 	return &VariableStore{
-		variables: make(map[string]interface{}),
+		variables: make(map[string]*variable),
+		constants: make(map[string]bool),
+	}
+}
+
+// checkWritable - ошибка, если name - константа; вызывается под mu
+func (vs *VariableStore) checkWritable(name string) error {
+	if vs.constants[name] {
+		return fmt.Errorf("%s: %w", name, ErrConstant)
+	}
+	if IsBuiltin(name) {
+		return fmt.Errorf("%s: встроенная %w", name, ErrConstant)
+	}
+	return nil
+}
+
+// Writable - ошибка, если переменную name нельзя изменить: константа или встроенная
+func (vs *VariableStore) Writable(name string) error {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+	return vs.checkWritable(name)
+}
+
+// set - новое значение и выражение, из которого оно получено (formula - значение привязано
+// к выражению); описание, единица и теги сохраняются. Вызывается под mu.
+func (vs *VariableStore) set(name string, value interface{}, expression string, formula bool) {
+	now := time.Now().Format(time.RFC3339)
+	v, ok := vs.variables[name]
+	if !ok {
+		v = &variable{meta: persistence.VariableMeta{Created: now}}
+		vs.variables[name] = v
+	}
+	v.value = value
+	v.meta.Updated = now
+	v.meta.Expression = expression
+	v.meta.Formula = formula
+}
+
+// SetVariable - установка переменной; константы не перезаписываются
+func (vs *VariableStore) SetVariable(name string, value interface{}) error {
+	return vs.SetVariableFrom(name, value, "")
+}
+
+// SetVariableFrom - установка переменной, вычисленной из выражения expression;
+// привязка к формуле снимается
+func (vs *VariableStore) SetVariableFrom(name string, value interface{}, expression string) error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	if err := vs.checkWritable(name); err != nil {
+		return err
+	}
+	vs.set(name, value, expression, false)
+	return nil
+}
+
+// SetFormula - установка переменной, привязанной к формуле expression (x := ...).
+// Если значение и формула не изменились, время изменения остается прежним.
+func (vs *VariableStore) SetFormula(name string, value interface{}, expression string) error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	if err := vs.checkWritable(name); err != nil {
+		return err
 	}
+	if v, ok := vs.variables[name]; ok && v.meta.Formula && v.meta.Expression == expression && reflect.DeepEqual(v.value, value) {
+		return nil
+	}
+	vs.set(name, value, expression, true)
+	return nil
 }
 
-// SetVariable - установка переменной
-func (vs *VariableStore) SetVariable(name string, value interface{}) {
-	vs.variables[name] = value
+// Formulas - формулы переменных, привязанных к выражениям: имя -> выражение
+func (vs *VariableStore) Formulas() map[string]string {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+	formulas := make(map[string]string)
+	for name, v := range vs.variables {
+		if v.meta.Formula {
+			formulas[name] = v.meta.Expression
+		}
+	}
+	return formulas
+}
+
+// DefineConstant - объявление константы: значение больше нельзя изменить или удалить.
+// Обычная переменная с тем же именем становится константой с новым значением.
+func (vs *VariableStore) DefineConstant(name string, value interface{}, expression string) error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	if err := vs.checkWritable(name); err != nil {
+		return err
+	}
+	vs.set(name, value, expression, false)
+	vs.constants[name] = true
+	return nil
+}
+
+// restore - значение и описание из журнала отмены; без описания - как SetVariable
+func (vs *VariableStore) restore(name string, value interface{}, meta *persistence.VariableMeta) error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	if err := vs.checkWritable(name); err != nil {
+		return err
+	}
+	if meta == nil {
+		vs.set(name, value, "", false)
+		return nil
+	}
+	vs.variables[name] = &variable{value: value, meta: copyMeta(*meta)}
+	return nil
 }
 
-// GetVariable - получение переменной
+// Describe - изменение описания переменной функцией update; время изменения значения
+// не меняется. Встроенные константы описать нельзя.
+func (vs *VariableStore) Describe(name string, update func(meta *persistence.VariableMeta)) error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	v, ok := vs.variables[name]
+	if !ok {
+		if IsBuiltin(name) {
+			return fmt.Errorf("%s: встроенная константа", name)
+		}
+		return fmt.Errorf("переменная %s не найдена", name)
+	}
+	update(&v.meta)
+	return nil
+}
+
+// GetVariable - получение переменной или встроенной константы
 func (vs *VariableStore) GetVariable(name string) interface{} {
-	return vs.variables[name] // В Go возвращает nil если ключа нет
+	value, _ := vs.LookupVariable(name)
+	return value // nil если нет ни переменной, ни константы
+}
+
+// LookupVariable - значение переменной или встроенной константы и признак его наличия
+func (vs *VariableStore) LookupVariable(name string) (interface{}, bool) {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+	if v, ok := vs.variables[name]; ok {
+		return v.value, true
+	}
+	if value, ok := Builtins[name]; ok {
+		return value, true
+	}
+	return nil, false
+}
+
+// Meta - копия описания переменной; nil, если переменной нет
+func (vs *VariableStore) Meta(name string) *persistence.VariableMeta {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+	v, ok := vs.variables[name]
+	if !ok {
+		return nil
+	}
+	meta := copyMeta(v.meta)
+	return &meta
+}
+
+// DeleteVariable - удаление переменной вместе с описанием; константы не удаляются
+func (vs *VariableStore) DeleteVariable(name string) error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	if vs.constants[name] {
+		return fmt.Errorf("%s: %w", name, ErrConstant)
+	}
+	delete(vs.variables, name)
+	return nil
+}
+
+// IsConstant - name - константа пользователя или встроенная
+func (vs *VariableStore) IsConstant(name string) bool {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+	return vs.constants[name] || IsBuiltin(name)
+}
+
+// Constants - имена констант пользователя по алфавиту
+func (vs *VariableStore) Constants() []string {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+	names := make([]string, 0, len(vs.constants))
+	for name := range vs.constants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
-// GetVariables - получение копии всех переменных
+// GetVariables - получение копии всех переменных (включая константы пользователя,
+// без встроенных)
 func (vs *VariableStore) GetVariables() map[string]interface{} {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
 	// Создаем новую мапу и копируем значения
 	copyMap := make(map[string]interface{})
 	for k, v := range vs.variables {
-		copyMap[k] = v
+		copyMap[k] = v.value
 	}
 	return copyMap
 }
 
-// SetVariables - установка всех переменных
+// SetVariables - установка всех переменных без описаний; отметки констант и описания
+// задаются отдельно (SetConstants, SetMetadata)
 func (vs *VariableStore) SetVariables(variablesDict map[string]interface{}) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
 	// Создаем новую мапу и копируем значения
-	vs.variables = make(map[string]interface{})
+	vs.variables = make(map[string]*variable)
 	for k, v := range variablesDict {
-		vs.variables[k] = v
+		vs.variables[k] = &variable{value: v}
+	}
+}
+
+// SetConstants - отметка сохраненных констант; имена без значения пропускаются
+func (vs *VariableStore) SetConstants(names []string) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	vs.constants = make(map[string]bool, len(names))
+	for _, name := range names {
+		if _, ok := vs.variables[name]; ok {
+			vs.constants[name] = true
+		}
+	}
+}
+
+// Metadata - копии описаний всех переменных для сохранения; пустые описания пропускаются
+func (vs *VariableStore) Metadata() map[string]persistence.VariableMeta {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+	metadata := make(map[string]persistence.VariableMeta, len(vs.variables))
+	for name, v := range vs.variables {
+		if !reflect.DeepEqual(v.meta, persistence.VariableMeta{}) {
+			metadata[name] = copyMeta(v.meta)
+		}
+	}
+	return metadata
+}
+
+// SetMetadata - сохраненные описания; имена без значения пропускаются
+func (vs *VariableStore) SetMetadata(metadata map[string]persistence.VariableMeta) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	for name, meta := range metadata {
+		if v, ok := vs.variables[name]; ok {
+			v.meta = copyMeta(meta)
+		}
+	}
+}
+
+func copyMeta(meta persistence.VariableMeta) persistence.VariableMeta {
+	meta.Tags = append([]string(nil), meta.Tags...)
+	return meta
+}
+
+// VariableInfo - переменная для вывода (/api/vars): значение, описание и признаки константы
+type VariableInfo struct {
+	Name     string      `json:"name"`
+	Value    interface{} `json:"value"`
+	Constant bool        `json:"constant"`
+	Builtin  bool        `json:"builtin,omitempty"`
+	persistence.VariableMeta
+}
+
+// List - переменные и не перекрытые ими встроенные константы, имена по алфавиту
+func (vs *VariableStore) List() []VariableInfo {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+	list := make([]VariableInfo, 0, len(vs.variables)+len(Builtins))
+	for name, v := range vs.variables {
+		list = append(list, VariableInfo{Name: name, Value: v.value, Constant: vs.constants[name], VariableMeta: copyMeta(v.meta)})
+	}
+	for name, value := range Builtins {
+		if _, ok := vs.variables[name]; !ok {
+			list = append(list, VariableInfo{Name: name, Value: value, Constant: true, Builtin: true})
+		}
 	}
+	sort.Slice(list, func(a, b int) bool { return list[a].Name < list[b].Name })
+	return list
 }
@@ -1,37 +1,67 @@
 package main
 
 import (
-	"app/core/interpreter"
-	"app/ui"
 	"fmt"
-	"log"
-	"time"
-
-	"github.com/skratchdot/open-golang/open"
+	"os"
+	"strings"
 )
 
-func main() {
-	// Инициализация интерпретатора
-	i := interpreter.NewInterpreter()
+// command - подкоманда calc
+type command struct {
+	name        string
+	description string
+	run         func(args []string) int
+}
 
-	web := ui.NewWebInterface(i)
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "веб-сервер калькулятора (по умолчанию)", runServe},
+		{"repl", "интерактивный режим в терминале", runREPL},
+		{"eval", "вычисление выражений из аргументов или stdin", runEval},
+		{"run", "выполнение файла со скриптом", runScript},
+		{"export", "выгрузка в JSON; vars, history - в json, csv, env, yaml, jsonl, markdown", runExport},
+		{"import", "загрузка из JSON; vars, history - со стратегией merge, overwrite, skip", runImport},
+		{"migrate", "перенос данных между хранилищами (--from json --to bolt)", runMigrate},
+		{"rekey", "смена ключа шифрования данных (--new-key-file, --decrypt)", runRekey},
+		{"audit", "журнал изменений: кто, когда и что менял (--var x)", runAudit},
+		{"config", "config print - действующая конфигурация (секреты скрыты)", runConfig},
+		{"help", "справка по командам", runHelp},
+	}
+}
 
-	calcAddr := ":8080"
-	calcURL := "http://localhost" + calcAddr
+func main() {
+	name := "serve"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
 
-	go func() {
-		time.Sleep(500 * time.Millisecond)
-		err := open.Run(calcURL)
-		if err != nil {
-			log.Printf("❌ Не удалось открыть браузер: %v", err)
+	for _, cmd := range commands {
+		if cmd.name == name {
+			os.Exit(cmd.run(args))
 		}
-	}()
+	}
 
-	fmt.Printf("🌐 Калькулятор открывается в браузере: %s\n", calcURL)
-	fmt.Println("Нажмите Ctrl+C для выхода.")
+	fmt.Fprintf(os.Stderr, "Неизвестная команда %q\n\n", name)
+	printUsage()
+	os.Exit(2)
+}
+
+func runHelp(_ []string) int {
+	printUsage()
+	return 0
+}
 
-	err := web.Start(calcAddr)
-	if err != nil {
-		log.Fatal(err)
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Использование: calc <команда> [флаги]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Команды:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.description)
 	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Флаги команды: calc <команда> -h")
+	fmt.Fprintln(os.Stderr, "Приоритет настроек: флаги > переменные окружения (CALC_*) > файл --config > значения по умолчанию.")
 }
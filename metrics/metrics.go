@@ -47,6 +47,39 @@ var (
 		},
 	)
 
+	ConfigReloads = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "calculator_config_reloads_total",
+			Help: "Total number of configuration reloads",
+		},
+		[]string{"result"}, // success, error, unchanged
+	)
+
+	// Метрики сессий веб-сервера
+	Sessions = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "calculator_sessions",
+			Help: "Number of web sessions by state",
+		},
+		[]string{"state"}, // active, spilled
+	)
+
+	SessionEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "calculator_session_events_total",
+			Help: "Total number of web session lifecycle events",
+		},
+		[]string{"event"}, // created, restored, evicted, expired, rejected
+	)
+
+	SessionLimits = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "calculator_session_limits",
+			Help: "Configured web session limits",
+		},
+		[]string{"limit"}, // max_active, max_sessions, idle_timeout_seconds
+	)
+
 	// WebRTC метрики (если будет интеграция)
 	ActiveWebRTCConnections = promauto.NewGauge(
 		prometheus.GaugeOpts{
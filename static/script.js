@@ -108,8 +108,7 @@ btnVars.addEventListener('click',async ()=>{
   try{
     const r=await fetch('/api/vars');
     const vars=await r.json();
-    appendLine('Переменные:',{type:'plain'});
-    appendLine(JSON.stringify(vars,null,2),{type:'result',typing:true});
+    showVarCards(vars||[]);
   } catch(e){ appendLine('Ошибка загрузки переменных: '+String(e),{type:'error',typing:true}); }
 });
 
@@ -129,20 +128,45 @@ function showCards(items){
     const no=document.createElement('div'); no.className='card';
     no.innerHTML=`<div class="meta">История</div><div class="body">Пусто</div>`;
     cardsInner.appendChild(no);
-  } else items.slice().reverse().forEach(cmd=>{
+  } else items.slice().reverse().forEach(item=>{
+    const cmd=item.command;
     const card=document.createElement('div'); card.className='card';
     const meta=document.createElement('div'); meta.className='meta';
-    meta.textContent=`Команда • ${new Date().toLocaleString()}`;
+    const when=item.timestamp?new Date(item.timestamp).toLocaleString():item.time;
+    const took=item.duration_ms?` • ${item.duration_ms} мс`:'';
+    meta.textContent=`#${item.id} • ${item.kind||'команда'} • ${when}${took}`;
     const body=document.createElement('div'); body.className='body'; body.textContent=String(cmd);
     card.appendChild(meta); card.appendChild(body);
+    if(item.error||item.result!==undefined){
+      const outcome=document.createElement('div'); outcome.className='meta '+(item.error?'error':'result');
+      outcome.textContent=item.error?item.error:'= '+(typeof item.result==='string'?item.result:JSON.stringify(item.result));
+      card.appendChild(outcome);
+    }
     card.addEventListener('click',()=>{ input.value=cmd; input.focus(); card.animate([{transform:'scale(1)'},{transform:'scale(.995)'}],{duration:150,easing:'ease-out'}); });
     cardsInner.appendChild(card);
   });
   cards.classList.remove('hidden'); cards.setAttribute('aria-hidden','false');
 }
+function showVarCards(vars){
+  cardsInner.innerHTML='';
+  vars.slice().sort((a,b)=>(a.builtin===b.builtin)?0:(a.builtin?1:-1)).forEach(v=>{
+    const card=document.createElement('div'); card.className='card';
+    const meta=document.createElement('div'); meta.className='meta';
+    meta.textContent=(v.builtin?'🔒 встроенная константа':v.constant?'🔒 константа':'переменная')+(v.updated?' • '+new Date(v.updated).toLocaleString():'');
+    const body=document.createElement('div'); body.className='body';
+    body.textContent=v.name+' = '+(typeof v.value==='string'?v.value:JSON.stringify(v.value))+(v.unit?' '+v.unit:'');
+    card.appendChild(meta); card.appendChild(body);
+    [v.description, v.expression?(v.formula?':= ':'= ')+v.expression:'', (v.tags||[]).map(t=>'#'+t).join(' ')].filter(Boolean).forEach(text=>{
+      const line=document.createElement('div'); line.className='meta'; line.textContent=text; card.appendChild(line);
+    });
+    card.addEventListener('click',()=>{ input.value+=v.name; input.focus(); });
+    cardsInner.appendChild(card);
+  });
+  cards.classList.remove('hidden'); cards.setAttribute('aria-hidden','false');
+}
 function hideCards(){ cards.classList.add('hidden'); cards.setAttribute('aria-hidden','true'); }
 document.getElementById('close-cards').addEventListener('click',hideCards);
-document.addEventListener('click',(ev)=>{ if(!cards.classList.contains('hidden')){ const inside=cards.contains(ev.target)||ev.target===btnHistory; if(!inside) hideCards(); } });
+document.addEventListener('click',(ev)=>{ if(!cards.classList.contains('hidden')){ const inside=cards.contains(ev.target)||ev.target===btnHistory||ev.target===btnVars; if(!inside) hideCards(); } });
 
 clearOutput();
 append
@@ -0,0 +1,129 @@
+package ui
+
+import (
+	"app/core/interpreter"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Форматы вывода пакетного режима
+const (
+	FormatPlain = "plain"
+	FormatJSON  = "json"
+	FormatCSV   = "csv"
+)
+
+// BatchResult - результат вычисления одной строки
+type BatchResult struct {
+	Input  string      `json:"input"`
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// BatchInterface - неинтерактивное вычисление для shell-скриптов и Makefile
+type BatchInterface struct {
+	interpreter *interpreter.Interpreter
+	out         io.Writer
+	errOut      io.Writer
+	format      string
+	csvWriter   *csv.Writer
+}
+
+func NewBatchInterface(i *interpreter.Interpreter, format string) (*BatchInterface, error) {
+	switch format {
+	case FormatPlain, FormatJSON, FormatCSV:
+	default:
+		return nil, fmt.Errorf("неизвестный формат вывода %q (plain, json, csv)", format)
+	}
+
+	return &BatchInterface{
+		interpreter: i,
+		out:         os.Stdout,
+		errOut:      os.Stderr,
+		format:      format,
+	}, nil
+}
+
+// Run - вычисление переданных выражений; false, если хотя бы одно завершилось ошибкой
+func (b *BatchInterface) Run(inputs []string) bool {
+	ok := true
+	for _, input := range inputs {
+		if !b.evaluate(input) {
+			ok = false
+		}
+	}
+	b.flush()
+	return ok
+}
+
+// RunReader - построчное вычисление входного потока (пустые строки и # комментарии пропускаются)
+func (b *BatchInterface) RunReader(r io.Reader) (bool, error) {
+	ok := true
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if !b.evaluate(scanner.Text()) {
+			ok = false
+		}
+	}
+	b.flush()
+	return ok, scanner.Err()
+}
+
+func (b *BatchInterface) evaluate(input string) bool {
+	input = strings.TrimSpace(input)
+	if input == "" || strings.HasPrefix(input, "#") {
+		return true
+	}
+
+	res := BatchResult{Input: input}
+	result, err := b.interpreter.Execute(input)
+	switch {
+	case err != nil:
+		res.Error = err.Error()
+	case isErrorResult(result):
+		res.Error = strings.TrimPrefix(FormatResult(result), "❌ ")
+	default:
+		res.Result = result
+	}
+
+	b.write(res)
+	return res.Error == ""
+}
+
+func (b *BatchInterface) write(res BatchResult) {
+	switch b.format {
+	case FormatJSON:
+		encoder := json.NewEncoder(b.out)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(res)
+	case FormatCSV:
+		if b.csvWriter == nil {
+			b.csvWriter = csv.NewWriter(b.out)
+			b.csvWriter.Write([]string{"input", "result", "error"})
+		}
+		b.csvWriter.Write([]string{res.Input, FormatResult(res.Result), res.Error})
+	default:
+		if res.Error != "" {
+			fmt.Fprintf(b.errOut, "Ошибка: %s\n", res.Error)
+			return
+		}
+		fmt.Fprintln(b.out, FormatResult(res.Result))
+	}
+}
+
+func (b *BatchInterface) flush() {
+	if b.csvWriter != nil {
+		b.csvWriter.Flush()
+	}
+}
+
+// isErrorResult - команды curl и AI сообщают об ошибке текстом результата
+func isErrorResult(result interface{}) bool {
+	s, ok := result.(string)
+	return ok && strings.HasPrefix(s, "❌")
+}
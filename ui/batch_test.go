@@ -0,0 +1,82 @@
+package ui
+
+import (
+	"app/core/interpreter"
+	"app/core/persistence"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func newTestBatch(t *testing.T, format string) (*BatchInterface, *bytes.Buffer, *bytes.Buffer) {
+	t.Helper()
+	i := interpreter.NewInterpreterWithPersistence(persistence.NewInMemoryPersistenceManager())
+	batch, err := NewBatchInterface(i, format)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	batch.out = out
+	batch.errOut = errOut
+	return batch, out, errOut
+}
+
+func TestBatchPlain(t *testing.T) {
+	batch, out, errOut := newTestBatch(t, FormatPlain)
+
+	ok, err := batch.RunReader(strings.NewReader("x=5\n\n# комментарий\nx*2\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !ok {
+		t.Errorf("Expected success, stderr: %s", errOut.String())
+	}
+	if out.String() != "x = 5\n10\n" {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+}
+
+func TestBatchFailureReported(t *testing.T) {
+	batch, _, errOut := newTestBatch(t, FormatPlain)
+
+	if batch.Run([]string{"2+2", "5/0"}) {
+		t.Error("Expected failure for division by zero")
+	}
+	if !strings.Contains(errOut.String(), "деление на ноль") {
+		t.Errorf("Expected error in stderr, got: %s", errOut.String())
+	}
+}
+
+func TestBatchJSON(t *testing.T) {
+	batch, out, _ := newTestBatch(t, FormatJSON)
+	batch.interpreter.SetVariables(map[string]interface{}{"x": 21.0})
+
+	batch.Run([]string{"x*2"})
+
+	var res BatchResult
+	if err := json.Unmarshal(out.Bytes(), &res); err != nil {
+		t.Fatalf("Failed to parse JSON output: %v", err)
+	}
+	if res.Input != "x*2" || res.Result != 42.0 || res.Error != "" {
+		t.Errorf("Unexpected result: %+v", res)
+	}
+}
+
+func TestBatchCSV(t *testing.T) {
+	batch, out, _ := newTestBatch(t, FormatCSV)
+
+	batch.Run([]string{"1+1", "1/0"})
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || lines[0] != "input,result,error" || lines[1] != "1+1,2," {
+		t.Errorf("Unexpected CSV output: %q", out.String())
+	}
+}
+
+func TestBatchUnknownFormat(t *testing.T) {
+	i := interpreter.NewInterpreterWithPersistence(persistence.NewInMemoryPersistenceManager())
+	if _, err := NewBatchInterface(i, "xml"); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
@@ -0,0 +1,428 @@
+package ui
+
+import (
+	"app/core/interpreter"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const (
+	replPrompt         = "calc> "
+	replContinuePrompt = "...> "
+	replHistoryLimit   = 500
+)
+
+// replMetaCommands - мета-команды REPL (не передаются в интерпретатор)
+var replMetaCommands = []string{":vars", ":history", ":clear", ":help", ":quit", ":{", ":}"}
+
+// replKeywords - команды интерпретатора, доступные для автодополнения
+var replKeywords = []string{"history", "history clear", "history search", "history delete", "history export", "history replay", "workspace", "workspace use", "workspace new", "undo", "redo", "const", "del", "describe", "explain", "vars", "vars --tag", "curl", "login", "call"}
+
+// ANSI цвета для терминала
+const (
+	colorReset = "\033[0m"
+	colorRed   = "\033[31m"
+	colorGreen = "\033[32m"
+	colorGray  = "\033[90m"
+)
+
+// REPL - интерактивный терминальный режим, работающий напрямую с интерпретатором
+type REPL struct {
+	interpreter *interpreter.Interpreter
+	in          *os.File
+	out         io.Writer
+	color       bool
+}
+
+func NewREPL(i *interpreter.Interpreter) *REPL {
+	return &REPL{
+		interpreter: i,
+		in:          os.Stdin,
+		out:         os.Stdout,
+		color:       term.IsTerminal(int(os.Stdout.Fd())),
+	}
+}
+
+// Start - запуск цикла чтения команд до EOF, Ctrl+C/Ctrl+D или :quit
+func (r *REPL) Start() error {
+	fd := int(r.in.Fd())
+	if !term.IsTerminal(fd) {
+		// Ввод не из терминала - читаем построчно без редактирования
+		return r.runPlain(r.in)
+	}
+
+	screen := struct {
+		io.Reader
+		io.Writer
+	}{r.in, r.out}
+
+	t := term.NewTerminal(screen, replPrompt)
+	t.History = newReplHistory(r.interpreter.GetHistoryCommands(replHistoryLimit))
+	t.AutoCompleteCallback = r.complete
+
+	fmt.Fprintln(r.out, "Калькулятор в режиме REPL. :help - список команд, Ctrl+D - выход.")
+
+	for {
+		line, err := readLineRaw(t, fd)
+		if err == io.EOF {
+			fmt.Fprintln(r.out)
+			return nil
+		}
+		if err != nil && err != term.ErrPasteIndicator {
+			return err
+		}
+
+		// Продолжение строки через "\" в конце
+		for strings.HasSuffix(line, "\\") {
+			t.SetPrompt(replContinuePrompt)
+			next, err := readLineRaw(t, fd)
+			t.SetPrompt(replPrompt)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil && err != term.ErrPasteIndicator {
+				return err
+			}
+			line = strings.TrimSuffix(line, "\\") + " " + next
+		}
+
+		// Многострочный блок :{ ... :}
+		if strings.TrimSpace(line) == ":{" {
+			t.SetPrompt(replContinuePrompt)
+			block, err := readBlock(func() (string, error) {
+				line, err := readLineRaw(t, fd)
+				if err == term.ErrPasteIndicator {
+					err = nil
+				}
+				return line, err
+			})
+			t.SetPrompt(replPrompt)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			for _, cmd := range block {
+				r.execute(cmd)
+			}
+			continue
+		}
+
+		if !r.handleLine(line) {
+			return nil
+		}
+	}
+}
+
+// runPlain - обработка ввода без терминала (например, перенаправленного файла)
+func (r *REPL) runPlain(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	next := func() (string, error) {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+		return scanner.Text(), nil
+	}
+
+	for {
+		line, err := next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for strings.HasSuffix(line, "\\") {
+			more, err := next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			line = strings.TrimSuffix(line, "\\") + " " + more
+		}
+
+		if strings.TrimSpace(line) == ":{" {
+			block, err := readBlock(next)
+			if err != nil && err != io.EOF {
+				return err
+			}
+			for _, cmd := range block {
+				r.execute(cmd)
+			}
+			continue
+		}
+
+		if !r.handleLine(line) {
+			return nil
+		}
+	}
+}
+
+// handleLine - обработка одной строки; false означает завершение работы
+func (r *REPL) handleLine(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" {
+		return true
+	}
+
+	switch trimmed {
+	case ":quit", ":q", ":exit":
+		return false
+	case ":help":
+		r.printHelp()
+	case ":vars":
+		r.printVars()
+	case ":history":
+		r.printHistory()
+	case ":clear":
+		fmt.Fprint(r.out, "\033[H\033[2J")
+	default:
+		// :vars <тег> - переменные с тегом, таблицей с описаниями
+		if tag, ok := strings.CutPrefix(trimmed, ":vars "); ok {
+			r.execute("vars --tag " + strings.TrimSpace(tag))
+			return true
+		}
+		if strings.HasPrefix(trimmed, ":") {
+			r.printError(fmt.Sprintf("неизвестная мета-команда %s, см. :help", trimmed))
+			return true
+		}
+		r.execute(trimmed)
+	}
+	return true
+}
+
+func (r *REPL) execute(command string) {
+	command = strings.TrimSpace(command)
+	if command == "" {
+		return
+	}
+
+	result, err := r.interpreter.Execute(command)
+	if err != nil {
+		r.printError(err.Error())
+		return
+	}
+	r.printResult(result)
+}
+
+func (r *REPL) printResult(result interface{}) {
+	text := FormatResult(result)
+	if isErrorResult(result) {
+		r.printError(text)
+		return
+	}
+	fmt.Fprintln(r.out, r.paint(colorGreen, text))
+}
+
+func (r *REPL) printError(message string) {
+	fmt.Fprintln(r.out, r.paint(colorRed, "Ошибка: "+strings.TrimPrefix(message, "❌ ")))
+}
+
+// printVars - переменные, затем встроенные константы серым
+func (r *REPL) printVars() {
+	list := r.interpreter.ListVariables()
+	builtins := make([]string, 0)
+	for _, v := range list {
+		switch {
+		case v.Builtin:
+			builtins = append(builtins, fmt.Sprintf("%s = %v", v.Name, v.Value))
+		case v.Constant:
+			fmt.Fprintf(r.out, "%s = %v%s %s\n", v.Name, v.Value, unitSuffix(v.Unit), r.paint(colorGray, "(константа)"))
+		case v.Formula:
+			fmt.Fprintf(r.out, "%s = %v%s %s\n", v.Name, v.Value, unitSuffix(v.Unit), r.paint(colorGray, ":= "+v.Expression))
+		default:
+			fmt.Fprintf(r.out, "%s = %v%s\n", v.Name, v.Value, unitSuffix(v.Unit))
+		}
+	}
+	if len(builtins) == len(list) {
+		fmt.Fprintln(r.out, r.paint(colorGray, "Переменных нет"))
+	}
+	fmt.Fprintln(r.out, r.paint(colorGray, "Встроенные константы: "+strings.Join(builtins, ", ")))
+}
+
+func unitSuffix(unit string) string {
+	if unit == "" {
+		return ""
+	}
+	return " " + unit
+}
+
+func (r *REPL) printHistory() {
+	entries := r.interpreter.GetDetailedHistory(r.interpreter.Config().History.RecentCount)
+	if len(entries) == 0 {
+		fmt.Fprintln(r.out, r.paint(colorGray, "История пуста"))
+		return
+	}
+	for _, entry := range entries {
+		fmt.Fprintf(r.out, "%3d. %s %s\n", entry.ID, r.paint(colorGray, "["+entry.Time+"]"), entry.Command)
+	}
+}
+
+func (r *REPL) printHelp() {
+	fmt.Fprintln(r.out, `Мета-команды:
+  :vars      Показать переменные
+  :vars тег  Переменные с тегом, с описаниями
+  :history   Показать последние команды
+  :clear     Очистить экран
+  :{ ... :}  Многострочный блок, каждая строка выполняется по очереди
+  :quit      Выход (также Ctrl+D)
+Строка, оканчивающаяся на "\", продолжается на следующей.
+Tab дополняет имена переменных и команд.`)
+}
+
+func (r *REPL) paint(color, text string) string {
+	if !r.color {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// complete - автодополнение по Tab для переменных, команд и мета-команд
+func (r *REPL) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+
+	start := pos
+	for start > 0 && (isAlphaNumeric(line[start-1]) || line[start-1] == ':') {
+		start--
+	}
+	prefix := line[start:pos]
+	if prefix == "" {
+		return "", 0, false
+	}
+
+	candidates := make([]string, 0)
+	for _, name := range sortedKeys(r.interpreter.GetVariables()) {
+		candidates = append(candidates, name)
+	}
+	if start == 0 {
+		candidates = append(candidates, replKeywords...)
+		candidates = append(candidates, replMetaCommands...)
+	}
+
+	matches := make([]string, 0)
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) && c != prefix {
+			matches = append(matches, c)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+
+	completion := commonPrefix(matches)
+	if len(completion) <= len(prefix) {
+		return "", 0, false
+	}
+
+	newLine := line[:start] + completion + line[pos:]
+	return newLine, start + len(completion), true
+}
+
+// replHistory - история строк для редактора, загружаемая из HistoryManager
+type replHistory struct {
+	entries []string
+}
+
+func newReplHistory(commands []string) *replHistory {
+	entries := make([]string, len(commands))
+	copy(entries, commands)
+	return &replHistory{entries: entries}
+}
+
+func (h *replHistory) Add(entry string) {
+	if strings.TrimSpace(entry) == "" {
+		return
+	}
+	if n := len(h.entries); n > 0 && h.entries[n-1] == entry {
+		return
+	}
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > replHistoryLimit {
+		h.entries = h.entries[len(h.entries)-replHistoryLimit:]
+	}
+}
+
+func (h *replHistory) Len() int {
+	return len(h.entries)
+}
+
+func (h *replHistory) At(idx int) string {
+	return h.entries[len(h.entries)-1-idx]
+}
+
+// readLineRaw - чтение строки в raw-режиме терминала.
+// Raw-режим включается только на время ввода, чтобы вывод интерпретатора
+// печатался как обычно.
+func readLineRaw(t *term.Terminal, fd int) (string, error) {
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(fd, state)
+	return t.ReadLine()
+}
+
+// readBlock - чтение строк многострочного блока до ":}"
+func readBlock(next func() (string, error)) ([]string, error) {
+	lines := make([]string, 0)
+	for {
+		line, err := next()
+		if err != nil {
+			return lines, err
+		}
+		if strings.TrimSpace(line) == ":}" {
+			return lines, nil
+		}
+		lines = append(lines, line)
+	}
+}
+
+// FormatResult - текстовое представление результата выполнения команды
+func FormatResult(result interface{}) string {
+	switch v := result.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func commonPrefix(values []string) string {
+	prefix := values[0]
+	for _, v := range values[1:] {
+		for !strings.HasPrefix(v, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+func isAlphaNumeric(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_'
+}
@@ -0,0 +1,168 @@
+package ui
+
+import (
+	"app/core/interpreter"
+	"app/core/persistence"
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestREPL() (*REPL, *bytes.Buffer) {
+	var out bytes.Buffer
+	interp := interpreter.NewInterpreterWithPersistence(persistence.NewInMemoryPersistenceManager())
+	return &REPL{interpreter: interp, out: &out}, &out
+}
+
+func TestREPLComplete(t *testing.T) {
+	r, _ := newTestREPL()
+	r.interpreter.Execute("price = 1")
+	r.interpreter.Execute("prime = 2")
+
+	tests := []struct {
+		line    string
+		pos     int
+		key     rune
+		newLine string
+		newPos  int
+		ok      bool
+	}{
+		{"pr", 2, '\t', "pri", 3, true},     // общий префикс price и prime
+		{"pric", 4, '\t', "price", 5, true}, // переменная
+		{"x = pric * 2", 8, '\t', "x = price * 2", 9, true},
+		{"hist", 4, '\t', "history", 7, true}, // команда в начале строки
+		{"x = hist", 8, '\t', "", 0, false},   // команды только в начале строки
+		{":va", 3, '\t', ":vars", 5, true},    // мета-команда
+		{"price", 5, '\t', "", 0, false},      // уже полностью
+		{"", 0, '\t', "", 0, false},
+		{"pric", 4, 'a', "", 0, false}, // не Tab
+	}
+	for _, tt := range tests {
+		newLine, newPos, ok := r.complete(tt.line, tt.pos, tt.key)
+		if newLine != tt.newLine || newPos != tt.newPos || ok != tt.ok {
+			t.Errorf("complete(%q, %d): expected %q %d %v, got %q %d %v", tt.line, tt.pos, tt.newLine, tt.newPos, tt.ok, newLine, newPos, ok)
+		}
+	}
+}
+
+func TestREPLHandleLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		proceed  bool
+		expected string
+	}{
+		{"2+2", true, "4"},
+		{"   ", true, ""},
+		{":nope", true, "неизвестная мета-команда :nope"},
+		{"1/0", true, "Ошибка:"},
+		{":quit", false, ""},
+		{":q", false, ""},
+	}
+	for _, tt := range tests {
+		r, out := newTestREPL()
+		if proceed := r.handleLine(tt.line); proceed != tt.proceed {
+			t.Errorf("%q: expected proceed %v, got %v", tt.line, tt.proceed, proceed)
+		}
+		if !strings.Contains(out.String(), tt.expected) || (tt.expected == "" && out.Len() != 0) {
+			t.Errorf("%q: expected output with %q, got %q", tt.line, tt.expected, out.String())
+		}
+	}
+}
+
+func TestReadBlock(t *testing.T) {
+	tests := []struct {
+		input    []string
+		expected []string
+		err      error
+	}{
+		{[]string{"x = 1", "y = 2", ":}", "z = 3"}, []string{"x = 1", "y = 2"}, nil},
+		{[]string{"  :}  "}, []string{}, nil},
+		{[]string{"x = 1"}, []string{"x = 1"}, io.EOF},
+	}
+	for _, tt := range tests {
+		lines := tt.input
+		next := func() (string, error) {
+			if len(lines) == 0 {
+				return "", io.EOF
+			}
+			line := lines[0]
+			lines = lines[1:]
+			return line, nil
+		}
+		block, err := readBlock(next)
+		if !reflect.DeepEqual(block, tt.expected) || err != tt.err {
+			t.Errorf("%q: expected %q %v, got %q %v", tt.input, tt.expected, tt.err, block, err)
+		}
+	}
+}
+
+func TestREPLPlainContinuation(t *testing.T) {
+	r, out := newTestREPL()
+	input := "x = 1 + \\\n2\n:{\ny = x * 2\nz = y + \\\n1\n:}\nz\n"
+	if err := r.runPlain(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	vars := r.interpreter.GetVariables()
+	if vars["x"] != 3.0 || vars["y"] != 6.0 {
+		t.Errorf("Expected x = 3 and y = 6, got %v", vars)
+	}
+	// Внутри блока "\" строку не продолжает: z = y + \ - ошибка, следующая строка - выражение
+	if _, ok := vars["z"]; ok {
+		t.Errorf("Expected z not to be set inside the block, got %v", vars)
+	}
+	if !strings.Contains(out.String(), "x = 3") {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+}
+
+// failingReader - первые строки, затем ошибка чтения
+type failingReader struct {
+	data io.Reader
+	err  error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.data.Read(p)
+	if err == io.EOF {
+		return n, f.err
+	}
+	return n, err
+}
+
+func TestREPLPlainReadErrors(t *testing.T) {
+	readErr := errors.New("обрыв ввода")
+	for _, input := range []string{"", "1 + \\\n", ":{\nx = 1\n"} {
+		r, _ := newTestREPL()
+		err := r.runPlain(&failingReader{data: strings.NewReader(input), err: readErr})
+		if !errors.Is(err, readErr) {
+			t.Errorf("%q: expected the read error, got %v", input, err)
+		}
+	}
+}
+
+func TestREPLHistory(t *testing.T) {
+	h := newReplHistory([]string{"a = 1", "a + 1"})
+	h.Add("a + 1") // повтор последней строки не добавляется
+	h.Add("   ")
+	h.Add("a * 2")
+
+	expected := []string{"a * 2", "a + 1", "a = 1"}
+	if h.Len() != len(expected) {
+		t.Fatalf("Expected %d entries, got %d", len(expected), h.Len())
+	}
+	for idx, entry := range expected {
+		if got := h.At(idx); got != entry {
+			t.Errorf("At(%d): expected %q, got %q", idx, entry, got)
+		}
+	}
+
+	for n := 0; n < replHistoryLimit+10; n++ {
+		h.Add(strings.Repeat("x", n%7+1) + string(rune('a'+n%26)))
+	}
+	if h.Len() != replHistoryLimit {
+		t.Errorf("Expected history limited to %d, got %d", replHistoryLimit, h.Len())
+	}
+}
@@ -0,0 +1,314 @@
+package ui
+
+import (
+	"app/config"
+	"app/core/interpreter"
+	"app/core/persistence"
+	"app/metrics"
+	"container/list"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// СЕССИИ
+// ============================================================================
+
+// SessionCookie - cookie с идентификатором сессии; API-клиенты могут передавать его в SessionHeader
+const (
+	SessionCookie = "calc_session"
+	SessionHeader = "X-Session-Token"
+)
+
+// ErrTooManySessions - достигнут sessions.max_sessions
+var ErrTooManySessions = errors.New("слишком много активных сессий, попробуйте позже")
+
+var sessionIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// randRead - источник случайных идентификаторов сессий; тесты подменяют его
+var randRead = rand.Read
+
+// SessionManager - отдельный интерпретатор для каждого клиента веб-сервера.
+// Последние sessions.max_active сессий держатся в памяти (LRU), остальные выгружаются:
+// их состояние уже сохранено в хранилище и загружается при следующем запросе.
+// Сессии без запросов дольше sessions.idle_timeout удаляются вместе с данными.
+// Сессия, которую использует незавершенный запрос, не выгружается и не удаляется:
+// иначе его запись состояния перезаписала бы сессию, загруженную заново.
+type SessionManager struct {
+	storage *persistence.PersistenceManager
+
+	mu     sync.Mutex
+	cfg    *config.Config
+	lru    *list.List               // *session, недавно использованные впереди
+	active map[string]*list.Element // сессии в памяти
+	seen   map[string]time.Time     // все сессии, включая выгруженные: время последнего запроса
+	now    func() time.Time
+}
+
+type session struct {
+	id     string
+	interp *interpreter.Interpreter
+	refs   int // незавершенные запросы (см. Acquire)
+}
+
+// NewSessionManager - менеджер сессий поверх корневого хранилища.
+// Сессии, сохраненные до перезапуска, подхватываются со временем последнего изменения.
+func NewSessionManager(cfg *config.Config, storage *persistence.PersistenceManager) *SessionManager {
+	sm := &SessionManager{
+		storage: storage,
+		lru:     list.New(),
+		active:  make(map[string]*list.Element),
+		seen:    make(map[string]time.Time),
+		now:     time.Now,
+	}
+
+	saved, err := storage.Sessions()
+	if err != nil {
+		log.Printf("❌ Не удалось прочитать сохраненные сессии: %v", err)
+	}
+	for id, modTime := range saved {
+		if sessionIDPattern.MatchString(id) {
+			sm.seen[id] = modTime
+		}
+	}
+
+	sm.ApplyConfig(cfg)
+	return sm
+}
+
+// ApplyConfig - новые настройки для всех сессий в памяти и новые ограничения
+func (sm *SessionManager) ApplyConfig(cfg *config.Config) {
+	sm.mu.Lock()
+	sm.cfg = cfg
+	sessionCfg := sm.sessionConfig()
+	interps := make([]*interpreter.Interpreter, 0, sm.lru.Len())
+	for e := sm.lru.Front(); e != nil; e = e.Next() {
+		interps = append(interps, e.Value.(*session).interp)
+	}
+	sm.evictLocked()
+	sm.updateMetricsLocked()
+	sm.mu.Unlock()
+
+	metrics.SessionLimits.WithLabelValues("max_active").Set(float64(cfg.Sessions.MaxActive))
+	metrics.SessionLimits.WithLabelValues("max_sessions").Set(float64(cfg.Sessions.MaxSessions))
+	metrics.SessionLimits.WithLabelValues("idle_timeout_seconds").Set(cfg.Sessions.IdleTimeout.Std().Seconds())
+
+	// Интерпретатор ждет завершения своих команд - не держим при этом общую блокировку
+	for _, interp := range interps {
+		interp.ApplyConfig(sessionCfg)
+	}
+}
+
+// sessionConfig - у сессии свое хранилище, рабочее пространство при запуске - то, что
+// было открыто в ней при выгрузке (см. activateLocked), а не общее storage.workspace
+func (sm *SessionManager) sessionConfig() *config.Config {
+	cfg := sm.cfg.Clone()
+	cfg.Storage.Workspace = ""
+	return cfg
+}
+
+// Acquire - интерпретатор сессии id на время запроса; по его завершении вызывается release.
+// Для пустого, неизвестного или истекшего id создается новая сессия; ее идентификатор
+// возвращается вторым значением.
+func (sm *SessionManager) Acquire(id string) (*interpreter.Interpreter, string, func(), error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	now := sm.now()
+	if last, ok := sm.seen[id]; ok && now.Sub(last) > sm.cfg.Sessions.IdleTimeout.Std() && !sm.inUseLocked(id) {
+		sm.expireLocked(id)
+	}
+
+	if e, ok := sm.active[id]; ok {
+		sm.lru.MoveToFront(e)
+		sm.seen[id] = now
+		return sm.holdLocked(e.Value.(*session)), id, sm.releaser(e.Value.(*session)), nil
+	}
+
+	if _, ok := sm.seen[id]; ok {
+		// Выгруженная сессия: состояние читается из хранилища
+		s := sm.activateLocked(id)
+		sm.seen[id] = now
+		metrics.SessionEvents.WithLabelValues("restored").Inc()
+		sm.updateMetricsLocked()
+		return s.interp, id, sm.releaser(s), nil
+	}
+
+	if len(sm.seen) >= sm.cfg.Sessions.MaxSessions {
+		sm.sweepLocked(now)
+		if len(sm.seen) >= sm.cfg.Sessions.MaxSessions {
+			metrics.SessionEvents.WithLabelValues("rejected").Inc()
+			return nil, "", nil, ErrTooManySessions
+		}
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	s := sm.activateLocked(id)
+	sm.seen[id] = now
+	metrics.SessionEvents.WithLabelValues("created").Inc()
+	sm.updateMetricsLocked()
+	return s.interp, id, sm.releaser(s), nil
+}
+
+// holdLocked - отметка запроса, использующего сессию
+func (sm *SessionManager) holdLocked(s *session) *interpreter.Interpreter {
+	s.refs++
+	return s.interp
+}
+
+// releaser - функция завершения запроса; вызывается один раз. Сессии сверх
+// sessions.max_active, которые ждали завершения запросов, выгружаются.
+func (sm *SessionManager) releaser(s *session) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			sm.mu.Lock()
+			defer sm.mu.Unlock()
+			s.refs--
+			sm.evictLocked()
+			sm.updateMetricsLocked()
+		})
+	}
+}
+
+func (sm *SessionManager) inUseLocked(id string) bool {
+	e, ok := sm.active[id]
+	return ok && e.Value.(*session).refs > 0
+}
+
+// activateLocked - сессия в памяти, уже отмеченная как используемая запросом
+func (sm *SessionManager) activateLocked(id string) *session {
+	storage := sm.storage.Session(id)
+	cfg := sm.sessionConfig()
+	cfg.Storage.Workspace = storage.CurrentNamespace()
+	interp := interpreter.NewInterpreterWithConfig(cfg, storage)
+	interp.SetSessionID(id)
+	interp.RestrictFiles()
+	s := &session{id: id, interp: interp, refs: 1}
+	sm.active[id] = sm.lru.PushFront(s)
+	sm.evictLocked()
+	return s
+}
+
+// evictLocked - выгрузка давно не использованных сессий сверх sessions.max_active;
+// сессии с незавершенными запросами пропускаются до их завершения
+func (sm *SessionManager) evictLocked() {
+	for e := sm.lru.Back(); e != nil && sm.lru.Len() > sm.cfg.Sessions.MaxActive; {
+		prev := e.Prev()
+		s := e.Value.(*session)
+		if s.refs > 0 {
+			e = prev
+			continue
+		}
+		sm.lru.Remove(e)
+		e = prev
+		id := s.id
+		delete(sm.active, id)
+		// Несохраненные изменения сессии и ее рабочих пространств записываются, память освобождается
+		storage := sm.storage.Session(id)
+		storage.SetCurrentNamespace(s.interp.Workspace())
+		if err := storage.Unload(); err != nil {
+			log.Printf("❌ Не удалось сохранить сессию %s: %v", id, err)
+		}
+		metrics.SessionEvents.WithLabelValues("evicted").Inc()
+	}
+}
+
+func (sm *SessionManager) expireLocked(id string) {
+	if e, ok := sm.active[id]; ok {
+		sm.lru.Remove(e)
+		delete(sm.active, id)
+	}
+	delete(sm.seen, id)
+	if err := sm.storage.DeleteSession(id); err != nil {
+		log.Printf("❌ Не удалось удалить сессию %s: %v", id, err)
+	}
+	metrics.SessionEvents.WithLabelValues("expired").Inc()
+}
+
+// Sweep - удаление сессий, простаивающих дольше sessions.idle_timeout; возвращает их число
+func (sm *SessionManager) Sweep() int {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.sweepLocked(sm.now())
+}
+
+func (sm *SessionManager) sweepLocked(now time.Time) int {
+	expired := 0
+	for id, last := range sm.seen {
+		if now.Sub(last) > sm.cfg.Sessions.IdleTimeout.Std() && !sm.inUseLocked(id) {
+			sm.expireLocked(id)
+			expired++
+		}
+	}
+	sm.updateMetricsLocked()
+	return expired
+}
+
+// Run - периодическая очистка истекших сессий до отмены ctx
+func (sm *SessionManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sm.Sweep()
+		}
+	}
+}
+
+// Close - запись состояния всех сессий и закрытие хранилища при остановке сервера
+func (sm *SessionManager) Close() error {
+	sm.mu.Lock()
+	for e := sm.lru.Front(); e != nil; e = e.Next() {
+		s := e.Value.(*session)
+		sm.storage.Session(s.id).SetCurrentNamespace(s.interp.Workspace())
+	}
+	sm.mu.Unlock()
+	return sm.storage.Close()
+}
+
+// Stats - число сессий в памяти и всего, включая выгруженные
+func (sm *SessionManager) Stats() (active, total int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.lru.Len(), len(sm.seen)
+}
+
+func (sm *SessionManager) updateMetricsLocked() {
+	metrics.Sessions.WithLabelValues("active").Set(float64(sm.lru.Len()))
+	metrics.Sessions.WithLabelValues("spilled").Set(float64(len(sm.seen) - sm.lru.Len()))
+}
+
+// sessionFromRequest - идентификатор из заголовка X-Session-Token или cookie
+func sessionFromRequest(r *http.Request) string {
+	if id := r.Header.Get(SessionHeader); id != "" {
+		return id
+	}
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// newSessionID - случайный идентификатор новой сессии
+func newSessionID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := randRead(buf); err != nil {
+		return "", fmt.Errorf("не удалось создать идентификатор сессии: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
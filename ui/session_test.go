@@ -0,0 +1,222 @@
+package ui
+
+import (
+	"app/config"
+	"app/core/interpreter"
+	"app/core/persistence"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSessions(maxActive, maxSessions int) (*SessionManager, *persistence.PersistenceManager) {
+	cfg := config.Default()
+	cfg.Sessions.MaxActive = maxActive
+	cfg.Sessions.MaxSessions = maxSessions
+	cfg.Sessions.IdleTimeout = config.Duration(time.Hour)
+	storage := persistence.NewInMemoryPersistenceManager()
+	return NewSessionManager(cfg, storage), storage
+}
+
+// get - интерпретатор сессии, как у завершенного запроса
+func get(sm *SessionManager, id string) (*interpreter.Interpreter, string, error) {
+	interp, id, release, err := sm.Acquire(id)
+	if release != nil {
+		release()
+	}
+	return interp, id, err
+}
+
+func TestSessionsAreIsolated(t *testing.T) {
+	sm, _ := newTestSessions(10, 10)
+
+	a, idA, err := get(sm, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	b, idB, _ := get(sm, "")
+	if idA == idB {
+		t.Fatal("Expected different session ids")
+	}
+
+	a.Execute("x = 1")
+	b.Execute("x = 2")
+	if again, id, _ := get(sm, idA); again != a || id != idA || a.GetVariables()["x"] != 1.0 {
+		t.Errorf("Expected session A to keep x = 1, got %v", a.GetVariables())
+	}
+	if a.SessionID() != idA {
+		t.Errorf("Expected history session id %s, got %s", idA, a.SessionID())
+	}
+}
+
+func TestSessionSpillAndRestore(t *testing.T) {
+	sm, _ := newTestSessions(1, 10)
+
+	a, idA, _ := get(sm, "")
+	a.Execute("x = 42")
+	get(sm, "")
+
+	if active, total := sm.Stats(); active != 1 || total != 2 {
+		t.Errorf("Expected 1 active of 2 sessions, got %d of %d", active, total)
+	}
+
+	restored, id, _ := get(sm, idA)
+	if id != idA || restored == a {
+		t.Fatalf("Expected session A to be restored from storage")
+	}
+	if x := restored.GetVariables()["x"]; x != 42.0 {
+		t.Errorf("Expected x = 42 after restore, got %v", x)
+	}
+}
+
+func TestSessionRestoresWorkspace(t *testing.T) {
+	sm, _ := newTestSessions(1, 10)
+
+	a, idA, _ := get(sm, "")
+	if _, err := a.Execute("workspace new draft"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	a.Execute("y = 5")
+	get(sm, "")
+
+	restored, _, _ := get(sm, idA)
+	if restored == a {
+		t.Fatal("Expected session A to be restored from storage")
+	}
+	if ws := restored.Workspace(); ws != "draft" {
+		t.Errorf("Expected restored session in workspace draft, got %s", ws)
+	}
+	if y := restored.GetVariables()["y"]; y != 5.0 {
+		t.Errorf("Expected y = 5 in the restored workspace, got %v", y)
+	}
+}
+
+func TestSessionInUseIsNotEvicted(t *testing.T) {
+	sm, _ := newTestSessions(1, 10)
+
+	a, idA, releaseA, _ := sm.Acquire("")
+	get(sm, "")
+	if active, _ := sm.Stats(); active != 1 {
+		t.Errorf("Expected only the session in use to stay in memory, got %d", active)
+	}
+
+	// Запрос к A продолжается после появления другой сессии: его запись не теряется
+	a.Execute("x = 1")
+	releaseA()
+	releaseA()
+	if again, id, _ := get(sm, idA); again != a || id != idA || again.GetVariables()["x"] != 1.0 {
+		t.Errorf("Expected the same interpreter of session A with x = 1, got %v", again.GetVariables())
+	}
+
+	// Сессия, дождавшаяся завершения запроса, выгружается
+	_, _, releaseA, _ = sm.Acquire(idA)
+	_, _, releaseB, _ := sm.Acquire("")
+	if active, _ := sm.Stats(); active != 2 {
+		t.Errorf("Expected both sessions in use to stay in memory, got %d", active)
+	}
+	releaseA()
+	releaseB()
+	if active, total := sm.Stats(); active != 1 || total != 3 {
+		t.Errorf("Expected 1 active of 3 sessions after release, got %d of %d", active, total)
+	}
+}
+
+func TestSessionExpiryAndLimit(t *testing.T) {
+	sm, storage := newTestSessions(10, 2)
+	now := time.Now()
+	sm.now = func() time.Time { return now }
+
+	a, idA, _ := get(sm, "")
+	a.Execute("x = 1")
+	get(sm, "")
+	if _, _, err := get(sm, ""); err != ErrTooManySessions {
+		t.Errorf("Expected ErrTooManySessions, got %v", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, id, err := get(sm, idA); err != nil || id == idA {
+		t.Errorf("Expected expired session to be replaced, got %s, %v", id, err)
+	}
+	if storage.SessionExists(idA) {
+		t.Error("Expected expired session data to be deleted")
+	}
+	if expired := sm.Sweep(); expired != 1 {
+		t.Errorf("Expected the other idle session to expire, got %d", expired)
+	}
+}
+
+func TestSessionsSurviveRestart(t *testing.T) {
+	cfg := config.Default()
+	storage := persistence.NewPersistenceManagerWithFile(filepath.Join(t.TempDir(), "calc.json"))
+
+	a, id, _ := get(NewSessionManager(cfg, storage), "")
+	a.Execute("x = 7")
+
+	sm := NewSessionManager(cfg, storage)
+	restored, restoredID, _ := get(sm, id)
+	if restoredID != id || restored.GetVariables()["x"] != 7.0 {
+		t.Errorf("Expected session to survive restart, got %s %v", restoredID, restored.GetVariables())
+	}
+
+	// Открытое рабочее пространство запоминается при остановке сервера
+	restored.Execute("workspace new draft")
+	if err := sm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if again, _, _ := get(NewSessionManager(cfg, storage), id); again.Workspace() != "draft" {
+		t.Errorf("Expected workspace draft after restart, got %s", again.Workspace())
+	}
+}
+
+func TestWebSessionCookie(t *testing.T) {
+	sm, _ := newTestSessions(10, 10)
+	web := NewWebInterfaceWithSessions(sm, "static")
+
+	rec := httptest.NewRecorder()
+	web.handleExecute(rec, httptest.NewRequest("POST", "/api/execute", strings.NewReader(`{"input":"x = 5"}`)))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != SessionCookie || rec.Header().Get(SessionHeader) != cookies[0].Value {
+		t.Fatalf("Expected session cookie and header, got %v", cookies)
+	}
+
+	req := httptest.NewRequest("GET", "/api/vars", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	web.handleVars(rec, req)
+	vars := decodeVars(t, rec.Body.Bytes())
+	if vars["x"] != 5.0 || len(rec.Result().Cookies()) != 0 {
+		t.Errorf("Expected x = 5 in the same session, got %v", vars)
+	}
+
+	rec = httptest.NewRecorder()
+	web.handleVars(rec, httptest.NewRequest("GET", "/api/vars", nil))
+	vars = decodeVars(t, rec.Body.Bytes())
+	if _, exists := vars["x"]; exists {
+		t.Errorf("Another client must not see x, got %v", vars)
+	}
+}
+
+func TestSessionIDFailure(t *testing.T) {
+	sm, _ := newTestSessions(10, 10)
+	web := NewWebInterfaceWithSessions(sm, "static")
+
+	randRead = func([]byte) (int, error) { return 0, errors.New("нет энтропии") }
+	defer func() { randRead = rand.Read }()
+
+	if _, _, err := get(sm, ""); err == nil {
+		t.Fatal("Expected an error when a session id cannot be generated")
+	}
+	rec := httptest.NewRecorder()
+	web.handleVars(rec, httptest.NewRequest("GET", "/api/vars", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500, got %d", rec.Code)
+	}
+	if _, total := sm.Stats(); total != 0 {
+		t.Errorf("Expected no session to be created, got %d", total)
+	}
+}
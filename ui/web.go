@@ -1,9 +1,16 @@
 package ui
 
 import (
+	"app/core/history"
 	"app/core/interpreter"
+	"app/core/transfer"
 	"app/metrics"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"path/filepath"
 	"strconv"
@@ -14,12 +21,62 @@ import (
 
 type WebInterface struct {
 	interpreter *interpreter.Interpreter
+	sessions    *SessionManager // если задан, у каждого клиента свой интерпретатор
+	staticDir   string
 }
 
 func NewWebInterface(i *interpreter.Interpreter) *WebInterface {
+	return NewWebInterfaceWithStaticDir(i, filepath.Join(".", "static"))
+}
+
+// NewWebInterfaceWithStaticDir - веб-интерфейс с общим интерпретатором; файлы его команд
+// ограничены каталогом server.files_dir, а рабочее пространство одно для всех клиентов
+func NewWebInterfaceWithStaticDir(i *interpreter.Interpreter, staticDir string) *WebInterface {
+	i.RestrictFiles()
+	i.PinWorkspace()
 	return &WebInterface{
 		interpreter: i,
+		staticDir:   staticDir,
+	}
+}
+
+// NewWebInterfaceWithSessions - веб-интерфейс, в котором каждый браузер работает со своим состоянием
+func NewWebInterfaceWithSessions(sessions *SessionManager, staticDir string) *WebInterface {
+	return &WebInterface{
+		sessions:  sessions,
+		staticDir: staticDir,
+	}
+}
+
+// interpreterFor - интерпретатор клиента и функция, которую обработчик вызывает по
+// завершении запроса. Новой сессии выставляется cookie, а ее идентификатор возвращается
+// в заголовке X-Session-Token. При ошибке ответ уже отправлен.
+func (w *WebInterface) interpreterFor(wr http.ResponseWriter, r *http.Request) (*interpreter.Interpreter, func(), bool) {
+	if w.sessions == nil {
+		return w.interpreter, func() {}, true
+	}
+
+	requested := sessionFromRequest(r)
+	interp, id, release, err := w.sessions.Acquire(requested)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrTooManySessions) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(wr, err.Error(), status)
+		return nil, nil, false
+	}
+	if id != requested {
+		http.SetCookie(wr, &http.Cookie{
+			Name:     SessionCookie,
+			Value:    id,
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		wr.Header().Set(SessionHeader, id)
 	}
+	return interp, release, true
 }
 
 // Middleware для метрик
@@ -57,12 +114,20 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
-func (w *WebInterface) Start(addr string) error {
+// Start - запуск сервера до отмены ctx; после отмены сервер дожидается
+// завершения начатых запросов (не дольше shutdownTimeout)
+func (w *WebInterface) Start(ctx context.Context, addr string) error {
 	// API routes с метриками
 	http.HandleFunc("/api/execute", metricsMiddleware(w.handleExecute))
 	http.HandleFunc("/api/vars", metricsMiddleware(w.handleVars))
 	http.HandleFunc("/api/history", metricsMiddleware(w.handleHistory))
+	http.HandleFunc("/api/history/search", metricsMiddleware(w.handleHistorySearch))
 	http.HandleFunc("/api/clear-history", metricsMiddleware(w.handleClearHistory))
+	http.HandleFunc("/api/undo", metricsMiddleware(w.handleUndo((*interpreter.Interpreter).Undo)))
+	http.HandleFunc("/api/redo", metricsMiddleware(w.handleUndo((*interpreter.Interpreter).Redo)))
+	http.HandleFunc("/api/workspaces", metricsMiddleware(w.handleWorkspaces))
+	http.HandleFunc("/api/export", metricsMiddleware(w.handleExport))
+	http.HandleFunc("/api/import", metricsMiddleware(w.handleImport))
 
 	// Prometheus metrics endpoint
 	http.Handle("/metrics", promhttp.Handler())
@@ -74,10 +139,33 @@ func (w *WebInterface) Start(addr string) error {
 	})
 
 	// Static files
-	fs := http.FileServer(http.Dir(filepath.Join(".", "static")))
+	fs := http.FileServer(http.Dir(w.staticDir))
 	http.Handle("/", fs)
 
-	return http.ListenAndServe(addr, nil)
+	server := &http.Server{Addr: addr}
+	stopped := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		stopped <- server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return <-stopped
+}
+
+// shutdownTimeout - сколько ждать завершения запросов при остановке сервера
+const shutdownTimeout = 10 * time.Second
+
+// Close - запись отложенных изменений состояния; вызывается после остановки сервера
+func (w *WebInterface) Close() error {
+	if w.sessions != nil {
+		return w.sessions.Close()
+	}
+	return w.interpreter.Close()
 }
 
 func (w *WebInterface) handleExecute(wr http.ResponseWriter, r *http.Request) {
@@ -91,7 +179,12 @@ func (w *WebInterface) handleExecute(wr http.ResponseWriter, r *http.Request) {
 	}
 	json.NewDecoder(r.Body).Decode(&req)
 
-	result, err := w.interpreter.Execute(req.Input)
+	interp, release, ok := w.interpreterFor(wr, r)
+	if !ok {
+		return
+	}
+	defer release()
+	result, err := interp.Execute(req.Input)
 
 	if err != nil {
 		metrics.CalculatorOperations.WithLabelValues("error").Inc()
@@ -103,31 +196,270 @@ func (w *WebInterface) handleExecute(wr http.ResponseWriter, r *http.Request) {
 	metrics.CalculatorOperations.WithLabelValues("success").Inc()
 
 	// Обновляем метрики
-	vars := w.interpreter.GetVariables()
-	history := w.interpreter.GetHistoryCommands(1000)
+	vars := interp.GetVariables()
+	history := interp.GetHistoryCommands(1000)
 	metrics.UpdateCalculatorMetrics(len(vars), len(history))
 
 	json.NewEncoder(wr).Encode(map[string]interface{}{"result": result})
 }
 
-func (w *WebInterface) handleVars(wr http.ResponseWriter, _ *http.Request) {
-	vars := w.interpreter.GetVariables()
-	metrics.UpdateCalculatorMetrics(len(vars), 0)
-	json.NewEncoder(wr).Encode(vars)
+// handleVars - GET /api/vars: переменные и встроенные константы списком
+// {name, value, constant, builtin}, имена по алфавиту
+func (w *WebInterface) handleVars(wr http.ResponseWriter, r *http.Request) {
+	interp, release, ok := w.interpreterFor(wr, r)
+	if !ok {
+		return
+	}
+	defer release()
+	metrics.UpdateCalculatorMetrics(len(interp.GetVariables()), 0)
+	json.NewEncoder(wr).Encode(interp.ListVariables())
+}
+
+// handleHistory - последние записи истории с результатами (?limit=N, по умолчанию 10)
+func (w *WebInterface) handleHistory(wr http.ResponseWriter, r *http.Request) {
+	limit := 10
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(wr, "limit must be a positive integer", 400)
+			return
+		}
+		limit = n
+	}
+
+	interp, release, ok := w.interpreterFor(wr, r)
+	if !ok {
+		return
+	}
+	defer release()
+	wr.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(wr).Encode(interp.GetDetailedHistory(limit))
 }
 
-func (w *WebInterface) handleHistory(wr http.ResponseWriter, _ *http.Request) {
-	commands := w.interpreter.GetHistoryCommands(10)
-	if len(commands) == 0 {
-		json.NewEncoder(wr).Encode([]string{})
+// handleHistorySearch - поиск по истории: ?q=<запрос>&limit=N&offset=N (по умолчанию limit=50).
+// Параметры limit и offset имеют приоритет над одноименными условиями в q.
+func (w *WebInterface) handleHistorySearch(wr http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query, err := history.ParseQuery(params.Get("q"), time.Now())
+	if err != nil {
+		http.Error(wr, err.Error(), 400)
 		return
 	}
-	json.NewEncoder(wr).Encode(commands)
+
+	if query.Limit == 0 {
+		query.Limit = 50
+	}
+	for name, target := range map[string]*int{"limit": &query.Limit, "offset": &query.Offset} {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			http.Error(wr, name+" must be a non-negative integer", 400)
+			return
+		}
+		*target = n
+	}
+
+	interp, release, ok := w.interpreterFor(wr, r)
+	if !ok {
+		return
+	}
+	defer release()
+	wr.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(wr).Encode(interp.SearchHistory(query))
 }
 
-func (w *WebInterface) handleClearHistory(wr http.ResponseWriter, _ *http.Request) {
-	w.interpreter.ClearHistory()
-	history := w.interpreter.GetHistoryCommands(1000)
+// handleUndo - POST /api/undo и /api/redo; в ответе результат и переменные после изменения
+func (w *WebInterface) handleUndo(action func(*interpreter.Interpreter) (interface{}, error)) http.HandlerFunc {
+	return func(wr http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(wr, "only POST", 400)
+			return
+		}
+
+		interp, release, ok := w.interpreterFor(wr, r)
+		if !ok {
+			return
+		}
+		defer release()
+		result, err := action(interp)
+		if err != nil {
+			wr.WriteHeader(400)
+			json.NewEncoder(wr).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+
+		vars := interp.GetVariables()
+		metrics.UpdateCalculatorMetrics(len(vars), 0)
+		json.NewEncoder(wr).Encode(map[string]interface{}{"result": result, "variables": vars})
+	}
+}
+
+// handleWorkspaces - GET: список рабочих пространств; POST {"action", "name", "to"}:
+// new, use, clone (name -> to), rename (name -> to), delete. В ответе всегда актуальный список.
+func (w *WebInterface) handleWorkspaces(wr http.ResponseWriter, r *http.Request) {
+	interp, release, ok := w.interpreterFor(wr, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var req struct {
+			Action string `json:"action"`
+			Name   string `json:"name"`
+			To     string `json:"to"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(wr, "invalid JSON", 400)
+			return
+		}
+
+		var err error
+		switch req.Action {
+		case "new":
+			err = interp.CreateWorkspace(req.Name)
+		case "use":
+			err = interp.UseWorkspace(req.Name)
+		case "clone":
+			err = interp.CloneWorkspace(req.Name, req.To)
+		case "rename":
+			err = interp.RenameWorkspace(req.Name, req.To)
+		case "delete":
+			err = interp.DeleteWorkspace(req.Name)
+		default:
+			http.Error(wr, "unknown action", 400)
+			return
+		}
+		if err != nil {
+			wr.WriteHeader(400)
+			json.NewEncoder(wr).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+	default:
+		http.Error(wr, "only GET or POST", 400)
+		return
+	}
+
+	workspaces, err := interp.Workspaces()
+	if err != nil {
+		http.Error(wr, err.Error(), 500)
+		return
+	}
+	wr.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(wr).Encode(map[string]interface{}{
+		"current":    interp.Workspace(),
+		"workspaces": workspaces,
+	})
+}
+
+// maxImportSize - наибольший размер загружаемого через /api/import файла
+const maxImportSize = 10 << 20
+
+// transferFormat - формат из параметра format для раздела what (vars или history)
+func transferFormat(what, format string) (string, error) {
+	switch what {
+	case "vars":
+		return transfer.VariableFormat(format, "")
+	case "history":
+		return transfer.HistoryFormat(format, "")
+	}
+	return "", fmt.Errorf("what must be vars or history")
+}
+
+// handleExport - GET /api/export?what=vars|history&format=...: файл для скачивания.
+// Форматы переменных: json, csv, env, yaml; истории: json, csv, jsonl, markdown.
+func (w *WebInterface) handleExport(wr http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(wr, "only GET", 400)
+		return
+	}
+	params := r.URL.Query()
+	what := params.Get("what")
+	format, err := transferFormat(what, params.Get("format"))
+	if err != nil {
+		http.Error(wr, err.Error(), 400)
+		return
+	}
+
+	interp, release, ok := w.interpreterFor(wr, r)
+	if !ok {
+		return
+	}
+	defer release()
+	export := interp.ExportVariables
+	if what == "history" {
+		export = interp.ExportHistory
+	}
+	var buf bytes.Buffer
+	if err := export(&buf, format); err != nil {
+		http.Error(wr, err.Error(), 500)
+		return
+	}
+
+	wr.Header().Set("Content-Type", transfer.ContentType(format))
+	wr.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", transfer.FileName(what, format)))
+	wr.Write(buf.Bytes())
+}
+
+// handleImport - POST /api/import?what=vars|history&format=...&strategy=merge|overwrite|skip,
+// содержимое файла в теле запроса. В ответе итог загрузки и переменные после нее.
+func (w *WebInterface) handleImport(wr http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(wr, "only POST", 400)
+		return
+	}
+	params := r.URL.Query()
+	what := params.Get("what")
+	format, err := transferFormat(what, params.Get("format"))
+	if err != nil {
+		http.Error(wr, err.Error(), 400)
+		return
+	}
+	strategy, err := transfer.ParseStrategy(params.Get("strategy"))
+	if err != nil {
+		http.Error(wr, err.Error(), 400)
+		return
+	}
+
+	interp, release, ok := w.interpreterFor(wr, r)
+	if !ok {
+		return
+	}
+	defer release()
+	body := io.Reader(http.MaxBytesReader(wr, r.Body, maxImportSize))
+	load := interp.ImportVariables
+	if what == "history" {
+		load = interp.ImportHistory
+	}
+	stats, err := load(body, format, strategy)
+	if err != nil {
+		wr.WriteHeader(400)
+		json.NewEncoder(wr).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	vars := interp.GetVariables()
+	metrics.UpdateCalculatorMetrics(len(vars), 0)
+	json.NewEncoder(wr).Encode(map[string]interface{}{
+		"result":    "✅ Загружено: " + stats.String(),
+		"stats":     stats,
+		"variables": vars,
+	})
+}
+
+func (w *WebInterface) handleClearHistory(wr http.ResponseWriter, r *http.Request) {
+	interp, release, ok := w.interpreterFor(wr, r)
+	if !ok {
+		return
+	}
+	defer release()
+	interp.ClearHistory()
+	history := interp.GetHistoryCommands(1000)
 	metrics.UpdateCalculatorMetrics(0, len(history))
 	wr.WriteHeader(200)
 }
@@ -0,0 +1,408 @@
+package ui
+
+import (
+	"app/config"
+	"app/core/history"
+	"app/core/interpreter"
+	"app/core/persistence"
+	"app/core/variables"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHandleHistoryReturnsRecords(t *testing.T) {
+	web := NewWebInterface(interpreter.NewInterpreterWithPersistence(persistence.NewInMemoryPersistenceManager()))
+	web.interpreter.Execute("2+2")
+	web.interpreter.Execute("1/0")
+
+	rec := httptest.NewRecorder()
+	web.handleHistory(rec, httptest.NewRequest("GET", "/api/history?limit=5", nil))
+
+	var entries []history.DetailedHistoryEntry
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("Invalid JSON: %v\n%s", err, rec.Body.String())
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Command != "2+2" || entries[0].Result != 4.0 || entries[0].Kind != "math" {
+		t.Errorf("Unexpected first entry: %+v", entries[0])
+	}
+	if entries[1].Error == "" {
+		t.Errorf("Expected error in second entry: %+v", entries[1])
+	}
+
+	rec = httptest.NewRecorder()
+	web.handleHistory(rec, httptest.NewRequest("GET", "/api/history?limit=x", nil))
+	if rec.Code != 400 {
+		t.Errorf("Expected 400 for invalid limit, got %d", rec.Code)
+	}
+}
+
+func TestHandleHistorySearch(t *testing.T) {
+	web := NewWebInterface(interpreter.NewInterpreterWithPersistence(persistence.NewInMemoryPersistenceManager()))
+	web.interpreter.Execute("a = 1")
+	web.interpreter.Execute("a + 1")
+	web.interpreter.Execute("2 / 0")
+
+	rec := httptest.NewRecorder()
+	web.handleHistorySearch(rec, httptest.NewRequest("GET", "/api/history/search?q=uses:a&limit=1", nil))
+
+	var result history.SearchResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Invalid JSON: %v\n%s", err, rec.Body.String())
+	}
+	if result.Total != 1 || len(result.Entries) != 1 || result.Entries[0].Command != "a + 1" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	rec = httptest.NewRecorder()
+	web.handleHistorySearch(rec, httptest.NewRequest("GET", "/api/history/search?q=re:(", nil))
+	if rec.Code != 400 {
+		t.Errorf("Expected 400 for invalid regex, got %d", rec.Code)
+	}
+}
+
+func TestHandleUndo(t *testing.T) {
+	web := NewWebInterface(interpreter.NewInterpreterWithPersistence(persistence.NewInMemoryPersistenceManager()))
+	web.interpreter.Execute("x = 1")
+	web.interpreter.Execute("x = 2")
+
+	rec := httptest.NewRecorder()
+	web.handleUndo((*interpreter.Interpreter).Undo)(rec, httptest.NewRequest("POST", "/api/undo", nil))
+
+	var response struct {
+		Result    string                 `json:"result"`
+		Variables map[string]interface{} `json:"variables"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Invalid JSON: %v\n%s", err, rec.Body.String())
+	}
+	if response.Variables["x"] != 1.0 {
+		t.Errorf("Expected x = 1 after undo, got %+v", response)
+	}
+
+	rec = httptest.NewRecorder()
+	web.handleUndo((*interpreter.Interpreter).Redo)(rec, httptest.NewRequest("GET", "/api/redo", nil))
+	if rec.Code != 400 {
+		t.Errorf("Expected 400 for GET, got %d", rec.Code)
+	}
+}
+
+type workspacesResponse struct {
+	Current    string                      `json:"current"`
+	Workspaces []interpreter.WorkspaceInfo `json:"workspaces"`
+	Error      string                      `json:"error"`
+}
+
+func postWorkspaces(t *testing.T, web *WebInterface, body string, cookies ...*http.Cookie) (*httptest.ResponseRecorder, workspacesResponse) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/api/workspaces", strings.NewReader(body))
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	web.handleWorkspaces(rec, req)
+	var resp workspacesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Invalid JSON: %v\n%s", err, rec.Body.String())
+	}
+	return rec, resp
+}
+
+func TestHandleWorkspaces(t *testing.T) {
+	sm, _ := newTestSessions(10, 10)
+	web := NewWebInterfaceWithSessions(sm, "static")
+
+	rec, resp := postWorkspaces(t, web, `{"action":"new","name":"budget"}`)
+	if resp.Current != "budget" || len(resp.Workspaces) != 2 {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+
+	rec, _ = postWorkspaces(t, web, `{"action":"delete","name":"budget"}`, rec.Result().Cookies()...)
+	if rec.Code != 400 {
+		t.Errorf("Expected 400 deleting current workspace, got %d", rec.Code)
+	}
+}
+
+// TestSharedWebWorkspaceIsPinned - без сессий пространство общее, переход одного клиента
+// сменил бы его у всех
+func TestSharedWebWorkspaceIsPinned(t *testing.T) {
+	web := NewWebInterface(interpreter.NewInterpreterWithPersistence(persistence.NewInMemoryPersistenceManager()))
+
+	rec, resp := postWorkspaces(t, web, `{"action":"new","name":"budget"}`)
+	if rec.Code != 400 || !strings.Contains(resp.Error, "sessions.enabled") {
+		t.Errorf("Expected switching to be refused, got %d %+v", rec.Code, resp)
+	}
+	if _, resp = postWorkspaces(t, web, `{"action":"clone","name":"default","to":"copy"}`); resp.Current != "default" || len(resp.Workspaces) != 2 {
+		t.Errorf("Expected clone to work without switching, got %+v", resp)
+	}
+	if _, err := web.interpreter.Execute("workspace use copy"); err == nil {
+		t.Error("Expected workspace use to be refused")
+	}
+	if ws := web.interpreter.Workspace(); ws != "default" {
+		t.Errorf("Expected shared workspace default, got %s", ws)
+	}
+}
+
+// TestExecuteConcurrent - параллельные запросы к /api/execute; запускать с -race
+func TestExecuteConcurrent(t *testing.T) {
+	cfg := config.Default()
+	cfg.History.MaxEntries = 10000
+	pm := persistence.NewInMemoryPersistenceManager()
+	web := NewWebInterface(interpreter.NewInterpreterWithConfig(cfg, pm))
+	web.interpreter.Execute("counter = 0")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/execute", web.handleExecute)
+	mux.HandleFunc("/api/vars", web.handleVars)
+	mux.HandleFunc("/api/history", web.handleHistory)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	const workers, perWorker = 8, 25
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for n := 0; n < perWorker; n++ {
+				body := strings.NewReader(`{"input":"counter = counter + 1"}`)
+				resp, err := http.Post(server.URL+"/api/execute", "application/json", body)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				resp.Body.Close()
+
+				// Чтения вперемешку с записями
+				path := "/api/vars"
+				if n%2 == 0 {
+					path = "/api/history?limit=5"
+				}
+				if resp, err := http.Get(server.URL + path); err == nil {
+					resp.Body.Close()
+				}
+				web.interpreter.Execute(fmt.Sprintf("%d * 2", w))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if counter := web.interpreter.GetVariables()["counter"]; counter != float64(workers*perWorker) {
+		t.Errorf("Expected counter = %d, got %v", workers*perWorker, counter)
+	}
+
+	// Записи истории не теряются и получают разные номера
+	entries := web.interpreter.GetDetailedHistory(0)
+	if len(entries) != 1+2*workers*perWorker {
+		t.Errorf("Expected %d history entries, got %d", 1+2*workers*perWorker, len(entries))
+	}
+	ids := make(map[int]bool)
+	for _, entry := range entries {
+		if ids[entry.ID] {
+			t.Fatalf("Duplicate history id %d", entry.ID)
+		}
+		ids[entry.ID] = true
+	}
+
+	if saved := pm.LoadVariables(); saved["counter"] != float64(workers*perWorker) {
+		t.Errorf("Expected saved counter, got %v", saved["counter"])
+	}
+}
+
+// decodeVars - ответ /api/vars как имя -> значение
+func decodeVars(t *testing.T, body []byte) map[string]interface{} {
+	var list []variables.VariableInfo
+	if err := json.Unmarshal(body, &list); err != nil {
+		t.Fatalf("Unexpected /api/vars response %s: %v", body, err)
+	}
+	vars := make(map[string]interface{}, len(list))
+	for _, v := range list {
+		vars[v.Name] = v.Value
+	}
+	return vars
+}
+
+func TestHandleVarsReturnsMetadata(t *testing.T) {
+	web := NewWebInterface(interpreter.NewInterpreterWithPersistence(persistence.NewInMemoryPersistenceManager()))
+	web.interpreter.Execute("x = 1")
+	web.interpreter.Execute("const rate = 0.2")
+	web.interpreter.Execute("describe rate Ставка налога --unit доля --tags налоги")
+
+	rec := httptest.NewRecorder()
+	web.handleVars(rec, httptest.NewRequest("GET", "/api/vars", nil))
+	var list []variables.VariableInfo
+	json.Unmarshal(rec.Body.Bytes(), &list)
+
+	got := make(map[string]variables.VariableInfo)
+	for _, v := range list {
+		got[v.Name] = v
+	}
+	if got["x"].Constant || !got["rate"].Constant || got["rate"].Builtin || got["rate"].Value != 0.2 {
+		t.Errorf("Unexpected variables %+v", list)
+	}
+	if rate := got["rate"]; rate.Description != "Ставка налога" || rate.Unit != "доля" || !rate.HasTag("налоги") || rate.Expression != "0.2" || rate.Updated == "" {
+		t.Errorf("Expected metadata of rate, got %+v", rate)
+	}
+	if !strings.Contains(rec.Body.String(), `"description":"Ставка налога"`) {
+		t.Errorf("Expected flat metadata fields in JSON, got %s", rec.Body.String())
+	}
+	if pi := got["pi"]; !pi.Constant || !pi.Builtin {
+		t.Errorf("Expected builtin pi, got %+v", pi)
+	}
+}
+
+func TestHandleExportImport(t *testing.T) {
+	web := NewWebInterface(interpreter.NewInterpreterWithPersistence(persistence.NewInMemoryPersistenceManager()))
+	web.interpreter.Execute("x = 1")
+	web.interpreter.Execute("y = 2")
+
+	rec := httptest.NewRecorder()
+	web.handleExport(rec, httptest.NewRequest("GET", "/api/export?what=vars&format=csv", nil))
+	if rec.Code != 200 || rec.Body.String() != "name,value\nx,1\ny,2\n" {
+		t.Fatalf("Unexpected export %d:\n%s", rec.Code, rec.Body.String())
+	}
+	if disposition := rec.Header().Get("Content-Disposition"); !strings.Contains(disposition, `filename="vars.csv"`) {
+		t.Errorf("Unexpected Content-Disposition %q", disposition)
+	}
+
+	rec = httptest.NewRecorder()
+	web.handleImport(rec, httptest.NewRequest("POST", "/api/import?what=vars&format=env&strategy=overwrite", strings.NewReader("x=10\nz=\"text\"\n")))
+	var response struct {
+		Stats     map[string]int         `json:"stats"`
+		Variables map[string]interface{} `json:"variables"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Invalid JSON: %v\n%s", err, rec.Body.String())
+	}
+	if len(response.Variables) != 2 || response.Variables["x"] != 10.0 || response.Variables["z"] != "text" {
+		t.Errorf("Expected variables replaced, got %v", response.Variables)
+	}
+	if response.Stats["removed"] != 1 || response.Stats["updated"] != 1 || response.Stats["added"] != 1 {
+		t.Errorf("Unexpected stats %v", response.Stats)
+	}
+
+	rec = httptest.NewRecorder()
+	web.handleExport(rec, httptest.NewRequest("GET", "/api/export?what=history&format=jsonl", nil))
+	if lines := strings.Count(rec.Body.String(), "\n"); lines != 2 {
+		t.Errorf("Expected 2 history lines, got %d:\n%s", lines, rec.Body.String())
+	}
+
+	bad := []*http.Request{
+		httptest.NewRequest("GET", "/api/export?what=everything", nil),
+		httptest.NewRequest("GET", "/api/export?what=vars&format=markdown", nil),
+		httptest.NewRequest("GET", "/api/import?what=vars", nil),
+		httptest.NewRequest("POST", "/api/import?what=vars&strategy=replace", strings.NewReader("{}")),
+		httptest.NewRequest("POST", "/api/import?what=vars", strings.NewReader(`{"1x": 1}`)),
+	}
+	for _, r := range bad {
+		rec = httptest.NewRecorder()
+		if r.URL.Path == "/api/export" {
+			web.handleExport(rec, r)
+		} else {
+			web.handleImport(rec, r)
+		}
+		if rec.Code != 400 {
+			t.Errorf("%s %s: expected 400, got %d", r.Method, r.URL, rec.Code)
+		}
+	}
+}
+
+// executeWeb - команда через POST /api/execute: код ответа и тело
+func executeWeb(web *WebInterface, input string) (int, string) {
+	body, _ := json.Marshal(map[string]string{"input": input})
+	rec := httptest.NewRecorder()
+	web.handleExecute(rec, httptest.NewRequest("POST", "/api/execute", bytes.NewReader(body)))
+	return rec.Code, rec.Body.String()
+}
+
+func TestWebHistoryExportFiles(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "history.json")
+
+	// Без server.files_dir файлы из веб-интерфейса недоступны
+	web := NewWebInterface(interpreter.NewInterpreterWithConfig(config.Default(), persistence.NewInMemoryPersistenceManager()))
+	web.interpreter.Execute("2+2")
+	if code, body := executeWeb(web, "history export "+outside); code != 400 || !strings.Contains(body, "server.files_dir") {
+		t.Errorf("Expected export to a file to be refused, got %d %s", code, body)
+	}
+	if _, err := os.Stat(outside); !os.IsNotExist(err) {
+		t.Errorf("File must not be created, got %v", err)
+	}
+	if code, body := executeWeb(web, "history export"); code != 200 || !strings.Contains(body, "2+2") {
+		t.Errorf("Expected export into the response, got %d %s", code, body)
+	}
+
+	// С каталогом - только внутри него
+	cfg := config.Default()
+	cfg.Server.FilesDir = t.TempDir()
+	web = NewWebInterface(interpreter.NewInterpreterWithConfig(cfg, persistence.NewInMemoryPersistenceManager()))
+	web.interpreter.Execute("2+2")
+	for _, path := range []string{outside, "../history.json", "sub/../../history.json"} {
+		if code, body := executeWeb(web, "history export "+path); code != 400 {
+			t.Errorf("%s: expected 400, got %d %s", path, code, body)
+		}
+	}
+	if code, body := executeWeb(web, "history export history.json"); code != 200 {
+		t.Fatalf("Expected export inside files_dir, got %d %s", code, body)
+	}
+	if data, err := os.ReadFile(filepath.Join(cfg.Server.FilesDir, "history.json")); err != nil || !strings.Contains(string(data), "2+2") {
+		t.Errorf("Expected history in files_dir, got %q, %v", data, err)
+	}
+}
+
+func TestWebTransferFiles(t *testing.T) {
+	root := t.TempDir()
+	filesDir := filepath.Join(root, "files")
+	os.Mkdir(filesDir, 0755)
+	secret := filepath.Join(root, "secret.json")
+	os.WriteFile(secret, []byte(`{"stolen": 1}`), 0644)
+	os.Symlink(secret, filepath.Join(filesDir, "link.json"))
+	os.Symlink(root, filepath.Join(filesDir, "up"))
+	os.WriteFile(filepath.Join(filesDir, "vars.json"), []byte(`{"y": 2}`), 0644)
+
+	cfg := config.Default()
+	cfg.Server.FilesDir = filesDir
+	web := NewWebInterface(interpreter.NewInterpreterWithConfig(cfg, persistence.NewInMemoryPersistenceManager()))
+	web.interpreter.Execute("x = 1")
+
+	for _, command := range []string{
+		"import vars ../secret.json",
+		"import vars " + secret,
+		"import vars link.json",
+		"import vars up/secret.json",
+		"import history ../secret.json",
+		"export vars ../out.json",
+		"export vars " + filepath.Join(root, "out.json"),
+		"export vars up/out.json",
+		"export history ../out.json",
+	} {
+		if code, body := executeWeb(web, command); code != 400 {
+			t.Errorf("%s: expected 400, got %d %s", command, code, body)
+		}
+	}
+	if _, ok := web.interpreter.GetVariables()["stolen"]; ok {
+		t.Error("A file outside files_dir must not be imported")
+	}
+	if _, err := os.Stat(filepath.Join(root, "out.json")); !os.IsNotExist(err) {
+		t.Errorf("A file outside files_dir must not be created, got %v", err)
+	}
+
+	if code, body := executeWeb(web, "import vars vars.json"); code != 200 || web.interpreter.GetVariables()["y"] != 2.0 {
+		t.Errorf("Expected import inside files_dir, got %d %s", code, body)
+	}
+	if code, body := executeWeb(web, "export vars out.json"); code != 200 {
+		t.Errorf("Expected export inside files_dir, got %d %s", code, body)
+	}
+	if data, err := os.ReadFile(filepath.Join(filesDir, "out.json")); err != nil || !strings.Contains(string(data), `"x"`) {
+		t.Errorf("Expected variables in files_dir, got %q, %v", data, err)
+	}
+}